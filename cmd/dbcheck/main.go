@@ -0,0 +1,43 @@
+// dbcheck verifies a database file offline and reports every problem it finds.
+//
+//	go run ./cmd/dbcheck path/to/file.db
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "usage: dbcheck <database file>")
+		os.Exit(2)
+	}
+	path := os.Args[1]
+
+	// NewPager creates missing files, so make sure we're checking something real
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	pager, err := storage.NewPager(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(2)
+	}
+
+	problems := db.CheckIntegrity(pager)
+	if len(problems) == 0 {
+		fmt.Println("ok")
+		return
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("\n%d problem(s) found\n", len(problems))
+	os.Exit(1)
+}
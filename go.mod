@@ -2,4 +2,4 @@ module github.com/mbeka02/pesapal_challenge
 
 go 1.24.3
 
-require github.com/alecthomas/participle/v2 v2.1.4 // indirect
+require github.com/alecthomas/participle/v2 v2.1.4
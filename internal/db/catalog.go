@@ -3,126 +3,366 @@ package db
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"io"
 
 	"github.com/mbeka02/pesapal_challenge/internal/storage"
 	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
+/*
+The catalog is a heap of CatalogEntry records rooted at page 1, right after
+the meta page, with its free space map at page 2. Entries are looked up by name.
+Views are entries too, sharing the names of tables: they hold the text of
+their SELECT and the columns it returns, and have no pages. A materialized
+view has both, the text of its SELECT and a heap of the rows it returned.
+*/
+const (
+	CATALOG_ROOT storage.PageID = 1
+	CATALOG_FSM  storage.PageID = 2
+)
+
 type Catalog struct {
-	pager *storage.Pager
+	heap *storage.Heap
 }
 type CatalogEntry struct {
-	Name      string
-	StartPage uint64
-	NumPages  uint32
-	Schema    []types.Column
+	Name         string
+	StartPage    uint64
+	FSMPage      uint64
+	NumPages     uint32
+	Version      uint16 // version of Schema, stamped on every record written with it
+	NextColumnID uint16
+	Schema       []types.Column
+	History      []SchemaVersion // older schemas that stored records may still use
+	Indexes      []IndexEntry
+	View         string // the SELECT a view runs, empty for a table
+	Materialized bool   // set for a view whose rows are stored in its heap
 }
 
-/*Sets the Page Headers*/
-func initializeCatalogPage(page []byte) {
-	binary.LittleEndian.PutUint16(page[0:2], 0)                         // NumEntries
-	binary.LittleEndian.PutUint16(page[2:4], uint16(storage.PAGE_SIZE)) // DataStart
+type SchemaVersion struct {
+	Version uint16
+	Columns []types.Column
 }
 
-func LoadCatalog(pager *storage.Pager) ([]CatalogEntry, error) {
-	// if file is empty, initialize catalog page
+// OpenCatalog opens the catalog, formatting the meta and catalog pages if the file is empty
+func OpenCatalog(pager *storage.Pager) (*Catalog, error) {
 	if pager.NextPageID() == 0 {
-		page := make([]byte, storage.PAGE_SIZE)
-		initializeCatalogPage(page)
-		if _, err := pager.WritePage(0, page); err != nil {
+		if err := pager.InitMetaPage(); err != nil {
+			return nil, err
+		}
+		heap, err := storage.CreateHeap(pager)
+		if err != nil {
 			return nil, err
 		}
-		return nil, nil
+		if heap.StartPage() != CATALOG_ROOT || heap.FSMStartPage() != CATALOG_FSM {
+			return nil, fmt.Errorf("catalog allocated at pages %d/%d, expected %d/%d",
+				heap.StartPage(), heap.FSMStartPage(), CATALOG_ROOT, CATALOG_FSM)
+		}
+		return &Catalog{heap: heap}, nil
 	}
+	return &Catalog{heap: storage.NewHeap(pager, CATALOG_ROOT, CATALOG_FSM)}, nil
+}
 
-	page, err := pager.ReadPage(0)
+func (c *Catalog) Entries() ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+	var decodeErr error
+	err := c.heap.Scan(func(rid storage.RecordID, data []byte) bool {
+		entry, err := DecodeCatalogEntry(data)
+		if err != nil {
+			decodeErr = fmt.Errorf("catalog entry at page %d slot %d: %w", rid.PageID, rid.Slot, err)
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+	return entries, decodeErr
+}
 
-	numEntries := binary.LittleEndian.Uint16(page[0:2])
-	entries := make([]CatalogEntry, 0, numEntries)
-
-	for i := uint16(0); i < numEntries; i++ {
-		slotOffset := storage.PAGE_HEADER_SIZE + i*storage.SLOT_SIZE
+func (c *Catalog) Insert(entry CatalogEntry) error {
+	_, err := c.heap.Insert(EncodeCatalogEntry(entry))
+	return err
+}
 
-		recordOffset := binary.LittleEndian.Uint16(page[slotOffset : slotOffset+2])
-		recordLen := binary.LittleEndian.Uint16(page[slotOffset+2 : slotOffset+4])
-		record := page[recordOffset : recordOffset+recordLen]
-		entry := DecodeCatalogEntry(record)
-		entries = append(entries, entry)
+// Update rewrites the entry for a table after applying fn to it
+func (c *Catalog) Update(name string, fn func(*CatalogEntry)) error {
+	rid, entry, err := c.find(name)
+	if err != nil {
+		return err
 	}
+	fn(&entry)
+	_, err = c.heap.Update(rid, EncodeCatalogEntry(entry))
+	return err
+}
 
-	return entries, nil
+func (c *Catalog) Get(name string) (CatalogEntry, error) {
+	_, entry, err := c.find(name)
+	return entry, err
 }
 
-// func (c *Catalog) CreateTable(entry CatalogEntry) error
-// func (c *Catalog) ListTables() ([]CatalogEntry, error)
+func (c *Catalog) Delete(name string) error {
+	rid, _, err := c.find(name)
+	if err != nil {
+		return err
+	}
+	return c.heap.Delete(rid)
+}
+
+func (c *Catalog) find(name string) (storage.RecordID, CatalogEntry, error) {
+	var (
+		found     bool
+		foundRID  storage.RecordID
+		foundItem CatalogEntry
+		decodeErr error
+	)
+	err := c.heap.Scan(func(rid storage.RecordID, data []byte) bool {
+		entry, err := DecodeCatalogEntry(data)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		if entry.Name == name {
+			found, foundRID, foundItem = true, rid, entry
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return storage.RecordID{}, CatalogEntry{}, err
+	}
+	if decodeErr != nil {
+		return storage.RecordID{}, CatalogEntry{}, decodeErr
+	}
+	if !found {
+		return storage.RecordID{}, CatalogEntry{}, fmt.Errorf("table %s not found in catalog", name)
+	}
+	return foundRID, foundItem, nil
+}
 
 /*
 Encoding format:
 | nameLen (u16) | name bytes |
 | startPage (u64) |
+| fsmPage (u64) |
 | numPages (u32) |
+| version (u16) |
+| nextColumnID (u16) |
+| columns |
+| numHistory (u16) |
+| [ version (u16) | columns ] × H |
+| numIndexes (u16) |
+| [ indexNameLen (u16) | indexName | columnNameLen (u16) | columnName | pathLen (u16) | path | kind (u8) | root (u64) ] × M |
+| viewLen (u16) | view | materialized (u8) |
+
+where columns is
 | numColumns (u16) |
-| [ columnNameLen (u16) | columnName | columnType (u8) ] × N |
+| [ columnNameLen (u16) | columnName | columnType (u8) | precision (u8) | scale (u8) | length (u16) | columnID (u16) | defaultLen (u16) | default ] × N |
+and a default is a single value encoded like a row, defaultLen 0 meaning none.
 */
 func EncodeCatalogEntry(e CatalogEntry) []byte {
 	buff := new(bytes.Buffer)
 	// table name
-	binary.Write(buff, binary.LittleEndian, uint16(len(e.Name)))
-	buff.Write([]byte(e.Name))
+	writeString(buff, e.Name)
 
 	// heap info
 	binary.Write(buff, binary.LittleEndian, e.StartPage)
+	binary.Write(buff, binary.LittleEndian, e.FSMPage)
 	binary.Write(buff, binary.LittleEndian, e.NumPages)
 
 	// schema
-	binary.Write(buff, binary.LittleEndian, uint16(len(e.Schema)))
-	for _, col := range e.Schema {
-		binary.Write(buff, binary.LittleEndian, uint16(len(col.Name)))
-		buff.Write([]byte(col.Name))
-		binary.Write(buff, binary.LittleEndian, uint8(col.Type))
+	binary.Write(buff, binary.LittleEndian, e.Version)
+	binary.Write(buff, binary.LittleEndian, e.NextColumnID)
+	encodeColumns(buff, e.Schema)
+	binary.Write(buff, binary.LittleEndian, uint16(len(e.History)))
+	for _, v := range e.History {
+		binary.Write(buff, binary.LittleEndian, v.Version)
+		encodeColumns(buff, v.Columns)
 	}
+
+	// indexes
+	binary.Write(buff, binary.LittleEndian, uint16(len(e.Indexes)))
+	for _, idx := range e.Indexes {
+		writeString(buff, idx.Name)
+		writeString(buff, idx.Column)
+		writeString(buff, idx.Path)
+		binary.Write(buff, binary.LittleEndian, uint8(idx.Kind))
+		binary.Write(buff, binary.LittleEndian, idx.Root)
+	}
+
+	// view
+	writeString(buff, e.View)
+	binary.Write(buff, binary.LittleEndian, e.Materialized)
 	return buff.Bytes()
 }
 
-func DecodeCatalogEntry(data []byte) CatalogEntry {
+func encodeColumns(buff *bytes.Buffer, columns []types.Column) {
+	binary.Write(buff, binary.LittleEndian, uint16(len(columns)))
+	for _, col := range columns {
+		writeString(buff, col.Name)
+		binary.Write(buff, binary.LittleEndian, uint8(col.Type))
+		binary.Write(buff, binary.LittleEndian, col.Precision)
+		binary.Write(buff, binary.LittleEndian, col.Scale)
+		binary.Write(buff, binary.LittleEndian, col.Length)
+		binary.Write(buff, binary.LittleEndian, col.ID)
+		var def []byte
+		if col.Default != nil {
+			def = storage.EncodeRow(types.Row{col.Default})
+		}
+		binary.Write(buff, binary.LittleEndian, uint16(len(def)))
+		buff.Write(def)
+	}
+}
+
+func DecodeCatalogEntry(data []byte) (CatalogEntry, error) {
 	r := bytes.NewReader(data)
+	var e CatalogEntry
+
+	var err error
+	if e.Name, err = readString(r); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &e.StartPage); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &e.FSMPage); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &e.NumPages); err != nil {
+		return CatalogEntry{}, err
+	}
 
-	var nameLen uint16
-	binary.Read(r, binary.LittleEndian, &nameLen)
-	name := make([]byte, nameLen)
-	r.Read(name)
+	if err := binary.Read(r, binary.LittleEndian, &e.Version); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &e.NextColumnID); err != nil {
+		return CatalogEntry{}, err
+	}
+	if e.Schema, err = decodeColumns(r); err != nil {
+		return CatalogEntry{}, err
+	}
+	var numHistory uint16
+	if err := binary.Read(r, binary.LittleEndian, &numHistory); err != nil {
+		return CatalogEntry{}, err
+	}
+	for i := uint16(0); i < numHistory; i++ {
+		var v SchemaVersion
+		if err := binary.Read(r, binary.LittleEndian, &v.Version); err != nil {
+			return CatalogEntry{}, err
+		}
+		if v.Columns, err = decodeColumns(r); err != nil {
+			return CatalogEntry{}, err
+		}
+		e.History = append(e.History, v)
+	}
 
-	var startPage uint64
-	var numPages uint32
-	binary.Read(r, binary.LittleEndian, &startPage)
-	binary.Read(r, binary.LittleEndian, &numPages)
+	var numIndexes uint16
+	if err := binary.Read(r, binary.LittleEndian, &numIndexes); err != nil {
+		return CatalogEntry{}, err
+	}
+	for i := uint16(0); i < numIndexes; i++ {
+		var idx IndexEntry
+		if idx.Name, err = readString(r); err != nil {
+			return CatalogEntry{}, err
+		}
+		if idx.Column, err = readString(r); err != nil {
+			return CatalogEntry{}, err
+		}
+		if idx.Path, err = readString(r); err != nil {
+			return CatalogEntry{}, err
+		}
+		var kind uint8
+		if err := binary.Read(r, binary.LittleEndian, &kind); err != nil {
+			return CatalogEntry{}, err
+		}
+		idx.Kind = IndexKind(kind)
+		if idx.Kind != HASH_INDEX && idx.Kind != BTREE_INDEX {
+			return CatalogEntry{}, fmt.Errorf("index %s has unknown kind %d", idx.Name, kind)
+		}
+		if err := binary.Read(r, binary.LittleEndian, &idx.Root); err != nil {
+			return CatalogEntry{}, err
+		}
+		e.Indexes = append(e.Indexes, idx)
+	}
+
+	if e.View, err = readString(r); err != nil {
+		return CatalogEntry{}, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &e.Materialized); err != nil {
+		return CatalogEntry{}, err
+	}
+	if r.Len() != 0 {
+		return CatalogEntry{}, fmt.Errorf("%d trailing bytes in catalog entry", r.Len())
+	}
+	return e, nil
+}
 
+func decodeColumns(r *bytes.Reader) ([]types.Column, error) {
 	var numCols uint16
-	binary.Read(r, binary.LittleEndian, &numCols)
+	if err := binary.Read(r, binary.LittleEndian, &numCols); err != nil {
+		return nil, err
+	}
 
-	schema := make([]types.Column, 0, numCols)
+	columns := make([]types.Column, 0, numCols)
 	for i := uint16(0); i < numCols; i++ {
-		var colNameLen uint16
-		binary.Read(r, binary.LittleEndian, &colNameLen)
-		colName := make([]byte, colNameLen)
-		r.Read(colName)
-
+		var col types.Column
+		var err error
+		if col.Name, err = readString(r); err != nil {
+			return nil, err
+		}
 		var colType uint8
-		binary.Read(r, binary.LittleEndian, &colType)
+		if err := binary.Read(r, binary.LittleEndian, &colType); err != nil {
+			return nil, err
+		}
+		col.Type = types.DataType(colType)
+		if err := binary.Read(r, binary.LittleEndian, &col.Precision); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &col.Scale); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &col.Length); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &col.ID); err != nil {
+			return nil, err
+		}
 
-		schema = append(schema, types.Column{
-			Name: string(colName),
-			Type: types.DataType(colType),
-		})
+		var defLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &defLen); err != nil {
+			return nil, err
+		}
+		if defLen > 0 {
+			def := make([]byte, defLen)
+			if _, err := io.ReadFull(r, def); err != nil {
+				return nil, err
+			}
+			row, err := storage.DecodeRow(def, []types.Column{col})
+			if err != nil {
+				return nil, fmt.Errorf("default for column %s: %w", col.Name, err)
+			}
+			col.Default = row[0]
+		}
+		columns = append(columns, col)
 	}
+	return columns, nil
+}
 
-	return CatalogEntry{
-		Name:      string(name),
-		StartPage: startPage,
-		NumPages:  numPages,
-		Schema:    schema,
+func writeString(buff *bytes.Buffer, s string) {
+	binary.Write(buff, binary.LittleEndian, uint16(len(s)))
+	buff.Write([]byte(s))
+}
+
+// readString reads a u16 length prefixed string
+func readString(r *bytes.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
 	}
+	return string(b), nil
 }
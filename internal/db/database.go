@@ -1,127 +1,184 @@
 package db
 
 import (
-	"encoding/binary"
-	"fmt"
 	"log"
+	"os"
 
 	"github.com/mbeka02/pesapal_challenge/internal/storage"
 	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
 type DB struct {
-	Tables map[string]*Table
-	Pager  *storage.Pager
+	Tables  map[string]*Table
+	Views   map[string]*View
+	Pager   *storage.Pager
+	catalog *Catalog
+	// Go functions registered to be called from SQL, see functions.go
+	scalars    map[string]*ScalarFunc
+	aggregates map[string]*AggregateFunc
 }
 
-func insertCatalogEntry(pager *storage.Pager, entry CatalogEntry) error {
-	page, err := pager.ReadPage(0)
-	if err != nil {
-		return err
-	}
+// openTable wires a catalog entry up to its heap
+func (db *DB) openTable(e CatalogEntry) *Table {
+	heap := storage.NewHeap(db.Pager, storage.PageID(e.StartPage), storage.PageID(e.FSMPage))
 
-	data := EncodeCatalogEntry(entry)
+	// capture table name for closure
+	tableName := e.Name
+	// set callback to update catalog when heap grows or shrinks
+	heap.SetGrowthCallback(func(newNumPages uint32) {
+		err := db.catalog.Update(tableName, func(entry *CatalogEntry) {
+			entry.NumPages = newNumPages
+		})
+		if err != nil {
+			log.Printf("ERROR updating catalog: %v", err)
+		}
+	})
 
-	heap := storage.NewHeap(pager, 0)
-	if !heap.InsertRaw(page, data) {
-		return fmt.Errorf("catalog page full")
+	table := &Table{
+		Name:   e.Name,
+		Schema: e.Schema,
+		Heap:   heap,
+		View:   e.View,
+		format: newRowFormat(e),
 	}
-	_, err = pager.WritePage(0, page)
-	return err
+	for _, ie := range e.Indexes {
+		table.Indexes = append(table.Indexes, openIndex(db.Pager, ie))
+	}
+	return table
 }
 
-// updateCatalogEntry updates an existing catalog entry in-place
-func updateCatalogEntry(pager *storage.Pager, tableName string, numPages uint32) error {
-	page, err := pager.ReadPage(0)
-	if err != nil {
+func (db *DB) CreateTable(name string, schema []types.Column) error {
+	return db.createTable(name, schema, "")
+}
+
+// createTable creates a table, or a materialized view of the query when one is given
+func (db *DB) createTable(name string, schema []types.Column, query string) error {
+	if err := db.checkNameFree(name); err != nil {
 		return err
 	}
 
-	numEntries := binary.LittleEndian.Uint16(page[0:2])
-
-	// find the entry to update
-	for i := uint16(0); i < numEntries; i++ {
-		slotOffset := storage.PAGE_HEADER_SIZE + i*storage.SLOT_SIZE
-		recordOffset := binary.LittleEndian.Uint16(page[slotOffset : slotOffset+2])
-		recordLen := binary.LittleEndian.Uint16(page[slotOffset+2 : slotOffset+4])
-		record := page[recordOffset : recordOffset+recordLen]
+	// the heap's first page and free space map come from the allocator
+	heap, err := storage.CreateHeap(db.Pager)
+	if err != nil {
+		return err
+	}
 
-		entry := DecodeCatalogEntry(record)
+	entry := CatalogEntry{
+		Name:         name,
+		StartPage:    uint64(heap.StartPage()),
+		FSMPage:      uint64(heap.FSMStartPage()),
+		NumPages:     1,
+		Schema:       append([]types.Column(nil), schema...),
+		View:         query,
+		Materialized: query != "",
+	}
+	assignColumnIDs(&entry)
 
-		if entry.Name == tableName {
+	if err := db.catalog.Insert(entry); err != nil {
+		return err
+	}
 
-			// update the entry
-			entry.NumPages = numPages
-			newRecord := EncodeCatalogEntry(entry)
+	db.Tables[name] = db.openTable(entry)
 
-			// verify sizes match (they should since we're only updating NumPages, a u32)
-			if len(newRecord) != len(record) {
-				return fmt.Errorf("catalog entry size mismatch: old=%d new=%d", len(record), len(newRecord))
-			}
+	return nil
+}
 
-			// update in place
-			copy(page[recordOffset:recordOffset+recordLen], newRecord)
-			_, err = pager.WritePage(0, page)
+/*
+Vacuum rebuilds the database compactly: every table is copied row by row into a
+fresh file next to the original, which is then renamed over it, and its
+indexes are rebuilt there. Rows are rewritten in the current schema version,
+so the copies start out with no schema history. The new file has no dead space, no free pages and
+every heap is densely packed, so the file shrinks by however many pages were wasted.
+Returns the number of pages reclaimed.
+*/
+func (db *DB) Vacuum() (int, error) {
+	before := db.Pager.NextPageID()
+
+	tmpPath := db.Pager.Path() + "-vacuum"
+	os.Remove(tmpPath)
+	dst, err := OpenDB(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := db.copyInto(dst); err != nil {
+		dst.Pager.Close()
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := dst.Pager.Close(); err != nil {
+		return 0, err
+	}
 
-			return err
-		}
+	if err := db.Pager.ReplaceWith(tmpPath); err != nil {
+		return 0, err
+	}
+	if err := db.load(); err != nil {
+		return 0, err
 	}
 
-	return fmt.Errorf("table %s not found in catalog", tableName)
+	return int(before) - int(db.Pager.NextPageID()), nil
 }
 
-func (db *DB) CreateTable(name string, schema []types.Column) error {
-	if _, exists := db.Tables[name]; exists {
-		return fmt.Errorf("table %s already exists", name)
-	}
-
-	// load fresh catalog data
-	entries, err := LoadCatalog(db.Pager)
+// copyInto recreates every table (in catalog order) in dst with its live records and indexes
+func (db *DB) copyInto(dst *DB) error {
+	entries, err := db.catalog.Entries()
 	if err != nil {
 		return err
 	}
-	// find next free page
-	var nextPage uint64 = 1
 	for _, e := range entries {
-		// each table needs at least its start page, even if NumPages=0
-		// if NumPages > 0, it occupies [StartPage, StartPage+NumPages)
-		// if NumPages = 0, it still reserves StartPage for future use
-		end := e.StartPage + uint64(e.NumPages)
-		if e.NumPages == 0 {
-			// reserve at least one page for tables with no data yet
-			end = e.StartPage + 1
+		if e.View != "" && !e.Materialized {
+			if err := dst.CreateView(e.Name, e.View, e.Schema); err != nil {
+				return err
+			}
+			continue
 		}
-		if end > nextPage {
-			nextPage = end
+		if err := dst.createTable(e.Name, e.Schema, e.View); err != nil {
+			return err
 		}
-	}
+		dstTable := dst.Tables[e.Name]
 
-	entry := CatalogEntry{
-		Name:      name,
-		StartPage: nextPage,
-		NumPages:  0,
-		Schema:    schema,
+		var insertErr error
+		err := db.Tables[e.Name].Scan(func(row types.Row) bool {
+			insertErr = dstTable.Insert(row)
+			return insertErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		if insertErr != nil {
+			return insertErr
+		}
+
+		for _, idx := range e.Indexes {
+			if err := dst.CreateIndex(idx.Name, e.Name, idx.Column, idx.Path, idx.Kind); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	if err := insertCatalogEntry(db.Pager, entry); err != nil {
+// load (re)reads the catalog and opens every table in it
+func (db *DB) load() error {
+	catalog, err := OpenCatalog(db.Pager)
+	if err != nil {
+		return err
+	}
+	entries, err := catalog.Entries()
+	if err != nil {
 		return err
 	}
 
-	heap := storage.NewHeap(db.Pager, storage.PageID(entry.StartPage))
-
-	// set callback to update catalog when heap grows
-	heap.SetGrowthCallback(func(newNumPages uint32) {
-		if err := updateCatalogEntry(db.Pager, name, newNumPages); err != nil {
-			log.Printf("ERROR updating catalog: %v", err)
+	db.catalog = catalog
+	db.Tables = make(map[string]*Table)
+	db.Views = make(map[string]*View)
+	for _, e := range entries {
+		if e.View != "" && !e.Materialized {
+			db.Views[e.Name] = openView(e)
+			continue
 		}
-	})
-
-	db.Tables[name] = &Table{
-		Name:   name,
-		Schema: schema,
-		Heap:   heap,
+		db.Tables[e.Name] = db.openTable(e)
 	}
-
 	return nil
 }
 
@@ -132,33 +189,15 @@ func OpenDB(path string) (*DB, error) {
 	}
 
 	db := &DB{
-		Tables: make(map[string]*Table),
-		Pager:  pager,
+		Tables:     make(map[string]*Table),
+		Pager:      pager,
+		scalars:    make(map[string]*ScalarFunc),
+		aggregates: make(map[string]*AggregateFunc),
 	}
 
-	entries, err := LoadCatalog(pager)
-	if err != nil {
+	if err := db.load(); err != nil {
 		return nil, err
 	}
 
-	for _, e := range entries {
-		heap := storage.NewHeap(pager, storage.PageID(e.StartPage))
-		heap.SetNumPages(e.NumPages)
-
-		// capture table name for closure
-		tableName := e.Name
-		heap.SetGrowthCallback(func(newNumPages uint32) {
-			if err := updateCatalogEntry(db.Pager, tableName, newNumPages); err != nil {
-				log.Printf("ERROR updating catalog: %v", err)
-			}
-		})
-
-		db.Tables[e.Name] = &Table{
-			Name:   e.Name,
-			Schema: e.Schema,
-			Heap:   heap,
-		}
-	}
-
 	return db, nil
 }
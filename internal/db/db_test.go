@@ -0,0 +1,441 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+func openTestDB(t *testing.T, path string) *DB {
+	t.Helper()
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Pager.Close() })
+	return db
+}
+
+func newTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	return openTestDB(t, path), path
+}
+
+func mustExec(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rows returns a table's rows, sorted by their first column
+func rows(t *testing.T, table *Table) []types.Row {
+	t.Helper()
+	var got []types.Row
+	mustExec(t, table.Scan(func(row types.Row) bool {
+		got = append(got, row)
+		return true
+	}))
+	sort.Slice(got, func(i, j int) bool { return got[i][0].(int) < got[j][0].(int) })
+	return got
+}
+
+func checkIntegrity(t *testing.T, db *DB) {
+	t.Helper()
+	if problems := db.IntegrityCheck(); len(problems) > 0 {
+		t.Fatalf("integrity check: %v", problems)
+	}
+}
+
+func TestOldRowsDecodeAfterAlter(t *testing.T) {
+	db, path := newTestDB(t)
+	mustExec(t, db.CreateTable("users", []types.Column{{Name: "id", Type: types.INT}, {Name: "name", Type: types.TEXT}}))
+	mustExec(t, db.Tables["users"].Insert(types.Row{1, "ann"}))
+
+	mustExec(t, db.AddColumn("users", types.Column{Name: "age", Type: types.INT, Default: 18}))
+	mustExec(t, db.Tables["users"].Insert(types.Row{2, "bob", 30}))
+
+	mustExec(t, db.DropColumn("users", "name"))
+	mustExec(t, db.Tables["users"].Insert(types.Row{3, 40}))
+
+	mustExec(t, db.RenameColumn("users", "age", "years"))
+	mustExec(t, db.AddColumn("users", types.Column{Name: "note", Type: types.TEXT}))
+
+	want := []types.Row{{1, 18, nil}, {2, 30, nil}, {3, 40, nil}}
+	if got := rows(t, db.Tables["users"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+	checkIntegrity(t, db)
+
+	// the history is persisted, a reopened database reads the same rows
+	mustExec(t, db.Pager.Close())
+	db = openTestDB(t, path)
+	if got := rows(t, db.Tables["users"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after reopening = %v, want %v", got, want)
+	}
+	schema := db.Tables["users"].Schema
+	if len(schema) != 3 || schema[1].Name != "years" || schema[2].Name != "note" {
+		t.Fatalf("schema after reopening = %+v", schema)
+	}
+
+	// VACUUM rewrites the rows in the current version and forgets the rest
+	_, err := db.Vacuum()
+	mustExec(t, err)
+	entry, err := db.catalog.Get("users")
+	mustExec(t, err)
+	if len(entry.History) != 0 {
+		t.Fatalf("history after VACUUM = %v, want none", entry.History)
+	}
+	if got := rows(t, db.Tables["users"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows after VACUUM = %v, want %v", got, want)
+	}
+	checkIntegrity(t, db)
+}
+
+func TestSchemaHistoryKeepsVersionsInUse(t *testing.T) {
+	db, _ := newTestDB(t)
+	// long column names make each schema version a good part of SCHEMA_HISTORY_LIMIT, so the history gets pruned
+	schema := []types.Column{{Name: "id", Type: types.INT}}
+	for i := 0; i < 5; i++ {
+		schema = append(schema, types.Column{Name: fmt.Sprintf("pad%d_%s", i, strings.Repeat("x", 90)), Type: types.INT})
+	}
+	mustExec(t, db.CreateTable("t", schema))
+	history := func() []uint16 {
+		entry, err := db.catalog.Get("t")
+		mustExec(t, err)
+		var versions []uint16
+		for _, v := range entry.History {
+			versions = append(versions, v.Version)
+		}
+		return versions
+	}
+	insert := func(id int) {
+		row := make(types.Row, len(db.Tables["t"].Schema))
+		row[0] = id
+		mustExec(t, db.Tables["t"].Insert(row))
+	}
+
+	steps := []struct {
+		name  string
+		alter func() error
+		want  []uint16
+	}{
+		{"a small entry keeps every version", func() error {
+			insert(1)
+			return db.AddColumn("t", types.Column{Name: "a", Type: types.INT})
+		}, []uint16{0}},
+		{"even those no row uses", func() error {
+			return db.AddColumn("t", types.Column{Name: "b", Type: types.INT})
+		}, []uint16{0, 1}},
+		{"a row written in version 2", func() error {
+			insert(2)
+			return db.DropColumn("t", "a")
+		}, []uint16{0, 1, 2}},
+		{"a large entry keeps the versions rows use", func() error {
+			var doomed []storage.RecordID
+			mustExec(t, db.Tables["t"].ScanRecords(func(rid storage.RecordID, row types.Row) bool {
+				if row[0] == 1 {
+					doomed = append(doomed, rid)
+				}
+				return true
+			}))
+			for _, rid := range doomed {
+				mustExec(t, db.Tables["t"].Delete(rid))
+			}
+			return db.AddColumn("t", types.Column{Name: "c", Type: types.TEXT})
+		}, []uint16{2}},
+	}
+	for _, step := range steps {
+		mustExec(t, step.alter())
+		if got := history(); !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: history versions %v, want %v", step.name, got, step.want)
+		}
+	}
+	if got := rows(t, db.Tables["t"]); len(got) != 1 || got[0][0] != 2 {
+		t.Fatalf("rows = %v, want only id 2", got)
+	}
+
+	// once the rows need more versions than the entry can hold, only VACUUM helps
+	var err error
+	for i := 0; err == nil; i++ {
+		if i == 10 {
+			t.Fatal("ADD COLUMN should fail once the history is full of versions in use")
+		}
+		insert(10 + i)
+		err = db.AddColumn("t", types.Column{Name: fmt.Sprintf("d%d", i), Type: types.INT})
+	}
+	if !strings.Contains(err.Error(), "run VACUUM") {
+		t.Fatalf("error %q should point at VACUUM", err)
+	}
+	_, err = db.Vacuum()
+	mustExec(t, err)
+	mustExec(t, db.AddColumn("t", types.Column{Name: "e", Type: types.INT}))
+	if got, want := history(), []uint16{0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("history versions after VACUUM %v, want %v", got, want)
+	}
+	checkIntegrity(t, db)
+}
+
+func TestSchemaVersionWraps(t *testing.T) {
+	db, _ := newTestDB(t)
+	mustExec(t, db.CreateTable("t", []types.Column{{Name: "id", Type: types.INT}, {Name: "x", Type: types.INT}}))
+	mustExec(t, db.Tables["t"].Insert(types.Row{1, 2}))
+	mustExec(t, db.AddColumn("t", types.Column{Name: "a", Type: types.INT}))
+	mustExec(t, db.catalog.Update("t", func(e *CatalogEntry) { e.Version = math.MaxUint16 }))
+	mustExec(t, db.reopenTable("t"))
+	mustExec(t, db.Tables["t"].Insert(types.Row{3, 4, 5}))
+
+	// the counter wraps past version 0, which the first row still uses
+	mustExec(t, db.AddColumn("t", types.Column{Name: "b", Type: types.INT}))
+	mustExec(t, db.Tables["t"].Insert(types.Row{6, 7, 8, 9}))
+	mustExec(t, db.DropColumn("t", "x"))
+
+	entry, err := db.catalog.Get("t")
+	mustExec(t, err)
+	var versions []uint16
+	for _, v := range entry.History {
+		versions = append(versions, v.Version)
+	}
+	if want := []uint16{0, math.MaxUint16, 1}; entry.Version != 2 || !reflect.DeepEqual(versions, want) {
+		t.Fatalf("version %d with history %v, want 2 with %v", entry.Version, versions, want)
+	}
+	want := []types.Row{{1, nil, nil}, {3, 5, nil}, {6, 8, 9}}
+	if got := rows(t, db.Tables["t"]); !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+	checkIntegrity(t, db)
+}
+
+func TestCatalogEntryEncoding(t *testing.T) {
+	entry := CatalogEntry{
+		Name:         "totals",
+		StartPage:    3,
+		FSMPage:      4,
+		NumPages:     2,
+		Version:      1,
+		NextColumnID: 3,
+		Schema: []types.Column{
+			{Name: "id", Type: types.INT, ID: 1, Default: 5},
+			{Name: "amount", Type: types.DECIMAL, ID: 2, Precision: 10, Scale: 2},
+		},
+		History:      []SchemaVersion{{Version: 0, Columns: []types.Column{{Name: "id", Type: types.INT, ID: 1}}}},
+		Indexes:      []IndexEntry{{Name: "totals_id", Column: "id", Kind: HASH_INDEX, Root: 9}},
+		View:         "SELECT id, amount FROM ledger",
+		Materialized: true,
+	}
+	data := EncodeCatalogEntry(entry)
+
+	got, err := DecodeCatalogEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entry) {
+		t.Fatalf("decoded %+v, want %+v", got, entry)
+	}
+
+	// every field is required, a cut short entry is as broken as one with extra bytes
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"without materialized", data[:len(data)-1]},
+		{"without the view", data[:len(data)-1-2-len(entry.View)]},
+		{"trailing bytes", append(append([]byte(nil), data...), 1, 2)},
+	}
+	for _, tt := range tests {
+		if _, err := DecodeCatalogEntry(tt.data); err == nil {
+			t.Errorf("%s: DecodeCatalogEntry should fail", tt.name)
+		}
+	}
+}
+
+func TestIndexFollowsInsertsAndDeletes(t *testing.T) {
+	db, _ := newTestDB(t)
+	mustExec(t, db.CreateTable("t", []types.Column{{Name: "id", Type: types.INT}, {Name: "k", Type: types.TEXT}}))
+	table := db.Tables["t"]
+	for i := 0; i < 200; i++ {
+		mustExec(t, table.Insert(types.Row{i, []string{"a", "b", "c"}[i%3]}))
+	}
+	mustExec(t, db.CreateIndex("t_k", "t", "k", "", HASH_INDEX))
+
+	lookup := func(key string) []int {
+		var ids []int
+		mustExec(t, table.LookupRecords(table.IndexOn("k"), key, func(_ storage.RecordID, row types.Row) bool {
+			ids = append(ids, row[0].(int))
+			return true
+		}))
+		return ids
+	}
+	if n := len(lookup("b")); n != 67 {
+		t.Fatalf("found %d rows for b, want 67", n)
+	}
+
+	var doomed []storage.RecordID
+	mustExec(t, table.ScanRecords(func(rid storage.RecordID, row types.Row) bool {
+		if row[1] == "b" && row[0].(int) < 100 {
+			doomed = append(doomed, rid)
+		}
+		return true
+	}))
+	for _, rid := range doomed {
+		mustExec(t, table.Delete(rid))
+	}
+	mustExec(t, table.Insert(types.Row{1000, "b"}))
+	if n := len(lookup("b")); n != 67-len(doomed)+1 {
+		t.Fatalf("found %d rows for b after deletes, want %d", n, 67-len(doomed)+1)
+	}
+	checkIntegrity(t, db)
+}
+
+func TestDropAndTruncateFreePages(t *testing.T) {
+	db, _ := newTestDB(t)
+	schema := []types.Column{{Name: "id", Type: types.INT}, {Name: "pad", Type: types.TEXT}}
+	mustExec(t, db.CreateTable("a", schema))
+	mustExec(t, db.CreateTable("b", schema))
+	for i := 0; i < 300; i++ {
+		mustExec(t, db.Tables["a"].Insert(types.Row{i, "0123456789012345678901234567890123456789"}))
+		mustExec(t, db.Tables["b"].Insert(types.Row{i, "x"}))
+	}
+	mustExec(t, db.CreateIndex("a_id", "a", "id", "", HASH_INDEX))
+	mustExec(t, db.CreateIndex("b_id", "b", "id", "", HASH_INDEX))
+
+	mustExec(t, db.TruncateTable("b"))
+	if got := rows(t, db.Tables["b"]); len(got) != 0 {
+		t.Fatalf("truncated table still has %d rows", len(got))
+	}
+	if db.Tables["b"].IndexOn("id") == nil {
+		t.Fatal("TRUNCATE should keep the table's indexes")
+	}
+	mustExec(t, db.DropTable("a"))
+	if _, ok := db.Tables["a"]; ok {
+		t.Fatal("dropped table is still open")
+	}
+	free, err := db.Pager.FreeList()
+	mustExec(t, err)
+	if len(free) == 0 {
+		t.Fatal("dropping a table should return its pages to the free list")
+	}
+	checkIntegrity(t, db)
+
+	// VACUUM leaves no free pages behind
+	_, err = db.Vacuum()
+	mustExec(t, err)
+	free, err = db.Pager.FreeList()
+	mustExec(t, err)
+	if len(free) != 0 {
+		t.Fatalf("%d free pages after VACUUM", len(free))
+	}
+	checkIntegrity(t, db)
+}
+
+func TestViewsShareTheTableNamespace(t *testing.T) {
+	db, path := newTestDB(t)
+	schema := []types.Column{{Name: "id", Type: types.INT}}
+	mustExec(t, db.CreateTable("t", schema))
+	mustExec(t, db.CreateView("v", "SELECT id FROM t", schema))
+	mustExec(t, db.CreateMaterializedView("m", "SELECT id FROM t", schema))
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"table over a view", db.CreateTable("v", schema)},
+		{"view over a table", db.CreateView("t", "SELECT 1", schema)},
+		{"view over a materialized view", db.CreateView("m", "SELECT 1", schema)},
+		{"drop a view as a table", db.DropTable("v")},
+		{"drop a materialized view as a table", db.DropTable("m")},
+		{"truncate a materialized view", db.TruncateTable("m")},
+		{"drop a materialized view as a view", db.DropView("m")},
+		{"drop a table as a view", db.DropView("t")},
+	}
+	for _, tt := range tests {
+		if tt.err == nil {
+			t.Errorf("%s: should fail", tt.name)
+		}
+	}
+
+	mustExec(t, db.Pager.Close())
+	db = openTestDB(t, path)
+	if db.Views["v"] == nil || db.Tables["m"] == nil || db.Tables["m"].View == "" {
+		t.Fatal("views should survive reopening the database")
+	}
+	mustExec(t, db.DropView("v"))
+	mustExec(t, db.DropMaterializedView("m"))
+	checkIntegrity(t, db)
+}
+
+// usedPages counts the pages of the file that aren't on the free list
+func usedPages(t *testing.T, db *DB) int {
+	t.Helper()
+	free, err := db.Pager.FreeList()
+	mustExec(t, err)
+	return int(db.Pager.NextPageID()) - len(free)
+}
+
+func TestRefreshMaterializedView(t *testing.T) {
+	db, path := newTestDB(t)
+	schema := []types.Column{{Name: "id", Type: types.INT}, {Name: "k", Type: types.TEXT}}
+	fillWith := func(n int, fail error) func(*Table) error {
+		return func(table *Table) error {
+			for i := 0; i < n; i++ {
+				if err := table.Insert(types.Row{i, []string{"a", "b"}[i%2]}); err != nil {
+					return err
+				}
+			}
+			return fail
+		}
+	}
+	lookup := func(key string) int {
+		table := db.Tables["m"]
+		n := 0
+		mustExec(t, table.LookupRecords(table.IndexOn("k"), key, func(storage.RecordID, types.Row) bool {
+			n++
+			return true
+		}))
+		return n
+	}
+
+	mustExec(t, db.CreateMaterializedView("m", "SELECT id, k FROM t", schema))
+	mustExec(t, fillWith(100, nil)(db.Tables["m"]))
+	mustExec(t, db.CreateIndex("m_k", "m", "k", "", HASH_INDEX))
+	before := usedPages(t, db)
+
+	// a refresh that fails part way leaves the old rows and index, and no pages behind
+	failure := errors.New("query failed")
+	if err := db.RefreshMaterializedView("m", fillWith(1000, failure)); !errors.Is(err, failure) {
+		t.Fatalf("refresh error = %v, want %v", err, failure)
+	}
+	if n := len(rows(t, db.Tables["m"])); n != 100 || lookup("a") != 50 {
+		t.Fatalf("after a failed refresh: %d rows, %d under a; want 100 and 50", n, lookup("a"))
+	}
+	if used := usedPages(t, db); used != before {
+		t.Fatalf("%d pages in use after a failed refresh, want %d", used, before)
+	}
+	checkIntegrity(t, db)
+
+	// a refresh that succeeds switches rows and index together and frees the old ones
+	mustExec(t, db.RefreshMaterializedView("m", fillWith(10, nil)))
+	if n := len(rows(t, db.Tables["m"])); n != 10 || lookup("a") != 5 {
+		t.Fatalf("after a refresh: %d rows, %d under a; want 10 and 5", n, lookup("a"))
+	}
+	if used := usedPages(t, db); used > before {
+		t.Fatalf("%d pages in use after refreshing with fewer rows, had %d", used, before)
+	}
+	checkIntegrity(t, db)
+
+	mustExec(t, db.Pager.Close())
+	db = openTestDB(t, path)
+	if n := len(rows(t, db.Tables["m"])); n != 10 || lookup("b") != 5 {
+		t.Fatalf("after reopening: %d rows, %d under b; want 10 and 5", n, lookup("b"))
+	}
+	checkIntegrity(t, db)
+}
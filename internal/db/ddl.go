@@ -0,0 +1,220 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+// DropTable removes a table and its indexes, returning all of their pages to the allocator
+func (db *DB) DropTable(name string) error {
+	table, err := db.alterableTable(name)
+	if err != nil {
+		return err
+	}
+
+	if err := db.catalog.Delete(name); err != nil {
+		return err
+	}
+	delete(db.Tables, name)
+
+	return freeTable(table)
+}
+
+// TruncateTable empties a table (and its indexes) without dropping it
+func (db *DB) TruncateTable(name string) error {
+	table, err := db.alterableTable(name)
+	if err != nil {
+		return err
+	}
+
+	heap, err := storage.CreateHeap(db.Pager)
+	if err != nil {
+		return err
+	}
+
+	// no rows are left to need the old schema versions
+	err = db.catalog.Update(name, func(e *CatalogEntry) {
+		e.StartPage = uint64(heap.StartPage())
+		e.FSMPage = uint64(heap.FSMStartPage())
+		e.NumPages = 1
+		e.History = nil
+		e.Indexes = nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := freeTable(table); err != nil {
+		return err
+	}
+	if err := db.reopenTable(name); err != nil {
+		return err
+	}
+
+	for _, idx := range table.Indexes {
+		if err := db.CreateIndex(idx.Name, name, idx.Column, idx.Path, idx.Kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+AddColumn appends a column to a table. Existing rows aren't touched: the
+column's Default is recorded in the catalog and rows written under the older
+schema version pick it up when they are decoded.
+*/
+func (db *DB) AddColumn(tableName string, col types.Column) error {
+	table, err := db.alterableTable(tableName)
+	if err != nil {
+		return err
+	}
+	if columnIndex(table.Schema, col.Name) >= 0 {
+		return fmt.Errorf("column %s already exists in table %s", col.Name, tableName)
+	}
+	entry, err := db.catalog.Get(tableName)
+	if err != nil {
+		return err
+	}
+	used, err := historyInUse(table, entry)
+	if err != nil {
+		return err
+	}
+
+	err = db.catalog.Update(tableName, func(e *CatalogEntry) {
+		col.ID = e.NextColumnID
+		e.NextColumnID++
+		newSchemaVersion(e, append(append([]types.Column(nil), e.Schema...), col), used)
+	})
+	if err != nil {
+		return err
+	}
+	return db.reopenTable(tableName)
+}
+
+/*
+DropColumn removes a column from a table, along with any index on it.
+Like AddColumn this only starts a new schema version, the dropped values stay
+in old rows (and are skipped when decoding) until VACUUM rewrites them.
+*/
+func (db *DB) DropColumn(tableName, column string) error {
+	table, err := db.alterableTable(tableName)
+	if err != nil {
+		return err
+	}
+	col := columnIndex(table.Schema, column)
+	if col < 0 {
+		return fmt.Errorf("table %s has no column %s", tableName, column)
+	}
+	if len(table.Schema) == 1 {
+		return fmt.Errorf("cannot drop %s, the only column of table %s", column, tableName)
+	}
+
+	entry, err := db.catalog.Get(tableName)
+	if err != nil {
+		return err
+	}
+	used, err := historyInUse(table, entry)
+	if err != nil {
+		return err
+	}
+
+	var dropped []*Index
+	for _, idx := range table.Indexes {
+		if strings.EqualFold(idx.Column, table.Schema[col].Name) {
+			dropped = append(dropped, idx)
+		}
+	}
+
+	err = db.catalog.Update(tableName, func(e *CatalogEntry) {
+		schema := append(append([]types.Column(nil), e.Schema[:col]...), e.Schema[col+1:]...)
+		newSchemaVersion(e, schema, used)
+
+		var kept []IndexEntry
+		for _, idx := range e.Indexes {
+			if !strings.EqualFold(idx.Column, table.Schema[col].Name) {
+				kept = append(kept, idx)
+			}
+		}
+		e.Indexes = kept
+	})
+	if err != nil {
+		return err
+	}
+	for _, idx := range dropped {
+		if err := idx.store.Free(); err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+	}
+	return db.reopenTable(tableName)
+}
+
+// RenameColumn renames a column in place, indexes on it follow the new name
+func (db *DB) RenameColumn(tableName, from, to string) error {
+	table, err := db.alterableTable(tableName)
+	if err != nil {
+		return err
+	}
+	col := columnIndex(table.Schema, from)
+	if col < 0 {
+		return fmt.Errorf("table %s has no column %s", tableName, from)
+	}
+	if other := columnIndex(table.Schema, to); other >= 0 && other != col {
+		return fmt.Errorf("column %s already exists in table %s", to, tableName)
+	}
+
+	oldName := table.Schema[col].Name
+	err = db.catalog.Update(tableName, func(e *CatalogEntry) {
+		e.Schema[col].Name = to
+		for i := range e.Indexes {
+			if strings.EqualFold(e.Indexes[i].Column, oldName) {
+				e.Indexes[i].Column = to
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return db.reopenTable(tableName)
+}
+
+// RenameTable gives a table a new name, only its catalog entry changes
+func (db *DB) RenameTable(from, to string) error {
+	if _, err := db.alterableTable(from); err != nil {
+		return err
+	}
+	if err := db.checkNameFree(to); err != nil {
+		return err
+	}
+
+	err := db.catalog.Update(from, func(e *CatalogEntry) {
+		e.Name = to
+	})
+	if err != nil {
+		return err
+	}
+	delete(db.Tables, from)
+	return db.reopenTable(to)
+}
+
+// reopenTable reloads a table from its catalog entry, e.g. after the entry was rewritten
+func (db *DB) reopenTable(name string) error {
+	entry, err := db.catalog.Get(name)
+	if err != nil {
+		return err
+	}
+	db.Tables[name] = db.openTable(entry)
+	return nil
+}
+
+// freeTable releases the pages of a table's heap and indexes
+func freeTable(table *Table) error {
+	for _, idx := range table.Indexes {
+		if err := idx.store.Free(); err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+	}
+	return table.Heap.Free()
+}
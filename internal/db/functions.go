@@ -0,0 +1,308 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Go functions can be registered on a DB to be called from SQL. Their parameter
+and result types are mapped to SQL types by reflection:
+  - int and its sized variants are INT, float32 and float64 FLOAT, string
+    TEXT, bool BOOLEAN and []byte BLOB
+  - time.Time is a TIMESTAMP (in UTC)
+  - types.Date, types.Timestamp, types.Interval, types.Decimal, types.Char and
+    types.JSONValue are DATE, TIMESTAMP, INTERVAL, DECIMAL, CHAR and JSON
+A function may also return an error as its last result, which fails the
+statement calling it. Registered functions are not persisted, they have to be
+registered again each time the database is opened.
+*/
+
+// Signature is the SQL side of a registered function
+type Signature struct {
+	Name   string
+	Params []types.DataType
+	// Variadic functions take any number of arguments of their last parameter's type
+	Variadic bool
+	Result   types.DataType
+}
+
+// ScalarFunc is a Go function called once per row
+type ScalarFunc struct {
+	Signature
+	fn reflect.Value
+}
+
+/*
+AggregateFunc folds the rows of a query into one value. It is registered as a
+constructor, func() *T, where *T has the methods
+  - Step(args...) to take in one row's arguments, optionally returning an error
+  - Done() to give the result, optionally with an error
+
+A fresh value is constructed for each aggregation.
+*/
+type AggregateFunc struct {
+	Signature
+	constructor reflect.Value
+}
+
+// AggregateState is one running aggregation
+type AggregateState struct {
+	fn    *AggregateFunc
+	state reflect.Value
+}
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	timeType  = reflect.TypeOf(time.Time{})
+
+	// the types package types, checked before falling back on the kind (a Date is an int32)
+	valueTypes = map[reflect.Type]types.DataType{
+		reflect.TypeOf(types.Date(0)):       types.DATE,
+		reflect.TypeOf(types.Timestamp(0)):  types.TIMESTAMP,
+		reflect.TypeOf(types.Interval{}):    types.INTERVAL,
+		reflect.TypeOf(types.Decimal{}):     types.DECIMAL,
+		reflect.TypeOf(types.Char("")):      types.CHAR,
+		reflect.TypeOf(types.JSONValue("")): types.JSON,
+		reflect.TypeOf([]byte(nil)):         types.BLOB,
+		timeType:                            types.TIMESTAMP,
+	}
+)
+
+// RegisterFunc makes a Go function callable from SQL as a scalar function,
+// e.g. RegisterFunc("luhn_check", func(string) bool {...})
+func (db *DB) RegisterFunc(name string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		return fmt.Errorf("function %s: expected a func, got %T", name, fn)
+	}
+	sig, err := signatureOf(name, v.Type())
+	if err != nil {
+		return err
+	}
+	db.scalars[strings.ToUpper(name)] = &ScalarFunc{Signature: sig, fn: v}
+	return nil
+}
+
+// RegisterAggregate makes a Go type callable from SQL as an aggregate function,
+// see AggregateFunc for what constructor has to look like
+func (db *DB) RegisterAggregate(name string, constructor any) error {
+	v := reflect.ValueOf(constructor)
+	t := v.Type()
+	if v.Kind() != reflect.Func || t.NumIn() != 0 || t.NumOut() != 1 {
+		return fmt.Errorf("aggregate %s: expected a constructor func() *T, got %T", name, constructor)
+	}
+	state := t.Out(0)
+	step, hasStep := state.MethodByName("Step")
+	done, hasDone := state.MethodByName("Done")
+	if !hasStep || !hasDone {
+		return fmt.Errorf("aggregate %s: %s needs Step and Done methods", name, state)
+	}
+
+	// the method types include the receiver as their first parameter
+	sig := Signature{Name: strings.ToUpper(name)}
+	var err error
+	if sig.Params, sig.Variadic, err = paramsOf(name, step.Type, 1); err != nil {
+		return err
+	}
+	if n := step.Type.NumOut(); n > 1 || (n == 1 && step.Type.Out(0) != errorType) {
+		return fmt.Errorf("aggregate %s: Step can only return an error", name)
+	}
+	if done.Type.NumIn() != 1 {
+		return fmt.Errorf("aggregate %s: Done takes no arguments", name)
+	}
+	if sig.Result, err = resultOf(name, done.Type); err != nil {
+		return err
+	}
+
+	db.aggregates[strings.ToUpper(name)] = &AggregateFunc{Signature: sig, constructor: v}
+	return nil
+}
+
+// ScalarFunc looks up a registered scalar function, names are case insensitive
+func (db *DB) ScalarFunc(name string) (*ScalarFunc, bool) {
+	fn, ok := db.scalars[strings.ToUpper(name)]
+	return fn, ok
+}
+
+// AggregateFunc looks up a registered aggregate function, names are case insensitive
+func (db *DB) AggregateFunc(name string) (*AggregateFunc, bool) {
+	fn, ok := db.aggregates[strings.ToUpper(name)]
+	return fn, ok
+}
+
+// signatureOf maps a func's parameters and result to SQL types
+func signatureOf(name string, t reflect.Type) (Signature, error) {
+	sig := Signature{Name: strings.ToUpper(name)}
+	var err error
+	if sig.Params, sig.Variadic, err = paramsOf(name, t, 0); err != nil {
+		return Signature{}, err
+	}
+	if sig.Result, err = resultOf(name, t); err != nil {
+		return Signature{}, err
+	}
+	return sig, nil
+}
+
+// paramsOf maps a func's parameters to SQL types, skipping the first skip of them (a method's receiver)
+func paramsOf(name string, t reflect.Type, skip int) ([]types.DataType, bool, error) {
+	var params []types.DataType
+	for i := skip; i < t.NumIn(); i++ {
+		in := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			in = in.Elem()
+		}
+		dt, ok := dataTypeOf(in)
+		if !ok {
+			return nil, false, fmt.Errorf("function %s: parameter %d has unsupported type %s", name, i-skip+1, in)
+		}
+		params = append(params, dt)
+	}
+	return params, t.IsVariadic(), nil
+}
+
+// resultOf maps a func's result to a SQL type, it may be followed by an error
+func resultOf(name string, t reflect.Type) (types.DataType, error) {
+	out := t.NumOut()
+	if out == 0 || out > 2 || (out == 2 && t.Out(1) != errorType) {
+		return 0, fmt.Errorf("function %s: expected one result, optionally followed by an error", name)
+	}
+	dt, ok := dataTypeOf(t.Out(0))
+	if !ok {
+		return 0, fmt.Errorf("function %s: unsupported result type %s", name, t.Out(0))
+	}
+	return dt, nil
+}
+
+func dataTypeOf(t reflect.Type) (types.DataType, bool) {
+	if dt, ok := valueTypes[t]; ok {
+		return dt, true
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return types.INT, true
+	case reflect.Float32, reflect.Float64:
+		return types.FLOAT, true
+	case reflect.String:
+		return types.TEXT, true
+	case reflect.Bool:
+		return types.BOOLEAN, true
+	default:
+		return 0, false
+	}
+}
+
+// Call runs the function on arguments already converted to its parameter types
+func (f *ScalarFunc) Call(args []types.Value) (types.Value, error) {
+	return call(f.Name, f.fn, args)
+}
+
+// Start constructs the state for a new aggregation
+func (f *AggregateFunc) Start() *AggregateState {
+	return &AggregateState{fn: f, state: f.constructor.Call(nil)[0]}
+}
+
+// Step feeds the state one row's arguments, converted to the function's parameter types
+func (s *AggregateState) Step(args []types.Value) error {
+	_, err := call(s.fn.Name, s.state.MethodByName("Step"), args)
+	return err
+}
+
+// Done is the aggregation's result
+func (s *AggregateState) Done() (types.Value, error) {
+	return call(s.fn.Name, s.state.MethodByName("Done"), nil)
+}
+
+// call converts the arguments to Go, calls fn and converts its result back, turning a panic into an error
+func call(name string, fn reflect.Value, args []types.Value) (result types.Value, err error) {
+	t := fn.Type()
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		pt := t.In(min(i, t.NumIn()-1))
+		if t.IsVariadic() && i >= t.NumIn()-1 {
+			pt = pt.Elem()
+		}
+		if in[i], err = toGo(arg, pt); err != nil {
+			return nil, fmt.Errorf("%s argument %d: %w", name, i+1, err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, fmt.Errorf("%s: %v", name, r)
+		}
+	}()
+	out := fn.Call(in)
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if e := out[len(out)-1]; !e.IsNil() {
+			return nil, fmt.Errorf("%s: %w", name, e.Interface().(error))
+		}
+		out = out[:len(out)-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return fromGo(out[0])
+}
+
+func toGo(v types.Value, t reflect.Type) (reflect.Value, error) {
+	if ts, ok := v.(types.Timestamp); ok && t == timeType {
+		return reflect.ValueOf(ts.Time()), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Type() == t:
+		return rv, nil
+	case rv.Kind() == reflect.Int && t.Kind() != reflect.String && rv.CanConvert(t):
+		converted := rv.Convert(t)
+		if converted.Convert(rv.Type()).Int() != rv.Int() {
+			return reflect.Value{}, fmt.Errorf("%d is out of range for %s", v, t)
+		}
+		return converted, nil
+	case rv.Kind() == reflect.Float64 && (t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64),
+		rv.Kind() == reflect.String && t.Kind() == reflect.String:
+		return rv.Convert(t), nil
+	}
+	return reflect.Value{}, fmt.Errorf("cannot pass %T as %s", v, t)
+}
+
+func fromGo(rv reflect.Value) (types.Value, error) {
+	if rv.Type() == timeType {
+		return types.TimestampOf(rv.Interface().(time.Time)), nil
+	}
+	if _, ok := valueTypes[rv.Type()]; ok {
+		v := rv.Interface()
+		switch x := v.(type) {
+		case []byte:
+			if x == nil {
+				return nil, nil
+			}
+		case types.Decimal:
+			if x.Unscaled == nil {
+				return nil, fmt.Errorf("returned a DECIMAL without a value")
+			}
+		case types.JSONValue:
+			return types.ParseJSON(string(x))
+		}
+		return v, nil
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return rv.Bool(), nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %s", rv.Type())
+	}
+}
@@ -0,0 +1,320 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+type IndexKind uint8
+
+const (
+	HASH_INDEX IndexKind = iota
+	BTREE_INDEX
+)
+
+func (k IndexKind) String() string {
+	switch k {
+	case HASH_INDEX:
+		return "HASH"
+	case BTREE_INDEX:
+		return "BTREE"
+	default:
+		return fmt.Sprintf("IndexKind(%d)", uint8(k))
+	}
+}
+
+// Index is a secondary index over one column of a table, or over the text at
+// a path inside a JSON column when Path is set
+type Index struct {
+	Name   string
+	Column string
+	Path   string
+	Kind   IndexKind
+	store  keyStore
+}
+
+// keyStore is the structure on disk an index keeps its keys in, an extendible hash or a B+tree
+type keyStore interface {
+	Root() storage.PageID
+	Insert(key []byte, rid storage.RecordID) error
+	Delete(key []byte, rid storage.RecordID) error
+	Lookup(key []byte) ([]storage.RecordID, error)
+	Scan(cb func(key []byte, rid storage.RecordID) bool) error
+	Check() ([]storage.PageID, []string)
+	Free() error
+}
+
+// IndexEntry is how an index is recorded in its table's catalog entry
+type IndexEntry struct {
+	Name   string
+	Column string
+	Path   string
+	Kind   IndexKind
+	Root   uint64
+}
+
+func openIndex(pager *storage.Pager, e IndexEntry) *Index {
+	return &Index{
+		Name:   e.Name,
+		Column: e.Column,
+		Path:   e.Path,
+		Kind:   e.Kind,
+		store:  openStore(pager, e.Kind, storage.PageID(e.Root)),
+	}
+}
+
+func openStore(pager *storage.Pager, kind IndexKind, root storage.PageID) keyStore {
+	if kind == BTREE_INDEX {
+		return storage.OpenBTree(pager, root)
+	}
+	return storage.OpenHashIndex(pager, root)
+}
+
+// createStore allocates an empty index of the given kind
+func createStore(pager *storage.Pager, kind IndexKind) (keyStore, error) {
+	if kind == BTREE_INDEX {
+		tree, err := storage.CreateBTree(pager)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
+	}
+	hash, err := storage.CreateHashIndex(pager)
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// maxKeySize is the largest key an index of the given kind takes
+func maxKeySize(kind IndexKind) int {
+	if kind == BTREE_INDEX {
+		return storage.BTREE_MAX_KEY_SIZE
+	}
+	return storage.HASH_MAX_KEY_SIZE
+}
+
+// entry is how the index is recorded in the catalog
+func (idx *Index) entry() IndexEntry {
+	return IndexEntry{Name: idx.Name, Column: idx.Column, Path: idx.Path, Kind: idx.Kind, Root: uint64(idx.store.Root())}
+}
+
+// Describe names what the index covers, e.g. "email" or "body ->> '$.status'"
+func (idx *Index) Describe() string {
+	if idx.Path == "" {
+		return idx.Column
+	}
+	return fmt.Sprintf("%s ->> '%s'", idx.Column, idx.Path)
+}
+
+// A BTREE index keeps its keys in byte order. Text keys are a tag and the text
+// itself, so they sort like the text and those sharing a prefix form one range;
+// other values only need to be found again and keep the row encoding.
+const (
+	nullKey byte = iota
+	textKey
+	valueKey
+)
+
+// encodeKey turns a column value into index key bytes for an index of the given kind.
+// INT values in FLOAT columns are widened and decimals lose trailing zeros, so
+// every spelling of a number finds the same key.
+func encodeKey(kind IndexKind, v types.Value, t types.DataType) []byte {
+	if i, ok := v.(int); ok && t == types.FLOAT {
+		v = float64(i)
+	}
+	if d, ok := v.(types.Decimal); ok {
+		v = d.Normalize()
+	}
+	if kind != BTREE_INDEX {
+		return storage.EncodeRow(types.Row{v})
+	}
+	switch v := v.(type) {
+	case nil:
+		return []byte{nullKey}
+	case string:
+		return append([]byte{textKey}, v...)
+	default:
+		return append([]byte{valueKey}, storage.EncodeRow(types.Row{v})...)
+	}
+}
+
+// rowKey is the key a row is indexed under given its value for the indexed column:
+// the value itself, or for an index on a JSON path the text found there (NULL when
+// there is nothing, as ->> gives)
+func rowKey(kind IndexKind, v types.Value, t types.DataType, path string) []byte {
+	if path == "" {
+		return encodeKey(kind, v, t)
+	}
+	return encodeKey(kind, jsonPathText(v, path), types.TEXT)
+}
+
+func jsonPathText(v types.Value, path string) types.Value {
+	doc, ok := v.(types.JSONValue)
+	if !ok {
+		return nil
+	}
+	// paths are checked when the index is created
+	p, _ := types.ParseJSONPath(path)
+	found, ok := doc.Extract(p)
+	if !ok {
+		return nil
+	}
+	return found.Text()
+}
+
+func columnIndex(schema []types.Column, name string) int {
+	for i, col := range schema {
+		if strings.EqualFold(col.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndexOn returns the index covering a column, if there is one
+func (t *Table) IndexOn(column string) *Index {
+	return t.IndexOnPath(column, "")
+}
+
+// IndexOnPath returns the index covering the text at a JSON path in a column, if there is one
+func (t *Table) IndexOnPath(column, path string) *Index {
+	for _, idx := range t.Indexes {
+		if strings.EqualFold(idx.Column, column) && idx.Path == path {
+			return idx
+		}
+	}
+	return nil
+}
+
+func (t *Table) indexKey(idx *Index, row types.Row) []byte {
+	col := columnIndex(t.Schema, idx.Column)
+	return rowKey(idx.Kind, row[col], t.Schema[col].Type, idx.Path)
+}
+
+// CheckKeys makes sure every index takes a row's key, before any of the row is written
+func (t *Table) CheckKeys(row types.Row) error {
+	for _, idx := range t.Indexes {
+		if n := len(t.indexKey(idx, row)); n > maxKeySize(idx.Kind) {
+			return fmt.Errorf("index %s: key of %d bytes is too large, a %s index takes at most %d", idx.Name, n, idx.Kind, maxKeySize(idx.Kind))
+		}
+	}
+	return nil
+}
+
+// LookupRecords visits the rows whose indexed column (or the text at its JSON path) equals value
+func (t *Table) LookupRecords(idx *Index, value types.Value, cb func(storage.RecordID, types.Row) bool) error {
+	keyType := types.TEXT
+	if idx.Path == "" {
+		keyType = t.Schema[columnIndex(t.Schema, idx.Column)].Type
+	}
+	rids, err := idx.store.Lookup(encodeKey(idx.Kind, value, keyType))
+	if err != nil {
+		return err
+	}
+	return t.visitRecords(idx, rids, cb)
+}
+
+// LookupPrefix visits the rows whose indexed text starts with prefix, one range of a BTREE index
+func (t *Table) LookupPrefix(idx *Index, prefix string, cb func(storage.RecordID, types.Row) bool) error {
+	tree, ok := idx.store.(*storage.BTree)
+	if !ok {
+		return fmt.Errorf("index %s is a %s index, only a BTREE index is ordered", idx.Name, idx.Kind)
+	}
+	start := encodeKey(idx.Kind, prefix, types.TEXT)
+	var rids []storage.RecordID
+	err := tree.ScanFrom(start, func(key []byte, rid storage.RecordID) bool {
+		if !bytes.HasPrefix(key, start) {
+			return false
+		}
+		rids = append(rids, rid)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return t.visitRecords(idx, rids, cb)
+}
+
+// visitRecords reads the rows an index lookup found
+func (t *Table) visitRecords(idx *Index, rids []storage.RecordID, cb func(storage.RecordID, types.Row) bool) error {
+	for _, rid := range rids {
+		data, err := t.Heap.Get(rid)
+		if err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+		row, err := t.format.decode(data)
+		if err != nil {
+			return err
+		}
+		if !cb(rid, row) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// CreateIndex indexes a column, or with a path the text at that path in a JSON column
+func (db *DB) CreateIndex(name, tableName, column, path string, kind IndexKind) error {
+	table, err := db.lookupTable(tableName)
+	if err != nil {
+		return err
+	}
+	for _, t := range db.Tables {
+		for _, idx := range t.Indexes {
+			if strings.EqualFold(idx.Name, name) {
+				return fmt.Errorf("index %s already exists", name)
+			}
+		}
+	}
+	col := columnIndex(table.Schema, column)
+	if col < 0 {
+		return fmt.Errorf("table %s has no column %s", tableName, column)
+	}
+	if kind != HASH_INDEX && kind != BTREE_INDEX {
+		return fmt.Errorf("unsupported index type %s", kind)
+	}
+	if path != "" {
+		if table.Schema[col].Type != types.JSON {
+			return fmt.Errorf("cannot index a JSON path of %s, it is %s", column, table.Schema[col].TypeName())
+		}
+		p, err := types.ParseJSONPath(path)
+		if err != nil {
+			return err
+		}
+		path = p.String()
+	}
+
+	store, err := createStore(db.Pager, kind)
+	if err != nil {
+		return err
+	}
+	idx := &Index{Name: name, Column: table.Schema[col].Name, Path: path, Kind: kind, store: store}
+
+	// index the rows that are already there
+	var insertErr error
+	err = table.ScanRecords(func(rid storage.RecordID, row types.Row) bool {
+		insertErr = store.Insert(table.indexKey(idx, row), rid)
+		return insertErr == nil
+	})
+	if err == nil {
+		err = insertErr
+	}
+	if err != nil {
+		store.Free()
+		return err
+	}
+
+	err = db.catalog.Update(tableName, func(e *CatalogEntry) {
+		e.Indexes = append(e.Indexes, idx.entry())
+	})
+	if err != nil {
+		return err
+	}
+	table.Indexes = append(table.Indexes, idx)
+	return nil
+}
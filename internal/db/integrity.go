@@ -0,0 +1,274 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+CheckIntegrity walks the meta page, the free list, the catalog and every heap
+in the file and reports all the problems it finds, it doesn't stop at the first one:
+  - pages that fail their checksum or can't be read
+  - page chains that loop or point past the end of the file
+  - slot offsets/lengths that fall outside the page bounds or overlap
+  - records that don't decode against their table's schema
+  - overflow chains that are broken or don't add up to their record's length
+  - pages claimed by more than one table (or by a table and the free list)
+  - pages that nothing refers to
+  - catalog page counts that disagree with the heap's chain
+  - free space maps that list the wrong pages or stale free space
+  - hash indexes with misplaced entries, or that disagree with their table's rows
+
+An empty slice means the database is consistent.
+*/
+func CheckIntegrity(pager *storage.Pager) []string {
+	c := &checker{pager: pager, owners: make(map[storage.PageID]string)}
+
+	if pager.NextPageID() == 0 {
+		c.report("file is empty: missing meta page")
+		return c.problems
+	}
+
+	c.claim(storage.META_PAGE, "meta page")
+	freePages, err := pager.FreeList()
+	if err != nil {
+		c.report("%v", err)
+	}
+	for _, id := range freePages {
+		c.claim(id, "free list")
+	}
+
+	entries := c.checkCatalog()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		if seen[entry.Name] {
+			c.report("catalog: table %s is defined more than once", entry.Name)
+		}
+		seen[entry.Name] = true
+		// a view is only its catalog entry, a materialized one has a heap like a table
+		if entry.View == "" || entry.Materialized {
+			c.checkTable(entry)
+		}
+	}
+
+	for id := storage.PageID(0); id < pager.NextPageID(); id++ {
+		if _, ok := c.owners[id]; !ok {
+			c.report("page %d is not used by anything (leaked)", id)
+		}
+	}
+
+	return c.problems
+}
+
+type checker struct {
+	pager    *storage.Pager
+	owners   map[storage.PageID]string
+	problems []string
+}
+
+func (c *checker) report(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// claim records who a page belongs to, flagging pages with two owners
+func (c *checker) claim(id storage.PageID, owner string) {
+	if prev, ok := c.owners[id]; ok {
+		c.report("page %d is claimed by both %s and %s", id, prev, owner)
+		return
+	}
+	c.owners[id] = owner
+}
+
+// checkHeap walks a heap's page chain and free space map and returns the pages that passed the slotted page checks
+func (c *checker) checkHeap(start, fsm storage.PageID, owner string) ([]storage.PageID, map[storage.PageID][]byte) {
+	chain, err := storage.WalkChain(c.pager, start)
+	if err != nil {
+		c.report("%s: %v", owner, err)
+	}
+
+	pages := make(map[storage.PageID][]byte)
+	for _, id := range chain {
+		c.claim(id, owner)
+		page, err := c.pager.ReadPage(id)
+		if err != nil {
+			// WalkChain already reported it
+			continue
+		}
+		pageProblems := storage.CheckPage(page)
+		for _, p := range pageProblems {
+			c.report("%s page %d: %s", owner, id, p)
+		}
+		if len(pageProblems) == 0 {
+			pages[id] = page
+		}
+	}
+
+	c.checkFSM(fsm, owner, chain, pages)
+	return chain, pages
+}
+
+// checkFSM makes sure a free space map lists exactly the heap's pages, in order, with up to date buckets
+func (c *checker) checkFSM(fsm storage.PageID, owner string, chain []storage.PageID, pages map[storage.PageID][]byte) {
+	fsmOwner := owner + " free space map"
+	fsmChain, err := storage.WalkChain(c.pager, fsm)
+	for _, id := range fsmChain {
+		c.claim(id, fsmOwner)
+	}
+	if err != nil {
+		c.report("%s: %v", fsmOwner, err)
+		return
+	}
+
+	listed, free, _, err := storage.ReadFSM(c.pager, fsm)
+	if err != nil {
+		c.report("%s: %v", fsmOwner, err)
+		return
+	}
+	if len(listed) != len(chain) {
+		c.report("%s: lists %d pages, heap chain has %d", fsmOwner, len(listed), len(chain))
+		return
+	}
+	for i, id := range chain {
+		if listed[i] != id {
+			c.report("%s: entry %d is page %d, heap chain has page %d", fsmOwner, i, listed[i], id)
+			continue
+		}
+		if page, ok := pages[id]; ok && storage.FreeBucket(page) != free[i] {
+			c.report("%s: page %d is in bucket %d, actual free space puts it in %d",
+				fsmOwner, id, free[i], storage.FreeBucket(page))
+		}
+	}
+}
+
+func (c *checker) checkCatalog() []CatalogEntry {
+	chain, pages := c.checkHeap(CATALOG_ROOT, CATALOG_FSM, "catalog")
+
+	var entries []CatalogEntry
+	for _, id := range chain {
+		page, ok := pages[id]
+		if !ok {
+			continue
+		}
+		for _, slot := range storage.LiveSlots(page) {
+			data, ok := c.record(page, id, slot, "catalog")
+			if !ok {
+				continue
+			}
+			entry, err := DecodeCatalogEntry(data)
+			if err != nil {
+				c.report("catalog page %d slot %d: %v", id, slot, err)
+				continue
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// record returns the record in a slot, claiming its overflow pages for the heap's owner
+func (c *checker) record(page []byte, id storage.PageID, slot uint16, owner string) ([]byte, bool) {
+	if !storage.RecordOverflows(page, slot) {
+		return storage.ReadRecord(page, slot), true
+	}
+	data, pages, err := storage.ReadOverflow(c.pager, storage.ReadRecord(page, slot))
+	for _, overflow := range pages {
+		c.claim(overflow, owner+" overflow")
+	}
+	if err != nil {
+		c.report("%s page %d slot %d: %v", owner, id, slot, err)
+		return nil, false
+	}
+	return data, true
+}
+
+// checkTable validates every page of a table's heap and decodes each record against the schema version it was written with
+func (c *checker) checkTable(entry CatalogEntry) {
+	owner := "table " + entry.Name
+	if entry.StartPage == 0 || entry.FSMPage == 0 {
+		c.report("%s: heap or free space map starts at the meta page", owner)
+		return
+	}
+
+	chain, pages := c.checkHeap(storage.PageID(entry.StartPage), storage.PageID(entry.FSMPage), owner)
+	if uint32(len(chain)) != entry.NumPages {
+		c.report("%s: catalog says %d pages, heap chain has %d", owner, entry.NumPages, len(chain))
+	}
+
+	format := newRowFormat(entry)
+	rows := make(map[storage.RecordID]types.Row)
+	for _, id := range chain {
+		page, ok := pages[id]
+		if !ok {
+			continue
+		}
+		for _, slot := range storage.LiveSlots(page) {
+			data, ok := c.record(page, id, slot, owner)
+			if !ok {
+				continue
+			}
+			row, err := format.decode(data)
+			if err != nil {
+				c.report("%s page %d slot %d: %v", owner, id, slot, err)
+				continue
+			}
+			rows[storage.RecordID{PageID: id, Slot: slot}] = row
+		}
+	}
+
+	for _, ie := range entry.Indexes {
+		c.checkIndex(entry, ie, rows)
+	}
+}
+
+// checkIndex validates an index's structure and that it holds exactly one entry per row
+func (c *checker) checkIndex(entry CatalogEntry, ie IndexEntry, rows map[storage.RecordID]types.Row) {
+	owner := fmt.Sprintf("index %s on %s", ie.Name, entry.Name)
+	col := columnIndex(entry.Schema, ie.Column)
+	if col < 0 {
+		c.report("%s: table has no column %s", owner, ie.Column)
+		return
+	}
+
+	ix := openStore(c.pager, ie.Kind, storage.PageID(ie.Root))
+	pages, problems := ix.Check()
+	for _, id := range pages {
+		c.claim(id, owner)
+	}
+	for _, p := range problems {
+		c.report("%s: %s", owner, p)
+	}
+	if len(problems) > 0 {
+		return
+	}
+
+	// every row should be indexed under its own key, once
+	expected := make(map[storage.RecordID]string, len(rows))
+	for rid, row := range rows {
+		expected[rid] = string(rowKey(ie.Kind, row[col], entry.Schema[col].Type, ie.Path))
+	}
+	err := ix.Scan(func(key []byte, rid storage.RecordID) bool {
+		want, ok := expected[rid]
+		switch {
+		case !ok:
+			c.report("%s: entry points at page %d slot %d, which holds no row (or was indexed twice)", owner, rid.PageID, rid.Slot)
+		case want != string(key):
+			c.report("%s: entry for page %d slot %d has a key that doesn't match the row", owner, rid.PageID, rid.Slot)
+		}
+		delete(expected, rid)
+		return true
+	})
+	if err != nil {
+		c.report("%s: %v", owner, err)
+		return
+	}
+	for rid := range expected {
+		c.report("%s: row at page %d slot %d is missing from the index", owner, rid.PageID, rid.Slot)
+	}
+}
+
+// IntegrityCheck runs CheckIntegrity against the database file
+func (db *DB) IntegrityCheck() []string {
+	return CheckIntegrity(db.Pager)
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+func TestCheckIntegrityReportsCorruptPages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable("t", []types.Column{{Name: "id", Type: types.INT}, {Name: "name", Type: types.TEXT}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		if err := db.Tables["t"].Insert(types.Row{i, "row"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if problems := CheckIntegrity(db.Pager); len(problems) > 0 {
+		t.Fatalf("fresh database has problems: %v", problems)
+	}
+
+	// flip a byte in the table's last page behind the pager's back
+	last := db.Pager.NextPageID() - 1
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	offset := int64(last)*storage.PAGE_SIZE + storage.PAGE_SIZE - 1
+	if _, err := f.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := f.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+
+	problems := CheckIntegrity(db.Pager)
+	if len(problems) == 0 || !strings.Contains(strings.Join(problems, "\n"), "corrupt") {
+		t.Fatalf("problems = %v, want the corrupt page reported", problems)
+	}
+}
+
+func TestCheckIntegrityClaimsOverflowPages(t *testing.T) {
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateTable("t", []types.Column{{Name: "id", Type: types.INT}, {Name: "body", Type: types.TEXT}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Tables["t"].Insert(types.Row{1, strings.Repeat("x", 3*storage.PAGE_SIZE)}); err != nil {
+		t.Fatal(err)
+	}
+	if problems := CheckIntegrity(db.Pager); len(problems) > 0 {
+		t.Fatalf("database with an overflowed row has problems: %v", problems)
+	}
+
+	// the row's last overflow page is freed while the row still uses it
+	if err := db.Pager.FreePage(db.Pager.NextPageID() - 1); err != nil {
+		t.Fatal(err)
+	}
+	problems := CheckIntegrity(db.Pager)
+	if len(problems) == 0 || !strings.Contains(strings.Join(problems, "\n"), "claimed by both free list and table t overflow") {
+		t.Fatalf("problems = %v, want the page claimed twice", problems)
+	}
+}
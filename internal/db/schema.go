@@ -0,0 +1,161 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+rowFormat decodes a table's records whatever schema version they were written
+with. Records in the current version decode directly; older ones are decoded
+with their own schema and then mapped onto the current one by column ID:
+columns that were dropped since are skipped and columns added since take
+their Default. This is what lets ADD/DROP COLUMN change the catalog without
+rewriting any rows.
+*/
+type rowFormat struct {
+	version uint16
+	current []types.Column
+	history map[uint16][]types.Column
+}
+
+func newRowFormat(e CatalogEntry) *rowFormat {
+	f := &rowFormat{
+		version: e.Version,
+		current: e.Schema,
+		history: make(map[uint16][]types.Column, len(e.History)),
+	}
+	for _, v := range e.History {
+		f.history[v.Version] = v.Columns
+	}
+	return f
+}
+
+func (f *rowFormat) encode(row types.Row) []byte {
+	return storage.EncodeRecord(f.version, row)
+}
+
+func (f *rowFormat) decode(record []byte) (types.Row, error) {
+	version, data, err := storage.SplitRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if version == f.version {
+		return storage.DecodeRow(data, f.current)
+	}
+
+	schema, ok := f.history[version]
+	if !ok {
+		return nil, fmt.Errorf("record written with unknown schema version %d", version)
+	}
+	old, err := storage.DecodeRow(data, schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema version %d: %w", version, err)
+	}
+
+	byID := make(map[uint16]types.Value, len(schema))
+	for i, col := range schema {
+		byID[col.ID] = old[i]
+	}
+	row := make(types.Row, len(f.current))
+	for i, col := range f.current {
+		v, ok := byID[col.ID]
+		if !ok {
+			v = col.Default
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// the encoded size of a catalog entry past which an ALTER first forgets the schema versions no row uses,
+// so history doesn't grow by a version with every ALTER forever
+const SCHEMA_HISTORY_LIMIT = storage.PAGE_SIZE / 2
+
+/*
+historyInUse decides which of a table's old schema versions an ALTER keeps.
+History grows by one version per ALTER, so while the catalog entry is small
+it returns nil and everything is kept without reading a row. Past
+SCHEMA_HISTORY_LIMIT it scans the heap once for the versions the stored rows
+still use; the rest were rewritten or deleted and are dropped. If the rows
+still need too many versions only a VACUUM, which rewrites them, helps.
+*/
+func historyInUse(table *Table, e CatalogEntry) (map[uint16]bool, error) {
+	if len(EncodeCatalogEntry(e)) <= SCHEMA_HISTORY_LIMIT {
+		return nil, nil
+	}
+	used, err := versionsInUse(table)
+	if err != nil {
+		return nil, err
+	}
+	pruned := e
+	newSchemaVersion(&pruned, e.Schema, used)
+	if len(EncodeCatalogEntry(pruned)) > SCHEMA_HISTORY_LIMIT {
+		return nil, fmt.Errorf("table %s has rows in too many schema versions, run VACUUM to rewrite them", table.Name)
+	}
+	return used, nil
+}
+
+// versionsInUse finds the schema versions a table's stored rows were written with
+func versionsInUse(table *Table) (map[uint16]bool, error) {
+	used := make(map[uint16]bool)
+	var splitErr error
+	err := table.Heap.Scan(func(rid storage.RecordID, record []byte) bool {
+		version, _, err := storage.SplitRecord(record)
+		if err != nil {
+			splitErr = fmt.Errorf("page %d slot %d: %w", rid.PageID, rid.Slot, err)
+			return false
+		}
+		used[version] = true
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return used, splitErr
+}
+
+/*
+newSchemaVersion moves the entry's current schema into its history and
+installs schema as the next version. A nil used keeps the whole history,
+otherwise only the versions in used stay. The new version takes the next
+number no kept version holds, so when the counter wraps it reuses the
+numbers of pruned versions rather than claiming those of live rows.
+*/
+func newSchemaVersion(e *CatalogEntry, schema []types.Column, used map[uint16]bool) {
+	history := append(append([]SchemaVersion(nil), e.History...), SchemaVersion{Version: e.Version, Columns: e.Schema})
+	e.History = nil
+	taken := make(map[uint16]bool, len(history))
+	for _, v := range history {
+		if used == nil || used[v.Version] {
+			e.History = append(e.History, v)
+			taken[v.Version] = true
+		}
+	}
+	e.Version++
+	for taken[e.Version] {
+		e.Version++
+	}
+	e.Schema = schema
+}
+
+// assignColumnIDs gives IDs to columns that don't have one yet
+func assignColumnIDs(e *CatalogEntry) {
+	for i := range e.Schema {
+		if e.Schema[i].ID >= e.NextColumnID {
+			e.NextColumnID = e.Schema[i].ID + 1
+		}
+	}
+	// 0 means unassigned, so IDs start at 1
+	if e.NextColumnID == 0 {
+		e.NextColumnID = 1
+	}
+	for i := range e.Schema {
+		if e.Schema[i].ID == 0 {
+			e.Schema[i].ID = e.NextColumnID
+			e.NextColumnID++
+		}
+	}
+}
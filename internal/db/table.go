@@ -1,21 +1,141 @@
 package db
 
 import (
+	"bytes"
+	"fmt"
+
 	"github.com/mbeka02/pesapal_challenge/internal/storage"
 	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
 type Table struct {
-	Name   string
-	Schema []types.Column
-	Heap   *storage.Heap
+	Name    string
+	Schema  []types.Column
+	Heap    *storage.Heap
+	Indexes []*Index
+	// View is the SELECT a materialized view's rows come from, empty for a plain table
+	View   string
+	format *rowFormat
+}
+
+func (t *Table) Insert(row types.Row) error {
+	if err := checkRow(t.Schema, row); err != nil {
+		return err
+	}
+	if err := t.CheckKeys(row); err != nil {
+		return err
+	}
+	data := t.format.encode(row)
+	rid, err := t.Heap.Insert(data)
+	if err != nil {
+		return err
+	}
+	for _, idx := range t.Indexes {
+		if err := idx.store.Insert(t.indexKey(idx, row), rid); err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
+
+// checkRow makes sure a row matches the schema exactly (NULL fits any column), a value of the wrong
+// type would be encoded with the wrong width and corrupt the record
+func checkRow(schema []types.Column, row types.Row) error {
+	if len(row) != len(schema) {
+		return fmt.Errorf("row has %d values, table has %d columns", len(row), len(schema))
+	}
+	for i, col := range schema {
+		if row[i] == nil {
+			continue
+		}
+		if t, ok := types.TypeOf(row[i]); !ok || t != col.Type {
+			return fmt.Errorf("column %s is %s, got %v (%T)", col.Name, col.Type, row[i], row[i])
+		}
+		if d, ok := row[i].(types.Decimal); ok {
+			if err := d.CheckStorable(); err != nil {
+				return fmt.Errorf("column %s: %w", col.Name, err)
+			}
+		}
+	}
+	return nil
 }
 
-func (t *Table) Insert(row types.Row) {
-	data := storage.EncodeRow(row)
-	t.Heap.Insert(data)
+func (t *Table) Scan(cb func(types.Row) bool) error {
+	return t.ScanRecords(func(_ storage.RecordID, row types.Row) bool {
+		return cb(row)
+	})
 }
 
-func (t *Table) Scan(cb func(types.Row) bool) {
-	t.Heap.Iterate(t.Schema, cb)
-}
\ No newline at end of file
+// ScanRecords is Scan with each row's RecordID, for statements that modify what they find
+func (t *Table) ScanRecords(cb func(storage.RecordID, types.Row) bool) error {
+	var decodeErr error
+	err := t.Heap.Scan(func(rid storage.RecordID, data []byte) bool {
+		row, err := t.format.decode(data)
+		if err != nil {
+			decodeErr = fmt.Errorf("page %d slot %d: %w", rid.PageID, rid.Slot, err)
+			return false
+		}
+		return cb(rid, row)
+	})
+	if err != nil {
+		return err
+	}
+	return decodeErr
+}
+
+func (t *Table) Delete(rid storage.RecordID) error {
+	if len(t.Indexes) > 0 {
+		data, err := t.Heap.Get(rid)
+		if err != nil {
+			return err
+		}
+		row, err := t.format.decode(data)
+		if err != nil {
+			return err
+		}
+		for _, idx := range t.Indexes {
+			if err := idx.store.Delete(t.indexKey(idx, row), rid); err != nil {
+				return fmt.Errorf("index %s: %w", idx.Name, err)
+			}
+		}
+	}
+	return t.Heap.Delete(rid)
+}
+
+// Update replaces the row at rid, moving its index entries along with it when the
+// row's keys change or it no longer fits on its page
+func (t *Table) Update(rid storage.RecordID, row types.Row) error {
+	if err := checkRow(t.Schema, row); err != nil {
+		return err
+	}
+	if err := t.CheckKeys(row); err != nil {
+		return err
+	}
+	var old types.Row
+	if len(t.Indexes) > 0 {
+		data, err := t.Heap.Get(rid)
+		if err != nil {
+			return err
+		}
+		if old, err = t.format.decode(data); err != nil {
+			return err
+		}
+	}
+	newRID, err := t.Heap.Update(rid, t.format.encode(row))
+	if err != nil {
+		return err
+	}
+	for _, idx := range t.Indexes {
+		oldKey, newKey := t.indexKey(idx, old), t.indexKey(idx, row)
+		if newRID == rid && bytes.Equal(oldKey, newKey) {
+			continue
+		}
+		if err := idx.store.Delete(oldKey, rid); err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+		if err := idx.store.Insert(newKey, newRID); err != nil {
+			return fmt.Errorf("index %s: %w", idx.Name, err)
+		}
+	}
+	return nil
+}
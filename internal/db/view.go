@@ -0,0 +1,189 @@
+package db
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+// View is a named SELECT, run whenever the view is read
+type View struct {
+	Name string
+	// Query is the text of the SELECT
+	Query string
+	// Schema is the columns the SELECT returned when the view was created
+	Schema []types.Column
+}
+
+func openView(e CatalogEntry) *View {
+	return &View{Name: e.Name, Query: e.View, Schema: e.Schema}
+}
+
+// CreateView records a view in the catalog, the query is only stored here, checking it is up to the caller
+func (db *DB) CreateView(name, query string, schema []types.Column) error {
+	if err := db.checkNameFree(name); err != nil {
+		return err
+	}
+	entry := CatalogEntry{
+		Name:   name,
+		Schema: append([]types.Column(nil), schema...),
+		View:   query,
+	}
+	if err := db.catalog.Insert(entry); err != nil {
+		return err
+	}
+	db.Views[name] = openView(entry)
+	return nil
+}
+
+// DropView removes a view, which has no pages to free
+func (db *DB) DropView(name string) error {
+	if _, exists := db.Views[name]; !exists {
+		if table, isTable := db.Tables[name]; isTable && table.View != "" {
+			return fmt.Errorf("%s is a materialized view, use DROP MATERIALIZED VIEW", name)
+		} else if isTable {
+			return fmt.Errorf("%s is a table, not a view", name)
+		}
+		return fmt.Errorf("view %s does not exist", name)
+	}
+	if err := db.catalog.Delete(name); err != nil {
+		return err
+	}
+	delete(db.Views, name)
+	return nil
+}
+
+// CreateMaterializedView creates the empty heap of a materialized view, for the caller to fill with the query's rows
+func (db *DB) CreateMaterializedView(name, query string, schema []types.Column) error {
+	return db.createTable(name, schema, query)
+}
+
+// DropMaterializedView removes a materialized view, freeing its heap and indexes
+func (db *DB) DropMaterializedView(name string) error {
+	table, err := db.MaterializedView(name)
+	if err != nil {
+		return err
+	}
+	if err := db.catalog.Delete(name); err != nil {
+		return err
+	}
+	delete(db.Tables, name)
+	return freeTable(table)
+}
+
+/*
+RefreshMaterializedView replaces the rows of a materialized view with the
+ones fill inserts into a new, empty heap. The view's indexes are rebuilt
+alongside, as empty indexes the inserts fill in, so the new heap and indexes
+are complete before a single catalog update switches the entry over to them
+all at once. A refresh that fails before then leaves the old rows and indexes
+as they were. The old pages are freed last; that only fails to reclaim space,
+so it is logged rather than failing a refresh that has already happened.
+*/
+func (db *DB) RefreshMaterializedView(name string, fill func(*Table) error) error {
+	table, err := db.MaterializedView(name)
+	if err != nil {
+		return err
+	}
+	entry, err := db.catalog.Get(name)
+	if err != nil {
+		return err
+	}
+
+	heap, err := storage.CreateHeap(db.Pager)
+	if err != nil {
+		return err
+	}
+	fresh := &Table{Name: name, Schema: table.Schema, Heap: heap, View: table.View, format: newRowFormat(entry)}
+	discard := func(err error) error {
+		if freeErr := freeTable(fresh); freeErr != nil {
+			return fmt.Errorf("%w (freeing the new heap failed too: %v)", err, freeErr)
+		}
+		return err
+	}
+	for _, idx := range table.Indexes {
+		store, err := createStore(db.Pager, idx.Kind)
+		if err != nil {
+			return discard(err)
+		}
+		fresh.Indexes = append(fresh.Indexes, &Index{Name: idx.Name, Column: idx.Column, Path: idx.Path, Kind: idx.Kind, store: store})
+	}
+	if err := fill(fresh); err != nil {
+		return discard(err)
+	}
+	pages, err := heap.Pages()
+	if err != nil {
+		return discard(err)
+	}
+
+	indexes := make([]IndexEntry, len(fresh.Indexes))
+	for i, idx := range fresh.Indexes {
+		indexes[i] = idx.entry()
+	}
+	err = db.catalog.Update(name, func(e *CatalogEntry) {
+		e.StartPage = uint64(heap.StartPage())
+		e.FSMPage = uint64(heap.FSMStartPage())
+		e.NumPages = uint32(len(pages))
+		e.History = nil
+		e.Indexes = indexes
+	})
+	if err != nil {
+		return discard(err)
+	}
+	if err := db.reopenTable(name); err != nil {
+		return err
+	}
+	if err := freeTable(table); err != nil {
+		log.Printf("refreshing %s: freeing the old heap and indexes: %v", name, err)
+	}
+	return nil
+}
+
+// MaterializedView finds a materialized view, telling a table or plain view apart from a name nothing has
+func (db *DB) MaterializedView(name string) (*Table, error) {
+	table, exists := db.Tables[name]
+	switch {
+	case exists && table.View != "":
+		return table, nil
+	case exists:
+		return nil, fmt.Errorf("%s is a table, not a materialized view", name)
+	case db.Views[name] != nil:
+		return nil, fmt.Errorf("%s is a view, not a materialized view", name)
+	}
+	return nil, fmt.Errorf("materialized view %s does not exist", name)
+}
+
+// checkNameFree makes sure no table or view goes by a name
+func (db *DB) checkNameFree(name string) error {
+	if table, exists := db.Tables[name]; exists && table.View != "" {
+		return fmt.Errorf("materialized view %s already exists", name)
+	} else if exists {
+		return fmt.Errorf("table %s already exists", name)
+	}
+	if _, exists := db.Views[name]; exists {
+		return fmt.Errorf("view %s already exists", name)
+	}
+	return nil
+}
+
+// lookupTable finds a table, or a materialized view, telling a view apart from a name nothing has
+func (db *DB) lookupTable(name string) (*Table, error) {
+	if table, exists := db.Tables[name]; exists {
+		return table, nil
+	}
+	if _, exists := db.Views[name]; exists {
+		return nil, fmt.Errorf("%s is a view, not a table", name)
+	}
+	return nil, fmt.Errorf("table %s does not exist", name)
+}
+
+// alterableTable finds a table whose rows or columns can be changed, which a materialized view's can't but by a refresh
+func (db *DB) alterableTable(name string) (*Table, error) {
+	table, err := db.lookupTable(name)
+	if err == nil && table.View != "" {
+		return nil, fmt.Errorf("%s is a materialized view, not a table", name)
+	}
+	return table, err
+}
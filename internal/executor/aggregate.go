@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+A SELECT list that calls an aggregate function folds every row the WHERE
+clause keeps into a single result row. Each aggregate call gets its own
+accumulator, stepped with the call's arguments for every row; the SELECT list
+is then evaluated once with the accumulated values in place of the calls.
+There is no GROUP BY, so any column has to be read inside an aggregate.
+
+COUNT(*) counts rows and COUNT(x) the rows where x isn't NULL. SUM and AVG
+skip NULLs and are NULL when there is nothing left; AVG of a DECIMAL is a
+DECIMAL and of anything else a FLOAT. With DISTINCT,
+as in COUNT(DISTINCT x), an aggregate is stepped once for each distinct set
+of arguments, leaving out those holding a NULL; they are told apart with a
+hash set that spills to disk when it grows large (see distinct.go).
+*/
+
+type accumulator interface {
+	step(args []types.Value) error
+	result() (types.Value, error)
+}
+
+func (e *Executor) runAggregate(q *query, sc scope, cb func(types.Row) bool) error {
+	accumulators := make([]accumulator, len(q.aggregates))
+	defer func() {
+		for _, acc := range accumulators {
+			if d, ok := acc.(*distinctAccumulator); ok {
+				d.close()
+			}
+		}
+	}()
+	for i, call := range q.aggregates {
+		fn, err := lookupFunction(call.Name, sc)
+		if err != nil {
+			return err
+		}
+		accumulators[i] = fn.aggregate()
+		if call.Distinct {
+			if accumulators[i], err = distinctArgs(call, accumulators[i], sc); err != nil {
+				return err
+			}
+		}
+	}
+
+	var evalErr error
+	err := e.scan(q, q.where, sc, func(row types.Row) bool {
+		for i, call := range q.aggregates {
+			if evalErr = stepAggregate(call, accumulators[i], row, sc); evalErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if evalErr != nil {
+		return evalErr
+	}
+
+	sc.folded = make(map[*parser.Call]types.Value, len(q.aggregates))
+	for i, call := range q.aggregates {
+		v, err := accumulators[i].result()
+		if err != nil {
+			return err
+		}
+		sc.folded[call] = v
+	}
+	out, err := q.project(nil, sc)
+	if err != nil {
+		return err
+	}
+	cb(out)
+	return nil
+}
+
+func stepAggregate(call *parser.Call, acc accumulator, row types.Row, sc scope) error {
+	args := make([]types.Value, len(call.Args))
+	for i, arg := range call.Args {
+		v, err := evalExpr(arg, row, sc)
+		if err != nil {
+			return err
+		}
+		args[i] = v
+	}
+	return acc.step(args)
+}
+
+func newCount() accumulator {
+	return new(count)
+}
+
+// count counts the rows whose arguments are all set, every row for COUNT(*)
+type count struct {
+	n int
+}
+
+func (c *count) step(args []types.Value) error {
+	for _, v := range args {
+		if v == nil {
+			return nil
+		}
+	}
+	c.n++
+	return nil
+}
+
+func (c *count) result() (types.Value, error) {
+	return c.n, nil
+}
+
+func newSum() accumulator {
+	return new(sum)
+}
+
+// sum adds up the values that aren't NULL, in their own type
+type sum struct {
+	total types.Value
+}
+
+func (s *sum) step(args []types.Value) error {
+	switch {
+	case args[0] == nil:
+		return nil
+	case s.total == nil:
+		s.total = args[0]
+		return nil
+	}
+	var err error
+	s.total, err = arith("+", s.total, args[0])
+	return err
+}
+
+func (s *sum) result() (types.Value, error) {
+	return s.total, nil
+}
+
+func newAverage() accumulator {
+	return new(average)
+}
+
+type average struct {
+	sum sum
+	n   int
+}
+
+func (a *average) step(args []types.Value) error {
+	if args[0] == nil {
+		return nil
+	}
+	a.n++
+	return a.sum.step(args)
+}
+
+func (a *average) result() (types.Value, error) {
+	switch total := a.sum.total.(type) {
+	case nil:
+		return nil, nil
+	case types.Decimal:
+		return total.Quo(types.DecimalFromInt(a.n))
+	}
+	total, _ := toFloat(a.sum.total)
+	return total / float64(a.n), nil
+}
+
+func avgType(_ string, args []types.DataType) (types.DataType, error) {
+	if args[0] == types.DECIMAL {
+		return types.DECIMAL, nil
+	}
+	return types.FLOAT, nil
+}
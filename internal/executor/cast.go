@@ -0,0 +1,219 @@
+package executor
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+CAST(x AS type) converts between any two types that have a sensible mapping:
+  - anything casts to TEXT, and to VARCHAR(n) and CHAR(n), which cut the text
+    off at n characters rather than failing like a stored value would
+  - TEXT casts to any type by parsing it
+  - numbers cast to each other, FLOAT and DECIMAL round half away from zero to INT
+  - INT and BOOLEAN cast to each other as 1 and 0
+  - TIMESTAMP casts to DATE, dropping the time of day
+  - a JSON string, number or boolean casts as the value it holds
+*/
+
+// castColumn is the column a CAST produces
+func castColumn(cast *parser.Cast) (types.Column, error) {
+	col, err := typedColumn(cast.Type)
+	if err != nil {
+		return types.Column{}, fmt.Errorf("CAST: %w", err)
+	}
+	return col, nil
+}
+
+func castType(cast *parser.Cast, sc scope) (types.DataType, error) {
+	from, err := inferType(cast.Expr, sc)
+	if err != nil {
+		return 0, err
+	}
+	col, err := castColumn(cast)
+	if err != nil {
+		return 0, err
+	}
+	// TEXT and JSON are only known to convert once their content is seen
+	switch {
+	case from == nullType, from == types.TEXT, from == types.CHAR, from == types.JSON:
+	default:
+		if _, err := castValue(sampleValue(from), col); err != nil {
+			return 0, fmt.Errorf("cannot cast %s to %s", from, col.TypeName())
+		}
+	}
+	return col.Type, nil
+}
+
+func evalCast(cast *parser.Cast, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalExpr(cast.Expr, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	col, err := castColumn(cast)
+	if err != nil {
+		return nil, err
+	}
+	return castValue(v, col)
+}
+
+func castValue(v types.Value, col types.Column) (types.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if col.Type == types.TEXT || col.Type == types.CHAR {
+		text := textOf(v)
+		if col.Length > 0 && len(text) > int(col.Length) {
+			if runes := []rune(text); len(runes) > int(col.Length) {
+				text = string(runes[:col.Length])
+			}
+		}
+		return coerceValue(text, col)
+	}
+
+	if doc, ok := v.(types.JSONValue); ok && col.Type != types.JSON {
+		scalar, ok := doc.Scalar()
+		if !ok {
+			return nil, fmt.Errorf("cannot cast JSON %s to %s", doc, col.TypeName())
+		}
+		if scalar == nil {
+			return nil, nil
+		}
+		v = scalar
+	}
+	if c, ok := v.(types.Char); ok {
+		v = c.Trim()
+	}
+
+	cast, err := convert(v, col)
+	if err != nil {
+		return nil, err
+	}
+	if cast == nil {
+		return nil, fmt.Errorf("cannot cast %s to %s", describe(v), col.TypeName())
+	}
+	return cast, nil
+}
+
+// convert does the conversions castValue allows, nil if there is none from v to the column's type
+func convert(v types.Value, col types.Column) (types.Value, error) {
+	text, isText := v.(string)
+	switch col.Type {
+	case types.INT:
+		switch x := v.(type) {
+		case int:
+			return x, nil
+		case float64:
+			if math.IsNaN(x) || math.Abs(x) >= math.MaxInt64 {
+				return nil, fmt.Errorf("FLOAT %v is out of range for INT", x)
+			}
+			return int(math.Round(x)), nil
+		case types.Decimal:
+			d := x.Rescale(0)
+			if !d.Unscaled.IsInt64() {
+				return nil, fmt.Errorf("DECIMAL %s is out of range for INT", x)
+			}
+			return int(d.Unscaled.Int64()), nil
+		case bool:
+			if x {
+				return 1, nil
+			}
+			return 0, nil
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(x))
+			if err != nil {
+				return nil, fmt.Errorf("invalid INT %q", x)
+			}
+			return i, nil
+		}
+	case types.FLOAT:
+		switch x := v.(type) {
+		case int:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		case types.Decimal:
+			return x.Float64(), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid FLOAT %q", x)
+			}
+			return f, nil
+		}
+	case types.DECIMAL:
+		d, ok, err := toDecimal(v)
+		if isText {
+			d, err = types.ParseDecimal(text)
+			ok = true
+		}
+		if !ok || err != nil {
+			return nil, err
+		}
+		if col.Precision > 0 {
+			return d.Fit(int(col.Precision), int(col.Scale))
+		}
+		return d, nil
+	case types.BOOLEAN:
+		switch x := v.(type) {
+		case bool:
+			return x, nil
+		case int:
+			return x != 0, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(x)) {
+			case "true", "t", "yes", "y", "on", "1":
+				return true, nil
+			case "false", "f", "no", "n", "off", "0":
+				return false, nil
+			}
+			return nil, fmt.Errorf("invalid BOOLEAN %q", x)
+		}
+	case types.DATE:
+		if ts, ok := v.(types.Timestamp); ok {
+			return types.DateOf(ts.Time()), nil
+		}
+	case types.BLOB:
+		if isText {
+			return []byte(text), nil
+		}
+	case types.JSON:
+		switch v.(type) {
+		case string, int, float64, bool, types.Decimal:
+			return types.ParseJSON(textOf(v))
+		}
+	}
+
+	// the remaining conversions are those a stored value gets, parsing TEXT among them
+	t, _ := types.TypeOf(v)
+	if t == col.Type || isText {
+		return coerceValue(v, col)
+	}
+	if col.Type == types.TIMESTAMP && t == types.DATE {
+		return coerceValue(v, col)
+	}
+	return nil, nil
+}
+
+// textOf writes a value as TEXT the way CAST does: CHAR without its padding,
+// BLOBs in hex and everything else as it is shown
+func textOf(v types.Value) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case types.Char:
+		return x.Trim()
+	case []byte:
+		return hex.EncodeToString(x)
+	case types.JSONValue:
+		return string(x)
+	default:
+		return fmt.Sprint(v)
+	}
+}
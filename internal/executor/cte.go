@@ -0,0 +1,223 @@
+package executor
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Common table expressions are named queries a SELECT reads from like tables.
+Each one runs the first time it is read and its rows are kept in memory for
+the rest of the statement, except that one reading columns of an outer query
+runs again for every outer row, like a correlated subquery. A CTE can read
+the ones defined before it and, with WITH RECURSIVE, itself: it is then a
+SELECT followed by UNION [ALL] and a SELECT reading the CTE, in its FROM or a
+subquery. That second SELECT runs over and over, each time reading only the
+rows the previous run added, until it adds none. UNION drops rows that were
+already produced, which also ends a walk around a cycle; UNION ALL keeps them.
+*/
+type cte struct {
+	name    string
+	columns []types.Column
+	// anchor is the first SELECT, step the one after UNION
+	anchor, step *query
+	all          bool
+	// recursive is set when step reads the CTE, found while planning it
+	recursive, planning bool
+	correlated          bool
+
+	// rows once the CTE has run; while step runs its reads see only working
+	done, running bool
+	rows, working []types.Row
+}
+
+// maxRecursion bounds how often a recursive CTE's step runs, so one that never stops is reported
+const maxRecursion = 100000
+
+// planWith plans a WITH clause's CTEs in order, returning them along with the ones visible around it
+func (e *Executor) planWith(with *parser.With, outer *frame, visible map[string]*cte) (map[string]*cte, error) {
+	ctes := maps.Clone(visible)
+	if ctes == nil {
+		ctes = make(map[string]*cte)
+	}
+	defined := make(map[string]bool)
+	for _, def := range with.Tables {
+		if defined[def.Name] {
+			return nil, fmt.Errorf("CTE %s is defined more than once", def.Name)
+		}
+		defined[def.Name] = true
+		c, err := e.planCTE(def, with.Recursive, outer, ctes)
+		if err != nil {
+			return nil, fmt.Errorf("CTE %s: %w", def.Name, err)
+		}
+		ctes[def.Name] = c
+	}
+	return ctes, nil
+}
+
+func (e *Executor) planCTE(def *parser.CTE, recursive bool, outer *frame, ctes map[string]*cte) (*cte, error) {
+	if recursive && def.Select.TableName == def.Name {
+		return nil, fmt.Errorf("the SELECT before UNION can't read the CTE itself")
+	}
+	// a recursive CTE's last UNION splits it into the anchor and the step repeated after it
+	body, last := def.Select, (*parser.SetOp)(nil)
+	if n := len(body.Compound); recursive && n > 0 && strings.EqualFold(body.Compound[n-1].Op, "UNION") &&
+		body.OrderBy == nil && body.Limit == nil {
+		anchor := *body
+		anchor.Compound = body.Compound[:n-1]
+		body, last = &anchor, body.Compound[n-1]
+	}
+
+	anchor, err := e.planQuery(body, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	c := &cte{name: def.Name, anchor: anchor, correlated: anchor.correlated}
+
+	names := def.Columns
+	if names != nil && len(names) != len(anchor.columns) {
+		return nil, fmt.Errorf("%d column names given for %d columns", len(names), len(anchor.columns))
+	}
+	for i, col := range anchor.columns {
+		if names != nil {
+			col.Name = names[i]
+		}
+		if columnIndex(c.columns, col.Name) >= 0 {
+			return nil, fmt.Errorf("duplicate column %s, give one an alias with AS", col.Name)
+		}
+		c.columns = append(c.columns, col)
+	}
+	if last == nil {
+		return c, nil
+	}
+
+	c.all = last.All
+	ctes = maps.Clone(ctes)
+	ctes[def.Name] = c
+	c.planning = true
+	c.step, err = e.planCore(last.Select, outer, ctes)
+	c.planning = false
+	if err != nil {
+		return nil, err
+	}
+	c.correlated = c.correlated || c.step.correlated
+	if len(c.step.columns) != len(c.columns) {
+		return nil, fmt.Errorf("the SELECTs around UNION return %d and %d columns", len(c.columns), len(c.step.columns))
+	}
+	for i, col := range c.step.columns {
+		if _, err := coerceValue(sampleValue(col.Type), c.columns[i]); err != nil {
+			return nil, fmt.Errorf("column %s is %s, the SELECT after UNION gives %s", c.columns[i].Name, c.columns[i].Type, col.Type)
+		}
+	}
+	return c, nil
+}
+
+// read notes a FROM naming the CTE, which makes it recursive when it is met planning its own step
+func (c *cte) read() {
+	if c.planning {
+		c.recursive = true
+	}
+}
+
+// materialize runs a CTE for the outer row, or only the first time when it doesn't read one
+func (e *Executor) materialize(c *cte, outer *frame) ([]types.Row, error) {
+	switch {
+	case c.running:
+		return c.working, nil
+	case c.done:
+		return c.rows, nil
+	}
+
+	var seen map[string]bool
+	if c.step != nil && !c.all {
+		seen = make(map[string]bool)
+	}
+	collect := func(q *query) ([]types.Row, error) {
+		var rows []types.Row
+		var rowErr error
+		err := e.run(q, outer, func(row types.Row) bool {
+			if row, rowErr = coerceRow(row, c.columns); rowErr != nil {
+				return false
+			}
+			if seen != nil {
+				key := rowKey(row)
+				if seen[key] {
+					return true
+				}
+				seen[key] = true
+			}
+			rows = append(rows, row)
+			return true
+		})
+		if err == nil {
+			err = rowErr
+		}
+		return rows, err
+	}
+
+	rows, err := collect(c.anchor)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case c.step != nil && !c.recursive:
+		added, err := collect(c.step)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, added...)
+	case c.step != nil:
+		for i, added := 0, rows; len(added) > 0; i++ {
+			if i == maxRecursion {
+				return nil, fmt.Errorf("recursive CTE %s still adds rows after %d steps", c.name, maxRecursion)
+			}
+			// subqueries reading the CTE have to see each step's rows, not the first ones they cached
+			e.resetSubqueries()
+			c.working, c.running = added, true
+			added, err = collect(c.step)
+			c.working, c.running = nil, false
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, added...)
+		}
+	}
+
+	if !c.correlated {
+		c.done, c.rows = true, rows
+	}
+	return rows, nil
+}
+
+// coerceRow converts a row's values to the types of the columns it is stored under
+func coerceRow(row types.Row, columns []types.Column) (types.Row, error) {
+	out := make(types.Row, len(row))
+	for i, v := range row {
+		var err error
+		if out[i], err = coerceValue(v, columns[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// rowKey is a key equal for rows whose values are all equal, NULLs counting as equal to each other
+func rowKey(row types.Row) string {
+	var b strings.Builder
+	for _, v := range row {
+		if v == nil {
+			b.WriteString("-")
+			continue
+		}
+		key := keyer{}.of(v)
+		b.WriteString(strconv.Itoa(len(key)))
+		b.WriteString(":")
+		b.WriteString(key)
+	}
+	return b.String()
+}
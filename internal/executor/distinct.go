@@ -0,0 +1,152 @@
+package executor
+
+import (
+	"math"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+SELECT DISTINCT and aggregates called with DISTINCT tell rows apart by their
+encoding from storage.EncodeRow, after converting the values to their
+column's type so equal values encode alike: DECIMALs are normalized, so 1.5
+and 1.50 are the same, and a negative zero is a zero. The encoded rows go in
+a storage.SpillSet. Until it spills a row is passed on the moment it is first
+seen; the rows met after that are only sorted out once the scan is done, so
+they come last.
+*/
+
+// distinctMemory is roughly how many bytes of rows a DISTINCT holds in memory before spilling to disk
+const distinctMemory = 32 << 20
+
+// distinctKey encodes a row for a DISTINCT set, its values already converted to their column types
+func distinctKey(row types.Row) []byte {
+	normalized := make(types.Row, len(row))
+	for i, v := range row {
+		switch x := v.(type) {
+		case types.Decimal:
+			v = x.Normalize()
+		case float64:
+			if x == 0 {
+				v = math.Abs(x)
+			}
+		}
+		normalized[i] = v
+	}
+	return storage.EncodeRow(normalized)
+}
+
+// runDistinct streams the distinct rows of the query's own SELECT
+func (e *Executor) runDistinct(q *query, sc scope, cb func(out, src types.Row) bool) error {
+	set := storage.NewSpillSet(distinctMemory)
+	defer set.Close()
+
+	var rowErr error
+	stopped := false
+	err := e.runRows(q, sc, func(out, src types.Row) bool {
+		if out, rowErr = coerceRow(out, q.columns); rowErr != nil {
+			return false
+		}
+		isNew, err := set.Add(distinctKey(out))
+		if err != nil {
+			rowErr = err
+			return false
+		}
+		if isNew {
+			stopped = !cb(out, src)
+		}
+		return !stopped
+	})
+	if err == nil {
+		err = rowErr
+	}
+	if err != nil || stopped {
+		return err
+	}
+
+	err = set.Drain(func(key []byte) bool {
+		row, err := decodeDistinct(key, q.columns)
+		if err != nil {
+			rowErr = err
+			return false
+		}
+		return cb(row, nil)
+	})
+	if err == nil {
+		err = rowErr
+	}
+	return err
+}
+
+// decodeDistinct turns a key back into a row, DECIMALs regaining their column's scale
+func decodeDistinct(key []byte, columns []types.Column) (types.Row, error) {
+	row, err := storage.DecodeRow(key, columns)
+	if err != nil {
+		return nil, err
+	}
+	return coerceRow(row, columns)
+}
+
+// distinctAccumulator steps an aggregate once for each distinct set of arguments
+type distinctAccumulator struct {
+	acc     accumulator
+	columns []types.Column
+	set     *storage.SpillSet
+}
+
+// distinctArgs wraps an aggregate call's accumulator for DISTINCT, typing the call's arguments to compare them
+func distinctArgs(call *parser.Call, acc accumulator, sc scope) (accumulator, error) {
+	argScope := sc
+	argScope.aggregates, argScope.ungrouped = nil, false
+	columns := make([]types.Column, len(call.Args))
+	for i, arg := range call.Args {
+		t, err := inferType(arg, argScope)
+		if err != nil {
+			return nil, err
+		}
+		columns[i] = types.Column{Type: t}
+	}
+	return &distinctAccumulator{acc: acc, columns: columns, set: storage.NewSpillSet(distinctMemory)}, nil
+}
+
+func (d *distinctAccumulator) step(args []types.Value) error {
+	for _, v := range args {
+		if v == nil {
+			return nil
+		}
+	}
+	args, err := coerceRow(args, d.columns)
+	if err != nil {
+		return err
+	}
+	isNew, err := d.set.Add(distinctKey(args))
+	if err != nil || !isNew {
+		return err
+	}
+	return d.acc.step(args)
+}
+
+func (d *distinctAccumulator) result() (types.Value, error) {
+	var stepErr error
+	err := d.set.Drain(func(key []byte) bool {
+		args, err := decodeDistinct(key, d.columns)
+		if err == nil {
+			err = d.acc.step(args)
+		}
+		stepErr = err
+		return err == nil
+	})
+	if err == nil {
+		err = stepErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.acc.result()
+}
+
+func (d *distinctAccumulator) close() {
+	d.set.Close()
+}
@@ -0,0 +1,884 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+NULL follows SQL's three-valued logic: comparing anything with NULL gives
+NULL (represented as a nil Value), AND/OR only return NULL when the non-NULL
+operands don't decide the result, and WHERE keeps a row only when the clause
+is true.
+*/
+
+/*
+scope is what an expression is evaluated against: the schema of the rows it
+reads, the table (or alias) they are read as and the DB, whose registered Go
+functions it can call. Inside a subquery, outer is the query around it and the
+row it is on, so columns the subquery doesn't have are looked up there. The
+zero scope fits expressions that only hold literals.
+*/
+type scope struct {
+	schema []types.Column
+	table  string
+	outer  *frame
+	db     *db.DB
+	exec   *Executor
+	// query is the query being planned in this scope, marked correlated when
+	// one of its columns turns out to belong to an outer query
+	query *query
+	// aggregates collects the aggregate calls met while planning a SELECT list,
+	// it is nil where aggregates aren't allowed. ungrouped makes a column
+	// reference outside of an aggregate an error.
+	aggregates *[]*parser.Call
+	ungrouped  bool
+	// windows collects the window function calls met while planning a SELECT
+	// list, it is nil where they aren't allowed
+	windows *[]*parser.Call
+	// folded is each aggregate's value once the rows have been read, or each
+	// window function's for the row being projected
+	folded map[*parser.Call]types.Value
+	// patterns are the LIKE, GLOB and REGEXP patterns compiled for the statement
+	patterns patternCache
+	// reach, when set, records which queries the columns met belong to
+	reach *reach
+	// ctes are the common table expressions the query can read, by name
+	ctes map[string]*cte
+}
+
+// frame is an outer query's scope and the row it is on, nil while planning
+type frame struct {
+	sc  scope
+	row types.Row
+}
+
+// reach is what an expression reads: columns of its own query, of an outer
+// one, or a subquery (which could read either)
+type reach struct {
+	inner, outer, subquery bool
+}
+
+func (e *Executor) scope(schema []types.Column) scope {
+	return scope{schema: schema, db: e.db, exec: e, patterns: e.patterns}
+}
+
+// resolve finds the column a reference names, in this scope or an outer one,
+// returning how many queries out it was found
+func (sc scope) resolve(ref *parser.ColumnRef) (int, int, types.Column, error) {
+	cur := &sc
+	for level := 0; ; level++ {
+		if ref.Table == nil || strings.EqualFold(*ref.Table, cur.table) {
+			if idx := columnIndex(cur.schema, ref.Name); idx >= 0 {
+				if sc.reach != nil {
+					sc.reach.inner = sc.reach.inner || level == 0
+					sc.reach.outer = sc.reach.outer || level > 0
+				}
+				return level, idx, cur.schema[idx], nil
+			}
+		}
+		if cur.outer == nil {
+			return 0, 0, types.Column{}, fmt.Errorf("unknown column: %s", ref)
+		}
+		if cur.query != nil {
+			cur.query.correlated = true
+		}
+		cur = &cur.outer.sc
+	}
+}
+
+// columnValue reads a column from the row, or from an outer query's row
+func (sc scope) columnValue(ref *parser.ColumnRef, row types.Row) (types.Value, error) {
+	level, idx, _, err := sc.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	for f := sc.outer; level > 0; level-- {
+		row, f = f.row, f.sc.outer
+	}
+	if row == nil {
+		// an aggregate query's SELECT list is evaluated once all rows are folded
+		return nil, fmt.Errorf("column %s must be inside an aggregate function", ref)
+	}
+	return row[idx], nil
+}
+
+// evalWhere reports whether a row satisfies a WHERE clause, a nil clause matches everything
+func evalWhere(where *parser.Expr, row types.Row, sc scope) (bool, error) {
+	if where == nil {
+		return true, nil
+	}
+	v, err := evalExpr(where, row, sc)
+	if err != nil || v == nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("WHERE clause must be a boolean, got %v", v)
+	}
+	return b, nil
+}
+
+func evalExpr(expr *parser.Expr, row types.Row, sc scope) (types.Value, error) {
+	if len(expr.Or) == 1 {
+		return evalAnd(expr.Or[0], row, sc)
+	}
+	sawNull := false
+	for _, and := range expr.Or {
+		v, err := evalAnd(and, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			sawNull = true
+			continue
+		}
+		b, err := asBool(v, "OR")
+		if err != nil {
+			return nil, err
+		}
+		if b {
+			return true, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return false, nil
+}
+
+func evalAnd(expr *parser.AndExpr, row types.Row, sc scope) (types.Value, error) {
+	if len(expr.And) == 1 {
+		return evalNot(expr.And[0], row, sc)
+	}
+	sawNull := false
+	for _, not := range expr.And {
+		v, err := evalNot(not, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			sawNull = true
+			continue
+		}
+		b, err := asBool(v, "AND")
+		if err != nil {
+			return nil, err
+		}
+		if !b {
+			return false, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return true, nil
+}
+
+func evalNot(expr *parser.NotExpr, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalComparison(expr.Comparison, row, sc)
+	if err != nil || !expr.Not || v == nil {
+		return v, err
+	}
+	b, err := asBool(v, "NOT")
+	if err != nil {
+		return nil, err
+	}
+	return !b, nil
+}
+
+func evalComparison(expr *parser.Comparison, row types.Row, sc scope) (types.Value, error) {
+	left, err := evalSum(expr.Left, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	if expr.IsNull != nil {
+		return (left == nil) != expr.IsNull.Not, nil
+	}
+	if expr.Like != nil {
+		return evalLike(expr.Like, left, row, sc)
+	}
+	if expr.In != nil {
+		return evalIn(expr.In, left, row, sc)
+	}
+	if expr.Between != nil {
+		return evalBetween(expr.Between, left, row, sc)
+	}
+	if expr.Right == nil {
+		return left, nil
+	}
+	right, err := evalSum(expr.Right, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	if left == nil || right == nil || sqlNullInJSON(left, right) || sqlNullInJSON(right, left) {
+		return nil, nil
+	}
+
+	cmp, err := compareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch expr.Op {
+	case "=":
+		return cmp == 0, nil
+	case "!=", "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	default:
+		return nil, fmt.Errorf("unknown operator %s", expr.Op)
+	}
+}
+
+func evalSum(expr *parser.Sum, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalProduct(expr.Left, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range expr.Rest {
+		right, err := evalProduct(term.Product, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = arith(term.Op, v, right); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func evalProduct(expr *parser.Product, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalAccess(expr.Left, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range expr.Rest {
+		right, err := evalAccess(term.Access, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		if v, err = arith(term.Op, v, right); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func evalAccess(expr *parser.Access, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalOperand(expr.Operand, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	for _, arrow := range expr.Arrows {
+		if v, err = evalArrow(arrow, v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func evalOperand(op *parser.Operand, row types.Row, sc scope) (types.Value, error) {
+	switch {
+	case op.Value != nil:
+		return op.Value.ToInterface(), nil
+	case op.Column != nil:
+		return sc.columnValue(op.Column, row)
+	case op.Call != nil:
+		return evalCall(op.Call, row, sc)
+	case op.Extract != nil:
+		return evalExtract(op.Extract, row, sc)
+	case op.Cast != nil:
+		return evalCast(op.Cast, row, sc)
+	case op.Case != nil:
+		return evalCase(op.Case, row, sc)
+	case op.Exists != nil:
+		return sc.exec.evalExists(op.Exists, row, sc)
+	case op.Subquery != nil:
+		return sc.exec.evalScalarSubquery(op.Subquery, row, sc)
+	case op.Negate != nil:
+		v, err := evalOperand(op.Negate, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		return negate(v)
+	case op.Sub != nil:
+		return evalExpr(op.Sub, row, sc)
+	default:
+		return nil, fmt.Errorf("empty expression")
+	}
+}
+
+/*
+arith applies +, -, * or /, and || (see concatenate). Numbers stay INT while both sides are INT (INT / INT
+truncates), become DECIMAL when either side is DECIMAL and FLOAT otherwise.
+For dates and times:
+  - DATE ± INT moves by whole days, DATE - DATE counts the days between
+  - DATE or TIMESTAMP ± INTERVAL gives a TIMESTAMP
+  - TIMESTAMP - TIMESTAMP gives the INTERVAL between them (in days and time)
+  - INTERVAL ± INTERVAL adds up field by field
+*/
+func arith(op string, a, b types.Value) (types.Value, error) {
+	if a == nil || b == nil {
+		return nil, nil
+	}
+	if op == "||" {
+		return concatenate(a, b)
+	}
+	if x, y, ok, err := decimalOperands(a, b); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return decimalArith(op, x, y)
+	}
+	if op == "*" || op == "/" {
+		return multiply(op, a, b)
+	}
+
+	// subtracting a number or an interval is adding its negation, which leaves
+	// only differences between dates and timestamps for "-" below
+	switch b.(type) {
+	case int, float64, types.Interval:
+		if op == "-" {
+			nb, err := negate(b)
+			if err != nil {
+				return nil, err
+			}
+			return arith("+", a, nb)
+		}
+	}
+
+	switch x := a.(type) {
+	case int:
+		switch y := b.(type) {
+		case int:
+			return x + y, nil
+		case float64:
+			return float64(x) + y, nil
+		case types.Date:
+			if op == "+" {
+				return y.AddDays(x)
+			}
+		}
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return x + float64(y), nil
+		case float64:
+			return x + y, nil
+		}
+	case types.Date:
+		switch y := b.(type) {
+		case int:
+			return x.AddDays(y)
+		case types.Interval:
+			return y.AddTo(x.Timestamp())
+		case types.Date:
+			if op == "-" {
+				return int(x - y), nil
+			}
+		case types.Timestamp:
+			if op == "-" {
+				return between(x.Timestamp(), y), nil
+			}
+		}
+	case types.Timestamp:
+		switch y := b.(type) {
+		case types.Interval:
+			return y.AddTo(x)
+		case types.Timestamp:
+			if op == "-" {
+				return between(x, y), nil
+			}
+		case types.Date:
+			if op == "-" {
+				return between(x, y.Timestamp()), nil
+			}
+		}
+	case types.Interval:
+		switch y := b.(type) {
+		case types.Interval:
+			return x.Add(y)
+		case types.Date:
+			if op == "+" {
+				return x.AddTo(y.Timestamp())
+			}
+		case types.Timestamp:
+			if op == "+" {
+				return x.AddTo(y)
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot compute %s %s %s", describe(a), op, describe(b))
+}
+
+func multiply(op string, a, b types.Value) (types.Value, error) {
+	switch x := a.(type) {
+	case int:
+		switch y := b.(type) {
+		case int:
+			if op == "*" {
+				return x * y, nil
+			}
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return x / y, nil
+		case float64:
+			return multiplyFloat(op, float64(x), y)
+		}
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return multiplyFloat(op, x, float64(y))
+		case float64:
+			return multiplyFloat(op, x, y)
+		}
+	}
+	return nil, fmt.Errorf("cannot compute %s %s %s", describe(a), op, describe(b))
+}
+
+func multiplyFloat(op string, x, y float64) (types.Value, error) {
+	if op == "*" {
+		return x * y, nil
+	}
+	if y == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return x / y, nil
+}
+
+// decimalOperands converts both sides to DECIMAL when one of them is a DECIMAL and the other a number.
+// FLOATs convert through their shortest decimal form, so a literal like 1.1 is taken as written.
+func decimalOperands(a, b types.Value) (types.Decimal, types.Decimal, bool, error) {
+	_, aDec := a.(types.Decimal)
+	_, bDec := b.(types.Decimal)
+	if !aDec && !bDec {
+		return types.Decimal{}, types.Decimal{}, false, nil
+	}
+	x, okA, err := toDecimal(a)
+	if err != nil {
+		return types.Decimal{}, types.Decimal{}, false, err
+	}
+	y, okB, err := toDecimal(b)
+	if err != nil {
+		return types.Decimal{}, types.Decimal{}, false, err
+	}
+	return x, y, okA && okB, nil
+}
+
+func toDecimal(v types.Value) (types.Decimal, bool, error) {
+	switch x := v.(type) {
+	case types.Decimal:
+		return x, true, nil
+	case int:
+		return types.DecimalFromInt(x), true, nil
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return types.Decimal{}, false, fmt.Errorf("cannot use %v as a DECIMAL", x)
+		}
+		d, err := types.DecimalFromFloat(x)
+		return d, err == nil, err
+	default:
+		return types.Decimal{}, false, nil
+	}
+}
+
+func decimalArith(op string, x, y types.Decimal) (types.Value, error) {
+	switch op {
+	case "+":
+		return x.Add(y), nil
+	case "-":
+		return x.Add(y.Neg()), nil
+	case "*":
+		return x.Mul(y), nil
+	case "/":
+		return x.Quo(y)
+	default:
+		return nil, fmt.Errorf("unknown operator %s", op)
+	}
+}
+
+// between is the interval from b to a, split into whole days and the time left over
+func between(a, b types.Timestamp) types.Interval {
+	diff := int64(a - b)
+	return types.Interval{
+		Days:   int32(diff / types.MICROS_PER_DAY),
+		Micros: diff % types.MICROS_PER_DAY,
+	}
+}
+
+func negate(v types.Value) (types.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		return -x, nil
+	case float64:
+		return -x, nil
+	case types.Decimal:
+		return x.Neg(), nil
+	case types.Interval:
+		return x.Negate()
+	default:
+		return nil, fmt.Errorf("cannot negate %s", describe(v))
+	}
+}
+
+// describe names a value's type along with the value, for error messages
+func describe(v types.Value) string {
+	switch v.(type) {
+	case types.Date, types.Timestamp, types.Interval:
+		return formatLiteral(v)
+	}
+	if t, ok := types.TypeOf(v); ok {
+		return t.String() + " " + formatLiteral(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func columnIndex(schema []types.Column, name string) int {
+	for i, col := range schema {
+		if strings.EqualFold(col.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func asBool(v types.Value, op string) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s expects boolean operands, got %v", op, v)
+	}
+	return b, nil
+}
+
+/*
+compareValues orders two values of compatible types. INT and FLOAT compare
+numerically, a DATE compares with a TIMESTAMP as midnight of that day, and TEXT
+compared with a DATE or TIMESTAMP is read as one. Trailing spaces are ignored when
+either side is a CHAR. DECIMAL compares exactly
+with INT, and with FLOAT through its shortest decimal form. Intervals are ordered by
+their length counting a month as 30 days, as PostgreSQL does.
+*/
+func compareValues(a, b types.Value) (int, error) {
+	if cmp, ok := compareDecimal(a, b); ok {
+		return cmp, nil
+	}
+	if cmp, ok, err := compareJSON(a, b); ok || err != nil {
+		return cmp, err
+	}
+	switch x := a.(type) {
+	case int:
+		switch y := b.(type) {
+		case int:
+			return compareOrdered(x, y), nil
+		case float64:
+			return compareOrdered(float64(x), y), nil
+		}
+	case float64:
+		switch y := b.(type) {
+		case int:
+			return compareOrdered(x, float64(y)), nil
+		case float64:
+			return compareOrdered(x, y), nil
+		}
+	case string:
+		switch y := b.(type) {
+		case string:
+			return strings.Compare(x, y), nil
+		case types.Char:
+			return strings.Compare(strings.TrimRight(x, " "), y.Trim()), nil
+		case types.Date, types.Timestamp:
+			cmp, err := compareValues(b, a)
+			return -cmp, err
+		}
+	case types.Char:
+		switch y := b.(type) {
+		case types.Char:
+			return strings.Compare(x.Trim(), y.Trim()), nil
+		case string:
+			return strings.Compare(x.Trim(), strings.TrimRight(y, " ")), nil
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), nil
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			switch {
+			case x == y:
+				return 0, nil
+			case !x:
+				return -1, nil
+			default:
+				return 1, nil
+			}
+		}
+	case types.Date:
+		switch y := b.(type) {
+		case types.Date:
+			return compareOrdered(x, y), nil
+		case types.Timestamp:
+			return compareOrdered(x.Timestamp(), y), nil
+		case string:
+			d, err := types.ParseDate(y)
+			if err != nil {
+				return 0, err
+			}
+			return compareOrdered(x, d), nil
+		}
+	case types.Timestamp:
+		switch y := b.(type) {
+		case types.Timestamp:
+			return compareOrdered(x, y), nil
+		case types.Date:
+			return compareOrdered(x, y.Timestamp()), nil
+		case string:
+			t, err := types.ParseTimestamp(y)
+			if err != nil {
+				return 0, err
+			}
+			return compareOrdered(x, t), nil
+		}
+	case types.Interval:
+		if y, ok := b.(types.Interval); ok {
+			return compareOrdered(intervalMicros(x), intervalMicros(y)), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", describe(a), describe(b))
+}
+
+// compareDecimal compares as DECIMAL when either side is a DECIMAL and the other a number,
+// converting FLOATs the same way arithmetic does
+func compareDecimal(a, b types.Value) (int, bool) {
+	x, y, ok, err := decimalOperands(a, b)
+	if !ok || err != nil {
+		return 0, false
+	}
+	return x.Cmp(y), true
+}
+
+// sqlNullInJSON reports whether a is JSON null being compared with a plain SQL value, which is as unknown as NULL
+func sqlNullInJSON(a, b types.Value) bool {
+	_, bIsJSON := b.(types.JSONValue)
+	return a == types.JSONValue("null") && !bIsJSON
+}
+
+// compareJSON handles comparisons with a JSON value on either side
+func compareJSON(a, b types.Value) (int, bool, error) {
+	x, isX := a.(types.JSONValue)
+	y, isY := b.(types.JSONValue)
+	switch {
+	case isX && isY:
+		xs, okX := x.Scalar()
+		ys, okY := y.Scalar()
+		if okX && okY && xs != nil && ys != nil {
+			cmp, err := compareValues(xs, ys)
+			return cmp, true, err
+		}
+		return strings.Compare(string(x), string(y)), true, nil
+	case isY:
+		cmp, ok, err := compareJSON(b, a)
+		return -cmp, ok, err
+	case !isX:
+		return 0, false, nil
+	}
+	scalar, ok := x.Scalar()
+	if !ok || scalar == nil {
+		return 0, true, fmt.Errorf("cannot compare JSON %s with %s", x, describe(b))
+	}
+	cmp, err := compareValues(scalar, b)
+	return cmp, true, err
+}
+
+func intervalMicros(iv types.Interval) int64 {
+	return (int64(iv.Months)*30+int64(iv.Days))*types.MICROS_PER_DAY + iv.Micros
+}
+
+func compareOrdered[T ~int | ~int32 | ~int64 | ~float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// coerceValue converts a literal to the Go type stored for a column. INT widens to FLOAT,
+// DATE to TIMESTAMP, numbers convert to DECIMAL (rounded to the column's scale) and TEXT is
+// parsed for DATE, TIMESTAMP, INTERVAL, DECIMAL and JSON columns. TEXT and CHAR convert into
+// each other, checked against the column's length, with CHAR padded out to it, and JSON
+// is stored in a TEXT column as its text. NULL fits any column.
+func coerceValue(v types.Value, col types.Column) (types.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch col.Type {
+	case types.INT:
+		if i, ok := v.(int); ok {
+			return i, nil
+		}
+	case types.FLOAT:
+		switch x := v.(type) {
+		case float64:
+			return x, nil
+		case int:
+			return float64(x), nil
+		case types.Decimal:
+			return x.Float64(), nil
+		}
+	case types.DECIMAL:
+		d, ok, err := toDecimal(v)
+		if text, isText := v.(string); isText {
+			d, err = types.ParseDecimal(text)
+			ok = true
+		}
+		if ok || err != nil {
+			if err == nil && col.Precision > 0 {
+				d, err = d.Fit(int(col.Precision), int(col.Scale))
+			}
+			if err == nil {
+				err = d.CheckStorable()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			return d, nil
+		}
+	case types.TEXT:
+		switch x := v.(type) {
+		case string:
+			return fitLength(x, col)
+		case types.Char:
+			return fitLength(x.Trim(), col)
+		case types.JSONValue:
+			return fitLength(string(x), col)
+		}
+	case types.JSON:
+		switch x := v.(type) {
+		case types.JSONValue:
+			return x, nil
+		case string:
+			doc, err := types.ParseJSON(x)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", col.Name, err)
+			}
+			return doc, nil
+		}
+	case types.CHAR:
+		text, ok := v.(string)
+		if c, isChar := v.(types.Char); isChar {
+			text, ok = c.Trim(), true
+		}
+		if ok {
+			text, err := fitLength(text, col)
+			if err != nil {
+				return nil, err
+			}
+			return types.Char(text + strings.Repeat(" ", int(col.Length)-utf8.RuneCountInString(text))), nil
+		}
+	case types.BOOLEAN:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case types.BLOB:
+		if b, ok := v.([]byte); ok {
+			return b, nil
+		}
+	case types.DATE:
+		switch x := v.(type) {
+		case types.Date:
+			return x, nil
+		case string:
+			return types.ParseDate(x)
+		}
+	case types.TIMESTAMP:
+		switch x := v.(type) {
+		case types.Timestamp:
+			return x, nil
+		case types.Date:
+			return x.Timestamp(), nil
+		case string:
+			return types.ParseTimestamp(x)
+		}
+	case types.INTERVAL:
+		switch x := v.(type) {
+		case types.Interval:
+			return x, nil
+		case string:
+			return types.ParseInterval(x)
+		}
+	}
+	if t, ok := types.TypeOf(v); ok {
+		return nil, fmt.Errorf("column %s is %s, cannot store %s value %s", col.Name, col.TypeName(), t, formatLiteral(v))
+	}
+	return nil, fmt.Errorf("column %s is %s, cannot store %v", col.Name, col.TypeName(), v)
+}
+
+// fitLength checks a string against the length of a VARCHAR(n) or CHAR(n) column. Like
+// PostgreSQL, trailing spaces beyond the limit are cut off rather than rejected.
+func fitLength(s string, col types.Column) (string, error) {
+	if col.Length == 0 || utf8.RuneCountInString(s) <= int(col.Length) {
+		return s, nil
+	}
+	if n := utf8.RuneCountInString(strings.TrimRight(s, " ")); n > int(col.Length) {
+		return "", fmt.Errorf("column %s is %s, value %s is too long (%d characters)", col.Name, col.TypeName(), formatLiteral(s), n)
+	}
+	return string([]rune(s)[:col.Length]), nil
+}
+
+// formatValue writes a value for result output, BLOBs in hex
+func formatValue(v types.Value) string {
+	switch x := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return formatLiteral(x)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatLiteral writes a value the way it would appear in SQL
+func formatLiteral(v types.Value) string {
+	switch x := v.(type) {
+	case string:
+		return "'" + x + "'"
+	case types.Char:
+		return "'" + string(x) + "'"
+	case types.JSONValue:
+		return "'" + string(x) + "'"
+	case float64:
+		s := strconv.FormatFloat(x, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		return s
+	case []byte:
+		return "X'" + hex.EncodeToString(x) + "'"
+	case types.Date, types.Timestamp, types.Interval:
+		t, _ := types.TypeOf(v)
+		return fmt.Sprintf("%s '%v'", t, v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
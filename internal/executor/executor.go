@@ -2,15 +2,24 @@ package executor
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/mbeka02/pesapal_challenge/internal/db"
 	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
 	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
 type Executor struct {
 	db *db.DB
+	// per statement state: compiled patterns and planned subqueries
+	patterns   patternCache
+	subqueries map[*parser.Select]*subquery
+	// views are the views being planned, to catch one that ends up reading itself
+	views map[string]bool
+	// reads collects the tables and views planning reads, when set, to find what a view depends on
+	reads map[string]bool
 }
 
 func NewExecutor(database *db.DB) *Executor {
@@ -18,29 +27,67 @@ func NewExecutor(database *db.DB) *Executor {
 }
 
 func (e *Executor) Execute(sql *parser.SQL) (string, error) {
+	// patterns are compiled and subqueries planned once per statement
+	e.patterns = make(patternCache)
+	e.subqueries = make(map[*parser.Select]*subquery)
+	e.views = make(map[string]bool)
 	if sql.CreateTable != nil {
 		return e.executeCreateTable(sql.CreateTable)
 	}
+	if sql.CreateIndex != nil {
+		return e.executeCreateIndex(sql.CreateIndex)
+	}
+	if sql.CreateView != nil {
+		return e.executeCreateView(sql.CreateView)
+	}
+	if sql.DropTable != nil {
+		return e.executeDropTable(sql.DropTable)
+	}
+	if sql.DropView != nil {
+		return e.executeDropView(sql.DropView)
+	}
+	if sql.Refresh != nil {
+		return e.executeRefresh(sql.Refresh)
+	}
+	if sql.Truncate != nil {
+		return e.executeTruncate(sql.Truncate)
+	}
+	if sql.AlterTable != nil {
+		return e.executeAlterTable(sql.AlterTable)
+	}
 	if sql.Insert != nil {
 		return e.executeInsert(sql.Insert)
 	}
 	if sql.Select != nil {
 		return e.executeSelect(sql.Select)
 	}
+	if sql.Update != nil {
+		return e.executeUpdate(sql.Update)
+	}
+	if sql.Delete != nil {
+		return e.executeDelete(sql.Delete)
+	}
+	if sql.Vacuum != nil {
+		return e.executeVacuum()
+	}
+	if sql.Pragma != nil {
+		return e.executePragma(sql.Pragma)
+	}
 	return "", fmt.Errorf("unknown statement type")
 }
 
 func (e *Executor) executeCreateTable(stmt *parser.CreateTable) (string, error) {
+	if stmt.AsSelect != nil {
+		return e.createTableAs(stmt.TableName, stmt.AsSelect)
+	}
+
 	schema := make([]types.Column, len(stmt.Columns))
-	for i, col := range stmt.Columns {
-		dataType, err := parseDataType(col.Type)
+	for i, def := range stmt.Columns {
+		col, err := buildColumn(def)
 		if err != nil {
 			return "", err
 		}
-		schema[i] = types.Column{
-			Name: col.Name,
-			Type: dataType,
-		}
+		schema[i] = col
 	}
 
 	if err := e.db.CreateTable(stmt.TableName, schema); err != nil {
@@ -50,36 +97,349 @@ func (e *Executor) executeCreateTable(stmt *parser.CreateTable) (string, error)
 	return fmt.Sprintf("Table '%s' created successfully", stmt.TableName), nil
 }
 
+// createTableAs creates a table shaped like a query's output and fills it from the query
+func (e *Executor) createTableAs(name string, stmt *parser.Select) (string, error) {
+	q, err := e.planSelect(stmt, nil)
+	if err != nil {
+		return "", err
+	}
+	for i, col := range q.columns {
+		if columnIndex(q.columns[:i], col.Name) >= 0 {
+			return "", fmt.Errorf("duplicate column %s, give one an alias with AS", col.Name)
+		}
+	}
+	if err := e.db.CreateTable(name, q.columns); err != nil {
+		return "", err
+	}
+	table := e.db.Tables[name]
+
+	count := 0
+	var insertErr error
+	err = e.run(q, nil, func(row types.Row) bool {
+		insertErr = table.Insert(row)
+		count++
+		return insertErr == nil
+	})
+	if err == nil {
+		err = insertErr
+	}
+	if err != nil {
+		// don't leave a half filled table behind
+		if dropErr := e.db.DropTable(name); dropErr != nil {
+			return "", fmt.Errorf("%w (dropping %s failed too: %v)", err, name, dropErr)
+		}
+		return "", err
+	}
+	return fmt.Sprintf("Table '%s' created with %d row(s)", name, count), nil
+}
+
+func (e *Executor) executeCreateIndex(stmt *parser.CreateIndex) (string, error) {
+	// hash is the default, a B+tree also serves LIKE and GLOB prefixes
+	kind := db.HASH_INDEX
+	if strings.EqualFold(stmt.Using, "BTREE") {
+		kind = db.BTREE_INDEX
+	}
+
+	var path string
+	if stmt.Path != nil {
+		p, err := keyPath(*stmt.Path)
+		if err != nil {
+			return "", err
+		}
+		path = p.String()
+	}
+	if err := e.db.CreateIndex(stmt.IndexName, stmt.TableName, stmt.Column, path, kind); err != nil {
+		return "", err
+	}
+	idx := e.db.Tables[stmt.TableName].IndexOnPath(stmt.Column, path)
+	return fmt.Sprintf("Index '%s' created on '%s' (%s)", stmt.IndexName, stmt.TableName, idx.Describe()), nil
+}
+
+func (e *Executor) executeDropTable(stmt *parser.DropTable) (string, error) {
+	if _, exists := e.db.Tables[stmt.TableName]; !exists && e.db.Views[stmt.TableName] == nil && stmt.IfExists {
+		return fmt.Sprintf("Table '%s' does not exist, skipping", stmt.TableName), nil
+	}
+	if _, exists := e.db.Tables[stmt.TableName]; exists {
+		if err := e.checkNotRead("drop table", stmt.TableName); err != nil {
+			return "", err
+		}
+	}
+	if err := e.db.DropTable(stmt.TableName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Table '%s' dropped", stmt.TableName), nil
+}
+
+func (e *Executor) executeTruncate(stmt *parser.Truncate) (string, error) {
+	if err := e.db.TruncateTable(stmt.TableName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Table '%s' truncated", stmt.TableName), nil
+}
+
+func (e *Executor) executeAlterTable(stmt *parser.AlterTable) (string, error) {
+	// only adding a column leaves the views that read the table working
+	if _, exists := e.db.Tables[stmt.TableName]; exists && stmt.AddColumn == nil {
+		action := "rename table"
+		switch {
+		case stmt.DropColumn != nil:
+			action = fmt.Sprintf("drop column %s of table", *stmt.DropColumn)
+		case stmt.Rename.Column != nil:
+			action = fmt.Sprintf("rename column %s of table", stmt.Rename.Column.From)
+		}
+		if err := e.checkNotRead(action, stmt.TableName); err != nil {
+			return "", err
+		}
+	}
+
+	var err error
+	switch {
+	case stmt.AddColumn != nil:
+		err = e.addColumn(stmt.TableName, stmt.AddColumn)
+	case stmt.DropColumn != nil:
+		err = e.db.DropColumn(stmt.TableName, *stmt.DropColumn)
+	case stmt.Rename.Table != nil:
+		err = e.db.RenameTable(stmt.TableName, *stmt.Rename.Table)
+	default:
+		err = e.db.RenameColumn(stmt.TableName, stmt.Rename.Column.From, stmt.Rename.Column.To)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Table '%s' altered", stmt.TableName), nil
+}
+
+func (e *Executor) addColumn(tableName string, stmt *parser.AddColumn) error {
+	col, err := buildColumn(stmt.Column)
+	if err != nil {
+		return err
+	}
+	return e.db.AddColumn(tableName, col)
+}
+
+// buildColumn turns a column definition into a schema column, checking its DEFAULT against the type
+func buildColumn(def parser.Column) (types.Column, error) {
+	col, err := typedColumn(def.Type)
+	if err != nil {
+		return types.Column{}, fmt.Errorf("column %s: %w", def.Name, err)
+	}
+	col.Name = def.Name
+	if def.Default != nil {
+		col.Default, err = coerceValue(def.Default.ToInterface(), col)
+		if err != nil {
+			return types.Column{}, err
+		}
+	}
+	return col, nil
+}
+
+// typedColumn is an unnamed column of a declared type, with the (p, s) of DECIMAL
+// and the (n) of VARCHAR and CHAR checked; a CHAR without one is CHAR(1)
+func typedColumn(t parser.TypeName) (types.Column, error) {
+	dataType, err := parseDataType(t.Name)
+	if err != nil {
+		return types.Column{}, err
+	}
+	col, name, params := types.Column{Type: dataType}, strings.ToUpper(t.Name), t.Params
+	switch {
+	case dataType == types.DECIMAL && params != nil:
+		p, s := params.Size, 0
+		if params.Scale != nil {
+			s = *params.Scale
+		}
+		if p < 1 || p > types.MAX_DECIMAL_PRECISION {
+			return types.Column{}, fmt.Errorf("DECIMAL precision must be between 1 and %d, got %d", types.MAX_DECIMAL_PRECISION, p)
+		}
+		if s < 0 || s > p {
+			return types.Column{}, fmt.Errorf("DECIMAL scale must be between 0 and the precision %d, got %d", p, s)
+		}
+		col.Precision, col.Scale = uint8(p), uint8(s)
+	case name == "VARCHAR" || dataType == types.CHAR:
+		if params == nil {
+			if dataType == types.CHAR {
+				col.Length = 1
+			}
+			return col, nil
+		}
+		if params.Scale != nil {
+			return types.Column{}, fmt.Errorf("%s takes a single length", name)
+		}
+		if params.Size < 1 || params.Size > types.MAX_CHAR_LENGTH {
+			return types.Column{}, fmt.Errorf("%s length must be between 1 and %d, got %d", name, types.MAX_CHAR_LENGTH, params.Size)
+		}
+		col.Length = uint16(params.Size)
+	case params != nil:
+		return types.Column{}, fmt.Errorf("%s takes no parameters", dataType)
+	}
+	return col, nil
+}
+
+// executeInsert checks every VALUES row before inserting any, so a bad value
+// halfway through a bulk insert doesn't leave half the rows behind
 func (e *Executor) executeInsert(stmt *parser.Insert) (string, error) {
-	table, exists := e.db.Tables[stmt.TableName]
-	if !exists {
-		return "", fmt.Errorf("table '%s' does not exist", stmt.TableName)
+	table, err := e.writableTable(stmt.TableName)
+	if err != nil {
+		return "", err
 	}
 
-	if len(stmt.Values) != len(table.Schema) {
-		return "", fmt.Errorf("column count mismatch: expected %d, got %d",
-			len(table.Schema), len(stmt.Values))
+	targets, err := insertTargets(table.Schema, stmt.Columns)
+	if err != nil {
+		return "", err
+	}
+	if stmt.Select != nil {
+		return e.insertSelect(table, targets, stmt.Select)
 	}
 
-	row := make(types.Row, len(stmt.Values))
-	for i, val := range stmt.Values {
-		row[i] = val.ToInterface()
+	rows := make([]types.Row, len(stmt.Rows))
+	for r, values := range stmt.Rows {
+		if len(values.Values) != len(targets) {
+			return "", fmt.Errorf("row %d: column count mismatch: expected %d, got %d",
+				r+1, len(targets), len(values.Values))
+		}
+
+		literals := make(types.Row, len(values.Values))
+		var defaults []bool
+		for i, val := range values.Values {
+			if val.Default {
+				if defaults == nil {
+					defaults = make([]bool, len(values.Values))
+				}
+				defaults[i] = true
+				continue
+			}
+			literals[i] = val.Value.ToInterface()
+		}
+		row, err := buildRow(table.Schema, targets, literals, defaults)
+		if err == nil {
+			err = table.CheckKeys(row)
+		}
+		if err != nil {
+			if len(stmt.Rows) > 1 {
+				return "", fmt.Errorf("row %d: %w", r+1, err)
+			}
+			return "", err
+		}
+		rows[r] = row
+	}
+
+	for _, row := range rows {
+		if err := table.Insert(row); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Inserted %d row(s) into '%s'", len(rows), stmt.TableName), nil
+}
+
+/*
+insertSelect streams a query's rows into a table. Unlike VALUES the rows can't
+all be checked up front without holding the whole result, so a bad value stops
+the insert with the rows before it already written.
+*/
+func (e *Executor) insertSelect(table *db.Table, targets []int, stmt *parser.Select) (string, error) {
+	q, err := e.planSelect(stmt, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(q.columns) != len(targets) {
+		return "", fmt.Errorf("column count mismatch: expected %d, query returns %d", len(targets), len(q.columns))
+	}
+
+	count := 0
+	insert := func(values types.Row) error {
+		row, err := buildRow(table.Schema, targets, values, nil)
+		if err != nil {
+			return err
+		}
+		count++
+		return table.Insert(row)
+	}
+
+	var insertErr error
+	if q.readsTable(table) {
+		// reading the table being inserted into would find the new rows again,
+		// so this one case collects the result before writing any of it
+		var rows []types.Row
+		err = e.run(q, nil, func(row types.Row) bool {
+			rows = append(rows, row)
+			return true
+		})
+		for i := 0; err == nil && i < len(rows); i++ {
+			err = insert(rows[i])
+		}
+	} else {
+		err = e.run(q, nil, func(row types.Row) bool {
+			insertErr = insert(row)
+			return insertErr == nil
+		})
+	}
+	if err == nil {
+		err = insertErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Inserted %d row(s) into '%s'", count, table.Name), nil
+}
+
+/*
+buildRow fills a row in schema order: values go to the target columns and
+everything else, along with any value flagged in defaults, takes the column's
+DEFAULT, which is NULL unless one was declared.
+*/
+func buildRow(schema []types.Column, targets []int, values types.Row, defaults []bool) (types.Row, error) {
+	row := make(types.Row, len(schema))
+	for i, col := range schema {
+		row[i] = col.Default
+	}
+	for i, v := range values {
+		if defaults != nil && defaults[i] {
+			continue
+		}
+		v, err := coerceValue(v, schema[targets[i]])
+		if err != nil {
+			return nil, err
+		}
+		row[targets[i]] = v
+	}
+	return row, nil
+}
+
+// insertTargets maps an INSERT column list to schema positions, no list means every column in order
+func insertTargets(schema []types.Column, columns []string) ([]int, error) {
+	if len(columns) == 0 {
+		targets := make([]int, len(schema))
+		for i := range schema {
+			targets[i] = i
+		}
+		return targets, nil
 	}
 
-	table.Insert(row)
-	return fmt.Sprintf("Inserted 1 row into '%s'", stmt.TableName), nil
+	targets := make([]int, len(columns))
+	seen := make(map[int]bool, len(columns))
+	for i, name := range columns {
+		idx := columnIndex(schema, name)
+		if idx < 0 {
+			return nil, fmt.Errorf("unknown column: %s", name)
+		}
+		if seen[idx] {
+			return nil, fmt.Errorf("column %s listed more than once", name)
+		}
+		seen[idx] = true
+		targets[i] = idx
+	}
+	return targets, nil
 }
 
 func (e *Executor) executeSelect(stmt *parser.Select) (string, error) {
-	table, exists := e.db.Tables[stmt.TableName]
-	if !exists {
-		return "", fmt.Errorf("table '%s' does not exist", stmt.TableName)
+	q, err := e.planSelect(stmt, nil)
+	if err != nil {
+		return "", err
 	}
 
 	result := fmt.Sprintf("Results from '%s':\n", stmt.TableName)
 
 	// Print header
-	for i, col := range table.Schema {
+	for i, col := range q.columns {
 		if i > 0 {
 			result += " | "
 		}
@@ -89,32 +449,179 @@ func (e *Executor) executeSelect(stmt *parser.Select) (string, error) {
 
 	// Print rows
 	rowCount := 0
-	table.Scan(func(row types.Row) bool {
+	err = e.run(q, nil, func(row types.Row) bool {
 		for i, val := range row {
 			if i > 0 {
 				result += " | "
 			}
-			result += fmt.Sprintf("%v", val)
+			result += formatValue(val)
 		}
 		result += "\n"
 		rowCount++
 		return true
 	})
+	if err != nil {
+		return "", err
+	}
 
 	result += fmt.Sprintf("\n%d row(s) returned", rowCount)
 	return result, nil
 }
 
+/*
+executeUpdate evaluates the new values of every matching row against the row as
+it was, then writes them. Like a multi-row VALUES every row is checked before
+any is written, and collecting them first keeps a row that moves to another page
+from being found again by the scan.
+*/
+func (e *Executor) executeUpdate(stmt *parser.Update) (string, error) {
+	table, err := e.writableTable(stmt.TableName)
+	if err != nil {
+		return "", err
+	}
+
+	sc := e.tableScope(table, nil)
+	if err := checkWhere(stmt.Where, sc); err != nil {
+		return "", err
+	}
+	targets := make([]int, len(stmt.Set))
+	for i, set := range stmt.Set {
+		idx := columnIndex(table.Schema, set.Column)
+		if idx < 0 {
+			return "", fmt.Errorf("unknown column: %s", set.Column)
+		}
+		if slices.Contains(targets[:i], idx) {
+			return "", fmt.Errorf("column %s assigned more than once", set.Column)
+		}
+		targets[i] = idx
+		if set.Default {
+			continue
+		}
+		if _, err := inferType(set.Value, sc); err != nil {
+			return "", err
+		}
+	}
+
+	type change struct {
+		rid storage.RecordID
+		row types.Row
+	}
+	var changes []change
+	var evalErr error
+	err = e.scanTable(table, stmt.Where, sc, func(rid storage.RecordID, old types.Row) bool {
+		row := slices.Clone(old)
+		for i, set := range stmt.Set {
+			col := table.Schema[targets[i]]
+			if set.Default {
+				row[targets[i]] = col.Default
+				continue
+			}
+			v, err := evalExpr(set.Value, old, sc)
+			if err == nil {
+				v, err = coerceValue(v, col)
+			}
+			if err != nil {
+				evalErr = err
+				return false
+			}
+			row[targets[i]] = v
+		}
+		if err := table.CheckKeys(row); err != nil {
+			evalErr = err
+			return false
+		}
+		changes = append(changes, change{rid, row})
+		return true
+	})
+	if err == nil {
+		err = evalErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range changes {
+		if err := table.Update(c.rid, c.row); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Updated %d row(s) in '%s'", len(changes), stmt.TableName), nil
+}
+
+func (e *Executor) executeDelete(stmt *parser.Delete) (string, error) {
+	table, err := e.writableTable(stmt.TableName)
+	if err != nil {
+		return "", err
+	}
+
+	sc := e.tableScope(table, nil)
+	if err := checkWhere(stmt.Where, sc); err != nil {
+		return "", err
+	}
+
+	// collect matches first, deleting while scanning would shift pages under the scan
+	var matches []storage.RecordID
+	err = e.scanTable(table, stmt.Where, sc, func(rid storage.RecordID, _ types.Row) bool {
+		matches = append(matches, rid)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	for _, rid := range matches {
+		if err := table.Delete(rid); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Deleted %d row(s) from '%s'", len(matches), stmt.TableName), nil
+}
+
+func (e *Executor) executeVacuum() (string, error) {
+	freed, err := e.db.Vacuum()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Vacuum complete, %d page(s) reclaimed", freed), nil
+}
+
+func (e *Executor) executePragma(stmt *parser.Pragma) (string, error) {
+	switch strings.ToLower(stmt.Name) {
+	case "integrity_check":
+		problems := e.db.IntegrityCheck()
+		if len(problems) == 0 {
+			return "ok", nil
+		}
+		return strings.Join(problems, "\n") + fmt.Sprintf("\n\n%d problem(s) found", len(problems)), nil
+	default:
+		return "", fmt.Errorf("unknown pragma: %s", stmt.Name)
+	}
+}
+
 func parseDataType(typeStr string) (types.DataType, error) {
-	switch typeStr {
+	switch strings.ToUpper(typeStr) {
 	case "INT":
 		return types.INT, nil
-	case "TEXT":
+	case "TEXT", "VARCHAR":
 		return types.TEXT, nil
+	case "CHAR":
+		return types.CHAR, nil
+	case "JSON":
+		return types.JSON, nil
 	case "BOOLEAN":
 		return types.BOOLEAN, nil
 	case "FLOAT":
 		return types.FLOAT, nil
+	case "DATE":
+		return types.DATE, nil
+	case "TIMESTAMP":
+		return types.TIMESTAMP, nil
+	case "INTERVAL":
+		return types.INTERVAL, nil
+	case "DECIMAL", "NUMERIC":
+		return types.DECIMAL, nil
+	case "BLOB":
+		return types.BLOB, nil
 	default:
 		return 0, fmt.Errorf("unknown data type: %s", typeStr)
 	}
@@ -0,0 +1,311 @@
+package executor
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+)
+
+// session runs SQL against a fresh database file
+type session struct {
+	t    *testing.T
+	db   *db.DB
+	e    *Executor
+	path string
+}
+
+func newSession(t *testing.T) *session {
+	t.Helper()
+	s := &session{t: t, path: filepath.Join(t.TempDir(), "test.db")}
+	s.open()
+	t.Cleanup(func() { s.db.Pager.Close() })
+	return s
+}
+
+func (s *session) open() {
+	s.t.Helper()
+	database, err := db.OpenDB(s.path)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.db, s.e = database, NewExecutor(database)
+}
+
+// reopen closes the database and opens the file again, as a new process would
+func (s *session) reopen() {
+	s.t.Helper()
+	if err := s.db.Pager.Close(); err != nil {
+		s.t.Fatal(err)
+	}
+	s.open()
+}
+
+func (s *session) try(sql string) (string, error) {
+	stmt, err := parser.Parse(sql)
+	if err != nil {
+		return "", err
+	}
+	return s.e.Execute(stmt)
+}
+
+// exec runs statements that have to succeed, returning the output of the last
+func (s *session) exec(sqls ...string) string {
+	s.t.Helper()
+	var out string
+	for _, sql := range sqls {
+		var err error
+		if out, err = s.try(sql); err != nil {
+			s.t.Fatalf("%s: %v", sql, err)
+		}
+	}
+	return out
+}
+
+// fail runs a statement that has to fail with an error mentioning want
+func (s *session) fail(sql, want string) {
+	s.t.Helper()
+	out, err := s.try(sql)
+	if err == nil {
+		s.t.Fatalf("%s: succeeded with %q, want an error mentioning %q", sql, out, want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		s.t.Fatalf("%s: error %q, want one mentioning %q", sql, err, want)
+	}
+}
+
+// query runs a SELECT and returns its rows as "a | b" lines
+func (s *session) query(sql string) []string {
+	s.t.Helper()
+	out := s.exec(sql)
+	lines := strings.Split(out, "\n")
+	// the rows run from after the header's underline to the blank line before the count
+	rows := []string{}
+	for _, line := range lines[3 : len(lines)-2] {
+		rows = append(rows, line)
+	}
+	return rows
+}
+
+// expect checks a query's rows, in order
+func (s *session) expect(sql string, want ...string) {
+	s.t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if got := s.query(sql); !slices.Equal(got, want) {
+		s.t.Fatalf("%s:\n got %q\nwant %q", sql, got, want)
+	}
+}
+
+func (s *session) checkIntegrity() {
+	s.t.Helper()
+	if out := s.exec("PRAGMA integrity_check;"); out != "ok" {
+		s.t.Fatalf("integrity check:\n%s", out)
+	}
+}
+
+type queryTest struct {
+	sql  string
+	want []string
+}
+
+func (s *session) expectAll(tests []queryTest) {
+	s.t.Helper()
+	for _, tt := range tests {
+		s.expect(tt.sql, tt.want...)
+	}
+}
+
+type errorTest struct {
+	sql  string
+	want string
+}
+
+func (s *session) failAll(tests []errorTest) {
+	s.t.Helper()
+	for _, tt := range tests {
+		s.fail(tt.sql, tt.want)
+	}
+}
+
+func TestIntegrityCheckAfterWrites(t *testing.T) {
+	s := newSession(t)
+	s.exec("CREATE TABLE t (id INT, pad TEXT);")
+	for i := 0; i < 20; i++ {
+		s.exec("INSERT INTO t VALUES (1, 'aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa'), (2, 'b'), (3, 'c');")
+	}
+	s.exec("CREATE INDEX t_id ON t (id);", "DELETE FROM t WHERE id = 1;")
+	s.checkIntegrity()
+	s.expect("SELECT COUNT(*) FROM t;", "40")
+}
+
+func TestVacuum(t *testing.T) {
+	s := newSession(t)
+	s.exec("CREATE TABLE t (id INT, pad TEXT);", "CREATE INDEX t_id ON t (id);")
+	for i := 0; i < 50; i++ {
+		s.exec("INSERT INTO t VALUES (1, 'aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa'), (2, 'b');")
+	}
+	s.exec("DELETE FROM t WHERE id = 1;")
+	if out := s.exec("VACUUM;"); out == "Vacuum complete, 0 page(s) reclaimed" {
+		t.Fatal("VACUUM should reclaim the pages the deleted rows used")
+	}
+	s.checkIntegrity()
+	s.expect("SELECT COUNT(*) FROM t WHERE id = 2;", "50")
+
+	s.reopen()
+	s.expect("SELECT COUNT(*) FROM t;", "50")
+	s.checkIntegrity()
+}
+
+func TestChangingTables(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT);",
+		"INSERT INTO users VALUES (1, 'ann'), (2, 'bob');",
+		"ALTER TABLE users ADD COLUMN age INT DEFAULT 18;",
+		"INSERT INTO users VALUES (3, 'cy', 40);",
+		"ALTER TABLE users RENAME COLUMN name TO full_name;",
+		"ALTER TABLE users DROP COLUMN age;",
+		"ALTER TABLE users ADD COLUMN age INT;",
+		"ALTER TABLE users RENAME TO people;",
+	)
+	s.expect("SELECT * FROM people;", "1 | ann | NULL", "2 | bob | NULL", "3 | cy | NULL")
+	s.reopen()
+	s.expect("SELECT id, full_name FROM people WHERE id = 3;", "3 | cy")
+	s.failAll([]errorTest{
+		{"SELECT * FROM users;", "does not exist"},
+		{"ALTER TABLE people ADD COLUMN id INT;", "already exists"},
+		{"ALTER TABLE people DROP COLUMN nope;", "has no column nope"},
+		{"DROP TABLE nope;", "does not exist"},
+	})
+	s.exec("TRUNCATE people;")
+	s.expect("SELECT COUNT(*) FROM people;", "0")
+	s.exec("DROP TABLE people;", "DROP TABLE IF EXISTS people;")
+	s.checkIntegrity()
+}
+
+func TestInsertTypeChecking(t *testing.T) {
+	s := newSession(t)
+	s.exec("CREATE TABLE t (i INT, f FLOAT, b BOOLEAN, s TEXT);")
+	s.exec("INSERT INTO t VALUES (1, 2, true, 'x');")
+	s.expect("SELECT * FROM t;", "1 | 2 | true | x")
+	s.failAll([]errorTest{
+		{"INSERT INTO t VALUES (1.5, 2, true, 'x');", "column i"},
+		{"INSERT INTO t VALUES (1, 'two', true, 'x');", "column f"},
+		{"INSERT INTO t VALUES (1, 2, 1, 'x');", "column b"},
+		{"INSERT INTO t VALUES (1, 2, true);", "column count mismatch"},
+	})
+	s.expect("SELECT COUNT(*) FROM t;", "1")
+}
+
+func TestInsertColumnsAndDefaults(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT, admin BOOLEAN DEFAULT false, score FLOAT);",
+		"INSERT INTO users (id, name) VALUES (1, 'a'), (2, 'b');",
+		"INSERT INTO users VALUES (3, 'c', DEFAULT, 70);",
+		"INSERT INTO users (score, id) VALUES (1.5, 4);",
+	)
+	s.expect("SELECT * FROM users;",
+		"1 | a | false | NULL",
+		"2 | b | false | NULL",
+		"3 | c | false | 70",
+		"4 | NULL | false | 1.5",
+	)
+	// a bad row stops the whole statement
+	s.fail("INSERT INTO users (id) VALUES (5), ('six');", "column id")
+	s.expect("SELECT COUNT(*) FROM users;", "4")
+	s.fail("INSERT INTO users (id, nope) VALUES (5, 1);", "nope")
+	s.fail("INSERT INTO users (id, id) VALUES (5, 1);", "id")
+}
+
+func TestInsertSelectAndCreateTableAs(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT, score FLOAT);",
+		"INSERT INTO users VALUES (1, 'a', 10), (2, 'b', 60), (3, 'c', 90);",
+		"CREATE TABLE archive (id INT, name TEXT);",
+		"INSERT INTO archive (id, name) SELECT id, name FROM users WHERE score < 50;",
+		"CREATE TABLE passed AS SELECT id, name, score >= 50 AS passed FROM users;",
+	)
+	s.expect("SELECT * FROM archive;", "1 | a")
+	s.expect("SELECT * FROM passed WHERE passed;", "2 | b | true", "3 | c | true")
+
+	// a table can be inserted into from itself without seeing its own new rows
+	s.exec("INSERT INTO archive SELECT id + 10, name FROM archive;")
+	s.expect("SELECT * FROM archive;", "1 | a", "11 | a")
+
+	s.fail("CREATE TABLE bad AS SELECT id, id FROM users;", "duplicate column")
+	s.fail("SELECT * FROM bad;", "does not exist")
+}
+
+func TestUpdate(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT, score FLOAT, admin BOOLEAN DEFAULT false);",
+		"INSERT INTO users VALUES (1, 'a', 10, true), (2, 'b', 20, true), (3, 'c', NULL, false);",
+		"CREATE INDEX users_name ON users (name);",
+	)
+	s.exec(
+		"UPDATE users SET score = score * 2, name = UPPER(name) WHERE id < 3;",
+		"UPDATE users SET admin = DEFAULT, score = 1 WHERE name = 'c';",
+	)
+	s.expect("SELECT * FROM users;", "1 | A | 20 | true", "2 | B | 40 | true", "3 | c | 1 | false")
+	// the index follows the new values
+	s.expectAll([]queryTest{
+		{"SELECT id FROM users WHERE name = 'A';", []string{"1"}},
+		{"SELECT id FROM users WHERE name = 'a';", nil},
+	})
+	// values are computed from the row as it was
+	s.exec("UPDATE users SET id = id + 1, score = id;")
+	s.expect("SELECT id, score FROM users;", "2 | 1", "3 | 2", "4 | 3")
+
+	s.failAll([]errorTest{
+		{"UPDATE users SET score = 'high';", "column score"},
+		{"UPDATE users SET nope = 1;", "unknown column"},
+		{"UPDATE users SET id = 1, id = 2;", "more than once"},
+		{"UPDATE users SET id = SUM(id);", "not allowed"},
+		{"UPDATE users SET id = 1 WHERE name;", "boolean"},
+		{"UPDATE nope SET id = 1;", "does not exist"},
+	})
+	s.checkIntegrity()
+}
+
+func TestUpdateMovesGrowingRows(t *testing.T) {
+	s := newSession(t)
+	s.exec("CREATE TABLE t (id INT, pad TEXT);", "CREATE INDEX t_id ON t (id);")
+	for i := 0; i < 100; i++ {
+		s.exec("INSERT INTO t VALUES (1, 'x'), (2, 'y');")
+	}
+	// the rows no longer fit their page and move, each exactly once
+	s.exec("UPDATE t SET pad = 'zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz' WHERE id = 1;")
+	s.expectAll([]queryTest{
+		{"SELECT COUNT(*), COUNT(DISTINCT pad) FROM t WHERE id = 1;", []string{"100 | 1"}},
+		{"SELECT COUNT(*) FROM t WHERE pad = 'y';", []string{"100"}},
+	})
+	s.checkIntegrity()
+	s.reopen()
+	s.expect("SELECT COUNT(*) FROM t WHERE id = 1;", "100")
+
+	// shrinking the rows again leaves room the free space map hands to new inserts
+	pages, err := s.db.Tables["t"].Heap.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.exec("UPDATE t SET pad = 'x' WHERE id = 1;")
+	for i := 0; i < 100; i++ {
+		s.exec("INSERT INTO t VALUES (3, 'zzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzzz');")
+	}
+	after, err := s.db.Tables["t"].Heap.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(pages) {
+		t.Fatalf("heap grew from %d to %d pages, the inserts should reuse the space UPDATE freed", len(pages), len(after))
+	}
+	s.checkIntegrity()
+}
@@ -0,0 +1,502 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Built-in scalar functions are looked up by name in the functions registry,
+which records what each one accepts so a call is checked before any row is read.
+
+Date and time functions:
+  - NOW() is the current time as a TIMESTAMP, read each time it is evaluated
+  - DATE_TRUNC(field, t) rounds a DATE or TIMESTAMP down to the start of a
+    year, quarter, month, week (Monday), day, hour, minute or second
+  - EXTRACT(field FROM t) pulls a part out of a DATE or TIMESTAMP
+  - STRFTIME(format, t) formats a DATE or TIMESTAMP like SQLite's strftime
+
+COALESCE(a, b, ...) is its first argument that isn't NULL and NULLIF(a, b) is
+a unless it equals b, when it is NULL. String functions are in text.go, math
+functions in math.go, the JSON ones in json.go and Go functions registered on
+the DB in udf.go.
+*/
+
+// function is a built-in, or a Go function registered on the DB adapted by goScalar or goAggregate
+type function struct {
+	// params are what each argument may be; the last optional ones can be
+	// left out, and when variadic the last one can be repeated
+	params   []param
+	optional int
+	variadic bool
+	// result is the type returned for the given argument types
+	result func(name string, args []types.DataType) (types.DataType, error)
+	// a NULL argument makes the result NULL without calling eval, unless takesNull is set
+	takesNull bool
+	eval      func(args []types.Value) (types.Value, error)
+	// aggregate functions have no eval, they fold the rows of a query into an accumulator instead
+	aggregate func() accumulator
+	// star is set for COUNT, which can be called as COUNT(*)
+	star bool
+}
+
+type param struct {
+	name    string
+	accepts func(types.DataType) bool
+}
+
+var (
+	anyParam    = param{"any", func(types.DataType) bool { return true }}
+	numberParam = param{"number", isNumeric}
+	intParam    = param{"INT", func(t types.DataType) bool { return isType(t, types.INT) }}
+	textParam   = param{"TEXT", isTextType}
+	timeParam   = param{"DATE or TIMESTAMP", isTimeType}
+	jsonParam   = param{"JSON", isJSONType}
+	lengthParam = param{"TEXT or BLOB", func(t types.DataType) bool { return isTextType(t) || t == types.BLOB }}
+)
+
+func returns(t types.DataType) func(string, []types.DataType) (types.DataType, error) {
+	return func(string, []types.DataType) (types.DataType, error) {
+		return t, nil
+	}
+}
+
+func sameAsFirst(_ string, args []types.DataType) (types.DataType, error) {
+	return args[0], nil
+}
+
+var functions map[string]*function
+
+func init() {
+	functions = map[string]*function{
+		"NOW": {result: returns(types.TIMESTAMP), eval: func([]types.Value) (types.Value, error) {
+			return types.TimestampOf(time.Now()), nil
+		}},
+		"DATE_TRUNC": {params: []param{textParam, timeParam}, result: returns(types.TIMESTAMP), eval: timeFunction("DATE_TRUNC", dateTrunc)},
+		"STRFTIME":   {params: []param{textParam, timeParam}, result: returns(types.TEXT), eval: timeFunction("STRFTIME", strftime)},
+
+		"COALESCE": {params: []param{anyParam, anyParam}, variadic: true, result: commonType, takesNull: true, eval: coalesce},
+		"NULLIF":   {params: []param{anyParam, anyParam}, result: nullifType, takesNull: true, eval: nullif},
+
+		"UPPER":   {params: []param{textParam}, result: returns(types.TEXT), eval: mapText(strings.ToUpper)},
+		"LOWER":   {params: []param{textParam}, result: returns(types.TEXT), eval: mapText(strings.ToLower)},
+		"LENGTH":  {params: []param{lengthParam}, result: returns(types.INT), eval: length},
+		"SUBSTR":  {params: []param{textParam, intParam, intParam}, optional: 1, result: returns(types.TEXT), eval: substr},
+		"TRIM":    {params: []param{textParam, textParam}, optional: 1, result: returns(types.TEXT), eval: trim},
+		"REPLACE": {params: []param{textParam, textParam, textParam}, result: returns(types.TEXT), eval: replace},
+		"CONCAT":  {params: []param{anyParam}, variadic: true, result: returns(types.TEXT), takesNull: true, eval: concat},
+
+		"ABS":   {params: []param{numberParam}, result: sameAsFirst, eval: abs},
+		"ROUND": {params: []param{numberParam, intParam}, optional: 1, result: sameAsFirst, eval: roundFunction},
+		"FLOOR": {params: []param{numberParam}, result: sameAsFirst, eval: floorOrCeil(math.Floor, types.Decimal.Floor)},
+		"CEIL":  {params: []param{numberParam}, result: sameAsFirst, eval: floorOrCeil(math.Ceil, types.Decimal.Ceil)},
+		"MOD":   {params: []param{numberParam, numberParam}, result: modType, eval: mod},
+		"POWER": {params: []param{numberParam, numberParam}, result: returns(types.FLOAT), eval: power},
+
+		"COUNT": {params: []param{anyParam}, result: returns(types.INT), takesNull: true, aggregate: newCount, star: true},
+		"SUM":   {params: []param{numberParam}, result: sameAsFirst, aggregate: newSum},
+		"AVG":   {params: []param{numberParam}, result: avgType, aggregate: newAverage},
+
+		"JSON_EXTRACT":      {params: []param{jsonParam, textParam}, result: returns(types.TEXT), eval: jsonExtract},
+		"JSON_ARRAY_LENGTH": {params: []param{jsonParam, textParam}, optional: 1, result: returns(types.INT), eval: jsonArrayLength},
+	}
+	functions["CEILING"] = functions["CEIL"]
+	functions["POW"] = functions["POWER"]
+	functions["SUBSTRING"] = functions["SUBSTR"]
+}
+
+// lookupFunction finds a built-in, or else a Go function registered on the DB
+func lookupFunction(name string, sc scope) (*function, error) {
+	if fn, ok := functions[strings.ToUpper(name)]; ok {
+		return fn, nil
+	}
+	if sc.db != nil {
+		if fn, ok := sc.db.ScalarFunc(name); ok {
+			return goScalar(fn), nil
+		}
+		if fn, ok := sc.db.AggregateFunc(name); ok {
+			return goAggregate(fn), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown function %s", name)
+}
+
+// functionType checks a call's arguments against the function's signature and returns the type it produces
+func functionType(call *parser.Call, sc scope) (types.DataType, error) {
+	if call.Over != nil {
+		return windowType(call, sc)
+	}
+	name := strings.ToUpper(call.Name)
+	fn, err := lookupFunction(call.Name, sc)
+	if err != nil {
+		if _, ok := windowFunctions[name]; ok {
+			return 0, fmt.Errorf("%s is a window function, it needs OVER (...)", name)
+		}
+		return 0, err
+	}
+	switch {
+	case call.Distinct && fn.aggregate == nil:
+		return 0, fmt.Errorf("DISTINCT only applies to aggregate functions, not %s", name)
+	case call.Star && (!fn.star || call.Distinct):
+		return 0, fmt.Errorf("%s(%s*) is not valid, only COUNT(*) is", name, distinctPrefix(call))
+	}
+	// an aggregate's arguments are read from each row, where another aggregate makes no sense
+	argScope := sc
+	if fn.aggregate != nil {
+		if sc.aggregates == nil {
+			return 0, fmt.Errorf("aggregate function %s is not allowed here", name)
+		}
+		*sc.aggregates = append(*sc.aggregates, call)
+		argScope.aggregates, argScope.ungrouped, argScope.windows = nil, false, nil
+	}
+
+	args := make([]types.DataType, len(call.Args))
+	for i, arg := range call.Args {
+		t, err := inferType(arg, argScope)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = t
+	}
+
+	if call.Star {
+		return fn.result(name, args)
+	}
+	return fn.check(name, args)
+}
+
+// check checks argument types against the function's signature and returns the type it produces
+func (fn *function) check(name string, args []types.DataType) (types.DataType, error) {
+	required := len(fn.params) - fn.optional
+	if len(args) < required || (!fn.variadic && len(args) > len(fn.params)) {
+		return 0, fmt.Errorf("%s takes %s, got %d", name, fn.arity(), len(args))
+	}
+	for i, t := range args {
+		p := fn.params[min(i, len(fn.params)-1)]
+		if !p.accepts(t) {
+			return 0, fmt.Errorf("%s expects %s, got %s", name, fn.signature(), typeList(args))
+		}
+	}
+	return fn.result(name, args)
+}
+
+func distinctPrefix(call *parser.Call) string {
+	if call.Distinct {
+		return "DISTINCT "
+	}
+	return ""
+}
+
+// arity describes how many arguments a function takes, e.g. "1 or 2 arguments"
+func (fn *function) arity() string {
+	n, required := len(fn.params), len(fn.params)-fn.optional
+	switch {
+	case fn.variadic:
+		return "at least " + arguments(required)
+	case n == 0:
+		return "no arguments"
+	case required == n:
+		return arguments(n)
+	case required+1 == n:
+		return fmt.Sprintf("%d or %d arguments", required, n)
+	default:
+		return fmt.Sprintf("%d to %d arguments", required, n)
+	}
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%d arguments", n)
+}
+
+// signature describes a function's parameters, e.g. "(number[, INT])"
+func (fn *function) signature() string {
+	var b strings.Builder
+	required := len(fn.params) - fn.optional
+	for i, p := range fn.params {
+		switch {
+		case i == 0:
+		case i >= required:
+			b.WriteString("[, ")
+		default:
+			b.WriteString(", ")
+		}
+		b.WriteString(p.name)
+	}
+	b.WriteString(strings.Repeat("]", fn.optional))
+	if fn.variadic {
+		b.WriteString(", ...")
+	}
+	return "(" + b.String() + ")"
+}
+
+func evalCall(call *parser.Call, row types.Row, sc scope) (types.Value, error) {
+	if call.Over != nil {
+		v, ok := sc.folded[call]
+		if !ok {
+			return nil, fmt.Errorf("window function %s is not allowed here", strings.ToUpper(call.Name))
+		}
+		return v, nil
+	}
+	fn, err := lookupFunction(call.Name, sc)
+	if err != nil {
+		return nil, err
+	}
+	if fn.aggregate != nil {
+		v, ok := sc.folded[call]
+		if !ok {
+			return nil, fmt.Errorf("aggregate function %s is not allowed here", strings.ToUpper(call.Name))
+		}
+		return v, nil
+	}
+	args := make([]types.Value, len(call.Args))
+	for i, arg := range call.Args {
+		v, err := evalExpr(arg, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil && !fn.takesNull {
+			return nil, nil
+		}
+		args[i] = v
+	}
+	return fn.eval(args)
+}
+
+// timeFunction adapts DATE_TRUNC and STRFTIME, which take some text and a time
+func timeFunction(name string, f func(string, types.Timestamp) (types.Value, error)) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		t, err := asTime(args[1], name)
+		if err != nil {
+			return nil, err
+		}
+		return f(textOf(args[0]), t)
+	}
+}
+
+func coalesce(args []types.Value) (types.Value, error) {
+	for _, v := range args {
+		if v != nil {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+func nullif(args []types.Value) (types.Value, error) {
+	if args[0] == nil || args[1] == nil {
+		return args[0], nil
+	}
+	cmp, err := compareValues(args[0], args[1])
+	if err != nil || cmp == 0 {
+		return nil, err
+	}
+	return args[0], nil
+}
+
+// commonType is the type COALESCE's arguments share: numbers widen like they do
+// in arithmetic and TEXT and CHAR mix as TEXT
+func commonType(name string, args []types.DataType) (types.DataType, error) {
+	result := nullType
+	for _, t := range args {
+		switch {
+		case t == nullType || t == result:
+		case result == nullType:
+			result = t
+		case isNumeric(result) && isNumeric(t):
+			result, _ = inferArith(result, "+", t)
+		case isTextType(result) && isTextType(t):
+			result = types.TEXT
+		default:
+			return 0, fmt.Errorf("%s arguments must share a type, got %s", name, typeList(args))
+		}
+	}
+	return result, nil
+}
+
+func nullifType(name string, args []types.DataType) (types.DataType, error) {
+	if _, err := commonType(name, args); err != nil {
+		return 0, err
+	}
+	if args[0] == nullType {
+		return args[1], nil
+	}
+	return args[0], nil
+}
+
+// extractType checks an EXTRACT and returns its type: SECOND and EPOCH have fractions, the rest are whole numbers
+func extractType(expr *parser.Extract, sc scope) (types.DataType, error) {
+	t, err := inferType(expr.From, sc)
+	if err != nil {
+		return 0, err
+	}
+	if !isTimeType(t) {
+		return 0, fmt.Errorf("EXTRACT expects a DATE or TIMESTAMP, got %s", t)
+	}
+	switch strings.ToUpper(expr.Field) {
+	case "YEAR", "QUARTER", "MONTH", "WEEK", "DAY", "DOW", "DOY", "HOUR", "MINUTE":
+		return types.INT, nil
+	case "SECOND", "EPOCH":
+		return types.FLOAT, nil
+	default:
+		return 0, fmt.Errorf("EXTRACT: unknown field %s", expr.Field)
+	}
+}
+
+func evalExtract(expr *parser.Extract, row types.Row, sc scope) (types.Value, error) {
+	v, err := evalExpr(expr.From, row, sc)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	ts, err := asTime(v, "EXTRACT")
+	if err != nil {
+		return nil, err
+	}
+
+	t := ts.Time()
+	switch strings.ToUpper(expr.Field) {
+	case "YEAR":
+		return t.Year(), nil
+	case "QUARTER":
+		return (int(t.Month())-1)/3 + 1, nil
+	case "MONTH":
+		return int(t.Month()), nil
+	case "WEEK":
+		_, week := t.ISOWeek()
+		return week, nil
+	case "DAY":
+		return t.Day(), nil
+	case "DOW":
+		return int(t.Weekday()), nil
+	case "DOY":
+		return t.YearDay(), nil
+	case "HOUR":
+		return t.Hour(), nil
+	case "MINUTE":
+		return t.Minute(), nil
+	case "SECOND":
+		return float64(t.Second()) + float64(t.Nanosecond())/1e9, nil
+	case "EPOCH":
+		return float64(ts) / float64(types.MICROS_PER_SECOND), nil
+	default:
+		return nil, fmt.Errorf("EXTRACT: unknown field %s", expr.Field)
+	}
+}
+
+func dateTrunc(field string, ts types.Timestamp) (types.Value, error) {
+	t := ts.Time()
+	y, m, d := t.Date()
+	switch strings.ToLower(field) {
+	case "year":
+		t = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+	case "quarter":
+		t = time.Date(y, (m-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+	case "month":
+		t = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case "week":
+		// weeks start on Monday
+		back := (int(t.Weekday()) + 6) % 7
+		t = time.Date(y, m, d-back, 0, 0, 0, 0, time.UTC)
+	case "day":
+		t = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	case "hour":
+		t = t.Truncate(time.Hour)
+	case "minute":
+		t = t.Truncate(time.Minute)
+	case "second":
+		t = t.Truncate(time.Second)
+	default:
+		return nil, fmt.Errorf("DATE_TRUNC: unknown field %s", field)
+	}
+	return types.TimestampOf(t), nil
+}
+
+/*
+strftime supports SQLite's conversions: %d %H %j %m %M %S %Y, %f (seconds with
+milliseconds), %s (Unix seconds), %w (weekday, Sunday is 0), %W (week of the
+year, weeks start on Monday) and %%.
+*/
+func strftime(format string, ts types.Timestamp) (types.Value, error) {
+	t := ts.Time()
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return nil, fmt.Errorf("STRFTIME: format ends with a lone %%")
+		}
+		switch format[i] {
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'f':
+			fmt.Fprintf(&b, "%02d.%03d", t.Second(), t.Nanosecond()/1e6)
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 's':
+			fmt.Fprintf(&b, "%d", t.Unix())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'w':
+			fmt.Fprintf(&b, "%d", int(t.Weekday()))
+		case 'W':
+			monday := (int(t.Weekday()) + 6) % 7
+			fmt.Fprintf(&b, "%02d", (t.YearDay()-1+7-monday)/7)
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case '%':
+			b.WriteByte('%')
+		default:
+			return nil, fmt.Errorf("STRFTIME: unknown conversion %%%c", format[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// asTime widens a DATE to a TIMESTAMP at midnight
+func asTime(v types.Value, fn string) (types.Timestamp, error) {
+	switch t := v.(type) {
+	case types.Timestamp:
+		return t, nil
+	case types.Date:
+		return t.Timestamp(), nil
+	default:
+		return 0, fmt.Errorf("%s expects a DATE or TIMESTAMP, got %v", fn, v)
+	}
+}
+
+func isTimeType(t types.DataType) bool {
+	return t == types.DATE || t == types.TIMESTAMP || t == nullType
+}
+
+func isNumeric(t types.DataType) bool {
+	return t == types.INT || t == types.FLOAT || t == types.DECIMAL || t == nullType
+}
+
+func typeList(ts []types.DataType) string {
+	names := make([]string, len(ts))
+	for i, t := range ts {
+		names[i] = t.String()
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+// isType reports whether an inferred type is t, a NULL literal fits anything
+func isType(got, t types.DataType) bool {
+	return got == t || got == nullType
+}
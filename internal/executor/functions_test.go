@@ -0,0 +1,216 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+)
+
+type sumSquares struct{ total float64 }
+
+func (s *sumSquares) Step(x float64) { s.total += x * x }
+func (s *sumSquares) Done() float64  { return s.total }
+
+func TestBuiltinFunctionsAndCasts(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT, email TEXT, score FLOAT);",
+		"INSERT INTO users VALUES (1, 'Wanjiru', 'w@x.ke', 92.6), (2, 'otieno', NULL, NULL);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT UPPER(name), SUBSTR(name, 1, 3), LENGTH(name) FROM users;", []string{"WANJIRU | Wan | 7", "OTIENO | oti | 6"}},
+		{"SELECT name || ' <' || email || '>', CONCAT(name, email) FROM users;", []string{"Wanjiru <w@x.ke> | Wanjiruw@x.ke", "NULL | otieno"}},
+		{"SELECT COALESCE(score, 0), NULLIF(id, 2) FROM users;", []string{"92.6 | 1", "0 | NULL"}},
+		{"SELECT TRIM('xxhixx', 'x'), REPLACE(name, 'i', 'I') FROM users WHERE id = 1;", []string{"hi | WanjIru"}},
+		{"SELECT ABS(-3), ROUND(score), ROUND(score, 1), FLOOR(score), CEIL(score), MOD(7, 3), POWER(2, 10) FROM users WHERE id = 1;",
+			[]string{"3 | 93 | 92.6 | 92 | 93 | 1 | 1024"}},
+		{"SELECT CAST(score AS INT), CAST('2024-01-31' AS DATE), CAST(id AS TEXT) || '!' FROM users WHERE id = 1;", []string{"93 | 2024-01-31 | 1!"}},
+		{"SELECT CAST(true AS INT), CAST('12' AS INT) + 1 FROM users WHERE id = 1;", []string{"1 | 13"}},
+	})
+	s.failAll([]errorTest{
+		{"SELECT UPPER(id) FROM users;", "UPPER"},
+		{"SELECT ROUND(1, 2, 3) FROM users;", "ROUND"},
+		{"SELECT nope(id) FROM users;", "nope"},
+		{"SELECT CAST('abc' AS INT) FROM users;", "abc"},
+	})
+}
+
+func TestGoFunctions(t *testing.T) {
+	s := newSession(t)
+	for _, err := range []error{
+		s.db.RegisterFunc("luhn_check", func(code string) bool {
+			sum, double := 0, false
+			for i := len(code) - 1; i >= 0; i-- {
+				n := int(code[i] - '0')
+				if double {
+					if n *= 2; n > 9 {
+						n -= 9
+					}
+				}
+				sum, double = sum+n, !double
+			}
+			return sum%10 == 0
+		}),
+		s.db.RegisterFunc("join", func(sep string, parts ...string) string { return strings.Join(parts, sep) }),
+		s.db.RegisterFunc("half", func(x float64) float64 { return x / 2 }),
+		s.db.RegisterFunc("fail", func(s string) (string, error) { return "", errors.New("refused " + s) }),
+		s.db.RegisterFunc("boom", func(int) int { panic("kaboom") }),
+		s.db.RegisterAggregate("sum_squares", func() *sumSquares { return &sumSquares{} }),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.db.RegisterFunc("bad", func(m map[string]int) int { return 0 }) == nil {
+		t.Fatal("a map parameter has no SQL type and should be rejected")
+	}
+	if s.db.RegisterAggregate("bad", func() int { return 0 }) == nil {
+		t.Fatal("an aggregate without Step and Done should be rejected")
+	}
+
+	s.exec(
+		"CREATE TABLE cards (id INT, card TEXT, amount INT);",
+		"INSERT INTO cards VALUES (1, '79927398713', 3), (2, '79927398710', 4), (3, NULL, NULL);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT id FROM cards WHERE luhn_check(card);", []string{"1"}},
+		{"SELECT join('-', card, 'x', 'y'), half(amount) FROM cards WHERE id = 1;", []string{"79927398713-x-y | 1.5"}},
+		{"SELECT sum_squares(amount), sum_squares(DISTINCT amount) FROM cards;", []string{"25 | 25"}},
+		{"SELECT half(amount) FROM cards WHERE id = 3;", []string{"NULL"}},
+	})
+	s.failAll([]errorTest{
+		{"SELECT fail(card) FROM cards WHERE id = 1;", "refused 79927398713"},
+		{"SELECT boom(id) FROM cards;", "kaboom"},
+		{"SELECT luhn_check(id) FROM cards;", "LUHN_CHECK expects (TEXT)"},
+		{"SELECT id, sum_squares(amount) FROM cards;", "aggregate"},
+	})
+}
+
+func TestPatternMatching(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE files (path TEXT);",
+		`INSERT INTO files VALUES ('internal/db/db.go'), ('internal/executor/plan.go'), ('README.md'), ('KE_01'), ('KEX01'), (NULL);`,
+		"CREATE INDEX files_path ON files (path) USING HASH;",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT * FROM files WHERE path LIKE 'internal/%';", []string{"internal/db/db.go", "internal/executor/plan.go"}},
+		{"SELECT * FROM files WHERE path ILIKE 'readme%';", []string{"README.md"}},
+		{"SELECT * FROM files WHERE path LIKE 'KE!_%' ESCAPE '!';", []string{"KE_01"}},
+		{"SELECT * FROM files WHERE path LIKE 'KE_01';", []string{"KE_01", "KEX01"}},
+		{"SELECT * FROM files WHERE path GLOB 'internal/*/[a-m]*.go';", []string{"internal/db/db.go"}},
+		{"SELECT * FROM files WHERE path REGEXP '^KE.[0-9]+$';", []string{"KE_01", "KEX01"}},
+		{"SELECT * FROM files WHERE path NOT LIKE '%.go';", []string{"README.md", "KE_01", "KEX01"}},
+		{"SELECT * FROM files WHERE path LIKE 'README.md';", []string{"README.md"}},
+	})
+	s.fail("SELECT * FROM files WHERE path REGEXP '(';", "regexp")
+}
+
+func TestPatternPrefixRangeScan(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE files (path TEXT, ext TEXT);",
+		`INSERT INTO files VALUES ('internal/db/db.go', 'go'), ('internal/executor/plan.go', 'go'), ('internal', NULL),
+			('internak/x', NULL), ('internal0', NULL), ('README.md', 'md'), ('KE_01', NULL), ('KEX01', NULL), (NULL, NULL);`,
+		"CREATE INDEX files_path ON files (path) USING BTREE;",
+		"CREATE INDEX files_ext ON files (ext);",
+	)
+
+	plans := []struct {
+		where  string
+		index  string
+		prefix string
+		value  any
+	}{
+		{"path LIKE 'internal/%'", "files_path", "internal/", nil},
+		{"path LIKE 'KE!_%' ESCAPE '!'", "files_path", "KE_", nil},
+		{"path GLOB 'internal/*/[a-m]*.go'", "files_path", "internal/", nil},
+		{"path LIKE 'README.md'", "files_path", "", "README.md"},
+		// an equality reads fewer rows than a range
+		{"path LIKE 'internal/%' AND ext = 'go'", "files_ext", "", "go"},
+		// nothing for the range to start from, or no order to take it from
+		{"path LIKE '%.go'", "", "", nil},
+		{"path ILIKE 'internal/%'", "", "", nil},
+		{"path NOT LIKE 'internal/%'", "", "", nil},
+		{"ext LIKE 'g%'", "", "", nil},
+	}
+	for _, p := range plans {
+		sql, err := parser.Parse("DELETE FROM files WHERE " + p.where + ";")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan := indexLookup(s.db.Tables["files"], sql.Delete.Where, scope{table: "files"})
+		switch {
+		case plan == nil && p.index == "":
+		case plan == nil || p.index == "":
+			t.Errorf("%s: planned %+v, want index %q", p.where, plan, p.index)
+		case plan.index.Name != p.index || plan.prefix != p.prefix || plan.value != p.value:
+			t.Errorf("%s: planned %s prefix %q value %v, want %s prefix %q value %v",
+				p.where, plan.index.Name, plan.prefix, plan.value, p.index, p.prefix, p.value)
+		}
+	}
+
+	tests := []queryTest{
+		{"SELECT path FROM files WHERE path LIKE 'internal/%';", []string{"internal/db/db.go", "internal/executor/plan.go"}},
+		// read in key order
+		{"SELECT path FROM files WHERE path LIKE 'internal%';", []string{"internal", "internal/db/db.go", "internal/executor/plan.go", "internal0"}},
+		{"SELECT path FROM files WHERE path GLOB 'internal/*/[a-m]*.go';", []string{"internal/db/db.go"}},
+		{"SELECT path FROM files WHERE path LIKE 'KE!_%' ESCAPE '!';", []string{"KE_01"}},
+		{"SELECT path FROM files WHERE path LIKE 'KE_01';", []string{"KEX01", "KE_01"}},
+		{"SELECT path FROM files WHERE path = 'internal';", []string{"internal"}},
+		{"SELECT path FROM files WHERE path LIKE 'zz%';", nil},
+	}
+	s.expectAll(tests)
+	s.checkIntegrity()
+
+	// the index follows updates and deletes, and survives VACUUM
+	s.exec(
+		"UPDATE files SET path = 'internal/storage/btree.go' WHERE path = 'internak/x';",
+		"DELETE FROM files WHERE path LIKE 'internal/e%';",
+	)
+	s.expect("SELECT path FROM files WHERE path LIKE 'internal/%';", "internal/db/db.go", "internal/storage/btree.go")
+	s.exec("VACUUM;")
+	s.reopen()
+	s.expect("SELECT path FROM files WHERE path LIKE 'internal/%';", "internal/db/db.go", "internal/storage/btree.go")
+	s.checkIntegrity()
+
+	// a key too large for the tree is refused before any row is written
+	long := strings.Repeat("x", 2000)
+	s.fail("INSERT INTO files VALUES ('a', NULL), ('"+long+"', NULL);", "too large")
+	s.fail("UPDATE files SET path = path || '"+long+"' WHERE path LIKE 'internal/%';", "too large")
+	s.expect("SELECT COUNT(*) FROM files;", "8")
+	s.expect("SELECT path FROM files WHERE path LIKE 'internal/%';", "internal/db/db.go", "internal/storage/btree.go")
+	s.checkIntegrity()
+}
+
+func TestInBetweenCaseAndSubqueries(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE users (id INT, name TEXT, country TEXT, score INT, branch INT);",
+		"INSERT INTO users VALUES (1, 'a', 'KE', 95, 10), (2, 'b', 'UG', 80, 20), (3, 'c', 'TZ', 60, 10), (4, 'd', NULL, NULL, 30);",
+		"CREATE TABLE payments (user_id INT, amount INT);",
+		"INSERT INTO payments VALUES (1, 5000), (1, 10), (3, 2000);",
+		"CREATE TABLE branches (id INT, country TEXT);",
+		"INSERT INTO branches VALUES (10, 'KE'), (20, 'UG');",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT id FROM users WHERE country IN ('KE', 'UG') AND score BETWEEN 80 AND 95;", []string{"1", "2"}},
+		{"SELECT id FROM users WHERE country NOT IN ('KE', NULL);", nil},
+		{"SELECT name, CASE WHEN score >= 90 THEN 'A' WHEN score >= 75 THEN 'B' ELSE 'C' END AS grade FROM users;",
+			[]string{"a | A", "b | B", "c | C", "d | C"}},
+		{"SELECT CASE country WHEN 'KE' THEN 1 WHEN 'UG' THEN 2 END FROM users;", []string{"1", "2", "NULL", "NULL"}},
+		{"SELECT id FROM users WHERE id IN (SELECT user_id FROM payments WHERE amount > 1000);", []string{"1", "3"}},
+		{"SELECT id FROM users u WHERE EXISTS (SELECT 1 FROM payments p WHERE p.user_id = u.id AND amount < 100);", []string{"1"}},
+		{"SELECT id FROM users u WHERE NOT EXISTS (SELECT 1 FROM payments p WHERE p.user_id = u.id);", []string{"2", "4"}},
+		{"SELECT name, (SELECT country FROM branches WHERE branches.id = users.branch) AS country FROM users;",
+			[]string{"a | KE", "b | UG", "c | KE", "d | NULL"}},
+		{"SELECT id FROM users WHERE score > (SELECT AVG(score) FROM users);", []string{"1", "2"}},
+	})
+	s.failAll([]errorTest{
+		{"SELECT (SELECT id FROM users) FROM users;", "more than one row"},
+		{"SELECT (SELECT id, name FROM users WHERE id = 1) FROM users;", "single column"},
+		{"SELECT CASE WHEN id = 1 THEN 'x' ELSE 2 END FROM users;", "CASE"},
+	})
+}
@@ -0,0 +1,132 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+JSON values are reached with -> and ->>, or the functions below. Paths are
+written like SQLite's, $.items[0].id, and -> and ->> also take a bare key or
+array index: body -> 'items' -> 0 is body -> '$.items[0]'.
+  - doc -> path is the JSON found there
+  - doc ->> path and JSON_EXTRACT(doc, path) are the same value as TEXT, a
+    string without its quotes and JSON null as NULL
+  - JSON_ARRAY_LENGTH(doc[, path]) counts the elements of an array, 0 for
+    anything else
+A path that leads nowhere gives NULL. TEXT works wherever JSON is expected and
+is parsed when it is read.
+*/
+
+// arrowPath is the path an -> or ->> follows
+func arrowPath(arrow *parser.Arrow) (types.JSONPath, error) {
+	if arrow.Index != nil {
+		return types.JSONPath{{Index: *arrow.Index, ByIndex: true}}, nil
+	}
+	return keyPath(*arrow.Key)
+}
+
+// keyPath reads a $ path, anything else is a single key
+func keyPath(key string) (types.JSONPath, error) {
+	if strings.HasPrefix(key, "$") {
+		return types.ParseJSONPath(key)
+	}
+	return types.JSONPath{{Key: key}}, nil
+}
+
+func evalArrow(arrow *parser.Arrow, v types.Value) (types.Value, error) {
+	path, err := arrowPath(arrow)
+	if err != nil {
+		return nil, err
+	}
+	found, err := jsonAt(v, path, arrow.Op)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	if arrow.Op == "->>" {
+		return found.(types.JSONValue).Text(), nil
+	}
+	return found, nil
+}
+
+// jsonAt returns the JSON at a path in a document, nil when the document is NULL or has nothing there
+func jsonAt(v types.Value, path types.JSONPath, fn string) (types.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	doc, err := asJSON(v, fn)
+	if err != nil {
+		return nil, err
+	}
+	found, ok := doc.Extract(path)
+	if !ok {
+		return nil, nil
+	}
+	return found, nil
+}
+
+// asJSON parses TEXT, so JSON functions work on documents that aren't stored as JSON
+func asJSON(v types.Value, fn string) (types.JSONValue, error) {
+	switch x := v.(type) {
+	case types.JSONValue:
+		return x, nil
+	case string:
+		doc, err := types.ParseJSON(x)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", fn, err)
+		}
+		return doc, nil
+	default:
+		return "", fmt.Errorf("%s expects JSON, got %s", fn, describe(v))
+	}
+}
+
+// jsonPathArg reads the path argument of a JSON function
+func jsonPathArg(v types.Value, fn string) (types.JSONPath, error) {
+	if !isText(v) {
+		return nil, fmt.Errorf("%s expects a TEXT path, got %s", fn, describe(v))
+	}
+	path, err := types.ParseJSONPath(textOf(v))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
+	return path, nil
+}
+
+func jsonExtract(args []types.Value) (types.Value, error) {
+	path, err := jsonPathArg(args[1], "JSON_EXTRACT")
+	if err != nil {
+		return nil, err
+	}
+	found, err := jsonAt(args[0], path, "JSON_EXTRACT")
+	if err != nil || found == nil {
+		return nil, err
+	}
+	return found.(types.JSONValue).Text(), nil
+}
+
+func jsonArrayLength(args []types.Value) (types.Value, error) {
+	doc := args[0]
+	if len(args) == 2 {
+		path, err := jsonPathArg(args[1], "JSON_ARRAY_LENGTH")
+		if err != nil {
+			return nil, err
+		}
+		if doc, err = jsonAt(doc, path, "JSON_ARRAY_LENGTH"); err != nil || doc == nil {
+			return nil, err
+		}
+	}
+	array, err := asJSON(doc, "JSON_ARRAY_LENGTH")
+	if err != nil {
+		return nil, err
+	}
+	n, _ := array.ArrayLength()
+	return n, nil
+}
+
+func isJSONType(t types.DataType) bool {
+	return t == types.JSON || t == types.TEXT || t == nullType
+}
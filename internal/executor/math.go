@@ -0,0 +1,152 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Math functions take any number and, apart from POWER, keep its type:
+  - ABS(x), FLOOR(x) and CEIL(x)
+  - ROUND(x[, places]) rounds half away from zero, places may be negative
+  - MOD(a, b) is the remainder of a / b truncated, with the sign of a; it is
+    INT for two INTs, DECIMAL when either side is one and FLOAT otherwise
+  - POWER(a, b) is a raised to b as a FLOAT
+*/
+
+func abs(args []types.Value) (types.Value, error) {
+	switch x := args[0].(type) {
+	case int:
+		if x < 0 {
+			return -x, nil
+		}
+		return x, nil
+	case float64:
+		return math.Abs(x), nil
+	case types.Decimal:
+		return x.Abs(), nil
+	default:
+		return nil, fmt.Errorf("ABS expects a number, got %s", describe(x))
+	}
+}
+
+func roundFunction(args []types.Value) (types.Value, error) {
+	places := 0
+	if len(args) == 2 {
+		n, ok := args[1].(int)
+		if !ok {
+			return nil, fmt.Errorf("ROUND expects INT decimal places, got %s", describe(args[1]))
+		}
+		places = n
+	}
+	return round(args[0], places)
+}
+
+// round rounds half away from zero to a number of decimal places, which may be negative
+func round(v types.Value, places int) (types.Value, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case types.Decimal:
+		d := x.Rescale(int32(places))
+		if places < 0 {
+			d = d.Rescale(0)
+		}
+		return d, nil
+	case float64:
+		scale := math.Pow(10, float64(places))
+		return math.Round(x*scale) / scale, nil
+	case int:
+		if places >= 0 {
+			return x, nil
+		}
+		d := types.DecimalFromInt(x).Rescale(int32(places)).Rescale(0)
+		return int(d.Unscaled.Int64()), nil
+	default:
+		return nil, fmt.Errorf("ROUND expects a number, got %v", v)
+	}
+}
+
+func floorOrCeil(f func(float64) float64, d func(types.Decimal) types.Decimal) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		switch x := args[0].(type) {
+		case int:
+			return x, nil
+		case float64:
+			return f(x), nil
+		case types.Decimal:
+			return d(x), nil
+		default:
+			return nil, fmt.Errorf("FLOOR and CEIL expect a number, got %s", describe(x))
+		}
+	}
+}
+
+func modType(_ string, args []types.DataType) (types.DataType, error) {
+	switch {
+	case args[0] == types.DECIMAL || args[1] == types.DECIMAL:
+		return types.DECIMAL, nil
+	case args[0] == types.FLOAT || args[1] == types.FLOAT:
+		return types.FLOAT, nil
+	default:
+		return types.INT, nil
+	}
+}
+
+func mod(args []types.Value) (types.Value, error) {
+	a, b := args[0], args[1]
+	if x, y, ok, err := decimalOperands(a, b); ok || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return x.Rem(y)
+	}
+	x, xInt := a.(int)
+	y, yInt := b.(int)
+	if xInt && yInt {
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return x % y, nil
+	}
+	fx, fy, err := floatOperands("MOD", a, b)
+	if err != nil {
+		return nil, err
+	}
+	if fy == 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	return math.Mod(fx, fy), nil
+}
+
+func power(args []types.Value) (types.Value, error) {
+	x, y, err := floatOperands("POWER", args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return math.Pow(x, y), nil
+}
+
+func floatOperands(name string, a, b types.Value) (float64, float64, error) {
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if !okA || !okB {
+		return 0, 0, fmt.Errorf("%s expects numbers, got %s and %s", name, describe(a), describe(b))
+	}
+	return x, y, nil
+}
+
+func toFloat(v types.Value) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case float64:
+		return x, true
+	case types.Decimal:
+		return x.Float64(), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+ORDER BY sorts a query's result by its result columns, named or numbered
+from 1, or by expressions over them. A plain SELECT, one without set
+operations, aggregates or DISTINCT, can also be sorted by the columns of the
+rows it reads; a result column's name wins over a source column of the same
+name.
+NULLs sort before every other value, so they come last with DESC, and rows
+that sort alike keep the order they were produced in. Sorting happens in
+memory once all the rows are in, then OFFSET skips rows and LIMIT caps how many
+are returned. Without ORDER BY they apply as the rows stream past.
+*/
+type orderTerm struct {
+	expr *parser.Expr
+	// position is the 1 based result column sorted by, 0 when sorting by expr
+	position int
+	desc     bool
+}
+
+func planOrder(q *query, stmt *parser.Select) error {
+	if stmt.Limit != nil {
+		q.limit, q.offset = stmt.Limit.Count, stmt.Limit.Offset
+	}
+	if len(stmt.OrderBy) == 0 {
+		return nil
+	}
+
+	sc := q.scope
+	sc.schema = q.columns
+	if len(q.compound) == 0 && len(q.aggregates) == 0 && !q.distinct {
+		sc.schema = append(slices.Clone(q.columns), q.scope.schema...)
+	} else {
+		sc.table = ""
+	}
+	q.orderScope = sc
+
+	for _, item := range stmt.OrderBy {
+		term := orderTerm{expr: item.Expr, desc: item.Desc}
+		if op := bareOperand(item.Expr); op != nil && op.Value != nil && op.Value.Int != nil {
+			term.position = *op.Value.Int
+			if term.position < 1 || term.position > len(q.columns) {
+				return fmt.Errorf("ORDER BY position %d is not in the SELECT list", term.position)
+			}
+		} else if _, err := inferType(item.Expr, sc); err != nil {
+			return err
+		}
+		q.order = append(q.order, term)
+	}
+	return nil
+}
+
+// runOrdered collects the query's rows with their sort keys, sorts them and streams them to cb
+func (e *Executor) runOrdered(q *query, sc scope, cb func(types.Row) bool) error {
+	type sortRow struct {
+		out  types.Row
+		keys []types.Value
+	}
+	osc := q.orderScope
+	osc.outer = sc.outer
+	var rows []sortRow
+	var evalErr error
+	err := e.runCombined(q, sc, func(out, src types.Row) bool {
+		row := out
+		if len(osc.schema) > len(out) {
+			row = append(slices.Clone(out), src...)
+		}
+		keys := make([]types.Value, len(q.order))
+		for i, term := range q.order {
+			if term.position > 0 {
+				keys[i] = out[term.position-1]
+				continue
+			}
+			if keys[i], evalErr = evalExpr(term.expr, row, osc); evalErr != nil {
+				return false
+			}
+		}
+		rows = append(rows, sortRow{out: out, keys: keys})
+		return true
+	})
+	if err == nil {
+		err = evalErr
+	}
+	if err != nil {
+		return err
+	}
+
+	var cmpErr error
+	sort.SliceStable(rows, func(i, j int) bool {
+		cmp, err := compareKeys(q.order, rows[i].keys, rows[j].keys)
+		if err != nil && cmpErr == nil {
+			cmpErr = err
+		}
+		return cmp < 0
+	})
+	if cmpErr != nil {
+		return cmpErr
+	}
+
+	start, end := min(q.offset, len(rows)), len(rows)
+	if q.limit >= 0 {
+		end = min(start+q.limit, end)
+	}
+	for _, row := range rows[start:end] {
+		if !cb(row.out) {
+			break
+		}
+	}
+	return nil
+}
+
+func compareKeys(order []orderTerm, a, b []types.Value) (int, error) {
+	for i, term := range order {
+		cmp, err := compareSortValues(a[i], b[i])
+		if err != nil {
+			return 0, err
+		}
+		if term.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp, nil
+		}
+	}
+	return 0, nil
+}
+
+// compareSortValues orders values like compareValues, with NULL before everything
+func compareSortValues(a, b types.Value) (int, error) {
+	switch {
+	case a == nil && b == nil:
+		return 0, nil
+	case a == nil:
+		return -1, nil
+	case b == nil:
+		return 1, nil
+	}
+	return compareValues(a, b)
+}
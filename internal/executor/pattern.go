@@ -0,0 +1,308 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Pattern predicates match TEXT against a pattern, NULL when either is NULL:
+  - LIKE: % matches any run of characters and _ any single one. A backslash,
+    or the character given with ESCAPE, makes the next character literal, and
+    ESCAPE '' turns escaping off.
+  - ILIKE is LIKE ignoring case
+  - GLOB: SQLite's *, ? and [...] (negated with [^...]), case sensitive
+  - REGEXP: Go's regexp syntax, matching anywhere in the text unless anchored
+A CHAR is matched without its padding. Patterns are compiled to regexps once
+per statement and reused for every row.
+*/
+
+type patternKey struct {
+	op, pattern, escape string
+}
+
+// patternCache holds the patterns compiled while executing a statement
+type patternCache map[patternKey]*regexp.Regexp
+
+func (c patternCache) compile(op, pattern, escape string) (*regexp.Regexp, error) {
+	key := patternKey{op, pattern, escape}
+	if re, ok := c[key]; ok {
+		return re, nil
+	}
+	re, err := compilePattern(op, pattern, escape)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c[key] = re
+	}
+	return re, nil
+}
+
+func compilePattern(op, pattern, escape string) (*regexp.Regexp, error) {
+	switch op {
+	case "REGEXP":
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("REGEXP: invalid pattern %q: %w", pattern, err)
+		}
+		return re, nil
+	case "GLOB":
+		expr, _, _ := globPattern(pattern)
+		return regexp.Compile("^(?s)" + expr + "$")
+	default:
+		expr, _, _, err := likePattern(pattern, escape)
+		if err != nil {
+			return nil, err
+		}
+		flags := "(?s)"
+		if op == "ILIKE" {
+			flags = "(?is)"
+		}
+		return regexp.Compile("^" + flags + expr + "$")
+	}
+}
+
+// likePattern translates a LIKE pattern to a regexp. When it has no wildcards it
+// only matches plain, and wild is false.
+func likePattern(pattern, escape string) (expr, plain string, wild bool, err error) {
+	if utf8.RuneCountInString(escape) > 1 {
+		return "", "", false, fmt.Errorf("LIKE: ESCAPE must be a single character, got %q", escape)
+	}
+	esc, _ := utf8.DecodeRuneInString(escape)
+	var re, text strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			escaped = false
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			text.WriteRune(r)
+		case escape != "" && r == esc:
+			escaped = true
+		case r == '%':
+			re.WriteString(".*")
+			wild = true
+		case r == '_':
+			re.WriteString(".")
+			wild = true
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+			text.WriteRune(r)
+		}
+	}
+	if escaped {
+		return "", "", false, fmt.Errorf("LIKE: pattern %q ends with the escape character", pattern)
+	}
+	return re.String(), text.String(), wild, nil
+}
+
+// globPattern translates a GLOB pattern to a regexp, like likePattern. A [ without a closing ] is literal.
+func globPattern(pattern string) (expr, plain string, wild bool) {
+	var re strings.Builder
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			re.WriteString(".*")
+			wild = true
+		case '?':
+			re.WriteString(".")
+			wild = true
+		case '[':
+			end := classEnd(runes, i)
+			if end < 0 {
+				re.WriteString(regexp.QuoteMeta("["))
+				continue
+			}
+			re.WriteString(globClass(runes[i+1 : end]))
+			wild, i = true, end
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return re.String(), pattern, wild
+}
+
+// classEnd finds the ] closing the class opened at start, a ] straight after [ or [^ is part of the class
+func classEnd(runes []rune, start int) int {
+	i := start + 1
+	if i < len(runes) && runes[i] == '^' {
+		i++
+	}
+	if i < len(runes) && runes[i] == ']' {
+		i++
+	}
+	for ; i < len(runes); i++ {
+		if runes[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
+
+func globClass(class []rune) string {
+	var b strings.Builder
+	b.WriteString("[")
+	if len(class) > 0 && class[0] == '^' {
+		b.WriteString("^")
+		class = class[1:]
+	}
+	for _, r := range class {
+		if r == '-' {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// likeType checks a pattern predicate before any row is read, compiling a literal pattern to report mistakes in it
+func likeType(left types.DataType, like *parser.Like, sc scope) (types.DataType, error) {
+	op := strings.ToUpper(like.Op)
+	pattern, err := inferSumType(like.Pattern, sc)
+	if err != nil {
+		return 0, err
+	}
+	if !isTextType(left) || !isTextType(pattern) {
+		return 0, fmt.Errorf("%s expects TEXT, got %s %s %s", op, left, op, pattern)
+	}
+	escape, hasEscape := `\`, like.Escape != nil
+	if hasEscape {
+		if op != "LIKE" && op != "ILIKE" {
+			return 0, fmt.Errorf("ESCAPE only applies to LIKE and ILIKE, not %s", op)
+		}
+		t, err := inferSumType(like.Escape, sc)
+		if err != nil {
+			return 0, err
+		}
+		if !isTextType(t) {
+			return 0, fmt.Errorf("ESCAPE expects TEXT, got %s", t)
+		}
+	}
+
+	if text, ok := literalText(like.Pattern); ok {
+		if hasEscape {
+			if escape, ok = literalText(like.Escape); !ok {
+				return types.BOOLEAN, nil
+			}
+		}
+		if _, err := compilePattern(op, text, escape); err != nil {
+			return 0, err
+		}
+	}
+	return types.BOOLEAN, nil
+}
+
+func evalLike(like *parser.Like, left types.Value, row types.Row, sc scope) (types.Value, error) {
+	op := strings.ToUpper(like.Op)
+	pattern, err := evalSum(like.Pattern, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	escape := `\`
+	if like.Escape != nil {
+		v, err := evalSum(like.Escape, row, sc)
+		if err != nil || v == nil {
+			return nil, err
+		}
+		escape = textOf(v)
+	}
+	if left == nil || pattern == nil {
+		return nil, nil
+	}
+	if !isText(left) || !isText(pattern) {
+		return nil, fmt.Errorf("%s expects TEXT, got %s %s %s", op, describe(left), op, describe(pattern))
+	}
+
+	re, err := sc.patterns.compile(op, textOf(pattern), escape)
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(textOf(left)) != like.Not, nil
+}
+
+// likeEquality is the text a LIKE or GLOB with a literal pattern and no wildcards
+// matches, the one kind of pattern a hash index can look up
+func likeEquality(like *parser.Like) (string, bool) {
+	pattern, ok := literalText(like.Pattern)
+	if !ok || like.Not {
+		return "", false
+	}
+	switch strings.ToUpper(like.Op) {
+	case "LIKE":
+		escape := `\`
+		if like.Escape != nil {
+			if escape, ok = literalText(like.Escape); !ok {
+				return "", false
+			}
+		}
+		_, plain, wild, err := likePattern(pattern, escape)
+		return plain, err == nil && !wild
+	case "GLOB":
+		_, plain, wild := globPattern(pattern)
+		return plain, !wild
+	}
+	return "", false
+}
+
+// likePrefix is the literal text a LIKE or GLOB pattern starts with, before its
+// first wildcard. Everything the pattern matches starts with it, so a BTREE index
+// can read just the keys with that prefix.
+func likePrefix(like *parser.Like) (string, bool) {
+	pattern, ok := literalText(like.Pattern)
+	if !ok || like.Not {
+		return "", false
+	}
+	var prefix strings.Builder
+	switch strings.ToUpper(like.Op) {
+	case "LIKE":
+		escape := `\`
+		if like.Escape != nil {
+			if escape, ok = literalText(like.Escape); !ok {
+				return "", false
+			}
+		}
+		esc, _ := utf8.DecodeRuneInString(escape)
+		escaped := false
+	scan:
+		for _, r := range pattern {
+			switch {
+			case escaped:
+				escaped = false
+				prefix.WriteRune(r)
+			case escape != "" && r == esc:
+				escaped = true
+			case r == '%' || r == '_':
+				break scan
+			default:
+				prefix.WriteRune(r)
+			}
+		}
+	case "GLOB":
+		// a [ is a class, or literal without its ], either way the prefix can stop there
+		if end := strings.IndexAny(pattern, "*?["); end >= 0 {
+			pattern = pattern[:end]
+		}
+		prefix.WriteString(pattern)
+	default:
+		return "", false
+	}
+	return prefix.String(), prefix.Len() > 0
+}
+
+// literalText returns the string literal a sum consists of
+func literalText(sum *parser.Sum) (string, bool) {
+	op := sumOperand(sum)
+	if op == nil || op.Value == nil || op.Value.String == nil {
+		return "", false
+	}
+	return *op.Value.String, true
+}
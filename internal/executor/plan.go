@@ -0,0 +1,192 @@
+package executor
+
+import (
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/storage"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+scanTable visits the rows of a table that satisfy a WHERE clause.
+When the clause requires an indexed column, or the text at an indexed JSON path,
+to equal a literal (on its own or ANDed with other conditions), only the rows
+the index returns are read, and likewise for a LIKE or GLOB pattern with a
+literal prefix over a BTREE index; otherwise the whole heap is scanned. Either
+way the full clause is evaluated against every candidate row.
+*/
+func (e *Executor) scanTable(table *db.Table, where *parser.Expr, sc scope, cb func(storage.RecordID, types.Row) bool) error {
+	var evalErr error
+	filter := func(rid storage.RecordID, row types.Row) bool {
+		match, err := evalWhere(where, row, sc)
+		if err != nil {
+			evalErr = err
+			return false
+		}
+		if !match {
+			return true
+		}
+		return cb(rid, row)
+	}
+
+	var err error
+	switch plan := indexLookup(table, where, sc); {
+	case plan == nil:
+		err = table.ScanRecords(filter)
+	case plan.prefix != "":
+		err = table.LookupPrefix(plan.index, plan.prefix, filter)
+	default:
+		err = table.LookupRecords(plan.index, plan.value, filter)
+	}
+	if err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// scan visits the rows of a query's source that satisfy a WHERE clause, a table's through scanTable
+func (e *Executor) scan(q *query, where *parser.Expr, sc scope, cb func(types.Row) bool) error {
+	if q.view != nil {
+		return e.scanView(q, where, sc, cb)
+	}
+	if q.cte == nil {
+		return e.scanTable(q.source, where, sc, func(_ storage.RecordID, row types.Row) bool {
+			return cb(row)
+		})
+	}
+	rows, err := e.materialize(q.cte, sc.outer)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		match, err := evalWhere(where, row, sc)
+		if err != nil {
+			return err
+		}
+		if match && !cb(row) {
+			break
+		}
+	}
+	return nil
+}
+
+// indexScan is how scanTable reads a table through an index: the rows whose key
+// equals value, or with a prefix those whose text starts with it
+type indexScan struct {
+	index  *db.Index
+	value  types.Value
+	prefix string
+}
+
+// indexLookup looks for a top level `column = literal` conjunct on an indexed column,
+// or `column ->> path = literal` on an index over that JSON path. A LIKE or GLOB
+// pattern without wildcards is an equality too, and one starting with literal text
+// is a range of a BTREE index; an equality is preferred, it reads fewer rows.
+func indexLookup(table *db.Table, where *parser.Expr, sc scope) *indexScan {
+	if where == nil || len(where.Or) != 1 {
+		return nil
+	}
+	var ranged *indexScan
+	for _, term := range where.Or[0].And {
+		cmp := term.Comparison
+		if term.Not {
+			continue
+		}
+		if cmp.Like != nil {
+			idx := indexFor(table, sc, cmp.Left)
+			if idx == nil {
+				continue
+			}
+			if text, ok := likeEquality(cmp.Like); ok {
+				if value, ok := indexValue(table, idx, text); ok {
+					return &indexScan{index: idx, value: value}
+				}
+			} else if prefix, ok := likePrefix(cmp.Like); ok && idx.Kind == db.BTREE_INDEX && ranged == nil {
+				ranged = &indexScan{index: idx, prefix: prefix}
+			}
+			continue
+		}
+		if cmp.Right == nil || cmp.Op != "=" {
+			continue
+		}
+		indexed, other := cmp.Left, cmp.Right
+		idx := indexFor(table, sc, indexed)
+		if idx == nil {
+			indexed, other = other, indexed
+			idx = indexFor(table, sc, indexed)
+		}
+		literal := sumOperand(other)
+		if idx == nil || literal == nil || !isLiteral(literal) {
+			continue
+		}
+		// = NULL is never true, so there is nothing to look up
+		value, err := evalOperand(literal, nil, scope{})
+		if err != nil || value == nil {
+			continue
+		}
+		if value, ok := indexValue(table, idx, value); ok {
+			return &indexScan{index: idx, value: value}
+		}
+	}
+	return ranged
+}
+
+// indexValue converts a literal to the type of an index's keys. A literal that isn't
+// stored as the column's type (e.g. 7.0 for an INT) is left to the full scan, which
+// compares numerically or reports the mismatch; JSON path indexes hold text.
+func indexValue(table *db.Table, idx *db.Index, value types.Value) (types.Value, bool) {
+	keyColumn := types.Column{Type: types.TEXT}
+	if idx.Path == "" {
+		keyColumn = table.Schema[columnIndex(table.Schema, idx.Column)]
+	}
+	value, err := coerceValue(value, keyColumn)
+	return value, err == nil
+}
+
+// indexFor returns the index over what an expression reads: a bare column, or
+// column ->> path or JSON_EXTRACT(column, path) for a JSON path index
+func indexFor(table *db.Table, sc scope, sum *parser.Sum) *db.Index {
+	access := sumAccess(sum)
+	if access == nil {
+		return nil
+	}
+	op := access.Operand
+	// a column qualified with another query's table belongs to that query's rows
+	if op.Column != nil && op.Column.Table != nil && !strings.EqualFold(*op.Column.Table, sc.table) {
+		return nil
+	}
+	switch {
+	case op.Column != nil && len(access.Arrows) == 0:
+		return table.IndexOn(op.Column.Name)
+	case op.Column != nil && len(access.Arrows) == 1 && access.Arrows[0].Op == "->>":
+		path, err := arrowPath(access.Arrows[0])
+		if err != nil {
+			return nil
+		}
+		return table.IndexOnPath(op.Column.Name, path.String())
+	case op.Call != nil && len(access.Arrows) == 0 && strings.EqualFold(op.Call.Name, "JSON_EXTRACT") && len(op.Call.Args) == 2:
+		column, pathArg := bareOperand(op.Call.Args[0]), bareOperand(op.Call.Args[1])
+		if column == nil || column.Column == nil || pathArg == nil || pathArg.Value == nil || pathArg.Value.String == nil {
+			return nil
+		}
+		if column.Column.Table != nil && !strings.EqualFold(*column.Column.Table, sc.table) {
+			return nil
+		}
+		path, err := types.ParseJSONPath(*pathArg.Value.String)
+		if err != nil {
+			return nil
+		}
+		return table.IndexOnPath(column.Column.Name, path.String())
+	}
+	return nil
+}
+
+// isLiteral reports whether an operand is a literal, possibly negated like -1
+func isLiteral(op *parser.Operand) bool {
+	for op.Negate != nil {
+		op = op.Negate
+	}
+	return op.Value != nil
+}
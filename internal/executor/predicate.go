@@ -0,0 +1,238 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+IN, BETWEEN and CASE follow SQL's three valued logic:
+  - x IN (...) is true when x equals one of the values, otherwise NULL when x
+    or any of the values is NULL, else false. Against no values at all it is
+    false, even for a NULL x.
+  - x BETWEEN lo AND hi is x >= lo AND x <= hi, bounds included
+  - NOT IN and NOT BETWEEN negate the result, leaving NULL as NULL
+  - CASE takes the first WHEN that is true, or for CASE x WHEN v the first v
+    equal to x, a NULL never matching. Without a match it is the ELSE, or NULL.
+*/
+
+// comparableTypes reports whether values of two types can be compared, TEXT
+// with a DATE or TIMESTAMP only being checked when the text is read
+func comparableTypes(a, b types.DataType) bool {
+	switch {
+	case a == nullType || b == nullType || a == types.JSON || b == types.JSON:
+		return true
+	case a == types.TEXT && isTimeType(b), b == types.TEXT && isTimeType(a):
+		return true
+	}
+	_, err := compareValues(sampleValue(a), sampleValue(b))
+	return err == nil
+}
+
+func inType(left types.DataType, in *parser.In, sc scope) (types.DataType, error) {
+	if in.Select != nil {
+		sub, err := sc.exec.planSubquery(in.Select, sc)
+		if err != nil {
+			return 0, err
+		}
+		t, err := singleColumn(sub)
+		if err != nil {
+			return 0, err
+		}
+		if !comparableTypes(left, t) {
+			return 0, fmt.Errorf("IN: cannot compare %s with %s", left, t)
+		}
+		if key, ok := keyFor(left, t); ok {
+			sub.key = &key
+		}
+		return types.BOOLEAN, nil
+	}
+	for _, elem := range in.List {
+		t, err := inferType(elem, sc)
+		if err != nil {
+			return 0, err
+		}
+		if !comparableTypes(left, t) {
+			return 0, fmt.Errorf("IN: cannot compare %s with %s", left, t)
+		}
+	}
+	return types.BOOLEAN, nil
+}
+
+func evalIn(in *parser.In, left types.Value, row types.Row, sc scope) (types.Value, error) {
+	var result types.Value
+	var err error
+	if in.Select != nil {
+		result, err = sc.exec.evalInSubquery(in.Select, left, row, sc)
+	} else {
+		values := make([]types.Value, len(in.List))
+		for i, elem := range in.List {
+			if values[i], err = evalExpr(elem, row, sc); err != nil {
+				return nil, err
+			}
+		}
+		result, err = inValues(left, values)
+	}
+	if err != nil || result == nil || !in.Not {
+		return result, err
+	}
+	return !result.(bool), nil
+}
+
+// inValues checks a value against a list of values one by one
+func inValues(v types.Value, values []types.Value) (types.Value, error) {
+	if len(values) == 0 {
+		return false, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	sawNull := false
+	for _, value := range values {
+		if value == nil || sqlNullInJSON(v, value) || sqlNullInJSON(value, v) {
+			sawNull = true
+			continue
+		}
+		cmp, err := compareValues(v, value)
+		if err != nil {
+			return nil, err
+		}
+		if cmp == 0 {
+			return true, nil
+		}
+	}
+	if sawNull {
+		return nil, nil
+	}
+	return false, nil
+}
+
+func betweenType(left types.DataType, between *parser.Between, sc scope) (types.DataType, error) {
+	for _, bound := range []*parser.Sum{between.Low, between.High} {
+		t, err := inferSumType(bound, sc)
+		if err != nil {
+			return 0, err
+		}
+		if !comparableTypes(left, t) {
+			return 0, fmt.Errorf("BETWEEN: cannot compare %s with %s", left, t)
+		}
+	}
+	return types.BOOLEAN, nil
+}
+
+func evalBetween(between *parser.Between, left types.Value, row types.Row, sc scope) (types.Value, error) {
+	low, err := evalSum(between.Low, row, sc)
+	if err != nil {
+		return nil, err
+	}
+	high, err := evalSum(between.High, row, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// false on either side decides it, even when the other is unknown
+	unknown := false
+	for i, bound := range []types.Value{low, high} {
+		if left == nil || bound == nil || sqlNullInJSON(left, bound) || sqlNullInJSON(bound, left) {
+			unknown = true
+			continue
+		}
+		cmp, err := compareValues(left, bound)
+		if err != nil {
+			return nil, err
+		}
+		if (i == 0 && cmp < 0) || (i == 1 && cmp > 0) {
+			return between.Not, nil
+		}
+	}
+	if unknown {
+		return nil, nil
+	}
+	return !between.Not, nil
+}
+
+func caseType(expr *parser.Case, sc scope) (types.DataType, error) {
+	var value types.DataType
+	if expr.Value != nil {
+		var err error
+		if value, err = inferType(expr.Value, sc); err != nil {
+			return 0, err
+		}
+	}
+
+	var results []types.DataType
+	for _, when := range expr.Whens {
+		t, err := inferType(when.Cond, sc)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case expr.Value == nil && !isType(t, types.BOOLEAN):
+			return 0, fmt.Errorf("CASE WHEN expects a boolean condition, got %s", t)
+		case expr.Value != nil && !comparableTypes(value, t):
+			return 0, fmt.Errorf("CASE: cannot compare %s with %s", value, t)
+		}
+		if t, err = inferType(when.Result, sc); err != nil {
+			return 0, err
+		}
+		results = append(results, t)
+	}
+	if expr.Else != nil {
+		t, err := inferType(expr.Else, sc)
+		if err != nil {
+			return 0, err
+		}
+		results = append(results, t)
+	}
+
+	t, err := commonType("CASE", results)
+	if err != nil {
+		return 0, fmt.Errorf("CASE results must share a type, got %s", typeList(results))
+	}
+	return t, nil
+}
+
+func evalCase(expr *parser.Case, row types.Row, sc scope) (types.Value, error) {
+	var value types.Value
+	if expr.Value != nil {
+		var err error
+		if value, err = evalExpr(expr.Value, row, sc); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, when := range expr.Whens {
+		cond, err := evalExpr(when.Cond, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		matched, err := caseMatches(expr.Value != nil, value, cond)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			return evalExpr(when.Result, row, sc)
+		}
+	}
+	if expr.Else != nil {
+		return evalExpr(expr.Else, row, sc)
+	}
+	return nil, nil
+}
+
+// caseMatches is whether a WHEN is taken: its condition is true, or with a CASE value, equal to it
+func caseMatches(simple bool, value, when types.Value) (bool, error) {
+	if when == nil {
+		return false, nil
+	}
+	if !simple {
+		return asBool(when, "CASE WHEN")
+	}
+	if value == nil || sqlNullInJSON(value, when) || sqlNullInJSON(when, value) {
+		return false, nil
+	}
+	cmp, err := compareValues(value, when)
+	return cmp == 0, err
+}
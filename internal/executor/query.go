@@ -0,0 +1,534 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+query is a planned SELECT: the columns it produces, typed ahead of time so
+CREATE TABLE ... AS SELECT knows the schema before the first row, and what it
+reads. Executor.run streams the result rows one at a time. A subquery is
+planned inside the scope of the query around it (see subquery.go).
+*/
+type query struct {
+	columns []types.Column
+	source  *db.Table
+	where   *parser.Expr
+	items   []*parser.SelectItem
+	// cte is read instead of source when the FROM names one, and view is run when it names a view
+	cte  *cte
+	view *query
+	// aggregates are the aggregate calls in items, which fold the rows into one
+	aggregates []*parser.Call
+	// windows are the window function calls in items, computed once all the rows are read
+	windows []*parser.Call
+	// scope is what the query's expressions are evaluated in, without the outer row
+	scope scope
+	// correlated is set when the query reads a column of an outer query
+	correlated bool
+	// distinct drops repeated rows from the SELECT's result
+	distinct bool
+
+	// compound are the set operations combining further SELECTs with this one,
+	// applied in turn; order, limit and offset then apply to what comes out
+	compound   []*setOp
+	order      []orderTerm
+	orderScope scope
+	limit      int
+	offset     int
+}
+
+func (e *Executor) planSelect(stmt *parser.Select, outer *frame) (*query, error) {
+	var ctes map[string]*cte
+	if outer != nil {
+		ctes = outer.sc.ctes
+	}
+	return e.planQuery(stmt, outer, ctes)
+}
+
+// planQuery plans a SELECT that can read the CTEs in ctes as well as its own
+func (e *Executor) planQuery(stmt *parser.Select, outer *frame, ctes map[string]*cte) (*query, error) {
+	if stmt.With != nil {
+		var err error
+		if ctes, err = e.planWith(stmt.With, outer, ctes); err != nil {
+			return nil, err
+		}
+	}
+
+	q, err := e.planCore(&stmt.SelectCore, outer, ctes)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.planSetOps(q, stmt.Compound, outer, ctes); err != nil {
+		return nil, err
+	}
+	if err := planOrder(q, stmt); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// planCore plans a single SELECT
+func (e *Executor) planCore(stmt *parser.SelectCore, outer *frame, ctes map[string]*cte) (*query, error) {
+	q := &query{where: stmt.Where, distinct: stmt.Distinct, limit: -1}
+	var sc scope
+	if c, ok := ctes[stmt.TableName]; ok {
+		c.read()
+		q.cte, q.correlated = c, c.correlated
+		sc = e.namedScope(c.name, c.columns, stmt.Alias)
+	} else if view, ok := e.db.Views[stmt.TableName]; ok {
+		if e.reads != nil {
+			e.reads[view.Name] = true
+		}
+		var err error
+		if q.view, err = e.planView(view.Name, view.Query, view.Schema); err != nil {
+			return nil, err
+		}
+		sc = e.namedScope(view.Name, view.Schema, stmt.Alias)
+	} else {
+		table, exists := e.db.Tables[stmt.TableName]
+		if !exists {
+			return nil, fmt.Errorf("table '%s' does not exist", stmt.TableName)
+		}
+		if e.reads != nil {
+			e.reads[table.Name] = true
+		}
+		q.source = table
+		sc = e.tableScope(table, stmt.Alias)
+	}
+	sc.outer, sc.query, sc.ctes = outer, q, ctes
+	q.scope = sc
+	if err := checkWhere(stmt.Where, sc); err != nil {
+		return nil, err
+	}
+
+	if stmt.Star {
+		for _, col := range sc.schema {
+			q.columns = append(q.columns, outputColumn(col.Name, col))
+		}
+		return q, nil
+	}
+
+	itemScope := sc
+	itemScope.aggregates, itemScope.windows = &q.aggregates, &q.windows
+	for i, item := range stmt.Items {
+		t, err := inferType(item.Expr, itemScope)
+		if err != nil {
+			return nil, err
+		}
+		if t == nullType {
+			return nil, fmt.Errorf("cannot infer a type for NULL, it needs a typed column")
+		}
+		col := types.Column{Type: t}
+		// a bare column or a CAST keeps its declared type, e.g. DECIMAL(10, 2) rather than any DECIMAL
+		if op := bareOperand(item.Expr); op != nil && op.Column != nil {
+			_, _, col, _ = sc.resolve(op.Column)
+		} else if op != nil && op.Cast != nil {
+			col, _ = castColumn(op.Cast)
+		}
+		q.columns = append(q.columns, outputColumn(outputName(item, i), col))
+	}
+	q.items = stmt.Items
+
+	if len(q.aggregates) > 0 && len(q.windows) > 0 {
+		return nil, fmt.Errorf("window functions can't be used in a SELECT with aggregates")
+	}
+	// with no GROUP BY an aggregate query is a single row, so every column has to be inside an aggregate
+	if len(q.aggregates) > 0 {
+		check := sc
+		check.aggregates, check.ungrouped = new([]*parser.Call), true
+		for _, item := range stmt.Items {
+			if _, err := inferType(item.Expr, check); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return q, nil
+}
+
+// tableScope is the scope of a statement reading a table, under its alias if it has one
+func (e *Executor) tableScope(table *db.Table, alias *string) scope {
+	return e.namedScope(table.Name, table.Schema, alias)
+}
+
+// namedScope is the scope of rows read as name, or as alias if there is one
+func (e *Executor) namedScope(name string, schema []types.Column, alias *string) scope {
+	sc := e.scope(schema)
+	sc.table = name
+	if alias != nil {
+		sc.table = *alias
+	}
+	return sc
+}
+
+// checkWhere checks that a WHERE clause is a boolean before any row is read
+func checkWhere(where *parser.Expr, sc scope) error {
+	if where == nil {
+		return nil
+	}
+	t, err := inferType(where, sc)
+	if err != nil {
+		return err
+	}
+	if !isType(t, types.BOOLEAN) {
+		return fmt.Errorf("WHERE clause must be a boolean, got %s", t)
+	}
+	return nil
+}
+
+// outputColumn describes a result column: the name and type of col without its table specific parts
+func outputColumn(name string, col types.Column) types.Column {
+	return types.Column{Name: name, Type: col.Type, Precision: col.Precision, Scale: col.Scale, Length: col.Length}
+}
+
+// outputName is the alias, the column's own name for a bare column, or columnN for anything else
+func outputName(item *parser.SelectItem, i int) string {
+	if item.Alias != nil {
+		return *item.Alias
+	}
+	if op := bareOperand(item.Expr); op != nil && op.Column != nil {
+		return op.Column.Name
+	}
+	return fmt.Sprintf("column%d", i+1)
+}
+
+// run streams the query's rows, converted to its column types, to cb until it returns false.
+// outer is the row of the query around a subquery, nil otherwise.
+func (e *Executor) run(q *query, outer *frame, cb func(types.Row) bool) error {
+	sc := q.scope
+	sc.outer = outer
+	if q.order != nil {
+		return e.runOrdered(q, sc, cb)
+	}
+	if q.limit == 0 {
+		return nil
+	}
+	skip, left := q.offset, q.limit
+	return e.runCombined(q, sc, func(out, _ types.Row) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if left > 0 {
+			left--
+		}
+		return cb(out) && left != 0
+	})
+}
+
+// runCombined streams the rows of the query's set operations, or of the query itself when it has
+// none. For a plain SELECT each result row comes with the row it was made from, nil after aggregation.
+func (e *Executor) runCombined(q *query, sc scope, cb func(out, src types.Row) bool) error {
+	if len(q.compound) > 0 {
+		return e.runSetOps(q, len(q.compound), sc, func(out types.Row) bool {
+			return cb(out, nil)
+		})
+	}
+	return e.runCore(q, sc, cb)
+}
+
+// runCore streams the rows of the query's own SELECT
+func (e *Executor) runCore(q *query, sc scope, cb func(out, src types.Row) bool) error {
+	if q.distinct {
+		return e.runDistinct(q, sc, cb)
+	}
+	return e.runRows(q, sc, cb)
+}
+
+// runRows streams the rows of the query's own SELECT, duplicates and all
+func (e *Executor) runRows(q *query, sc scope, cb func(out, src types.Row) bool) error {
+	if len(q.aggregates) > 0 {
+		return e.runAggregate(q, sc, func(out types.Row) bool {
+			return cb(out, nil)
+		})
+	}
+	if len(q.windows) > 0 {
+		return e.runWindows(q, sc, cb)
+	}
+	var evalErr error
+	err := e.scan(q, q.where, sc, func(row types.Row) bool {
+		if q.items == nil {
+			return cb(row, row)
+		}
+		out, err := q.project(row, sc)
+		if err != nil {
+			evalErr = err
+			return false
+		}
+		return cb(out, row)
+	})
+	if err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// project evaluates the SELECT list against a row
+func (q *query) project(row types.Row, sc scope) (types.Row, error) {
+	out := make(types.Row, len(q.items))
+	for i, item := range q.items {
+		v, err := evalExpr(item.Expr, row, sc)
+		if err == nil {
+			// a value can be narrower than its column, e.g. the INT a COALESCE typed FLOAT returns
+			v, err = coerceValue(v, q.columns[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// bareOperand returns the operand an expression consists of when it is nothing more than that
+func bareOperand(expr *parser.Expr) *parser.Operand {
+	if len(expr.Or) != 1 || len(expr.Or[0].And) != 1 {
+		return nil
+	}
+	not := expr.Or[0].And[0]
+	if not.Not || isPredicate(not.Comparison) {
+		return nil
+	}
+	return sumOperand(not.Comparison.Left)
+}
+
+// isPredicate reports whether a comparison is more than its left side
+func isPredicate(cmp *parser.Comparison) bool {
+	return cmp.Right != nil || cmp.IsNull != nil || cmp.Like != nil || cmp.In != nil || cmp.Between != nil
+}
+
+// sumOperand returns the single operand a sum consists of, nil if there is arithmetic or JSON access
+func sumOperand(sum *parser.Sum) *parser.Operand {
+	if access := sumAccess(sum); access != nil && len(access.Arrows) == 0 {
+		return access.Operand
+	}
+	return nil
+}
+
+// sumAccess returns the operand and JSON access a sum consists of, nil if there is arithmetic
+func sumAccess(sum *parser.Sum) *parser.Access {
+	if len(sum.Rest) != 0 || len(sum.Left.Rest) != 0 {
+		return nil
+	}
+	return sum.Left.Left
+}
+
+// nullType is what inference gives a bare NULL, it fits wherever any type would
+const nullType types.DataType = -1
+
+/*
+inferType works out the type an expression evaluates to, checking column
+references, function calls and the operands of AND, OR, NOT and arithmetic on
+the way so mistakes are reported before any row is read.
+*/
+func inferType(expr *parser.Expr, sc scope) (types.DataType, error) {
+	logical := len(expr.Or) > 1
+	for _, and := range expr.Or {
+		if len(and.And) > 1 {
+			logical = true
+		}
+		for _, not := range and.And {
+			if not.Not {
+				logical = true
+			}
+		}
+	}
+
+	var result types.DataType
+	for _, and := range expr.Or {
+		for _, not := range and.And {
+			t, err := inferComparisonType(not.Comparison, sc)
+			if err != nil {
+				return 0, err
+			}
+			if logical && !isType(t, types.BOOLEAN) {
+				return 0, fmt.Errorf("AND, OR and NOT expect boolean operands, got %s", t)
+			}
+			result = t
+		}
+	}
+	if logical {
+		return types.BOOLEAN, nil
+	}
+	return result, nil
+}
+
+func inferComparisonType(cmp *parser.Comparison, sc scope) (types.DataType, error) {
+	left, err := inferSumType(cmp.Left, sc)
+	if err != nil {
+		return 0, err
+	}
+	if cmp.Right != nil {
+		if _, err := inferSumType(cmp.Right, sc); err != nil {
+			return 0, err
+		}
+		return types.BOOLEAN, nil
+	}
+	if cmp.IsNull != nil {
+		return types.BOOLEAN, nil
+	}
+	if cmp.Like != nil {
+		return likeType(left, cmp.Like, sc)
+	}
+	if cmp.In != nil {
+		return inType(left, cmp.In, sc)
+	}
+	if cmp.Between != nil {
+		return betweenType(left, cmp.Between, sc)
+	}
+	return left, nil
+}
+
+// inferSumType types arithmetic by applying it to sample values of the operand types,
+// so it can't disagree with what arith does at run time
+func inferSumType(sum *parser.Sum, sc scope) (types.DataType, error) {
+	t, err := inferProductType(sum.Left, sc)
+	if err != nil {
+		return 0, err
+	}
+	for _, term := range sum.Rest {
+		right, err := inferProductType(term.Product, sc)
+		if err != nil {
+			return 0, err
+		}
+		if t, err = inferArith(t, term.Op, right); err != nil {
+			return 0, err
+		}
+	}
+	return t, nil
+}
+
+func inferProductType(product *parser.Product, sc scope) (types.DataType, error) {
+	t, err := inferAccessType(product.Left, sc)
+	if err != nil {
+		return 0, err
+	}
+	for _, term := range product.Rest {
+		right, err := inferAccessType(term.Access, sc)
+		if err != nil {
+			return 0, err
+		}
+		if t, err = inferArith(t, term.Op, right); err != nil {
+			return 0, err
+		}
+	}
+	return t, nil
+}
+
+func inferArith(left types.DataType, op string, right types.DataType) (types.DataType, error) {
+	switch {
+	case op == "||" && (left == nullType || right == nullType):
+		return types.TEXT, nil
+	case left == nullType:
+		return right, nil
+	case right == nullType:
+		return left, nil
+	}
+	v, err := arith(op, sampleValue(left), sampleValue(right))
+	if err != nil {
+		return 0, fmt.Errorf("cannot compute %s %s %s", left, op, right)
+	}
+	t, _ := types.TypeOf(v)
+	return t, nil
+}
+
+func inferAccessType(access *parser.Access, sc scope) (types.DataType, error) {
+	t, err := inferOperandType(access.Operand, sc)
+	if err != nil {
+		return 0, err
+	}
+	for _, arrow := range access.Arrows {
+		if !isJSONType(t) {
+			return 0, fmt.Errorf("%s expects JSON on its left, got %s", arrow.Op, t)
+		}
+		if _, err := arrowPath(arrow); err != nil {
+			return 0, err
+		}
+		t = types.JSON
+		if arrow.Op == "->>" {
+			t = types.TEXT
+		}
+	}
+	return t, nil
+}
+
+func inferOperandType(op *parser.Operand, sc scope) (types.DataType, error) {
+	switch {
+	case op.Value != nil:
+		t, ok := types.TypeOf(op.Value.ToInterface())
+		if !ok {
+			return nullType, nil
+		}
+		return t, nil
+	case op.Column != nil:
+		level, _, col, err := sc.resolve(op.Column)
+		if err != nil {
+			return 0, err
+		}
+		// an outer query's column is a constant for each run of a subquery
+		if sc.ungrouped && level == 0 {
+			return 0, fmt.Errorf("column %s must be inside an aggregate function", op.Column)
+		}
+		return col.Type, nil
+	case op.Call != nil:
+		return functionType(op.Call, sc)
+	case op.Extract != nil:
+		return extractType(op.Extract, sc)
+	case op.Cast != nil:
+		return castType(op.Cast, sc)
+	case op.Case != nil:
+		return caseType(op.Case, sc)
+	case op.Exists != nil:
+		return existsType(op.Exists, sc)
+	case op.Subquery != nil:
+		return scalarSubqueryType(op.Subquery, sc)
+	case op.Negate != nil:
+		t, err := inferOperandType(op.Negate, sc)
+		if err != nil {
+			return 0, err
+		}
+		if t != nullType {
+			if _, err := negate(sampleValue(t)); err != nil {
+				return 0, fmt.Errorf("cannot negate a %s", t)
+			}
+		}
+		return t, nil
+	case op.Sub != nil:
+		return inferType(op.Sub, sc)
+	default:
+		return 0, fmt.Errorf("empty expression")
+	}
+}
+
+func sampleValue(t types.DataType) types.Value {
+	switch t {
+	case types.INT:
+		return 1
+	case types.FLOAT:
+		return 1.0
+	case types.TEXT:
+		return ""
+	case types.BOOLEAN:
+		return false
+	case types.DATE:
+		return types.Date(0)
+	case types.TIMESTAMP:
+		return types.Timestamp(0)
+	case types.INTERVAL:
+		return types.Interval{}
+	case types.DECIMAL:
+		return types.DecimalFromInt(1)
+	case types.BLOB:
+		return []byte{}
+	case types.CHAR:
+		return types.Char("")
+	case types.JSON:
+		return types.JSONValue("null")
+	default:
+		return nil
+	}
+}
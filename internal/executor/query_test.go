@@ -0,0 +1,210 @@
+package executor
+
+import "testing"
+
+func TestCommonTableExpressions(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE agents (id INT, name TEXT, parent INT);",
+		"INSERT INTO agents VALUES (1, 'root', NULL), (7, 'a', 1), (8, 'b', 7), (9, 'c', 8), (10, 'd', 1), (11, 'e', 9);",
+		"CREATE TABLE payments (id INT, amount INT);",
+		"INSERT INTO payments VALUES (1, 5), (2, 5), (3, 2000);",
+	)
+	s.expectAll([]queryTest{
+		{"WITH big AS (SELECT * FROM agents WHERE id > 7), odd AS (SELECT * FROM big WHERE MOD(id, 2) = 1) SELECT id FROM odd;",
+			[]string{"9", "11"}},
+		{"WITH renamed (n) AS (SELECT name FROM agents WHERE id = 7) SELECT n FROM renamed;", []string{"a"}},
+		// a CTE keeps its duplicate rows
+		{"WITH amounts AS (SELECT amount FROM payments) SELECT * FROM amounts;", []string{"5", "5", "2000"}},
+		{`WITH RECURSIVE downline (id, name) AS (
+			SELECT id, name FROM agents WHERE id = 7
+			UNION ALL
+			SELECT id, name FROM agents WHERE parent IN (SELECT id FROM downline)
+		) SELECT * FROM downline;`, []string{"7 | a", "8 | b", "9 | c", "11 | e"}},
+		{`WITH RECURSIVE counter (n) AS (SELECT 1 FROM agents WHERE id = 1 UNION SELECT MOD(n + 1, 3) FROM counter)
+			SELECT * FROM counter;`, []string{"1", "2", "0"}},
+	})
+	s.failAll([]errorTest{
+		{"WITH RECURSIVE loop (n) AS (SELECT 1 FROM agents WHERE id = 1 UNION ALL SELECT n FROM loop) SELECT * FROM loop;", "100000"},
+		{"WITH c AS (SELECT id FROM agents), c AS (SELECT id FROM agents) SELECT * FROM c;", "c"},
+	})
+}
+
+func TestSetOperations(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE a (n INT);",
+		"INSERT INTO a VALUES (1), (1), (2), (3);",
+		"CREATE TABLE b (m FLOAT);",
+		"INSERT INTO b VALUES (1), (3), (4);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT n FROM a UNION SELECT m FROM b ORDER BY 1;", []string{"1", "2", "3", "4"}},
+		{"SELECT n FROM a UNION ALL SELECT m FROM b ORDER BY n DESC LIMIT 3;", []string{"4", "3", "3"}},
+		{"SELECT n FROM a INTERSECT SELECT m FROM b ORDER BY 1;", []string{"1", "3"}},
+		{"SELECT n FROM a EXCEPT ALL SELECT m FROM b ORDER BY 1;", []string{"1", "2"}},
+		{"SELECT n FROM a EXCEPT SELECT m FROM b;", []string{"2"}},
+	})
+	s.failAll([]errorTest{
+		{"SELECT n FROM a UNION SELECT m, m FROM b;", "columns"},
+		{"SELECT n FROM a UNION SELECT 'x' FROM b;", "TEXT"},
+	})
+}
+
+func TestDistinct(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE customers (city TEXT, country TEXT, phone TEXT, amount DECIMAL(5, 2));",
+		"INSERT INTO customers VALUES ('Nairobi', 'KE', '1', 1.5), ('Nairobi', 'KE', '2', '1.50'), ('Kampala', 'UG', NULL, NULL), ('Kampala', 'UG', '1', 2);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT DISTINCT city, country FROM customers;", []string{"Nairobi | KE", "Kampala | UG"}},
+		{"SELECT DISTINCT amount FROM customers;", []string{"1.50", "NULL", "2.00"}},
+		{"SELECT COUNT(*), COUNT(phone), COUNT(DISTINCT phone), SUM(DISTINCT amount) FROM customers;", []string{"4 | 3 | 2 | 3.50"}},
+		// functions without arguments can be called next to DISTINCT
+		{"SELECT DISTINCT NOW() > TIMESTAMP '2000-01-01 00:00:00' FROM customers;", []string{"true"}},
+	})
+}
+
+func TestWindowFunctions(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE ledger (id INT, account TEXT, amount INT);",
+		"INSERT INTO ledger VALUES (1, 'a', 10), (2, 'b', 5), (3, 'a', 20), (4, 'a', 20), (5, 'b', 1);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT id, SUM(amount) OVER (PARTITION BY account ORDER BY id ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW) FROM ledger ORDER BY id;",
+			[]string{"1 | 10", "2 | 5", "3 | 30", "4 | 50", "5 | 6"}},
+		{"SELECT id, ROW_NUMBER() OVER (ORDER BY amount DESC), RANK() OVER (ORDER BY amount DESC), DENSE_RANK() OVER (ORDER BY amount DESC) FROM ledger;",
+			[]string{"1 | 3 | 3 | 2", "2 | 4 | 4 | 3", "3 | 1 | 1 | 1", "4 | 2 | 1 | 1", "5 | 5 | 5 | 4"}},
+		{"SELECT id, LAG(amount) OVER (ORDER BY id), LEAD(amount, 2, 0) OVER (ORDER BY id) FROM ledger;",
+			[]string{"1 | NULL | 20", "2 | 10 | 20", "3 | 5 | 1", "4 | 20 | 0", "5 | 20 | 0"}},
+		{"SELECT id, LAG(amount, 0) OVER (ORDER BY id), LEAD(amount, NULL) OVER (ORDER BY id) FROM ledger WHERE id < 3;",
+			[]string{"1 | 10 | NULL", "2 | 5 | NULL"}},
+		{"SELECT id, FIRST_VALUE(id) OVER (PARTITION BY account ORDER BY amount DESC) FROM ledger;",
+			[]string{"1 | 3", "2 | 2", "3 | 3", "4 | 3", "5 | 2"}},
+		{"SELECT id, AVG(amount) OVER (ORDER BY id ROWS 1 PRECEDING) FROM ledger;",
+			[]string{"1 | 10", "2 | 7.5", "3 | 12.5", "4 | 20", "5 | 10.5"}},
+		// without a frame, rows tied on the ORDER BY are all in it
+		{"SELECT id, SUM(amount) OVER (ORDER BY amount) FROM ledger;",
+			[]string{"1 | 16", "2 | 6", "3 | 56", "4 | 56", "5 | 1"}},
+		{"SELECT id, COUNT(*) OVER (PARTITION BY account) FROM ledger;",
+			[]string{"1 | 3", "2 | 2", "3 | 3", "4 | 3", "5 | 2"}},
+	})
+	s.failAll([]errorTest{
+		{"SELECT id FROM ledger WHERE ROW_NUMBER() OVER (ORDER BY id) = 1;", "window"},
+		{"SELECT SUM(amount), ROW_NUMBER() OVER (ORDER BY id) FROM ledger;", "window"},
+		{"SELECT ROW_NUMBER() FROM ledger;", "OVER"},
+		// a negative offset is an error rather than the other direction
+		{"SELECT LAG(amount, -1) OVER (ORDER BY id) FROM ledger;", "LAG: negative offset -1"},
+		{"SELECT LEAD(amount, id - 3, 0) OVER (ORDER BY id) FROM ledger;", "LEAD: negative offset -2"},
+	})
+}
+
+func TestViews(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE payments (id INT, phone TEXT, amount INT);",
+		"INSERT INTO payments VALUES (1, '0700', 500), (2, '0711', 5000), (3, '0722', 9000);",
+		"CREATE VIEW big_payments AS SELECT id, phone, amount FROM payments WHERE amount > 1000;",
+		"CREATE VIEW top (phone) AS SELECT phone FROM big_payments WHERE amount > 6000;",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT phone FROM big_payments WHERE amount > 1000;", []string{"0711", "0722"}},
+		{"SELECT * FROM top;", []string{"0722"}},
+	})
+	// a view always reflects its tables
+	s.exec("INSERT INTO payments VALUES (4, '0733', 7000);")
+	s.reopen()
+	s.expect("SELECT * FROM top;", "0722", "0733")
+
+	s.failAll([]errorTest{
+		{"INSERT INTO big_payments VALUES (5, '0744', 2000);", "view"},
+		{"DELETE FROM big_payments;", "view"},
+		{"UPDATE big_payments SET amount = 1;", "view"},
+		{"CREATE INDEX v_id ON big_payments (id);", "view"},
+		{"CREATE VIEW payments AS SELECT 1 FROM top;", "exists"},
+		{"CREATE VIEW broken AS SELECT nope FROM payments;", "nope"},
+	})
+	s.exec("DROP VIEW top;", "DROP VIEW IF EXISTS top;")
+	s.fail("SELECT * FROM top;", "does not exist")
+	s.checkIntegrity()
+}
+
+func TestMaterializedViews(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE ledger (id INT, account TEXT, amount INT);",
+		"INSERT INTO ledger VALUES (1, 'a', 10), (2, 'b', 5), (3, 'a', 20);",
+		"CREATE MATERIALIZED VIEW totals AS SELECT DISTINCT account, SUM(amount) OVER (PARTITION BY account) AS total FROM ledger;",
+		"CREATE INDEX totals_account ON totals (account);",
+	)
+	s.expect("SELECT * FROM totals;", "a | 30", "b | 5")
+
+	// the rows stay as they were until a refresh
+	s.exec("INSERT INTO ledger VALUES (4, 'b', 100), (5, 'c', 1);")
+	s.expect("SELECT total FROM totals WHERE account = 'b';", "5")
+	s.exec("REFRESH MATERIALIZED VIEW totals;")
+	s.expect("SELECT total FROM totals WHERE account = 'b';", "105")
+	s.expect("SELECT * FROM totals;", "a | 30", "b | 105", "c | 1")
+
+	s.reopen()
+	s.expect("SELECT total FROM totals WHERE account = 'c';", "1")
+	s.failAll([]errorTest{
+		{"INSERT INTO totals VALUES ('d', 1);", "materialized view"},
+		{"TRUNCATE totals;", "materialized view"},
+		{"UPDATE totals SET total = 0;", "materialized view"},
+		{"DROP VIEW totals;", "materialized view"},
+		{"REFRESH MATERIALIZED VIEW ledger;", "ledger"},
+	})
+	s.checkIntegrity()
+	s.exec("DROP MATERIALIZED VIEW totals;")
+	s.checkIntegrity()
+}
+
+func TestViewDependencies(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE t (id INT);",
+		"CREATE TABLE u (id INT);",
+		"CREATE TABLE w (id INT);",
+		"CREATE TABLE c (id INT);",
+		"CREATE VIEW v AS SELECT id FROM t;",
+		"CREATE VIEW vv AS SELECT * FROM v;",
+		"CREATE MATERIALIZED VIEW m AS SELECT id FROM u WHERE id IN (SELECT id FROM w);",
+		"CREATE VIEW cv AS WITH q AS (SELECT id FROM c) SELECT * FROM q;",
+	)
+	s.failAll([]errorTest{
+		{"DROP TABLE t;", "cannot drop table t, views v, vv read it"},
+		{"ALTER TABLE t RENAME TO t2;", "cannot rename table t, views v, vv read it"},
+		{"DROP VIEW v;", "cannot drop view v, view vv reads it"},
+		{"DROP TABLE u;", "cannot drop table u, view m reads it"},
+		// tables read in a subquery or a CTE count too
+		{"DROP TABLE w;", "cannot drop table w, view m reads it"},
+		{"DROP TABLE c;", "cannot drop table c, view cv reads it"},
+		// so do changes to the table's columns
+		{"ALTER TABLE t DROP COLUMN id;", "cannot drop column id of table t, views v, vv read it"},
+		{"ALTER TABLE t RENAME COLUMN id TO ident;", "cannot rename column id of table t, views v, vv read it"},
+		{"ALTER TABLE u RENAME id TO ident;", "cannot rename column id of table u, view m reads it"},
+		{"ALTER TABLE w DROP COLUMN id;", "cannot drop column id of table w, view m reads it"},
+	})
+	s.expect("SELECT * FROM vv;")
+	s.reopen()
+	s.fail("DROP TABLE t;", "views v, vv read it")
+
+	// other changes to the tables are still allowed, and once the views are gone so are these
+	s.exec(
+		"TRUNCATE t;",
+		"ALTER TABLE t ADD COLUMN x INT;",
+		"DROP VIEW vv;",
+		"DROP VIEW v;",
+		"ALTER TABLE t RENAME TO t2;",
+		"ALTER TABLE t2 RENAME COLUMN id TO ident;",
+		"ALTER TABLE t2 DROP COLUMN x;",
+		"DROP TABLE t2;",
+		"DROP MATERIALIZED VIEW m;",
+		"DROP TABLE w;",
+		"DROP TABLE u;",
+	)
+	s.checkIntegrity()
+}
@@ -0,0 +1,167 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Set operations combine the rows of SELECTs returning the same number of
+columns, each column's types mixing like COALESCE's arguments do:
+  - UNION is the rows of both, UNION ALL keeps duplicates
+  - INTERSECT is the rows found in both, EXCEPT those of the left side not
+    found in the right one. With ALL a row appearing n times on the left and
+    m on the right comes out min(n, m) times for INTERSECT and n - m for EXCEPT.
+
+Without ALL duplicates are dropped, NULLs counting as equal to each other.
+They apply left to right, with INTERSECT binding no tighter than the others,
+and the result takes its column names from the first SELECT. Duplicates are
+found by hashing, and the right side of INTERSECT and EXCEPT is read into a
+hash table before the left side is streamed past it.
+*/
+type setOp struct {
+	op  string
+	all bool
+	q   *query
+}
+
+func (e *Executor) planSetOps(q *query, ops []*parser.SetOp, outer *frame, ctes map[string]*cte) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	columns := q.columns
+	for _, op := range ops {
+		name := strings.ToUpper(op.Op)
+		right, err := e.planCore(op.Select, outer, ctes)
+		if err != nil {
+			return err
+		}
+		if len(right.columns) != len(columns) {
+			return fmt.Errorf("each SELECT of a %s must return the same number of columns, got %d and %d", name, len(columns), len(right.columns))
+		}
+		for i, col := range right.columns {
+			if columns[i], err = mixColumn(name, columns[i], col); err != nil {
+				return err
+			}
+		}
+		q.correlated = q.correlated || right.correlated
+		q.compound = append(q.compound, &setOp{op: name, all: op.All, q: right})
+	}
+	q.columns = columns
+	return nil
+}
+
+// mixColumn is the column the values of two SELECTs' columns share: the first one
+// when they are declared alike, otherwise a column of their common type
+func mixColumn(op string, a, b types.Column) (types.Column, error) {
+	t, err := commonType(op, []types.DataType{a.Type, b.Type})
+	if err != nil {
+		return types.Column{}, fmt.Errorf("%s column %s: cannot mix %s with %s", op, a.Name, a.Type, b.Type)
+	}
+	if outputColumn(a.Name, b) == a {
+		return a, nil
+	}
+	return types.Column{Name: a.Name, Type: t}, nil
+}
+
+// runSetOps streams the rows of the query combined by its first n set operations
+func (e *Executor) runSetOps(q *query, n int, sc scope, cb func(types.Row) bool) error {
+	var rowErr error
+	coerced := func(row types.Row) (types.Row, bool) {
+		row, rowErr = coerceRow(row, q.columns)
+		return row, rowErr == nil
+	}
+	var err error
+	if n == 0 {
+		err = e.runCore(q, sc, func(out, _ types.Row) bool {
+			row, ok := coerced(out)
+			return ok && cb(row)
+		})
+	} else if op := q.compound[n-1]; op.op == "UNION" {
+		err = e.runUnion(q, n, sc, coerced, cb)
+	} else {
+		err = e.runFiltered(q, n, sc, coerced, cb)
+	}
+	if err != nil {
+		return err
+	}
+	return rowErr
+}
+
+func (e *Executor) runUnion(q *query, n int, sc scope, coerced func(types.Row) (types.Row, bool), cb func(types.Row) bool) error {
+	op := q.compound[n-1]
+	var seen map[string]bool
+	if !op.all {
+		seen = make(map[string]bool)
+	}
+	stopped := false
+	emit := func(row types.Row) bool {
+		if seen != nil {
+			key := rowKey(row)
+			if seen[key] {
+				return true
+			}
+			seen[key] = true
+		}
+		stopped = !cb(row)
+		return !stopped
+	}
+
+	if err := e.runSetOps(q, n-1, sc, emit); err != nil || stopped {
+		return err
+	}
+	return e.run(op.q, sc.outer, func(row types.Row) bool {
+		row, ok := coerced(row)
+		return ok && emit(row)
+	})
+}
+
+// runFiltered streams the left side of an INTERSECT or EXCEPT, keeping the rows its right side decides on
+func (e *Executor) runFiltered(q *query, n int, sc scope, coerced func(types.Row) (types.Row, bool), cb func(types.Row) bool) error {
+	op := q.compound[n-1]
+	counts := make(map[string]int)
+	err := e.run(op.q, sc.outer, func(row types.Row) bool {
+		row, ok := coerced(row)
+		if ok {
+			counts[rowKey(row)]++
+		}
+		return ok
+	})
+	if err != nil {
+		return err
+	}
+
+	intersect := op.op == "INTERSECT"
+	emitted := make(map[string]bool)
+	return e.runSetOps(q, n-1, sc, func(row types.Row) bool {
+		key := rowKey(row)
+		found := counts[key] > 0
+		if op.all && found {
+			counts[key]--
+		}
+		if found != intersect || emitted[key] {
+			return true
+		}
+		if !op.all {
+			emitted[key] = true
+		}
+		return cb(row)
+	})
+}
+
+// readsTable reports whether any of the query's SELECTs reads from a table, directly or through a view
+func (q *query) readsTable(table *db.Table) bool {
+	if q.source == table || q.view != nil && q.view.readsTable(table) {
+		return true
+	}
+	for _, op := range q.compound {
+		if op.q.readsTable(table) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Subqueries are planned once per statement, inside the scope of the query
+around them so they can read its columns:
+  - (SELECT ...) used as a value must produce a single column and at most
+    one row, none giving NULL
+  - EXISTS (SELECT ...) is whether it produces any row
+  - x IN (SELECT ...) checks x against its single column
+
+A subquery that reads nothing from the query around it runs once and its
+result is kept for every outer row, the values an IN checks against going in
+a hash set when their types allow. A correlated subquery runs again for every
+outer row, except for an EXISTS whose WHERE ties an inner expression to an
+outer one with = and is otherwise uncorrelated. That is turned into a hash
+semi-join: the inner values are collected in one pass and each outer row only
+looks its value up.
+*/
+type subquery struct {
+	q *query
+	// the first column of an uncorrelated subquery's rows, once it has run
+	ran    bool
+	values []types.Value
+	// key is set when an IN can look its values up in a hash set
+	key     *keyer
+	set     map[string]bool
+	hasNull bool
+	semi    *semiJoin
+}
+
+// semiJoin is a correlated EXISTS run as inner = outer over the rows the rest of its WHERE keeps
+type semiJoin struct {
+	inner, outer *parser.Sum
+	where        *parser.Expr
+	key          keyer
+	built        bool
+	set          map[string]bool
+}
+
+func (e *Executor) planSubquery(stmt *parser.Select, sc scope) (*subquery, error) {
+	if sc.reach != nil {
+		sc.reach.subquery = true
+	}
+	if sub, ok := e.subqueries[stmt]; ok {
+		return sub, nil
+	}
+	q, err := e.planSelect(stmt, &frame{sc: sc})
+	if err != nil {
+		return nil, err
+	}
+	sub := &subquery{q: q}
+	e.subqueries[stmt] = sub
+	return sub, nil
+}
+
+// singleColumn returns the type of the one column a subquery used as a value or by IN produces
+func singleColumn(sub *subquery) (types.DataType, error) {
+	if n := len(sub.q.columns); n != 1 {
+		return 0, fmt.Errorf("subquery must return a single column, got %d", n)
+	}
+	return sub.q.columns[0].Type, nil
+}
+
+func scalarSubqueryType(stmt *parser.Select, sc scope) (types.DataType, error) {
+	sub, err := sc.exec.planSubquery(stmt, sc)
+	if err != nil {
+		return 0, err
+	}
+	return singleColumn(sub)
+}
+
+func existsType(stmt *parser.Select, sc scope) (types.DataType, error) {
+	sub, err := sc.exec.planSubquery(stmt, sc)
+	if err != nil {
+		return 0, err
+	}
+	if sub.semi == nil {
+		sub.semi = planSemiJoin(sub.q)
+	}
+	return types.BOOLEAN, nil
+}
+
+// planSemiJoin looks for the inner = outer conjunct a correlated EXISTS can be joined on
+func planSemiJoin(q *query) *semiJoin {
+	if !q.correlated || len(q.aggregates) > 0 || len(q.compound) > 0 || q.limit >= 0 || q.where == nil || len(q.where.Or) != 1 {
+		return nil
+	}
+	var join *semiJoin
+	var rest []*parser.NotExpr
+	for _, term := range q.where.Or[0].And {
+		cmp := term.Comparison
+		if join == nil && !term.Not && cmp.Right != nil && cmp.Op == "=" {
+			if join = joinOn(q, cmp.Left, cmp.Right); join == nil {
+				join = joinOn(q, cmp.Right, cmp.Left)
+			}
+			if join != nil {
+				continue
+			}
+		}
+		if r, _ := reachOf(q, &parser.Expr{Or: []*parser.AndExpr{{And: []*parser.NotExpr{term}}}}); r.outer || r.subquery {
+			return nil
+		}
+		rest = append(rest, term)
+	}
+	if join != nil && len(rest) > 0 {
+		join.where = &parser.Expr{Or: []*parser.AndExpr{{And: rest}}}
+	}
+	return join
+}
+
+// joinOn returns a semi-join on inner = outer when inner only reads the subquery's
+// columns and outer only the outer query's, with types a hash set can match
+func joinOn(q *query, inner, outer *parser.Sum) *semiJoin {
+	innerReach, innerType := reachOf(q, &parser.Expr{Or: []*parser.AndExpr{{And: []*parser.NotExpr{{Comparison: &parser.Comparison{Left: inner}}}}}})
+	outerReach, outerType := reachOf(q, &parser.Expr{Or: []*parser.AndExpr{{And: []*parser.NotExpr{{Comparison: &parser.Comparison{Left: outer}}}}}})
+	if !innerReach.inner || innerReach.outer || innerReach.subquery {
+		return nil
+	}
+	if !outerReach.outer || outerReach.inner || outerReach.subquery {
+		return nil
+	}
+	key, ok := keyFor(innerType, outerType)
+	if !ok {
+		return nil
+	}
+	return &semiJoin{inner: inner, outer: outer, key: key}
+}
+
+// reachOf infers an expression inside a query's scope, recording whose columns it reads
+func reachOf(q *query, expr *parser.Expr) (reach, types.DataType) {
+	var r reach
+	sc := q.scope
+	sc.reach = &r
+	t, err := inferType(expr, sc)
+	if err != nil {
+		return reach{subquery: true}, 0
+	}
+	return r, t
+}
+
+func (e *Executor) evalScalarSubquery(stmt *parser.Select, row types.Row, sc scope) (types.Value, error) {
+	sub, err := e.planSubquery(stmt, sc)
+	if err != nil {
+		return nil, err
+	}
+	values, err := e.subqueryValues(sub, row, sc, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(values) {
+	case 0:
+		return nil, nil
+	case 1:
+		return values[0], nil
+	default:
+		return nil, fmt.Errorf("subquery used as a value returned more than one row")
+	}
+}
+
+func (e *Executor) evalExists(stmt *parser.Select, row types.Row, sc scope) (types.Value, error) {
+	sub, err := e.planSubquery(stmt, sc)
+	if err != nil {
+		return nil, err
+	}
+	if sub.semi != nil {
+		return e.probeSemiJoin(sub, row, sc)
+	}
+	values, err := e.subqueryValues(sub, row, sc, 1)
+	return len(values) > 0, err
+}
+
+// evalInSubquery checks a value against the column a subquery produces
+func (e *Executor) evalInSubquery(stmt *parser.Select, v types.Value, row types.Row, sc scope) (types.Value, error) {
+	sub, err := e.planSubquery(stmt, sc)
+	if err != nil {
+		return nil, err
+	}
+	values, err := e.subqueryValues(sub, row, sc, -1)
+	if err != nil || sub.key == nil || sub.q.correlated || v == nil || len(values) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return inValues(v, values)
+	}
+
+	if sub.set == nil {
+		sub.set = make(map[string]bool, len(values))
+		for _, value := range values {
+			if value == nil {
+				sub.hasNull = true
+				continue
+			}
+			sub.set[sub.key.of(value)] = true
+		}
+	}
+	switch {
+	case sub.set[sub.key.of(v)]:
+		return true, nil
+	case sub.hasNull:
+		return nil, nil
+	default:
+		return false, nil
+	}
+}
+
+// subqueryValues runs a subquery for the current outer row, or once when it is
+// uncorrelated, returning up to limit values of its first column (all when limit < 0)
+func (e *Executor) subqueryValues(sub *subquery, row types.Row, sc scope, limit int) ([]types.Value, error) {
+	if sub.ran {
+		return sub.values, nil
+	}
+	var values []types.Value
+	err := e.run(sub.q, &frame{sc: sc, row: row}, func(out types.Row) bool {
+		values = append(values, out[0])
+		return limit < 0 || len(values) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !sub.q.correlated {
+		sub.ran, sub.values = true, values
+	}
+	return values, nil
+}
+
+func (e *Executor) probeSemiJoin(sub *subquery, row types.Row, sc scope) (types.Value, error) {
+	join, inner := sub.semi, sub.q.scope
+	if !join.built {
+		join.set = make(map[string]bool)
+		var evalErr error
+		err := e.scan(sub.q, join.where, inner, func(innerRow types.Row) bool {
+			v, err := evalSum(join.inner, innerRow, inner)
+			if err != nil {
+				evalErr = err
+				return false
+			}
+			if v != nil {
+				join.set[join.key.of(v)] = true
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if evalErr != nil {
+			return nil, evalErr
+		}
+		join.built = true
+	}
+
+	// the outer side only reads the outer row, through the subquery's scope
+	inner.outer = &frame{sc: sc, row: row}
+	v, err := evalSum(join.outer, nil, inner)
+	if err != nil || v == nil {
+		return false, err
+	}
+	return join.set[join.key.of(v)], nil
+}
+
+/*
+keyer turns values into hash keys that are equal exactly when compareValues
+finds the values equal: numbers of any type by their decimal value, DATEs as
+the TIMESTAMP at midnight and INTERVALs by their length. With trim, text is
+keyed without trailing spaces, as it compares when either side is a CHAR.
+*/
+type keyer struct {
+	trim bool
+}
+
+// keyFor returns the keyer for matching values of two types, false when their
+// comparisons can't be reproduced by keys (JSON, or TEXT compared with a DATE)
+func keyFor(a, b types.DataType) (keyer, bool) {
+	class := func(t types.DataType) int {
+		switch t {
+		case types.INT, types.FLOAT, types.DECIMAL:
+			return 1
+		case types.TEXT, types.CHAR:
+			return 2
+		case types.DATE, types.TIMESTAMP:
+			return 3
+		case types.BOOLEAN, types.BLOB, types.INTERVAL:
+			return 4 + int(t)
+		default:
+			return 0
+		}
+	}
+	if class(a) == 0 || class(a) != class(b) {
+		return keyer{}, false
+	}
+	return keyer{trim: a == types.CHAR || b == types.CHAR}, true
+}
+
+func (k keyer) of(v types.Value) string {
+	switch x := v.(type) {
+	case int:
+		return "n" + types.DecimalFromInt(x).Normalize().String()
+	case float64:
+		d, err := types.DecimalFromFloat(x)
+		if err != nil {
+			return "f" + strconv.FormatFloat(x, 'g', -1, 64)
+		}
+		return "n" + d.Normalize().String()
+	case types.Decimal:
+		return "n" + x.Normalize().String()
+	case string:
+		if k.trim {
+			x = strings.TrimRight(x, " ")
+		}
+		return "s" + x
+	case types.Char:
+		return "s" + x.Trim()
+	case types.Date:
+		return "t" + strconv.FormatInt(int64(x.Timestamp()), 10)
+	case types.Timestamp:
+		return "t" + strconv.FormatInt(int64(x), 10)
+	case types.Interval:
+		return "i" + strconv.FormatInt(intervalMicros(x), 10)
+	case []byte:
+		return "x" + string(x)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// resetSubqueries drops the results kept from uncorrelated subqueries, for when what they read has changed
+func (e *Executor) resetSubqueries() {
+	for _, sub := range e.subqueries {
+		sub.ran, sub.values, sub.set, sub.hasNull = false, nil, nil, false
+		if sub.semi != nil {
+			sub.semi.built, sub.semi.set = false, nil
+		}
+	}
+}
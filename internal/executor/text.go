@@ -0,0 +1,107 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+String functions count characters, not bytes, and see a CHAR without its padding:
+  - UPPER(s), LOWER(s)
+  - LENGTH(s) counts characters, or the bytes of a BLOB
+  - SUBSTR(s, start[, count]) takes count characters from position start,
+    counting from 1 like PostgreSQL's substr
+  - TRIM(s[, chars]) strips spaces, or any of chars, from both ends
+  - REPLACE(s, from, to) replaces every from with to
+  - CONCAT(a, ...) joins the text of its arguments, skipping NULLs, where
+    a || b is NULL when either side is
+*/
+
+func isTextType(t types.DataType) bool {
+	return t == types.TEXT || t == types.CHAR || t == nullType
+}
+
+func mapText(f func(string) string) func([]types.Value) (types.Value, error) {
+	return func(args []types.Value) (types.Value, error) {
+		return f(textOf(args[0])), nil
+	}
+}
+
+func length(args []types.Value) (types.Value, error) {
+	if b, ok := args[0].([]byte); ok {
+		return len(b), nil
+	}
+	return utf8.RuneCountInString(textOf(args[0])), nil
+}
+
+func substr(args []types.Value) (types.Value, error) {
+	runes := []rune(textOf(args[0]))
+	start, ok := args[1].(int)
+	if !ok {
+		return nil, fmt.Errorf("SUBSTR expects an INT start, got %s", describe(args[1]))
+	}
+	// positions are 1 based and the range is cut down to the string, so
+	// SUBSTR('abc', 0, 2) is 'a'
+	end := len(runes) + 1
+	if len(args) == 3 {
+		count, ok := args[2].(int)
+		if !ok {
+			return nil, fmt.Errorf("SUBSTR expects an INT count, got %s", describe(args[2]))
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("SUBSTR: negative count %d", count)
+		}
+		end = min(end, start+count)
+	}
+	start = max(start, 1)
+	if start >= end {
+		return "", nil
+	}
+	return string(runes[start-1 : end-1]), nil
+}
+
+func trim(args []types.Value) (types.Value, error) {
+	chars := " "
+	if len(args) == 2 {
+		chars = textOf(args[1])
+	}
+	return strings.Trim(textOf(args[0]), chars), nil
+}
+
+func replace(args []types.Value) (types.Value, error) {
+	from := textOf(args[1])
+	if from == "" {
+		return textOf(args[0]), nil
+	}
+	return strings.ReplaceAll(textOf(args[0]), from, textOf(args[2])), nil
+}
+
+func concat(args []types.Value) (types.Value, error) {
+	var b strings.Builder
+	for _, v := range args {
+		if v != nil {
+			b.WriteString(textOf(v))
+		}
+	}
+	return b.String(), nil
+}
+
+// concatenate is a || b, which needs text on at least one side
+func concatenate(a, b types.Value) (types.Value, error) {
+	if !isText(a) && !isText(b) {
+		return nil, fmt.Errorf("|| expects TEXT on one side, got %s and %s", describe(a), describe(b))
+	}
+	return textOf(a) + textOf(b), nil
+}
+
+func isText(v types.Value) bool {
+	switch v.(type) {
+	case string, types.Char:
+		return true
+	default:
+		return false
+	}
+}
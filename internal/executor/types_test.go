@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDatesAndTimes(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE events (id INT, day DATE, at TIMESTAMP, length INTERVAL);",
+		"INSERT INTO events VALUES (1, '2024-01-31', '2024-01-31 10:30:00', '1 hour 30 minutes');",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT day + 1, day - DATE '2024-01-01' FROM events;", []string{"2024-02-01 | 30"}},
+		{"SELECT at + length FROM events;", []string{"2024-01-31 12:00:00"}},
+		{"SELECT at + INTERVAL '1 month', at - INTERVAL '2 months', day + INTERVAL '13 months' FROM events;",
+			[]string{"2024-02-29 10:30:00 | 2023-11-30 10:30:00 | 2025-02-28 00:00:00"}},
+		{"SELECT EXTRACT(YEAR FROM at), EXTRACT(DOY FROM day), DATE_TRUNC('month', day) FROM events;", []string{"2024 | 31 | 2024-01-01 00:00:00"}},
+		{"SELECT STRFTIME('%Y/%m/%d %H:%M', at) FROM events;", []string{"2024/01/31 10:30"}},
+		{"SELECT id FROM events WHERE at >= '2024-01-01' AND day < '2024-02-01';", []string{"1"}},
+		{"SELECT TIMESTAMP '2024-02-01 00:00:00' - at FROM events;", []string{"13:30:00"}},
+	})
+	s.failAll([]errorTest{
+		{"INSERT INTO events (id, day) VALUES (2, '2023-02-29');", "invalid date"},
+		{"INSERT INTO events (id, length) VALUES (2, '1 fortnight');", "unknown unit fortnight"},
+		{"SELECT day + day FROM events;", "DATE"},
+		// overflowing arithmetic fails instead of wrapping around
+		{"SELECT INTERVAL '200000000 years' FROM events;", "interval out of range"},
+		{"SELECT INTERVAL '2147483647 days' + INTERVAL '1 day' FROM events;", "interval out of range"},
+		{"SELECT TIMESTAMP '2024-01-01 00:00:00' + INTERVAL '100000000 years' FROM events;", "timestamp out of range"},
+		{"SELECT at - INTERVAL '2025 years' FROM events;", "timestamp out of range"},
+		{"SELECT day + 3000000 FROM events;", "date out of range"},
+	})
+}
+
+func TestDecimals(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE payments (id INT, amount DECIMAL(10, 2), rate NUMERIC(5, 4));",
+		"INSERT INTO payments VALUES (1, 19.99, 0.075), (2, '1234.565', '0.1');",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT amount, rate FROM payments;", []string{"19.99 | 0.0750", "1234.57 | 0.1000"}},
+		{"SELECT amount * rate, ROUND(amount * rate, 2), amount / 3 FROM payments WHERE id = 1;", []string{"1.499250 | 1.50 | 6.66333333"}},
+		{"SELECT SUM(amount) FROM payments;", []string{"1254.56"}},
+		{"SELECT id FROM payments WHERE amount = 19.99;", []string{"1"}},
+	})
+	s.fail("INSERT INTO payments VALUES (3, 123456789, 0);", "column amount")
+
+	// an unconstrained DECIMAL still has to fit the row format's 255 magnitude bytes
+	s.exec("CREATE TABLE big (d DECIMAL);")
+	s.fail("INSERT INTO big SELECT CAST('"+strings.Repeat("9", 700)+"' AS DECIMAL) FROM payments WHERE id = 1;", "too large to store")
+	s.exec("INSERT INTO big VALUES ('" + strings.Repeat("9", 600) + "');")
+	s.expect("SELECT LENGTH(CAST(d AS TEXT)) FROM big;", "600")
+	s.checkIntegrity()
+}
+
+func TestBlobs(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE signatures (id INT, sig BLOB);",
+		"INSERT INTO signatures VALUES (1, X'deadbeef'), (2, X''), (3, NULL);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT * FROM signatures WHERE sig = X'DEADBEEF';", []string{"1 | X'deadbeef'"}},
+		{"SELECT LENGTH(sig) FROM signatures WHERE id = 1;", []string{"4"}},
+		{"SELECT id, sig FROM signatures WHERE id = 2;", []string{"2 | X''"}},
+	})
+	s.failAll([]errorTest{
+		{"INSERT INTO signatures VALUES (4, X'abc');", "parse error"},
+		{"INSERT INTO signatures VALUES (4, 'deadbeef');", "column sig"},
+	})
+
+	// values larger than a page go to overflow pages
+	large := strings.Repeat("c0ffee", 5000)
+	s.exec(
+		"CREATE TABLE files (id INT, data BLOB, body TEXT);",
+		"INSERT INTO files VALUES (1, X'"+large+"', '"+strings.Repeat("a", 9000)+"'), (2, X'00', 'small');",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT id, LENGTH(data), LENGTH(body) FROM files;", []string{"1 | 15000 | 9000", "2 | 1 | 5"}},
+		{"SELECT id FROM files WHERE data = X'" + large + "';", []string{"1"}},
+	})
+	s.exec(
+		"UPDATE files SET body = body || body || body WHERE id = 1;",
+		"UPDATE files SET data = X'"+large+"', body = 'now small' WHERE id = 2;",
+	)
+	s.checkIntegrity()
+	s.reopen()
+	s.expect("SELECT id, LENGTH(data), LENGTH(body), SUBSTR(body, 26999) FROM files;", "1 | 15000 | 27000 | aa", "2 | 15000 | 9 | ")
+	s.exec("UPDATE files SET body = 'short' WHERE id = 1;", "DELETE FROM files WHERE id = 2;")
+	s.checkIntegrity()
+	s.exec("VACUUM;")
+	s.expect("SELECT id, LENGTH(data), body FROM files;", "1 | 15000 | short")
+	s.checkIntegrity()
+}
+
+func TestVarcharAndChar(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE countries (code CHAR(2), name VARCHAR(5), note TEXT);",
+		"INSERT INTO countries VALUES ('K', 'Kenya', NULL), ('UG', 'Ugan   ', NULL);",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT code || '|', name || '|' FROM countries;", []string{"K| | Kenya|", "UG| | Ugan |"}},
+		{"SELECT name FROM countries WHERE code = 'K';", []string{"Kenya"}},
+		{"SELECT CAST(name AS VARCHAR(3)) FROM countries WHERE code = 'UG';", []string{"Uga"}},
+	})
+	s.failAll([]errorTest{
+		{"INSERT INTO countries VALUES ('KEN', 'Kenya', NULL);", "column code"},
+		{"INSERT INTO countries VALUES ('TZ', 'Tanzania', NULL);", "column name"},
+		{"INSERT INTO countries (code, name) SELECT note, 'toolong' FROM countries;", "column name"},
+		// UPDATE checks the limits the same way, and writes nothing when a row fails
+		{"UPDATE countries SET name = name || 'xx';", "column name"},
+		{"UPDATE countries SET code = 'KEN' WHERE code = 'K';", "column code"},
+	})
+	s.expect("SELECT name FROM countries;", "Kenya", "Ugan ")
+	s.exec("UPDATE countries SET code = 'T', name = 'Tanz   ' WHERE code = 'UG';")
+	s.expect("SELECT code || '|', name || '|' FROM countries WHERE code = 'T';", "T| | Tanz |")
+}
+
+func TestJSON(t *testing.T) {
+	s := newSession(t)
+	s.exec(
+		"CREATE TABLE webhooks (id INT, body JSON);",
+		`INSERT INTO webhooks VALUES (1, '{"status": "paid", "amount": 100, "items": [{"sku": "A1"}]}'), (2, '{"status": "failed", "amount": 20, "items": []}');`,
+		"CREATE INDEX webhooks_status ON webhooks (body ->> '$.status') USING HASH;",
+	)
+	s.expectAll([]queryTest{
+		{"SELECT body ->> 'status', body -> 'items' -> 0 ->> 'sku', JSON_ARRAY_LENGTH(body, '$.items') FROM webhooks;",
+			[]string{"paid | A1 | 1", "failed | NULL | 0"}},
+		{"SELECT id FROM webhooks WHERE body -> 'amount' > 50 AND JSON_EXTRACT(body, '$.items[0].sku') = 'A1';", []string{"1"}},
+		{"SELECT id FROM webhooks WHERE body ->> '$.status' = 'failed';", []string{"2"}},
+		{"SELECT body -> 'items' FROM webhooks WHERE id = 1;", []string{`[{"sku":"A1"}]`}},
+	})
+	s.fail(`INSERT INTO webhooks VALUES (3, '{"status": ');`, "column body")
+	s.checkIntegrity()
+}
@@ -0,0 +1,123 @@
+package executor
+
+import (
+	"fmt"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+Go functions registered on the DB with RegisterFunc and RegisterAggregate are
+called like the built-ins, which win over a Go function of the same name.
+Arguments are converted to the Go parameter types the way a stored value is,
+so an INT can be passed for a float64 and TEXT for a types.Date. A NULL
+argument gives NULL without calling the function, and an aggregate skips the
+rows where any of its arguments is NULL.
+*/
+
+func goScalar(fn *db.ScalarFunc) *function {
+	return &function{
+		params:   goParams(fn.Signature),
+		optional: variadicOptional(fn.Signature),
+		variadic: fn.Variadic,
+		result:   returns(fn.Result),
+		eval: func(args []types.Value) (types.Value, error) {
+			args, err := goArgs(fn.Signature, args)
+			if err != nil {
+				return nil, err
+			}
+			return fn.Call(args)
+		},
+	}
+}
+
+func goAggregate(fn *db.AggregateFunc) *function {
+	return &function{
+		params:   goParams(fn.Signature),
+		optional: variadicOptional(fn.Signature),
+		variadic: fn.Variadic,
+		result:   returns(fn.Result),
+		aggregate: func() accumulator {
+			return &goAccumulator{sig: fn.Signature, state: fn.Start()}
+		},
+	}
+}
+
+// goAccumulator runs a Go aggregate's Step and Done
+type goAccumulator struct {
+	sig   db.Signature
+	state *db.AggregateState
+}
+
+func (acc *goAccumulator) step(args []types.Value) error {
+	for _, v := range args {
+		if v == nil {
+			return nil
+		}
+	}
+	args, err := goArgs(acc.sig, args)
+	if err != nil {
+		return err
+	}
+	return acc.state.Step(args)
+}
+
+func (acc *goAccumulator) result() (types.Value, error) {
+	return acc.state.Done()
+}
+
+func goParams(sig db.Signature) []param {
+	params := make([]param, len(sig.Params))
+	for i, t := range sig.Params {
+		params[i] = param{t.String(), func(got types.DataType) bool { return convertible(got, t) }}
+	}
+	return params
+}
+
+// variadicOptional lets the repeated parameter of a variadic Go function be left out altogether
+func variadicOptional(sig db.Signature) int {
+	if sig.Variadic {
+		return 1
+	}
+	return 0
+}
+
+// convertible reports whether values of one type can be passed as another. TEXT
+// is accepted by the types parsed from text, whether it parses is only seen per row.
+func convertible(from, to types.DataType) bool {
+	switch {
+	case from == to || from == nullType:
+		return true
+	case from == types.TEXT:
+		switch to {
+		case types.CHAR, types.JSON, types.DECIMAL, types.DATE, types.TIMESTAMP, types.INTERVAL:
+			return true
+		}
+		return false
+	}
+	_, err := goArg(sampleValue(from), to)
+	return err == nil
+}
+
+// goArgs converts arguments to the types of the Go function's parameters
+func goArgs(sig db.Signature, args []types.Value) ([]types.Value, error) {
+	converted := make([]types.Value, len(args))
+	for i, v := range args {
+		t := sig.Params[min(i, len(sig.Params)-1)]
+		arg, err := goArg(v, t)
+		if err != nil {
+			return nil, fmt.Errorf("%s argument %d: cannot pass %s as %s", sig.Name, i+1, describe(v), t)
+		}
+		converted[i] = arg
+	}
+	return converted, nil
+}
+
+func goArg(v types.Value, t types.DataType) (types.Value, error) {
+	// a CHAR parameter has no length to pad to
+	if t == types.CHAR && isText(v) {
+		return types.Char(textOf(v)), nil
+	}
+	return coerceValue(v, types.Column{Type: t})
+}
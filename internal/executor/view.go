@@ -0,0 +1,232 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/db"
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+A view is a SELECT stored under a name, along with the columns it returns.
+A query reading a view plans that SELECT afresh and streams its rows in place
+of a table's, so the view holds no rows and always shows what its tables hold
+now. It must still return the columns it was created with, of the same types;
+columns its tables gained since, say through SELECT *, are left out. Views
+are read only: INSERT, UPDATE and DELETE can't write through one. A table or
+view that a stored view reads can't be dropped or renamed while the view is
+there.
+
+A materialized view instead keeps the rows its SELECT returned in a table of
+its own, read like any other and indexable, but only brought up to date by
+REFRESH MATERIALIZED VIEW. A refresh runs the SELECT into a new heap and only
+swaps it in once the query succeeds, so the view shows either its old rows or
+all the new ones.
+*/
+
+func (e *Executor) executeCreateView(stmt *parser.CreateView) (string, error) {
+	q, err := e.planSelect(stmt.Select, nil)
+	if err != nil {
+		return "", err
+	}
+	names := stmt.Columns
+	if names != nil && len(names) != len(q.columns) {
+		return "", fmt.Errorf("%d column names given for %d columns", len(names), len(q.columns))
+	}
+	columns := make([]types.Column, len(q.columns))
+	for i, col := range q.columns {
+		if names != nil {
+			col.Name = names[i]
+		}
+		if columnIndex(columns[:i], col.Name) >= 0 {
+			return "", fmt.Errorf("duplicate column %s, give one an alias with AS", col.Name)
+		}
+		columns[i] = col
+	}
+	if stmt.Materialized {
+		return e.createMaterializedView(stmt.ViewName, stmt.Query, q, columns)
+	}
+	if err := e.db.CreateView(stmt.ViewName, stmt.Query, columns); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("View '%s' created", stmt.ViewName), nil
+}
+
+func (e *Executor) createMaterializedView(name, text string, q *query, columns []types.Column) (string, error) {
+	if err := e.db.CreateMaterializedView(name, text, columns); err != nil {
+		return "", err
+	}
+	count, err := e.fillView(e.db.Tables[name], q)
+	if err != nil {
+		// don't leave a half filled view behind
+		if dropErr := e.db.DropMaterializedView(name); dropErr != nil {
+			return "", fmt.Errorf("%w (dropping %s failed too: %v)", err, name, dropErr)
+		}
+		return "", err
+	}
+	return fmt.Sprintf("Materialized view '%s' created with %d row(s)", name, count), nil
+}
+
+func (e *Executor) executeRefresh(stmt *parser.Refresh) (string, error) {
+	table, err := e.db.MaterializedView(stmt.ViewName)
+	if err != nil {
+		return "", err
+	}
+	q, err := e.planView(table.Name, table.View, table.Schema)
+	if err != nil {
+		return "", err
+	}
+	count := 0
+	err = e.db.RefreshMaterializedView(table.Name, func(fresh *db.Table) error {
+		count, err = e.fillView(fresh, q)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Materialized view '%s' refreshed with %d row(s)", table.Name, count), nil
+}
+
+// fillView inserts a materialized view's rows, cut down to its columns, and returns how many there were
+func (e *Executor) fillView(table *db.Table, q *query) (int, error) {
+	count := 0
+	var insertErr error
+	err := e.run(q, nil, func(row types.Row) bool {
+		row, insertErr = coerceRow(row[:len(table.Schema)], table.Schema)
+		if insertErr == nil {
+			insertErr = table.Insert(row)
+			count++
+		}
+		return insertErr == nil
+	})
+	if err == nil {
+		err = insertErr
+	}
+	return count, err
+}
+
+func (e *Executor) executeDropView(stmt *parser.DropView) (string, error) {
+	kind, drop := "View", e.db.DropView
+	if stmt.Materialized {
+		kind, drop = "Materialized view", e.db.DropMaterializedView
+	}
+	if _, exists := e.db.Views[stmt.ViewName]; !exists && e.db.Tables[stmt.ViewName] == nil && stmt.IfExists {
+		return fmt.Sprintf("%s '%s' does not exist, skipping", kind, stmt.ViewName), nil
+	}
+	if err := e.checkNotRead("drop "+strings.ToLower(kind), stmt.ViewName); err != nil {
+		return "", err
+	}
+	if err := drop(stmt.ViewName); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s '%s' dropped", kind, stmt.ViewName), nil
+}
+
+// planView plans the SELECT behind a view or materialized view, checking it still returns the view's columns
+func (e *Executor) planView(name, text string, schema []types.Column) (*query, error) {
+	if e.views[name] {
+		return nil, fmt.Errorf("view %s reads itself", name)
+	}
+	sql, err := parser.Parse(text + ";")
+	if err != nil || sql.Select == nil {
+		return nil, fmt.Errorf("view %s: stored query can't be read back: %v", name, err)
+	}
+
+	e.views[name] = true
+	q, err := e.planSelect(sql.Select, nil)
+	delete(e.views, name)
+	if err != nil {
+		return nil, fmt.Errorf("view %s: %w", name, err)
+	}
+	if len(q.columns) < len(schema) {
+		return nil, fmt.Errorf("view %s returns %d columns now, %d when it was created", name, len(q.columns), len(schema))
+	}
+	for i, col := range schema {
+		if got := q.columns[i].Type; got != col.Type {
+			return nil, fmt.Errorf("view %s: column %s is %s now, %s when the view was created", name, col.Name, got, col.Type)
+		}
+	}
+	return q, nil
+}
+
+/*
+readersOf lists the views and materialized views whose SELECT reads name,
+directly or through another view, by planning each one and noting the tables
+and views it resolves. A view whose SELECT no longer plans is already broken;
+it counts as reading what was resolved before planning stopped.
+*/
+func (e *Executor) readersOf(name string) []string {
+	var readers []string
+	check := func(view, text string, schema []types.Column) {
+		if view == name {
+			return
+		}
+		e.reads = make(map[string]bool)
+		e.planView(view, text, schema)
+		if e.reads[name] {
+			readers = append(readers, view)
+		}
+	}
+	for _, view := range e.db.Views {
+		check(view.Name, view.Query, view.Schema)
+	}
+	for _, table := range e.db.Tables {
+		if table.View != "" {
+			check(table.Name, table.View, table.Schema)
+		}
+	}
+	e.reads = nil
+	sort.Strings(readers)
+	return readers
+}
+
+// checkNotRead fails when a stored view reads name, which dropping or renaming it would break
+func (e *Executor) checkNotRead(action, name string) error {
+	readers := e.readersOf(name)
+	switch len(readers) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("cannot %s %s, view %s reads it", action, name, readers[0])
+	}
+	return fmt.Errorf("cannot %s %s, views %s read it", action, name, strings.Join(readers, ", "))
+}
+
+// scanView runs a view's query, passing on the rows that satisfy a WHERE clause cut down to the view's columns
+func (e *Executor) scanView(q *query, where *parser.Expr, sc scope, cb func(types.Row) bool) error {
+	columns := q.scope.schema
+	var evalErr error
+	err := e.run(q.view, nil, func(row types.Row) bool {
+		if row, evalErr = coerceRow(row[:len(columns)], columns); evalErr != nil {
+			return false
+		}
+		match, err := evalWhere(where, row, sc)
+		if err != nil {
+			evalErr = err
+			return false
+		}
+		return !match || cb(row)
+	})
+	if err != nil {
+		return err
+	}
+	return evalErr
+}
+
+// writableTable finds the table an INSERT, UPDATE or DELETE writes to, which can't be a view
+func (e *Executor) writableTable(name string) (*db.Table, error) {
+	if _, ok := e.db.Views[name]; ok {
+		return nil, fmt.Errorf("cannot write to view %s, views are not updatable", name)
+	}
+	table, exists := e.db.Tables[name]
+	if !exists {
+		return nil, fmt.Errorf("table '%s' does not exist", name)
+	}
+	if table.View != "" {
+		return nil, fmt.Errorf("cannot write to materialized view %s, only REFRESH MATERIALIZED VIEW changes its rows", name)
+	}
+	return table, nil
+}
@@ -0,0 +1,396 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mbeka02/pesapal_challenge/internal/parser"
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+/*
+A window function gives each row a value computed from the rows around it,
+rather than folding them into one row like an aggregate. OVER splits the rows
+the WHERE clause keeps into partitions of rows alike in PARTITION BY, each
+sorted by ORDER BY, and a call sees the partition of its row:
+  - ROW_NUMBER() numbers the rows from 1
+  - RANK() gives rows sorting alike, called peers, the number of the first of
+    them, and DENSE_RANK() counts the groups of peers up to the row
+  - LAG(x[, n[, default]]) is x n rows back, 1 by default, and LEAD n rows on;
+    past the ends of the partition they are default, or NULL without one
+  - FIRST_VALUE(x) is x on the first row of the frame
+  - an aggregate, as in SUM(amount) OVER (...), folds the rows of the frame
+
+The frame is the rows ROWS BETWEEN start AND end picks around the row, each
+bound UNBOUNDED PRECEDING, n PRECEDING, CURRENT ROW, n FOLLOWING or UNBOUNDED
+FOLLOWING. Without one it runs from the start of the partition through the
+row's last peer, so the whole partition when there is no ORDER BY. A frame
+that starts with the partition keeps folding into the same accumulator as it
+grows; any other is folded afresh for each row.
+
+The rows are all read into memory before any comes out, in the order they
+were read. Window functions only go in the SELECT list, and not alongside
+aggregates since there is no GROUP BY to compute them over.
+*/
+
+// windowFunctions are the functions that can only be called with OVER; aggregates can be too
+var windowFunctions = map[string]*function{
+	"ROW_NUMBER":  {result: returns(types.INT)},
+	"RANK":        {result: returns(types.INT)},
+	"DENSE_RANK":  {result: returns(types.INT)},
+	"LAG":         {params: []param{anyParam, intParam, anyParam}, optional: 2, result: offsetType},
+	"LEAD":        {params: []param{anyParam, intParam, anyParam}, optional: 2, result: offsetType},
+	"FIRST_VALUE": {params: []param{anyParam}, result: sameAsFirst},
+}
+
+// offsetType is the type of LAG and LEAD, which their default has to share
+func offsetType(name string, args []types.DataType) (types.DataType, error) {
+	if len(args) < 3 {
+		return args[0], nil
+	}
+	return commonType(name, []types.DataType{args[0], args[2]})
+}
+
+// windowType checks a call with OVER and returns the type it produces
+func windowType(call *parser.Call, sc scope) (types.DataType, error) {
+	name := strings.ToUpper(call.Name)
+	if sc.windows == nil {
+		return 0, fmt.Errorf("window function %s is not allowed here", name)
+	}
+	fn, err := windowFunction(call, sc)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case fn.aggregate == nil && fn.eval != nil:
+		return 0, fmt.Errorf("%s is not an aggregate or window function, it can't be used with OVER", name)
+	case call.Distinct:
+		return 0, fmt.Errorf("DISTINCT is not supported in window functions")
+	case call.Star && !fn.star:
+		return 0, fmt.Errorf("%s(*) is not valid, only COUNT(*) is", name)
+	}
+	if err := checkFrame(call.Over.Frame); err != nil {
+		return 0, err
+	}
+	*sc.windows = append(*sc.windows, call)
+
+	// the arguments, PARTITION BY and ORDER BY are read from each row of the partition
+	argScope := sc
+	argScope.aggregates, argScope.ungrouped, argScope.windows = nil, false, nil
+	for _, expr := range call.Over.PartitionBy {
+		if _, err := inferType(expr, argScope); err != nil {
+			return 0, err
+		}
+	}
+	for _, item := range call.Over.OrderBy {
+		if _, err := inferType(item.Expr, argScope); err != nil {
+			return 0, err
+		}
+	}
+	args := make([]types.DataType, len(call.Args))
+	for i, arg := range call.Args {
+		if args[i], err = inferType(arg, argScope); err != nil {
+			return 0, err
+		}
+	}
+	if call.Star {
+		return fn.result(name, args)
+	}
+	return fn.check(name, args)
+}
+
+// windowFunction finds the function a call with OVER makes, a window function or an aggregate
+func windowFunction(call *parser.Call, sc scope) (*function, error) {
+	if fn, ok := windowFunctions[strings.ToUpper(call.Name)]; ok {
+		return fn, nil
+	}
+	return lookupFunction(call.Name, sc)
+}
+
+// checkFrame rejects frames ending before they start, whatever the row
+func checkFrame(frame *parser.Frame) error {
+	if frame == nil {
+		return nil
+	}
+	if frame.Between != (frame.End != nil) {
+		return fmt.Errorf("a frame is ROWS BETWEEN start AND end, or ROWS start")
+	}
+	start, end := frame.Start, frameEnd(frame)
+	switch {
+	case start.Unbounded && start.Following:
+		return fmt.Errorf("a frame can't start at UNBOUNDED FOLLOWING")
+	case end.Unbounded && !end.Following:
+		return fmt.Errorf("a frame can't end at UNBOUNDED PRECEDING")
+	case boundSide(start) > boundSide(end):
+		return fmt.Errorf("a frame starting at %s can't end at %s", start, end)
+	}
+	return nil
+}
+
+// frameEnd is where a frame ends, the current row when it only gives a start
+func frameEnd(frame *parser.Frame) *parser.FrameBound {
+	if frame.End == nil {
+		return &parser.FrameBound{Current: true}
+	}
+	return frame.End
+}
+
+// boundSide is -1 for bounds before the current row, 0 for the row itself and 1 for those after it
+func boundSide(b *parser.FrameBound) int {
+	switch {
+	case b.Current:
+		return 0
+	case b.Following:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// runWindows reads all the query's rows, computes its window functions over them and streams the projected rows
+func (e *Executor) runWindows(q *query, sc scope, cb func(out, src types.Row) bool) error {
+	var rows []types.Row
+	err := e.scan(q, q.where, sc, func(row types.Row) bool {
+		rows = append(rows, row)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	values := make([][]types.Value, len(q.windows))
+	for i, call := range q.windows {
+		if values[i], err = windowValues(call, rows, sc); err != nil {
+			return err
+		}
+	}
+	sc.folded = make(map[*parser.Call]types.Value, len(q.windows))
+	for r, row := range rows {
+		for i, call := range q.windows {
+			sc.folded[call] = values[i][r]
+		}
+		out, err := q.project(row, sc)
+		if err != nil {
+			return err
+		}
+		if !cb(out, row) {
+			break
+		}
+	}
+	return nil
+}
+
+// windowValues computes a window function for each of the rows
+func windowValues(call *parser.Call, rows []types.Row, sc scope) ([]types.Value, error) {
+	fn, err := windowFunction(call, sc)
+	if err != nil {
+		return nil, err
+	}
+	over := call.Over
+	order := make([]orderTerm, len(over.OrderBy))
+	orderBy := make([]*parser.Expr, len(over.OrderBy))
+	for i, item := range over.OrderBy {
+		order[i] = orderTerm{expr: item.Expr, desc: item.Desc}
+		orderBy[i] = item.Expr
+	}
+
+	// partitions hold the positions of their rows, in the order the partitions are first met
+	args := make([][]types.Value, len(rows))
+	keys := make([][]types.Value, len(rows))
+	var partitions [][]int
+	index := make(map[string]int)
+	for r, row := range rows {
+		if args[r], err = evalList(call.Args, row, sc); err != nil {
+			return nil, err
+		}
+		if keys[r], err = evalList(orderBy, row, sc); err != nil {
+			return nil, err
+		}
+		partition, err := evalList(over.PartitionBy, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		key := rowKey(partition)
+		p, ok := index[key]
+		if !ok {
+			p = len(partitions)
+			index[key] = p
+			partitions = append(partitions, nil)
+		}
+		partitions[p] = append(partitions[p], r)
+	}
+
+	values := make([]types.Value, len(rows))
+	w := &windowRun{call: call, fn: fn, args: args, values: values}
+	for _, part := range partitions {
+		var cmpErr error
+		sort.SliceStable(part, func(i, j int) bool {
+			cmp, err := compareKeys(order, keys[part[i]], keys[part[j]])
+			if err != nil && cmpErr == nil {
+				cmpErr = err
+			}
+			return cmp < 0
+		})
+		if cmpErr != nil {
+			return nil, cmpErr
+		}
+
+		// rows sorting alike are peers, each row's group of them running from peerStart to peerEnd
+		w.part = part
+		w.peerStart, w.peerEnd = make([]int, len(part)), make([]int, len(part))
+		for i := range part {
+			if i > 0 {
+				cmp, _ := compareKeys(order, keys[part[i-1]], keys[part[i]])
+				if cmp == 0 {
+					w.peerStart[i] = w.peerStart[i-1]
+					continue
+				}
+			}
+			w.peerStart[i] = i
+		}
+		for i := len(part) - 1; i >= 0; i-- {
+			w.peerEnd[i] = i
+			if i+1 < len(part) && w.peerStart[i+1] == w.peerStart[i] {
+				w.peerEnd[i] = w.peerEnd[i+1]
+			}
+		}
+		if err := w.fill(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func evalList(exprs []*parser.Expr, row types.Row, sc scope) ([]types.Value, error) {
+	values := make([]types.Value, len(exprs))
+	for i, expr := range exprs {
+		v, err := evalExpr(expr, row, sc)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// windowRun computes a window function over one sorted partition at a time
+type windowRun struct {
+	call *parser.Call
+	fn   *function
+	// args are each row's arguments and values the results, both by row read
+	args   [][]types.Value
+	values []types.Value
+	// part is the partition's rows in sort order, peerStart and peerEnd by position in it
+	part               []int
+	peerStart, peerEnd []int
+}
+
+func (w *windowRun) fill() error {
+	if w.fn.aggregate != nil {
+		return w.fold()
+	}
+	name := strings.ToUpper(w.call.Name)
+	dense := 0
+	for i, r := range w.part {
+		switch name {
+		case "ROW_NUMBER":
+			w.values[r] = i + 1
+		case "RANK":
+			w.values[r] = w.peerStart[i] + 1
+		case "DENSE_RANK":
+			if w.peerStart[i] == i {
+				dense++
+			}
+			w.values[r] = dense
+		case "LAG", "LEAD":
+			v, err := w.offset(i, name == "LEAD")
+			if err != nil {
+				return err
+			}
+			w.values[r] = v
+		case "FIRST_VALUE":
+			if start, end := w.frame(i); start <= end {
+				w.values[r] = w.args[w.part[start]][0]
+			}
+		}
+	}
+	return nil
+}
+
+// offset is LAG's value for the row at position i, or LEAD's when forward is set
+func (w *windowRun) offset(i int, forward bool) (types.Value, error) {
+	args := w.args[w.part[i]]
+	n := 1
+	if len(args) > 1 {
+		if args[1] == nil {
+			return nil, nil
+		}
+		n = args[1].(int)
+	}
+	// a negative offset would quietly turn LAG into LEAD and back
+	if n < 0 {
+		return nil, fmt.Errorf("%s: negative offset %d", strings.ToUpper(w.call.Name), n)
+	}
+	if forward {
+		n = -n
+	}
+	if j := i - n; j >= 0 && j < len(w.part) {
+		return w.args[w.part[j]][0], nil
+	}
+	if len(args) > 2 {
+		return args[2], nil
+	}
+	return nil, nil
+}
+
+// fold computes an aggregate over each row's frame
+func (w *windowRun) fold() error {
+	frame := w.call.Over.Frame
+	growing := frame == nil || frame.Start.Unbounded
+	var acc accumulator
+	stepped := 0
+	for i, r := range w.part {
+		start, end := w.frame(i)
+		if !growing || acc == nil {
+			acc, stepped = w.fn.aggregate(), start
+		}
+		for ; stepped <= end; stepped++ {
+			if err := acc.step(w.args[w.part[stepped]]); err != nil {
+				return err
+			}
+		}
+		v, err := acc.result()
+		if err != nil {
+			return err
+		}
+		w.values[r] = v
+	}
+	return nil
+}
+
+// frame gives the first and last positions in the frame of the row at position i, the first past the last when it is empty
+func (w *windowRun) frame(i int) (int, int) {
+	frame := w.call.Over.Frame
+	if frame == nil {
+		return 0, w.peerEnd[i]
+	}
+	last := len(w.part) - 1
+	return max(boundPosition(frame.Start, i, last), 0), min(boundPosition(frameEnd(frame), i, last), last)
+}
+
+// boundPosition is the position a frame bound stands for, from the row at position i in a partition ending at last
+func boundPosition(b *parser.FrameBound, i, last int) int {
+	switch {
+	case b.Current:
+		return i
+	case b.Unbounded && b.Following:
+		return last
+	case b.Unbounded:
+		return 0
+	case b.Following:
+		return i + *b.Offset
+	default:
+		return i - *b.Offset
+	}
+}
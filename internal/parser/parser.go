@@ -1,56 +1,504 @@
 package parser
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strings"
 
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
 // SQL is the top-level statement
 type SQL struct {
 	CreateTable *CreateTable `@@ ";"`
+	CreateIndex *CreateIndex `| @@ ";"`
+	CreateView  *CreateView  `| @@ ";"`
+	DropTable   *DropTable   `| @@ ";"`
+	DropView    *DropView    `| @@ ";"`
+	Refresh     *Refresh     `| @@ ";"`
+	Truncate    *Truncate    `| @@ ";"`
+	AlterTable  *AlterTable  `| @@ ";"`
 	Insert      *Insert      `| @@ ";"`
 	Select      *Select      `| @@ ";"`
+	Update      *Update      `| @@ ";"`
+	Delete      *Delete      `| @@ ";"`
+	Vacuum      *Vacuum      `| @@ ";"`
+	Pragma      *Pragma      `| @@ ";"`
 }
 
 // CREATE TABLE users (id INT, name TEXT, is_admin BOOLEAN, score FLOAT)
+// CREATE TABLE admins AS SELECT id, name FROM users WHERE is_admin
 type CreateTable struct {
 	TableName string   `"CREATE" "TABLE" @Ident`
-	Columns   []Column `"(" @@ ("," @@)* ")"`
+	Columns   []Column `( "(" @@ ("," @@)* ")"`
+	AsSelect  *Select  `| "AS" @@ )`
 }
 
 type Column struct {
-	Name string `@Ident`
-	Type string `@("INT" | "TEXT" | "BOOLEAN" | "FLOAT")`
+	Name    string   `@Ident`
+	Type    TypeName `@@`
+	Default *Value   `("DEFAULT" @@)?`
+}
+
+// INT, VARCHAR(20), DECIMAL(10, 2)
+type TypeName struct {
+	Name   string      `@("INT" | "TEXT" | "BOOLEAN" | "FLOAT" | "DATE" | "TIMESTAMP" | "INTERVAL" | "DECIMAL" | "NUMERIC" | "BLOB" | "VARCHAR" | "CHAR" | "JSON")`
+	Params *TypeParams `@@?`
+}
+
+// the (10, 2) of DECIMAL(10, 2) or the (20) of VARCHAR(20)
+type TypeParams struct {
+	Size  int  `"(" @Int`
+	Scale *int `("," @Int)? ")"`
+}
+
+// CREATE INDEX users_email ON users (email) USING HASH
+// CREATE INDEX payments_status ON payments (body ->> '$.status') USING HASH
+// CREATE INDEX files_path ON files (path) USING BTREE
+type CreateIndex struct {
+	IndexName string  `"CREATE" "INDEX" @Ident`
+	TableName string  `"ON" @Ident`
+	Column    string  `"(" @Ident`
+	Path      *string `("->>" @String)? ")"`
+	Using     string  `("USING" @("HASH" | "BTREE"))?`
+}
+
+// CREATE VIEW big_payments AS SELECT * FROM payments WHERE amount > 1000
+// CREATE VIEW balances (account, balance) AS SELECT account, SUM(amount) FROM ledger
+// CREATE MATERIALIZED VIEW totals AS SELECT SUM(amount) AS total FROM ledger
+type CreateView struct {
+	Materialized bool     `"CREATE" @"MATERIALIZED"?`
+	ViewName     string   `"VIEW" @Ident`
+	Columns      []string `("(" @Ident ("," @Ident)* ")")?`
+	Select       *Select  `"AS" @@`
+	// Query is the text of Select, filled in by Parse
+	Query string
+}
+
+// DROP VIEW IF EXISTS big_payments
+// DROP MATERIALIZED VIEW totals
+type DropView struct {
+	Materialized bool   `"DROP" @"MATERIALIZED"?`
+	IfExists     bool   `"VIEW" @("IF" "EXISTS")?`
+	ViewName     string `@Ident`
+}
+
+// REFRESH MATERIALIZED VIEW totals
+type Refresh struct {
+	ViewName string `"REFRESH" "MATERIALIZED" "VIEW" @Ident`
+}
+
+// DROP TABLE IF EXISTS users
+type DropTable struct {
+	IfExists  bool   `"DROP" "TABLE" @("IF" "EXISTS")?`
+	TableName string `@Ident`
+}
+
+// TRUNCATE TABLE users
+type Truncate struct {
+	TableName string `"TRUNCATE" "TABLE"? @Ident`
+}
+
+// ALTER TABLE users ADD COLUMN age INT DEFAULT 18
+// ALTER TABLE users DROP COLUMN age
+// ALTER TABLE users RENAME COLUMN name TO full_name
+// ALTER TABLE users RENAME TO customers
+type AlterTable struct {
+	TableName  string       `"ALTER" "TABLE" @Ident`
+	AddColumn  *AddColumn   `( "ADD" "COLUMN"? @@`
+	DropColumn *string      `| "DROP" "COLUMN"? @Ident`
+	Rename     *AlterRename `| "RENAME" @@ )`
+}
+
+type AddColumn struct {
+	Column Column `@@`
+}
+
+type AlterRename struct {
+	Table  *string       `  "TO" @Ident`
+	Column *RenameColumn `| "COLUMN"? @@`
+}
+
+type RenameColumn struct {
+	From string `@Ident`
+	To   string `"TO" @Ident`
 }
 
 // INSERT INTO users VALUES (1, 'Trevor', true, 95.5)
+// INSERT INTO users (id, name) VALUES (2, 'Ann'), (3, DEFAULT)
+// INSERT INTO archive SELECT * FROM users WHERE score < 10
 type Insert struct {
-	TableName string  `"INSERT" "INTO" @Ident`
-	Values    []Value `"VALUES" "(" @@ ("," @@)* ")"`
+	TableName string      `"INSERT" "INTO" @Ident`
+	Columns   []string    `("(" @Ident ("," @Ident)* ")")?`
+	Rows      []InsertRow `( "VALUES" @@ ("," @@)*`
+	Select    *Select     `| @@ )`
+}
+
+type InsertRow struct {
+	Values []InsertValue `"(" @@ ("," @@)* ")"`
+}
+
+// InsertValue is a literal or the DEFAULT keyword, which takes the column's DEFAULT
+type InsertValue struct {
+	Default bool   `  @"DEFAULT"`
+	Value   *Value `| @@`
 }
 
 type Value struct {
-	Number  *float64 `  @(Int | Float)`
+	Float   *float64 `  @("-"? Float)`
+	Int     *int     `| @("-"? Int)`
 	String  *string  `| @String`
-	Boolean *bool    `| (@"true" | "false")`
+	Boolean *Boolean `| @("true" | "false")`
+	Null    bool     `| @"NULL"`
+
+	Date      *DateLiteral      `| "DATE" @String`
+	Timestamp *TimestampLiteral `| "TIMESTAMP" @String`
+	Interval  *IntervalLiteral  `| "INTERVAL" @String`
+	Blob      *BlobLiteral      `| @Blob`
+}
+
+// Boolean captures both literals, a plain *bool would leave false unset
+type Boolean bool
+
+func (b *Boolean) Capture(values []string) error {
+	*b = Boolean(strings.EqualFold(values[0], "true"))
+	return nil
 }
 
-// SELECT * FROM users
+// DATE '2024-01-31', TIMESTAMP '2024-01-31 12:00:00' and INTERVAL '1 day' are
+// checked while parsing, so a malformed one is a parse error
+
+type DateLiteral types.Date
+
+func (d *DateLiteral) Capture(values []string) error {
+	v, err := types.ParseDate(values[0])
+	*d = DateLiteral(v)
+	return err
+}
+
+type TimestampLiteral types.Timestamp
+
+func (t *TimestampLiteral) Capture(values []string) error {
+	v, err := types.ParseTimestamp(values[0])
+	*t = TimestampLiteral(v)
+	return err
+}
+
+// X'deadbeef'
+type BlobLiteral []byte
+
+func (b *BlobLiteral) Capture(values []string) error {
+	text := values[0][2 : len(values[0])-1]
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return fmt.Errorf("invalid blob literal %s: %w", values[0], err)
+	}
+	*b = data
+	return nil
+}
+
+type IntervalLiteral types.Interval
+
+func (iv *IntervalLiteral) Capture(values []string) error {
+	v, err := types.ParseInterval(values[0])
+	*iv = IntervalLiteral(v)
+	return err
+}
+
+// SELECT * FROM users WHERE score > 50
+// SELECT name, score >= 50 AS passed FROM users ORDER BY score DESC LIMIT 10
+// SELECT o.id FROM orders o WHERE EXISTS (SELECT * FROM payments p WHERE p.order_id = o.id)
+// SELECT id FROM customers UNION SELECT customer_id FROM leads ORDER BY 1
+// WITH big AS (SELECT * FROM payments WHERE amount > 1000) SELECT id FROM big
 type Select struct {
-	TableName string `"SELECT" "*" "FROM" @Ident`
+	// Pos and EndPos are where the SELECT starts and ends in the text parsed
+	Pos    lexer.Position
+	EndPos lexer.Position
+
+	With *With `@@?`
+	SelectCore
+	Compound []*SetOp     `@@*`
+	OrderBy  []*OrderItem `("ORDER" "BY" @@ ("," @@)*)?`
+	Limit    *Limit       `@@?`
+}
+
+// SelectCore is a single SELECT, without the set operations, ORDER BY and LIMIT that apply to a whole query
+type SelectCore struct {
+	Distinct  bool          `"SELECT" @"DISTINCT"?`
+	Star      bool          `( @"*"`
+	Items     []*SelectItem `        | @@ ("," @@)* )`
+	TableName string        `"FROM" @Ident`
+	Alias     *string       `("AS"? @Ident)?`
+	Where     *Expr         `("WHERE" @@)?`
+}
+
+// UNION ALL SELECT ..., EXCEPT SELECT ...
+type SetOp struct {
+	Op     string      `@("UNION" | "INTERSECT" | "EXCEPT")`
+	All    bool        `@"ALL"?`
+	Select *SelectCore `@@`
+}
+
+// ORDER BY score DESC, 2
+type OrderItem struct {
+	Expr *Expr `@@`
+	Desc bool  `("ASC" | @"DESC")?`
+}
+
+// LIMIT 10 OFFSET 20
+type Limit struct {
+	Count  int `"LIMIT" @Int`
+	Offset int `("OFFSET" @Int)?`
+}
+
+// WITH recent AS (SELECT ...), big AS (SELECT * FROM recent WHERE ...)
+// WITH RECURSIVE up (id, parent) AS (SELECT id, parent FROM agents WHERE id = 7 UNION ALL SELECT id, parent FROM agents WHERE id IN (SELECT parent FROM up))
+type With struct {
+	Recursive bool   `"WITH" @"RECURSIVE"?`
+	Tables    []*CTE `@@ ("," @@)*`
+}
+
+type CTE struct {
+	Name    string   `@Ident`
+	Columns []string `("(" @Ident ("," @Ident)* ")")?`
+	Select  *Select  `"AS" "(" @@ ")"`
+}
+
+type SelectItem struct {
+	Expr  *Expr   `@@`
+	Alias *string `("AS" @Ident)?`
+}
+
+// UPDATE users SET score = score + 5, name = DEFAULT WHERE id = 1
+type Update struct {
+	TableName string       `"UPDATE" @Ident`
+	Set       []Assignment `"SET" @@ ("," @@)*`
+	Where     *Expr        `("WHERE" @@)?`
+}
+
+// Assignment is an expression or the DEFAULT keyword, which takes the column's DEFAULT
+type Assignment struct {
+	Column  string `@Ident "="`
+	Default bool   `( @"DEFAULT"`
+	Value   *Expr  `| @@ )`
+}
+
+// DELETE FROM users WHERE id = 1
+type Delete struct {
+	TableName string `"DELETE" "FROM" @Ident`
+	Where     *Expr  `("WHERE" @@)?`
+}
+
+// VACUUM
+type Vacuum struct {
+	Vacuum bool `@"VACUUM"`
+}
+
+// Expressions, lowest precedence first:
+// a = 1 OR NOT (b < 2 AND c != 'x')
+type Expr struct {
+	Or []*AndExpr `@@ ("OR" @@)*`
+}
+
+type AndExpr struct {
+	And []*NotExpr `@@ ("AND" @@)*`
+}
+
+type NotExpr struct {
+	Not        bool        `@"NOT"?`
+	Comparison *Comparison `@@`
+}
+
+type Comparison struct {
+	Left    *Sum     `@@`
+	Op      string   `( @("=" | "!=" | "<>" | "<=" | ">=" | "<" | ">")`
+	Right   *Sum     `  @@`
+	IsNull  *IsNull  `| "IS" @@`
+	Like    *Like    `| @@`
+	In      *In      `| @@`
+	Between *Between `| @@ )?`
+}
+
+// name LIKE 'Jo%', code NOT ILIKE 'ke\_%' ESCAPE '\', path GLOB '*.go', phone REGEXP '^2547'
+type Like struct {
+	Not     bool   `@"NOT"?`
+	Op      string `@("LIKE" | "ILIKE" | "GLOB" | "REGEXP")`
+	Pattern *Sum   `@@`
+	Escape  *Sum   `("ESCAPE" @@)?`
+}
+
+// status IN ('paid', 'due'), id NOT IN (SELECT user_id FROM banned)
+type In struct {
+	Not    bool    `@"NOT"? "IN" "("`
+	Select *Select `( @@`
+	List   []*Expr `| @@ ("," @@)* ) ")"`
+}
+
+// amount BETWEEN 10 AND 20, the bounds are sums so the AND isn't taken for a conjunction
+type Between struct {
+	Not  bool `@"NOT"? "BETWEEN"`
+	Low  *Sum `@@`
+	High *Sum `"AND" @@`
+}
+
+// a IS NULL, a IS NOT NULL
+type IsNull struct {
+	Not bool `@"NOT"? "NULL"`
+}
+
+// a + b - c, evaluated left to right
+type Sum struct {
+	Left *Product   `@@`
+	Rest []*SumTerm `@@*`
+}
+
+// a || b joins text, at the same precedence as + and -
+type SumTerm struct {
+	Op      string   `@("+" | "-" | "||")`
+	Product *Product `@@`
+}
+
+// a * b / c, binds tighter than + and -
+type Product struct {
+	Left *Access        `@@`
+	Rest []*ProductTerm `@@*`
+}
+
+type ProductTerm struct {
+	Op     string  `@("*" | "/")`
+	Access *Access `@@`
+}
+
+// body -> 'items' ->> 0, JSON member access binds tighter than * and /
+type Access struct {
+	Operand *Operand `@@`
+	Arrows  []*Arrow `@@*`
+}
+
+// -> gives the JSON found at a key, an array index or a $ path, ->> gives it as text
+type Arrow struct {
+	Op    string  `@("->>" | "->")`
+	Key   *string `( @String`
+	Index *int    `| @Int )`
+}
+
+type Operand struct {
+	Value    *Value     `  @@`
+	Extract  *Extract   `| @@`
+	Cast     *Cast      `| @@`
+	Case     *Case      `| @@`
+	Exists   *Select    `| "EXISTS" "(" @@ ")"`
+	Call     *Call      `| @@`
+	Column   *ColumnRef `| @@`
+	Negate   *Operand   `| "-" @@`
+	Subquery *Select    `| "(" @@ ")"`
+	Sub      *Expr      `| "(" @@ ")"`
+}
+
+// name, or users.name to say which table's column when subqueries are nested
+type ColumnRef struct {
+	Table *string `(@Ident ".")?`
+	Name  string  `@Ident`
+}
+
+func (c *ColumnRef) String() string {
+	if c.Table != nil {
+		return *c.Table + "." + c.Name
+	}
+	return c.Name
+}
+
+// CASE WHEN score >= 90 THEN 'A' WHEN score >= 80 THEN 'B' ELSE 'C' END
+// CASE status WHEN 'paid' THEN 1 ELSE 0 END
+type Case struct {
+	Value *Expr   `"CASE" @@?`
+	Whens []*When `@@+`
+	Else  *Expr   `("ELSE" @@)? "END"`
+}
+
+type When struct {
+	Cond   *Expr `"WHEN" @@`
+	Result *Expr `"THEN" @@`
+}
+
+// NOW(), DATE_TRUNC('month', created_at), COUNT(*), COUNT(DISTINCT phone)
+// RANK() OVER (PARTITION BY branch ORDER BY total DESC)
+type Call struct {
+	Name     string  `@Ident "("`
+	Distinct bool    `@"DISTINCT"?`
+	Star     bool    `( @"*"`
+	Args     []*Expr `| @@ ("," @@)* )? ")"`
+	Over     *Window `("OVER" @@)?`
+}
+
+// OVER (PARTITION BY account ORDER BY created_at ROWS BETWEEN 6 PRECEDING AND CURRENT ROW)
+// OVER () for all the rows
+type Window struct {
+	PartitionBy []*Expr      `"(" ("PARTITION" "BY" @@ ("," @@)*)?`
+	OrderBy     []*OrderItem `("ORDER" "BY" @@ ("," @@)*)?`
+	Frame       *Frame       `@@? ")"`
+}
+
+// ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW, or ROWS 2 PRECEDING which ends at the current row
+type Frame struct {
+	Between bool        `"ROWS" @"BETWEEN"?`
+	Start   *FrameBound `@@`
+	End     *FrameBound `("AND" @@)?`
+}
+
+// UNBOUNDED PRECEDING, 3 PRECEDING, CURRENT ROW, 1 FOLLOWING, UNBOUNDED FOLLOWING
+type FrameBound struct {
+	Current   bool `  @("CURRENT" "ROW")`
+	Unbounded bool `| ( @"UNBOUNDED"`
+	Offset    *int `  | @Int )`
+	Following bool `  ("PRECEDING" | @"FOLLOWING")`
+}
+
+func (b *FrameBound) String() string {
+	switch {
+	case b.Current:
+		return "CURRENT ROW"
+	case b.Unbounded && b.Following:
+		return "UNBOUNDED FOLLOWING"
+	case b.Unbounded:
+		return "UNBOUNDED PRECEDING"
+	case b.Following:
+		return fmt.Sprintf("%d FOLLOWING", *b.Offset)
+	default:
+		return fmt.Sprintf("%d PRECEDING", *b.Offset)
+	}
+}
+
+// CAST(amount AS DECIMAL(10, 2))
+type Cast struct {
+	Expr *Expr    `"CAST" "(" @@`
+	Type TypeName `"AS" @@ ")"`
+}
+
+// EXTRACT(YEAR FROM created_at)
+type Extract struct {
+	Field string `"EXTRACT" "(" @Ident`
+	From  *Expr  `"FROM" @@ ")"`
+}
+
+// PRAGMA integrity_check
+type Pragma struct {
+	Name string `"PRAGMA" @Ident`
 }
 
 var (
 	sqlLexer = lexer.MustSimple([]lexer.SimpleRule{
-		{Name: "Keyword", Pattern: `(?i)\b(CREATE|TABLE|INSERT|INTO|VALUES|SELECT|FROM|INT|TEXT|BOOLEAN|FLOAT|true|false)\b`},
+		{Name: "Keyword", Pattern: `(?i)\b(CREATE|TABLE|VIEW|MATERIALIZED|REFRESH|INSERT|INTO|VALUES|UPDATE|SET|SELECT|FROM|WHERE|DROP|IF|EXISTS|TRUNCATE|ALTER|ADD|COLUMN|RENAME|TO|DEFAULT|INDEX|ON|USING|HASH|BTREE|DELETE|VACUUM|PRAGMA|AS|AND|OR|NOT|IS|NULL|LIKE|ILIKE|GLOB|REGEXP|ESCAPE|IN|BETWEEN|CASE|WHEN|THEN|ELSE|END|WITH|RECURSIVE|UNION|ALL|INTERSECT|EXCEPT|DISTINCT|ORDER|BY|OVER|PARTITION|ROWS|PRECEDING|FOLLOWING|CURRENT|ROW|UNBOUNDED|ASC|DESC|LIMIT|OFFSET|EXTRACT|CAST|INT|TEXT|BOOLEAN|FLOAT|DATE|TIMESTAMP|INTERVAL|DECIMAL|NUMERIC|BLOB|VARCHAR|CHAR|JSON|true|false)\b`},
+		{Name: "Blob", Pattern: `[xX]'[0-9a-fA-F]*'`},
 		{Name: "Ident", Pattern: `[a-zA-Z_][a-zA-Z0-9_]*`},
 		{Name: "Float", Pattern: `\d+\.\d+`},
 		{Name: "Int", Pattern: `\d+`},
 		{Name: "String", Pattern: `'[^']*'`},
-		{Name: "Punct", Pattern: `[(),*;]`},
+		{Name: "Operator", Pattern: `->>|->|\|\||<>|<=|>=|!=|[=<>]`},
+		{Name: "Punct", Pattern: `[(),.*;+\-/]`},
 		{Name: "whitespace", Pattern: `\s+`},
 	})
 
@@ -68,23 +516,39 @@ func Parse(input string) (*SQL, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parse error: %w", err)
 	}
+	if v := sql.CreateView; v != nil {
+		v.Query = strings.TrimSpace(input[v.Select.Pos.Offset:v.Select.EndPos.Offset])
+	}
 	return sql, nil
 }
 
 // A helper to convert the  parsed value to an interface{}
 func (v *Value) ToInterface() interface{} {
-	if v.Number != nil {
-		// Check if it's an integer
-		if *v.Number == float64(int(*v.Number)) {
-			return int(*v.Number)
-		}
-		return *v.Number
+	// 95.0 stays a float, only literals written without a decimal point are ints
+	if v.Float != nil {
+		return *v.Float
+	}
+	if v.Int != nil {
+		return *v.Int
 	}
 	if v.String != nil {
 		return *v.String
 	}
 	if v.Boolean != nil {
-		return *v.Boolean
+		return bool(*v.Boolean)
+	}
+	if v.Date != nil {
+		return types.Date(*v.Date)
+	}
+	if v.Timestamp != nil {
+		return types.Timestamp(*v.Timestamp)
+	}
+	if v.Interval != nil {
+		return types.Interval(*v.Interval)
+	}
+	if v.Blob != nil {
+		return []byte(*v.Blob)
 	}
+	// NULL
 	return nil
 }
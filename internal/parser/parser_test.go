@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		sql     string
+		wantErr bool
+	}{
+		{sql: "CREATE TABLE users (id INT, name VARCHAR(20) DEFAULT 'x', amount DECIMAL(10, 2));"},
+		{sql: "CREATE TABLE passed AS SELECT id, score >= 50 AS passed FROM users;"},
+		{sql: "CREATE INDEX webhooks_status ON webhooks (body ->> '$.status') USING HASH;"},
+		{sql: "create materialized view totals as select sum(amount) as total from ledger;"},
+		{sql: "REFRESH MATERIALIZED VIEW totals;"},
+		{sql: "INSERT INTO users (id, name) VALUES (1, 'a'), (2, DEFAULT);"},
+		{sql: "ALTER TABLE users RENAME COLUMN name TO full_name;"},
+		{sql: "UPDATE users SET score = score + 5, name = DEFAULT WHERE id = 1;"},
+		{sql: "UPDATE users WHERE id = 1;", wantErr: true},
+		{sql: "WITH RECURSIVE t (n) AS (SELECT 1 FROM x UNION ALL SELECT n + 1 FROM t) SELECT * FROM t;"},
+		{sql: "SELECT id, LAG(amount, 1, 0) OVER (PARTITION BY account ORDER BY id ROWS BETWEEN 2 PRECEDING AND CURRENT ROW) FROM ledger;"},
+		{sql: "SELECT NOW() FROM users;"},
+		{sql: "PRAGMA integrity_check;"},
+		{sql: "SELECT * FROM users", wantErr: true},
+		{sql: "SELECT FROM users;", wantErr: true},
+		{sql: "INSERT INTO users VALUES (X'abc');", wantErr: true},
+		{sql: "DELETE users;", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.sql)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.sql, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	stmt, err := Parse("INSERT INTO t VALUES (1, 95.0, 'its', true, NULL, DATE '2024-02-29', INTERVAL '1 day', X'00ff');")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{1, 95.0, "its", true, nil, types.Date(19782), types.Interval{Days: 1}, []byte{0, 0xff}}
+	var got []interface{}
+	for _, v := range stmt.Insert.Rows[0].Values {
+		got = append(got, v.Value.ToInterface())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("values = %#v, want %#v", got, want)
+	}
+}
+
+func TestCreateViewKeepsQueryText(t *testing.T) {
+	stmt, err := Parse("CREATE VIEW big (id) AS   SELECT id FROM payments WHERE amount > 1000 ;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stmt.CreateView.Query; got != "SELECT id FROM payments WHERE amount > 1000" {
+		t.Fatalf("query = %q", got)
+	}
+}
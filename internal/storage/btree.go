@@ -0,0 +1,460 @@
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+/*
+BTree is a disk based B+tree mapping encoded keys to RecordIDs in key order, so
+besides looking a key up it can walk a range of keys, e.g. all those starting
+with a prefix.
+
+Every node is a page. The common header's NumCells counts the node's entries
+and, in a leaf, NextPage links to the next leaf in key order. Then:
++----------------+
+| Leaf (u8)      |
+| First (u64)    |  <- internal nodes: the child holding everything before the first entry
++----------------+
+| entries        |
++----------------+
+where a leaf entry is
+| keyLen (u16) | key | page (u64) | slot (u16) |
+and an internal entry adds | child (u64) |, the child holding the entries from
+it up to the next one. Entries are ordered by key and then RecordID, which
+keeps them unique however many rows share a key.
+
+The root page never moves: when it splits, its halves go to new pages and it
+becomes their parent, so the catalog can keep pointing at it. Deletes don't
+merge nodes, an emptied leaf stays in the chain until VACUUM rebuilds the index.
+*/
+type BTree struct {
+	pager *Pager
+	root  PageID
+}
+
+const (
+	BTREE_NODE_HEADER_SIZE = 9
+	btreeLeafEntrySize     = 12
+	btreeInternalEntrySize = 20
+	btreeNodeSpace         = PAGE_SIZE - PAGE_HEADER_SIZE - BTREE_NODE_HEADER_SIZE
+	// a node holds at least four of the largest entries, so the halves of a split always fit
+	BTREE_MAX_KEY_SIZE = btreeNodeSpace/4 - btreeInternalEntrySize
+)
+
+type btreeEntry struct {
+	key   []byte
+	rid   RecordID
+	child PageID // internal nodes only
+}
+
+type btreeNode struct {
+	leaf    bool
+	first   PageID // internal nodes only
+	next    PageID // leaves only
+	entries []btreeEntry
+}
+
+// CreateBTree allocates an empty tree, a root that is an empty leaf
+func CreateBTree(pager *Pager) (*BTree, error) {
+	root, err := pager.AllocatePage()
+	if err != nil {
+		return nil, err
+	}
+	t := &BTree{pager: pager, root: root}
+	if err := t.writeNode(root, &btreeNode{leaf: true}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenBTree opens an existing tree whose root is at root
+func OpenBTree(pager *Pager, root PageID) *BTree {
+	return &BTree{pager: pager, root: root}
+}
+
+func (t *BTree) Root() PageID {
+	return t.root
+}
+
+// compareEntry orders a (key, rid) pair against an entry
+func compareEntry(key []byte, rid RecordID, e btreeEntry) int {
+	if c := bytes.Compare(key, e.key); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(rid.PageID, e.rid.PageID); c != 0 {
+		return c
+	}
+	return cmp.Compare(rid.Slot, e.rid.Slot)
+}
+
+// search returns the number of entries at or before (key, rid)
+func (n *btreeNode) search(key []byte, rid RecordID) int {
+	return sort.Search(len(n.entries), func(i int) bool {
+		return compareEntry(key, rid, n.entries[i]) < 0
+	})
+}
+
+// child returns the child to follow for a pair search placed after i entries
+func (n *btreeNode) child(i int) PageID {
+	if i == 0 {
+		return n.first
+	}
+	return n.entries[i-1].child
+}
+
+func (n *btreeNode) entrySize(e btreeEntry) int {
+	if n.leaf {
+		return btreeLeafEntrySize + len(e.key)
+	}
+	return btreeInternalEntrySize + len(e.key)
+}
+
+func (n *btreeNode) size() int {
+	size := 0
+	for _, e := range n.entries {
+		size += n.entrySize(e)
+	}
+	return size
+}
+
+func (t *BTree) writeNode(id PageID, n *btreeNode) error {
+	page := make([]byte, PAGE_SIZE)
+	binary.LittleEndian.PutUint16(page[0:2], uint16(len(n.entries)))
+	SetNextPage(page, n.next)
+	if n.leaf {
+		page[PAGE_HEADER_SIZE] = 1
+	}
+	binary.LittleEndian.PutUint64(page[PAGE_HEADER_SIZE+1:], uint64(n.first))
+
+	off := PAGE_HEADER_SIZE + BTREE_NODE_HEADER_SIZE
+	for _, e := range n.entries {
+		binary.LittleEndian.PutUint16(page[off:], uint16(len(e.key)))
+		off += 2
+		off += copy(page[off:], e.key)
+		binary.LittleEndian.PutUint64(page[off:], uint64(e.rid.PageID))
+		binary.LittleEndian.PutUint16(page[off+8:], e.rid.Slot)
+		off += 10
+		if !n.leaf {
+			binary.LittleEndian.PutUint64(page[off:], uint64(e.child))
+			off += 8
+		}
+	}
+	_, err := t.pager.WritePage(id, page)
+	return err
+}
+
+func (t *BTree) readNode(id PageID) (*btreeNode, error) {
+	if id == 0 {
+		return nil, fmt.Errorf("B+tree %d links to the meta page", t.root)
+	}
+	page, err := t.pager.ReadPage(id)
+	if err != nil {
+		return nil, err
+	}
+	n := &btreeNode{
+		leaf:  page[PAGE_HEADER_SIZE] == 1,
+		first: PageID(binary.LittleEndian.Uint64(page[PAGE_HEADER_SIZE+1:])),
+		next:  NextPage(page),
+	}
+	if page[PAGE_HEADER_SIZE] > 1 {
+		return nil, fmt.Errorf("B+tree page %d: node type %d", id, page[PAGE_HEADER_SIZE])
+	}
+
+	count := int(binary.LittleEndian.Uint16(page[0:2]))
+	off := PAGE_HEADER_SIZE + BTREE_NODE_HEADER_SIZE
+	for i := 0; i < count; i++ {
+		if off+2 > PAGE_SIZE {
+			return nil, fmt.Errorf("B+tree page %d: entry %d runs past the page", id, i)
+		}
+		keyLen := int(binary.LittleEndian.Uint16(page[off:]))
+		e := btreeEntry{key: make([]byte, keyLen)}
+		if off+n.entrySize(e) > PAGE_SIZE {
+			return nil, fmt.Errorf("B+tree page %d: entry %d runs past the page", id, i)
+		}
+		off += 2
+		off += copy(e.key, page[off:off+keyLen])
+		e.rid = RecordID{
+			PageID: PageID(binary.LittleEndian.Uint64(page[off:])),
+			Slot:   binary.LittleEndian.Uint16(page[off+8:]),
+		}
+		off += 10
+		if !n.leaf {
+			e.child = PageID(binary.LittleEndian.Uint64(page[off:]))
+			off += 8
+		}
+		n.entries = append(n.entries, e)
+	}
+	return n, nil
+}
+
+func (t *BTree) Insert(key []byte, rid RecordID) error {
+	if len(key) > BTREE_MAX_KEY_SIZE {
+		return fmt.Errorf("index key of %d bytes is too large, a B+tree takes at most %d", len(key), BTREE_MAX_KEY_SIZE)
+	}
+	split, err := t.insert(t.root, btreeEntry{key: key, rid: rid})
+	if err != nil || split == nil {
+		return err
+	}
+
+	// the root split, its left half moves out and it becomes the parent of both
+	left, err := t.readNode(t.root)
+	if err != nil {
+		return err
+	}
+	leftID, err := t.pager.AllocatePage()
+	if err != nil {
+		return err
+	}
+	if err := t.writeNode(leftID, left); err != nil {
+		return err
+	}
+	return t.writeNode(t.root, &btreeNode{first: leftID, entries: []btreeEntry{*split}})
+}
+
+// insert adds e below node id, returning the separator for the parent when the node split
+func (t *BTree) insert(id PageID, e btreeEntry) (*btreeEntry, error) {
+	n, err := t.readNode(id)
+	if err != nil {
+		return nil, err
+	}
+	i := n.search(e.key, e.rid)
+	if n.leaf {
+		if i > 0 && compareEntry(e.key, e.rid, n.entries[i-1]) == 0 {
+			return nil, fmt.Errorf("B+tree %d already has this key for record %v", t.root, e.rid)
+		}
+	} else {
+		split, err := t.insert(n.child(i), e)
+		if err != nil || split == nil {
+			return nil, err
+		}
+		e = *split
+	}
+	n.entries = append(n.entries, btreeEntry{})
+	copy(n.entries[i+1:], n.entries[i:])
+	n.entries[i] = e
+
+	if n.size() <= btreeNodeSpace {
+		return nil, t.writeNode(id, n)
+	}
+	return t.split(id, n)
+}
+
+/*
+split() moves the upper half of an overfull node, by bytes, to a new page and
+returns the entry separating the two. A leaf's separator is a copy of the new
+leaf's first entry; an internal node's middle entry moves up instead, its
+child becoming the first child of the new node.
+*/
+func (t *BTree) split(id PageID, n *btreeNode) (*btreeEntry, error) {
+	half, m := n.size()/2, 0
+	for size := 0; size < half; m++ {
+		size += n.entrySize(n.entries[m])
+	}
+	// both halves keep an entry, and an internal node one more to move up
+	m = min(m, len(n.entries)-1)
+	if !n.leaf && m == len(n.entries)-1 {
+		m--
+	}
+
+	rightID, err := t.pager.AllocatePage()
+	if err != nil {
+		return nil, err
+	}
+	right := &btreeNode{leaf: n.leaf}
+	var sep btreeEntry
+	if n.leaf {
+		right.entries = append([]btreeEntry(nil), n.entries[m:]...)
+		right.next, n.next = n.next, rightID
+		sep = btreeEntry{key: right.entries[0].key, rid: right.entries[0].rid}
+	} else {
+		sep = n.entries[m]
+		right.first = sep.child
+		right.entries = append([]btreeEntry(nil), n.entries[m+1:]...)
+	}
+	sep.child = rightID
+	n.entries = n.entries[:m]
+
+	if err := t.writeNode(rightID, right); err != nil {
+		return nil, err
+	}
+	return &sep, t.writeNode(id, n)
+}
+
+// leafFor descends to the leaf where (key, rid) is or would be
+func (t *BTree) leafFor(key []byte, rid RecordID) (PageID, *btreeNode, error) {
+	id := t.root
+	for depth := 0; ; depth++ {
+		n, err := t.readNode(id)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n.leaf {
+			return id, n, nil
+		}
+		// a page read on the way down can't be its own ancestor, don't loop on a corrupt tree forever
+		if depth > 64 {
+			return 0, nil, fmt.Errorf("B+tree %d is more than 64 levels deep", t.root)
+		}
+		id = n.child(n.search(key, rid))
+	}
+}
+
+// ScanFrom visits the (key, RecordID) pairs in key order, starting at the first key >= start,
+// until cb returns false
+func (t *BTree) ScanFrom(start []byte, cb func(key []byte, rid RecordID) bool) error {
+	// no record lives on page 0, so this sorts before every entry with the key start
+	_, n, err := t.leafFor(start, RecordID{})
+	if err != nil {
+		return err
+	}
+	i := n.search(start, RecordID{})
+	for {
+		for _, e := range n.entries[i:] {
+			if !cb(e.key, e.rid) {
+				return nil
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		if n, err = t.readNode(n.next); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// Scan visits every (key, RecordID) pair in key order
+func (t *BTree) Scan(cb func(key []byte, rid RecordID) bool) error {
+	return t.ScanFrom(nil, cb)
+}
+
+// Lookup returns the RecordIDs stored under key
+func (t *BTree) Lookup(key []byte) ([]RecordID, error) {
+	var rids []RecordID
+	err := t.ScanFrom(key, func(k []byte, rid RecordID) bool {
+		if !bytes.Equal(k, key) {
+			return false
+		}
+		rids = append(rids, rid)
+		return true
+	})
+	return rids, err
+}
+
+// Delete removes the entry for key pointing at rid
+func (t *BTree) Delete(key []byte, rid RecordID) error {
+	id, n, err := t.leafFor(key, rid)
+	if err != nil {
+		return err
+	}
+	i := n.search(key, rid)
+	if i == 0 || compareEntry(key, rid, n.entries[i-1]) != 0 {
+		return fmt.Errorf("B+tree %d has no entry for record %v", t.root, rid)
+	}
+	n.entries = append(n.entries[:i-1], n.entries[i:]...)
+	return t.writeNode(id, n)
+}
+
+/*
+Check validates the tree for the integrity checker: that every node decodes
+and fits its page, keeps its entries in order and within the bounds its parent
+gives it, that all leaves are at the same depth, and that the leaf chain
+visits them in key order. It returns every page of the tree along with the
+problems found.
+*/
+func (t *BTree) Check() ([]PageID, []string) {
+	c := &btreeChecker{tree: t, seen: make(map[PageID]bool), next: make(map[PageID]PageID), leafDepth: -1}
+	c.node(t.root, nil, nil, 0)
+
+	for i, leaf := range c.leaves {
+		want := PageID(0)
+		if i+1 < len(c.leaves) {
+			want = c.leaves[i+1]
+		}
+		if next := c.next[leaf]; next != want {
+			c.problems = append(c.problems, fmt.Sprintf("leaf %d links to page %d, the next leaf is %d", leaf, next, want))
+		}
+	}
+	return c.pages, c.problems
+}
+
+type btreeChecker struct {
+	tree      *BTree
+	seen      map[PageID]bool
+	pages     []PageID
+	leaves    []PageID
+	next      map[PageID]PageID
+	leafDepth int
+	problems  []string
+}
+
+func (c *btreeChecker) report(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// node checks the subtree at id, whose entries must sort at or after lo and before hi (nil for no bound)
+func (c *btreeChecker) node(id PageID, lo, hi *btreeEntry, depth int) {
+	if c.seen[id] {
+		c.report("page %d is reached twice", id)
+		return
+	}
+	c.seen[id] = true
+	c.pages = append(c.pages, id)
+
+	n, err := c.tree.readNode(id)
+	if err != nil {
+		c.report("%v", err)
+		return
+	}
+	if n.size() > btreeNodeSpace {
+		c.report("page %d holds %d bytes of entries, at most %d fit", id, n.size(), btreeNodeSpace)
+	}
+	for i, e := range n.entries {
+		if i > 0 && compareEntry(e.key, e.rid, n.entries[i-1]) <= 0 {
+			c.report("page %d: entry %d is out of order", id, i)
+		}
+		if lo != nil && compareEntry(e.key, e.rid, *lo) < 0 || hi != nil && compareEntry(e.key, e.rid, *hi) >= 0 {
+			c.report("page %d: entry %d is outside the range its parent gives the page", id, i)
+		}
+	}
+
+	if n.leaf {
+		if c.leafDepth < 0 {
+			c.leafDepth = depth
+		} else if depth != c.leafDepth {
+			c.report("leaf %d is at depth %d, others at %d", id, depth, c.leafDepth)
+		}
+		c.leaves = append(c.leaves, id)
+		c.next[id] = n.next
+		return
+	}
+	for i := 0; i <= len(n.entries); i++ {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = &n.entries[i-1]
+		}
+		if i < len(n.entries) {
+			childHi = &n.entries[i]
+		}
+		c.node(n.child(i), childLo, childHi, depth+1)
+	}
+}
+
+// Free returns every page of the tree to the allocator
+func (t *BTree) Free() error {
+	pages, problems := t.Check()
+	if len(problems) > 0 {
+		return fmt.Errorf("B+tree %d: %s", t.root, problems[0])
+	}
+	for _, id := range pages {
+		if err := t.pager.FreePage(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
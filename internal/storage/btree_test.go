@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func newTestBTree(t *testing.T) (*Pager, *BTree) {
+	t.Helper()
+	pager := newTestPager(t)
+	tree, err := CreateBTree(pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pager, tree
+}
+
+func checkBTree(t *testing.T, tree *BTree) {
+	t.Helper()
+	if _, problems := tree.Check(); len(problems) > 0 {
+		t.Fatalf("B+tree check: %v", problems)
+	}
+}
+
+func mustScan(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBTreeSplitsAndKeepsKeyOrder(t *testing.T) {
+	pager, tree := newTestBTree(t)
+	const n = 5000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		if err := tree.Insert(key, RecordID{PageID: PageID(i + 10), Slot: uint16(i % 7)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, tree)
+	if root, _ := tree.readNode(tree.Root()); root.leaf {
+		t.Fatalf("root is still a leaf after %d inserts", n)
+	}
+
+	// a freshly opened tree finds every key, and walks them in order
+	reopened := OpenBTree(pager, tree.Root())
+	for i := 0; i < n; i += 97 {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		rids, err := reopened.Lookup(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := RecordID{PageID: PageID(i + 10), Slot: uint16(i % 7)}
+		if len(rids) != 1 || rids[0] != want {
+			t.Fatalf("Lookup(%s) = %v, want [%v]", key, rids, want)
+		}
+	}
+	if rids, _ := reopened.Lookup([]byte("key-")); len(rids) != 0 {
+		t.Fatalf("Lookup of a missing key = %v", rids)
+	}
+	var prev []byte
+	count := 0
+	mustScan(t, reopened.Scan(func(key []byte, _ RecordID) bool {
+		if prev != nil && bytes.Compare(prev, key) >= 0 {
+			t.Fatalf("Scan visited %s after %s", key, prev)
+		}
+		prev, count = key, count+1
+		return true
+	}))
+	if count != n {
+		t.Fatalf("Scan visited %d keys, want %d", count, n)
+	}
+
+	// a range starts at the first key at or after its start
+	var got []string
+	mustScan(t, reopened.ScanFrom([]byte("key-0419"), func(key []byte, _ RecordID) bool {
+		if bytes.Compare(key, []byte("key-04200")) > 0 {
+			return false
+		}
+		got = append(got, string(key))
+		return true
+	}))
+	if len(got) != 11 || got[0] != "key-04190" || got[10] != "key-04200" {
+		t.Fatalf("range from key-0419 = %v", got)
+	}
+}
+
+func TestBTreeDuplicateKeys(t *testing.T) {
+	_, tree := newTestBTree(t)
+	key := []byte("same key for every record")
+	const n = 1000
+	for i := 0; i < n; i++ {
+		for _, k := range [][]byte{key, []byte("other")} {
+			if err := tree.Insert(k, RecordID{PageID: PageID(i + 10), Slot: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checkBTree(t, tree)
+	rids, err := tree.Lookup(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rids) != n {
+		t.Fatalf("Lookup returned %d records, want %d", len(rids), n)
+	}
+	if err := tree.Insert(key, RecordID{PageID: 10, Slot: 1}); err == nil {
+		t.Fatal("inserting the same key and record twice should fail")
+	}
+}
+
+func TestBTreeDelete(t *testing.T) {
+	_, tree := newTestBTree(t)
+	const n = 2000
+	for i := 0; i < n; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("k%d", i%50)), RecordID{PageID: PageID(i + 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < n; i += 2 {
+		if err := tree.Delete([]byte(fmt.Sprintf("k%d", i%50)), RecordID{PageID: PageID(i + 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Delete([]byte("k0"), RecordID{PageID: 10}); err == nil {
+		t.Fatal("deleting a missing entry should fail")
+	}
+	checkBTree(t, tree)
+
+	rids, err := tree.Lookup([]byte("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rids) != n/50 {
+		t.Fatalf("k1 has %d records, want %d", len(rids), n/50)
+	}
+	if rids, _ := tree.Lookup([]byte("k2")); len(rids) != 0 {
+		t.Fatalf("k2 should be gone, found %v", rids)
+	}
+}
+
+func TestBTreeKeySize(t *testing.T) {
+	_, tree := newTestBTree(t)
+	// the largest keys still split into halves that fit
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("%03d%s", i, strings.Repeat("x", BTREE_MAX_KEY_SIZE-3)))
+		if err := tree.Insert(key, RecordID{PageID: PageID(i + 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, tree)
+	if err := tree.Insert(make([]byte, BTREE_MAX_KEY_SIZE+1), RecordID{PageID: 10}); err == nil {
+		t.Fatal("a key over BTREE_MAX_KEY_SIZE should be rejected")
+	}
+}
+
+func TestBTreeFree(t *testing.T) {
+	pager, tree := newTestBTree(t)
+	for i := 0; i < 3000; i++ {
+		if err := tree.Insert([]byte(fmt.Sprintf("key-%05d", i)), RecordID{PageID: PageID(i + 10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages, problems := tree.Check()
+	if len(problems) > 0 {
+		t.Fatal(problems)
+	}
+	if err := tree.Free(); err != nil {
+		t.Fatal(err)
+	}
+	free, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != len(pages) {
+		t.Fatalf("%d pages on the free list, the tree had %d", len(free), len(pages))
+	}
+}
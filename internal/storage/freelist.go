@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Page 0 is the meta page, it tracks the free list:
+Bytes 0-4: Magic "PSDB"
+Bytes 4-8: Checksum (uint32)
+Bytes 8-16: FreeListHead (uint64) - first free page, 0 if there are none
+Bytes 16-20: NumFreePages (uint32)
+
+Free pages are chained together through their NextPage header field.
+*/
+const (
+	META_PAGE  PageID = 0
+	META_MAGIC        = "PSDB"
+)
+
+// InitMetaPage formats the meta page of a new, empty file
+func (p *Pager) InitMetaPage() error {
+	page := make([]byte, PAGE_SIZE)
+	copy(page[0:4], META_MAGIC)
+	_, err := p.WritePage(META_PAGE, page)
+	return err
+}
+
+func (p *Pager) readMetaPage() ([]byte, error) {
+	page, err := p.ReadPage(META_PAGE)
+	if err != nil {
+		return nil, err
+	}
+	if string(page[0:4]) != META_MAGIC {
+		return nil, fmt.Errorf("meta page: bad magic %q", page[0:4])
+	}
+	return page, nil
+}
+
+/*
+AllocatePage hands out a page for a new chain member.
+Pages on the free list are reused first, otherwise the file grows by one page.
+The page is written out blank so it is reserved even before the caller fills it in.
+*/
+func (p *Pager) AllocatePage() (PageID, error) {
+	meta, err := p.readMetaPage()
+	if err != nil {
+		return 0, err
+	}
+
+	id := PageID(binary.LittleEndian.Uint64(meta[8:16]))
+	if id == 0 {
+		id = p.NextPageID()
+	} else {
+		free, err := p.ReadPage(id)
+		if err != nil {
+			return 0, err
+		}
+		numFree := binary.LittleEndian.Uint32(meta[16:20])
+		binary.LittleEndian.PutUint64(meta[8:16], uint64(NextPage(free)))
+		binary.LittleEndian.PutUint32(meta[16:20], numFree-1)
+		if _, err := p.WritePage(META_PAGE, meta); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := p.WritePage(id, make([]byte, PAGE_SIZE)); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// FreePage returns a page to the allocator by pushing it onto the free list
+func (p *Pager) FreePage(id PageID) error {
+	if id == META_PAGE {
+		return fmt.Errorf("cannot free the meta page")
+	}
+	meta, err := p.readMetaPage()
+	if err != nil {
+		return err
+	}
+
+	page := make([]byte, PAGE_SIZE)
+	SetNextPage(page, PageID(binary.LittleEndian.Uint64(meta[8:16])))
+	if _, err := p.WritePage(id, page); err != nil {
+		return err
+	}
+
+	numFree := binary.LittleEndian.Uint32(meta[16:20])
+	binary.LittleEndian.PutUint64(meta[8:16], uint64(id))
+	binary.LittleEndian.PutUint32(meta[16:20], numFree+1)
+	_, err = p.WritePage(META_PAGE, meta)
+	return err
+}
+
+// FreeList walks the free list, it is used by the integrity checker.
+// It stops with an error on a cycle or on a page that can't be read.
+func (p *Pager) FreeList() ([]PageID, error) {
+	meta, err := p.readMetaPage()
+	if err != nil {
+		return nil, err
+	}
+
+	numFree := binary.LittleEndian.Uint32(meta[16:20])
+	fileEnd := p.NextPageID()
+	var pages []PageID
+	seen := make(map[PageID]bool)
+	for id := PageID(binary.LittleEndian.Uint64(meta[8:16])); id != 0; {
+		if id >= fileEnd {
+			return pages, fmt.Errorf("free list: page %d is past the end of the file", id)
+		}
+		if seen[id] {
+			return pages, fmt.Errorf("free list: cycle at page %d", id)
+		}
+		seen[id] = true
+		pages = append(pages, id)
+
+		page, err := p.ReadPage(id)
+		if err != nil {
+			return pages, fmt.Errorf("free list: %w", err)
+		}
+		id = NextPage(page)
+	}
+
+	if uint32(len(pages)) != numFree {
+		return pages, fmt.Errorf("free list: meta page counts %d free pages, found %d", numFree, len(pages))
+	}
+	return pages, nil
+}
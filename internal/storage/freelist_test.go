@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFreeListReuse(t *testing.T) {
+	pager := newTestPager(t)
+	var ids []PageID
+	for i := 0; i < 4; i++ {
+		id, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	end := pager.NextPageID()
+
+	for _, id := range ids[1:3] {
+		if err := pager.FreePage(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	free, err := pager.FreeList()
+	if err != nil {
+		t.Fatalf("FreeList: %v", err)
+	}
+	// the free list is a stack, the page freed last is handed out first
+	if want := []PageID{ids[2], ids[1]}; !slices.Equal(free, want) {
+		t.Fatalf("free list = %v, want %v", free, want)
+	}
+
+	for _, want := range []PageID{ids[2], ids[1], end} {
+		got, err := pager.AllocatePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("AllocatePage = %d, want %d", got, want)
+		}
+	}
+	if free, err := pager.FreeList(); err != nil || len(free) != 0 {
+		t.Fatalf("free list after reuse = %v, %v; want it empty", free, err)
+	}
+}
+
+func TestFreeMetaPage(t *testing.T) {
+	pager := newTestPager(t)
+	if err := pager.FreePage(META_PAGE); err == nil {
+		t.Fatal("freeing the meta page should fail")
+	}
+}
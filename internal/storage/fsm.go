@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+Every heap has a free space map (FSM): a chain of pages listing each heap page
+together with a bucket for how much room it has left, free bytes / FSM_BUCKET_SIZE
+rounded down. Rounding down means a page whose bucket covers a record always
+has room for it. The FSM lists pages in heap chain order, so it also serves as
+the heap's page directory and opening a heap doesn't have to walk the chain.
+
+FSM page layout:
++----------------+
+| Header (16B)   |  <- NumCells = entries on this page, NextPage links the FSM chain
++----------------+
+| PageID (8B)    |
+| Bucket (1B)    |  <- one entry per heap page
+| ...            |
++----------------+
+*/
+const (
+	FSM_BUCKET_SIZE      = 16
+	FSM_ENTRY_SIZE       = 9
+	FSM_ENTRIES_PER_PAGE = (PAGE_SIZE - PAGE_HEADER_SIZE) / FSM_ENTRY_SIZE
+)
+
+// FreeBucket is the FSM bucket for a page's current free space
+func FreeBucket(page []byte) uint8 {
+	return uint8(min(FreeSpace(page)/FSM_BUCKET_SIZE, 255))
+}
+
+// ReadFSM loads a free space map, returning the heap pages it lists, their buckets and the FSM's own pages
+func ReadFSM(pager *Pager, start PageID) ([]PageID, []uint8, []PageID, error) {
+	fsmPages, err := WalkChain(pager, start)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("free space map: %w", err)
+	}
+
+	var pages []PageID
+	var free []uint8
+	for _, id := range fsmPages {
+		page, err := pager.ReadPage(id)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		n := int(binary.LittleEndian.Uint16(page[0:2]))
+		if n > FSM_ENTRIES_PER_PAGE {
+			return nil, nil, nil, fmt.Errorf("free space map page %d: %d entries, at most %d fit", id, n, FSM_ENTRIES_PER_PAGE)
+		}
+		for i := 0; i < n; i++ {
+			off := PAGE_HEADER_SIZE + i*FSM_ENTRY_SIZE
+			pages = append(pages, PageID(binary.LittleEndian.Uint64(page[off:off+8])))
+			free = append(free, page[off+8])
+		}
+	}
+	return pages, free, fsmPages, nil
+}
+
+// writeFSMPage rewrites the k-th FSM page from the in-memory map
+func (h *Heap) writeFSMPage(k int) error {
+	page := make([]byte, PAGE_SIZE)
+	first := k * FSM_ENTRIES_PER_PAGE
+	last := min(first+FSM_ENTRIES_PER_PAGE, len(h.pages))
+	for i := first; i < last; i++ {
+		off := PAGE_HEADER_SIZE + (i-first)*FSM_ENTRY_SIZE
+		binary.LittleEndian.PutUint64(page[off:off+8], uint64(h.pages[i]))
+		page[off+8] = h.free[i]
+	}
+	binary.LittleEndian.PutUint16(page[0:2], uint16(last-first))
+	if k+1 < len(h.fsmPages) {
+		SetNextPage(page, h.fsmPages[k+1])
+	}
+	_, err := h.pager.WritePage(h.fsmPages[k], page)
+	return err
+}
+
+/*
+saveFSM persists the map from entry `from` onwards, after pages were added to
+or removed from the heap. FSM pages are allocated or freed so the chain is
+exactly as long as it needs to be (the first one is always kept).
+*/
+func (h *Heap) saveFSM(from int) error {
+	needed := max(1, (len(h.pages)+FSM_ENTRIES_PER_PAGE-1)/FSM_ENTRIES_PER_PAGE)
+
+	firstDirty := from / FSM_ENTRIES_PER_PAGE
+	for len(h.fsmPages) < needed {
+		id, err := h.pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+		h.fsmPages = append(h.fsmPages, id)
+		// the previous page has to be rewritten to link to the new one
+		firstDirty = min(firstDirty, len(h.fsmPages)-2)
+	}
+	for len(h.fsmPages) > needed {
+		last := h.fsmPages[len(h.fsmPages)-1]
+		if err := h.pager.FreePage(last); err != nil {
+			return err
+		}
+		h.fsmPages = h.fsmPages[:len(h.fsmPages)-1]
+		firstDirty = min(firstDirty, len(h.fsmPages)-1)
+	}
+
+	for k := firstDirty; k < len(h.fsmPages); k++ {
+		if err := h.writeFSMPage(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateFree refreshes the bucket of the i-th heap page after it changed
+func (h *Heap) updateFree(i int, page []byte) error {
+	bucket := FreeBucket(page)
+	if h.free[i] == bucket {
+		return nil
+	}
+	h.free[i] = bucket
+	return h.writeFSMPage(i / FSM_ENTRIES_PER_PAGE)
+}
+
+// pageIndex finds a page's position in the heap
+func (h *Heap) pageIndex(id PageID) int {
+	for i, p := range h.pages {
+		if p == id {
+			return i
+		}
+	}
+	return -1
+}
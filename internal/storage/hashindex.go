@@ -0,0 +1,498 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+/*
+HashIndex is a disk based extendible hash index mapping encoded keys to RecordIDs.
+
+The directory holds 2^GlobalDepth bucket page IDs and is indexed by the low
+GlobalDepth bits of a key's hash. It is stored as a chain of directory pages
+starting at the index's root page:
++----------------+
+| Header (16B)   |  <- NumCells = directory entries on this page, NextPage links the chain
++----------------+
+| BucketID (8B)  |
+| ...            |  <- one per directory slot
++----------------+
+The global depth isn't stored, it is log2 of the number of directory entries.
+
+Buckets are slotted pages (same layout as heap pages) whose cells are
+| hash (u64) | page (u64) | slot (u16) | key bytes |
+Several directory slots may share a bucket; a bucket's local depth is
+GlobalDepth - log2(number of slots pointing at it). A full bucket is split on
+the next hash bit, doubling the directory when its local depth has caught up
+with the global depth. When every entry in a full bucket has the same hash
+(lots of duplicate keys) splitting can't help, so an overflow page is chained
+onto the bucket through NextPage instead.
+*/
+type HashIndex struct {
+	pager    *Pager
+	root     PageID
+	dirPages []PageID
+	dir      []PageID // bucket page for each directory slot, loaded on first use
+}
+
+const (
+	HASH_DIR_ENTRIES_PER_PAGE = (PAGE_SIZE - PAGE_HEADER_SIZE) / 8
+	HASH_ENTRY_HEADER_SIZE    = 18
+	// with 2^20 directory slots the directory alone is 8MB, past that buckets only overflow
+	HASH_MAX_DEPTH = 20
+	// an entry has to fit on an empty bucket page
+	HASH_MAX_KEY_SIZE = PAGE_SIZE - PAGE_HEADER_SIZE - SLOT_SIZE - HASH_ENTRY_HEADER_SIZE
+)
+
+// CreateHashIndex allocates an empty index: a one slot directory pointing at one empty bucket
+func CreateHashIndex(pager *Pager) (*HashIndex, error) {
+	root, err := pager.AllocatePage()
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := newBucketPage(pager)
+	if err != nil {
+		return nil, err
+	}
+	ix := &HashIndex{pager: pager, root: root, dirPages: []PageID{root}, dir: []PageID{bucket}}
+	if err := ix.saveDirectory(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// OpenHashIndex opens an existing index whose directory starts at root
+func OpenHashIndex(pager *Pager, root PageID) *HashIndex {
+	return &HashIndex{pager: pager, root: root}
+}
+
+func (ix *HashIndex) Root() PageID {
+	return ix.root
+}
+
+func newBucketPage(pager *Pager) (PageID, error) {
+	id, err := pager.AllocatePage()
+	if err != nil {
+		return 0, err
+	}
+	page := make([]byte, PAGE_SIZE)
+	initializePage(page)
+	_, err = pager.WritePage(id, page)
+	return id, err
+}
+
+func hashKey(key []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return h.Sum64()
+}
+
+func (ix *HashIndex) load() error {
+	if ix.dir != nil {
+		return nil
+	}
+	dirPages, err := WalkChain(ix.pager, ix.root)
+	if err != nil {
+		return fmt.Errorf("hash index directory: %w", err)
+	}
+	var dir []PageID
+	for _, id := range dirPages {
+		page, err := ix.pager.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		n := int(binary.LittleEndian.Uint16(page[0:2]))
+		if n > HASH_DIR_ENTRIES_PER_PAGE {
+			return fmt.Errorf("hash index directory page %d: %d entries, at most %d fit", id, n, HASH_DIR_ENTRIES_PER_PAGE)
+		}
+		for i := 0; i < n; i++ {
+			off := PAGE_HEADER_SIZE + i*8
+			dir = append(dir, PageID(binary.LittleEndian.Uint64(page[off:off+8])))
+		}
+	}
+	if len(dir) == 0 || len(dir)&(len(dir)-1) != 0 {
+		return fmt.Errorf("hash index %d: directory size %d is not a power of two", ix.root, len(dir))
+	}
+	ix.dirPages, ix.dir = dirPages, dir
+	return nil
+}
+
+// saveDirectory writes the directory out, allocating directory pages as it grows
+func (ix *HashIndex) saveDirectory() error {
+	needed := (len(ix.dir) + HASH_DIR_ENTRIES_PER_PAGE - 1) / HASH_DIR_ENTRIES_PER_PAGE
+	for len(ix.dirPages) < needed {
+		id, err := ix.pager.AllocatePage()
+		if err != nil {
+			return err
+		}
+		ix.dirPages = append(ix.dirPages, id)
+	}
+
+	for k, id := range ix.dirPages {
+		page := make([]byte, PAGE_SIZE)
+		first := k * HASH_DIR_ENTRIES_PER_PAGE
+		last := min(first+HASH_DIR_ENTRIES_PER_PAGE, len(ix.dir))
+		for i := first; i < last; i++ {
+			off := PAGE_HEADER_SIZE + (i-first)*8
+			binary.LittleEndian.PutUint64(page[off:off+8], uint64(ix.dir[i]))
+		}
+		binary.LittleEndian.PutUint16(page[0:2], uint16(last-first))
+		if k+1 < len(ix.dirPages) {
+			SetNextPage(page, ix.dirPages[k+1])
+		}
+		if _, err := ix.pager.WritePage(id, page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ix *HashIndex) globalDepth() uint {
+	depth := uint(0)
+	for 1<<depth < len(ix.dir) {
+		depth++
+	}
+	return depth
+}
+
+// localDepth works out a bucket's depth from how many directory slots share it
+func (ix *HashIndex) localDepth(bucket PageID) uint {
+	shared := 0
+	for _, b := range ix.dir {
+		if b == bucket {
+			shared++
+		}
+	}
+	depth := ix.globalDepth()
+	for shared > 1 {
+		shared >>= 1
+		depth--
+	}
+	return depth
+}
+
+func (ix *HashIndex) bucketFor(hash uint64) PageID {
+	return ix.dir[hash&uint64(len(ix.dir)-1)]
+}
+
+type hashEntry struct {
+	hash uint64
+	rid  RecordID
+	key  []byte
+}
+
+func encodeHashEntry(e hashEntry) []byte {
+	buff := make([]byte, HASH_ENTRY_HEADER_SIZE+len(e.key))
+	binary.LittleEndian.PutUint64(buff[0:8], e.hash)
+	binary.LittleEndian.PutUint64(buff[8:16], uint64(e.rid.PageID))
+	binary.LittleEndian.PutUint16(buff[16:18], e.rid.Slot)
+	copy(buff[HASH_ENTRY_HEADER_SIZE:], e.key)
+	return buff
+}
+
+func decodeHashEntry(data []byte) (hashEntry, error) {
+	if len(data) < HASH_ENTRY_HEADER_SIZE {
+		return hashEntry{}, fmt.Errorf("hash index entry of %d bytes is too short", len(data))
+	}
+	return hashEntry{
+		hash: binary.LittleEndian.Uint64(data[0:8]),
+		rid: RecordID{
+			PageID: PageID(binary.LittleEndian.Uint64(data[8:16])),
+			Slot:   binary.LittleEndian.Uint16(data[16:18]),
+		},
+		key: data[HASH_ENTRY_HEADER_SIZE:],
+	}, nil
+}
+
+// scanBucket visits every entry in a bucket and its overflow pages
+func (ix *HashIndex) scanBucket(bucket PageID, cb func(id PageID, page []byte, slot uint16, e hashEntry) bool) error {
+	for id := bucket; id != 0; {
+		page, err := ix.pager.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		if problems := CheckPage(page); len(problems) > 0 {
+			return fmt.Errorf("hash bucket page %d: %s", id, problems[0])
+		}
+		for _, slot := range LiveSlots(page) {
+			e, err := decodeHashEntry(ReadRecord(page, slot))
+			if err != nil {
+				return fmt.Errorf("hash bucket page %d slot %d: %w", id, slot, err)
+			}
+			if !cb(id, page, slot, e) {
+				return nil
+			}
+		}
+		id = NextPage(page)
+	}
+	return nil
+}
+
+// Lookup returns the RecordIDs stored under key
+func (ix *HashIndex) Lookup(key []byte) ([]RecordID, error) {
+	if err := ix.load(); err != nil {
+		return nil, err
+	}
+	hash := hashKey(key)
+	var rids []RecordID
+	err := ix.scanBucket(ix.bucketFor(hash), func(_ PageID, _ []byte, _ uint16, e hashEntry) bool {
+		if e.hash == hash && bytes.Equal(e.key, key) {
+			rids = append(rids, e.rid)
+		}
+		return true
+	})
+	return rids, err
+}
+
+func (ix *HashIndex) Insert(key []byte, rid RecordID) error {
+	if err := ix.load(); err != nil {
+		return err
+	}
+	e := hashEntry{hash: hashKey(key), rid: rid, key: key}
+	data := encodeHashEntry(e)
+	if len(key) > HASH_MAX_KEY_SIZE {
+		return fmt.Errorf("index key of %d bytes is too large", len(key))
+	}
+
+	for {
+		bucket := ix.bucketFor(e.hash)
+		ok, err := ix.insertIntoBucket(bucket, data, false)
+		if err != nil || ok {
+			return err
+		}
+
+		// the bucket is full, split it unless that can't separate anything
+		splittable := false
+		err = ix.scanBucket(bucket, func(_ PageID, _ []byte, _ uint16, other hashEntry) bool {
+			splittable = other.hash != e.hash
+			return !splittable
+		})
+		if err != nil {
+			return err
+		}
+		if !splittable || ix.localDepth(bucket) >= HASH_MAX_DEPTH {
+			_, err := ix.insertIntoBucket(bucket, data, true)
+			return err
+		}
+		if err := ix.split(bucket); err != nil {
+			return err
+		}
+	}
+}
+
+// insertIntoBucket tries each page of a bucket's chain, chaining on an overflow page if allowed
+func (ix *HashIndex) insertIntoBucket(bucket PageID, data []byte, overflow bool) (bool, error) {
+	for id := bucket; ; {
+		page, err := ix.pager.ReadPage(id)
+		if err != nil {
+			return false, err
+		}
+		if _, ok := insertIntoPage(page, data); ok {
+			_, err := ix.pager.WritePage(id, page)
+			return true, err
+		}
+		next := NextPage(page)
+		if next != 0 {
+			id = next
+			continue
+		}
+		if !overflow {
+			return false, nil
+		}
+
+		newID, err := newBucketPage(ix.pager)
+		if err != nil {
+			return false, err
+		}
+		SetNextPage(page, newID)
+		if _, err := ix.pager.WritePage(id, page); err != nil {
+			return false, err
+		}
+		id = newID
+	}
+}
+
+/*
+split() divides a bucket on its next hash bit: the directory doubles first if
+the bucket is already at the global depth, the slots whose bit is set are
+pointed at a new bucket, and every entry is redistributed between the two.
+*/
+func (ix *HashIndex) split(bucket PageID) error {
+	local := ix.localDepth(bucket)
+	if local == ix.globalDepth() {
+		ix.dir = append(ix.dir, ix.dir...)
+	}
+
+	newBucket, err := newBucketPage(ix.pager)
+	if err != nil {
+		return err
+	}
+	bit := uint64(1) << local
+	for i := range ix.dir {
+		if ix.dir[i] == bucket && uint64(i)&bit != 0 {
+			ix.dir[i] = newBucket
+		}
+	}
+
+	// pull everything out of the old bucket, releasing its overflow pages
+	var entries [][]byte
+	var overflowPages []PageID
+	err = ix.scanBucket(bucket, func(id PageID, _ []byte, _ uint16, e hashEntry) bool {
+		entries = append(entries, encodeHashEntry(e))
+		if id != bucket && (len(overflowPages) == 0 || overflowPages[len(overflowPages)-1] != id) {
+			overflowPages = append(overflowPages, id)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	page := make([]byte, PAGE_SIZE)
+	initializePage(page)
+	if _, err := ix.pager.WritePage(bucket, page); err != nil {
+		return err
+	}
+	for _, id := range overflowPages {
+		if err := ix.pager.FreePage(id); err != nil {
+			return err
+		}
+	}
+
+	for _, data := range entries {
+		target := bucket
+		if binary.LittleEndian.Uint64(data[0:8])&bit != 0 {
+			target = newBucket
+		}
+		if _, err := ix.insertIntoBucket(target, data, true); err != nil {
+			return err
+		}
+	}
+	return ix.saveDirectory()
+}
+
+// Delete removes the entry for key pointing at rid
+func (ix *HashIndex) Delete(key []byte, rid RecordID) error {
+	if err := ix.load(); err != nil {
+		return err
+	}
+	hash := hashKey(key)
+	var (
+		found     bool
+		foundID   PageID
+		foundPage []byte
+		foundSlot uint16
+	)
+	err := ix.scanBucket(ix.bucketFor(hash), func(id PageID, page []byte, slot uint16, e hashEntry) bool {
+		if e.hash == hash && e.rid == rid && bytes.Equal(e.key, key) {
+			found, foundID, foundPage, foundSlot = true, id, page, slot
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("hash index %d has no entry for record %v", ix.root, rid)
+	}
+	freeSlot(foundPage, foundSlot)
+	_, err = ix.pager.WritePage(foundID, foundPage)
+	return err
+}
+
+// Scan visits every (key, RecordID) pair in the index, bucket by bucket
+func (ix *HashIndex) Scan(cb func(key []byte, rid RecordID) bool) error {
+	if err := ix.load(); err != nil {
+		return err
+	}
+	stop := false
+	visited := make(map[PageID]bool)
+	for _, bucket := range ix.dir {
+		// several slots can share a bucket, only visit it once
+		if visited[bucket] {
+			continue
+		}
+		visited[bucket] = true
+		err := ix.scanBucket(bucket, func(_ PageID, _ []byte, _ uint16, e hashEntry) bool {
+			stop = !cb(e.key, e.rid)
+			return !stop
+		})
+		if err != nil || stop {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Check validates the index structure for the integrity checker: the directory
+size, and that every entry's key hashes to the bucket holding it. It returns
+every page the index uses (directory, buckets and overflow pages) along with
+the problems found.
+*/
+func (ix *HashIndex) Check() ([]PageID, []string) {
+	var problems []string
+	dirPages, err := WalkChain(ix.pager, ix.root)
+	if err != nil {
+		return dirPages, []string{err.Error()}
+	}
+	if err := ix.load(); err != nil {
+		return dirPages, []string{err.Error()}
+	}
+
+	shared := make(map[PageID]int)
+	for _, bucket := range ix.dir {
+		shared[bucket]++
+	}
+
+	pages := append([]PageID(nil), dirPages...)
+	visited := make(map[PageID]bool)
+	for i, bucket := range ix.dir {
+		if visited[bucket] {
+			continue
+		}
+		visited[bucket] = true
+		chain, err := WalkChain(ix.pager, bucket)
+		pages = append(pages, chain...)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("bucket %d: %v", bucket, err))
+			continue
+		}
+
+		// shared slots are 2^(global - local) apart, so the bucket's first slot fixes its low bits
+		local := ix.globalDepth()
+		for n := shared[bucket]; n > 1; n >>= 1 {
+			local--
+		}
+		mask := uint64(1)<<local - 1
+		err = ix.scanBucket(bucket, func(id PageID, _ []byte, slot uint16, e hashEntry) bool {
+			if e.hash != hashKey(e.key) {
+				problems = append(problems, fmt.Sprintf("bucket page %d slot %d: stored hash doesn't match key", id, slot))
+			} else if e.hash&mask != uint64(i)&mask {
+				problems = append(problems, fmt.Sprintf("bucket page %d slot %d: key hashes to a different bucket", id, slot))
+			}
+			return true
+		})
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	return pages, problems
+}
+
+// Free returns every page of the index to the allocator
+func (ix *HashIndex) Free() error {
+	pages, problems := ix.Check()
+	if len(problems) > 0 {
+		return fmt.Errorf("hash index %d: %s", ix.root, problems[0])
+	}
+	for _, id := range pages {
+		if err := ix.pager.FreePage(id); err != nil {
+			return err
+		}
+	}
+	ix.dir, ix.dirPages = nil, nil
+	return nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTestIndex(t *testing.T) (*Pager, *HashIndex) {
+	t.Helper()
+	pager := newTestPager(t)
+	ix, err := CreateHashIndex(pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pager, ix
+}
+
+func checkIndex(t *testing.T, ix *HashIndex) {
+	t.Helper()
+	if _, problems := ix.Check(); len(problems) > 0 {
+		t.Fatalf("index check: %v", problems)
+	}
+}
+
+func TestHashIndexSplitsAndDoublesDirectory(t *testing.T) {
+	pager, ix := newTestIndex(t)
+	const n = 3000
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		if err := ix.Insert(key, RecordID{PageID: PageID(i + 10), Slot: uint16(i % 7)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if depth := ix.globalDepth(); depth < 2 {
+		t.Fatalf("global depth %d after %d inserts, the directory should have doubled", depth, n)
+	}
+	checkIndex(t, ix)
+
+	// everything is found again through a freshly opened index
+	reopened := OpenHashIndex(pager, ix.Root())
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("key-%05d", i))
+		rids, err := reopened.Lookup(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := RecordID{PageID: PageID(i + 10), Slot: uint16(i % 7)}
+		if len(rids) != 1 || rids[0] != want {
+			t.Fatalf("Lookup(%s) = %v, want [%v]", key, rids, want)
+		}
+	}
+	if rids, _ := reopened.Lookup([]byte("missing")); len(rids) != 0 {
+		t.Fatalf("Lookup of a missing key = %v", rids)
+	}
+}
+
+func TestHashIndexOverflowsOnDuplicateKeys(t *testing.T) {
+	_, ix := newTestIndex(t)
+	key := []byte("same key for every record")
+	const n = 600
+	for i := 0; i < n; i++ {
+		if err := ix.Insert(key, RecordID{PageID: PageID(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// entries with one hash can't be split apart, so the bucket chains overflow pages
+	if depth := ix.globalDepth(); depth != 0 {
+		t.Fatalf("global depth %d, duplicate keys shouldn't split the directory", depth)
+	}
+	chain, err := WalkChain(ix.pager, ix.dir[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) < 2 {
+		t.Fatalf("bucket chain has %d pages, want overflow pages", len(chain))
+	}
+	checkIndex(t, ix)
+
+	rids, err := ix.Lookup(key)
+	if err != nil || len(rids) != n {
+		t.Fatalf("Lookup found %d records (%v), want %d", len(rids), err, n)
+	}
+}
+
+func TestHashIndexDelete(t *testing.T) {
+	_, ix := newTestIndex(t)
+	for i := 0; i < 500; i++ {
+		key := []byte(fmt.Sprintf("k%d", i%50))
+		if err := ix.Insert(key, RecordID{PageID: PageID(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ix.Delete([]byte("k3"), RecordID{PageID: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Delete([]byte("k3"), RecordID{PageID: 4}); err == nil {
+		t.Fatal("deleting the same entry twice should fail")
+	}
+	rids, err := ix.Lookup([]byte("k3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rids) != 9 {
+		t.Fatalf("Lookup found %d records, want 9", len(rids))
+	}
+	for _, rid := range rids {
+		if rid.PageID == 4 {
+			t.Fatal("deleted entry still found")
+		}
+	}
+	checkIndex(t, ix)
+}
+
+func TestHashIndexFree(t *testing.T) {
+	pager, ix := newTestIndex(t)
+	for i := 0; i < 2000; i++ {
+		if err := ix.Insert([]byte(fmt.Sprint(i)), RecordID{PageID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages, _ := ix.Check()
+	if err := ix.Free(); err != nil {
+		t.Fatal(err)
+	}
+	free, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != len(pages) {
+		t.Fatalf("%d pages on the free list, the index used %d", len(free), len(pages))
+	}
+}
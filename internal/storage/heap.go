@@ -3,30 +3,97 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
 
 	"github.com/mbeka02/pesapal_challenge/internal/types"
 )
 
 const (
-	PAGE_HEADER_SIZE = 4
+	PAGE_HEADER_SIZE = 16
 	SLOT_SIZE        = 4
 )
 
+/*
+A heap is a chain of slotted pages linked through the NextPage header field,
+plus a free space map (see fsm.go) that inserts use to find a page with room.
+The first page of each is allocated when the heap is created and never
+changes, so the catalog only needs to remember those two. Records are
+addressed by RecordID, which stays stable until the record is deleted.
+*/
 type Heap struct {
 	pager          *Pager
 	startPage      PageID
-	numPages       uint32
+	fsmStart       PageID
+	pages          []PageID // the page chain, loaded from the FSM on first use
+	free           []uint8  // FSM bucket of each page in pages
+	fsmPages       []PageID
 	growthCallback func(uint32)
 }
 
-func NewHeap(pager *Pager, start PageID) *Heap {
-	return &Heap{pager: pager, startPage: start, numPages: 0}
+type RecordID struct {
+	PageID PageID
+	Slot   uint16
+}
+
+// NewHeap opens an existing heap whose first page is start and whose free space map starts at fsm
+func NewHeap(pager *Pager, start PageID, fsm PageID) *Heap {
+	return &Heap{pager: pager, startPage: start, fsmStart: fsm}
+}
+
+// CreateHeap allocates and formats the first page and the free space map of a new, empty heap
+func CreateHeap(pager *Pager) (*Heap, error) {
+	start, err := pager.AllocatePage()
+	if err != nil {
+		return nil, err
+	}
+	fsm, err := pager.AllocatePage()
+	if err != nil {
+		return nil, err
+	}
+
+	page := make([]byte, PAGE_SIZE)
+	initializePage(page)
+	if _, err := pager.WritePage(start, page); err != nil {
+		return nil, err
+	}
+
+	h := &Heap{
+		pager:     pager,
+		startPage: start,
+		fsmStart:  fsm,
+		pages:     []PageID{start},
+		free:      []uint8{FreeBucket(page)},
+		fsmPages:  []PageID{fsm},
+	}
+	if err := h.writeFSMPage(0); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
+/*
+A row is encoded as a NULL bitmap followed by the values of its non-NULL columns:
+| null bitmap (one bit per column, set = NULL) | values... |
+INT, FLOAT and TIMESTAMP take 8 bytes, DATE 4, BOOLEAN 1, INTERVAL 16
+(months i32, days i32, micros i64) and TEXT, CHAR (padding included), JSON
+(compact text) and BLOB an i32 length plus their bytes.
+DECIMAL is | scale (i16) | sign (i8) | len (u8) | big-endian magnitude |.
+*/
 func EncodeRow(row types.Row) []byte {
 	buff := new(bytes.Buffer)
+	nulls := make([]byte, nullBitmapSize(len(row)))
+	for i, value := range row {
+		if value == nil {
+			nulls[i/8] |= 1 << (i % 8)
+		}
+	}
+	buff.Write(nulls)
+
 	for _, value := range row {
 		switch t := value.(type) {
+		case nil:
 		case int:
 			binary.Write(buff, binary.LittleEndian, int64(t))
 		case float64:
@@ -41,6 +108,29 @@ func EncodeRow(row types.Row) []byte {
 		case string:
 			binary.Write(buff, binary.LittleEndian, int32(len(t)))
 			buff.Write([]byte(t))
+		case types.Char:
+			binary.Write(buff, binary.LittleEndian, int32(len(t)))
+			buff.Write([]byte(t))
+		case types.JSONValue:
+			binary.Write(buff, binary.LittleEndian, int32(len(t)))
+			buff.Write([]byte(t))
+		case []byte:
+			binary.Write(buff, binary.LittleEndian, int32(len(t)))
+			buff.Write(t)
+		case types.Date:
+			binary.Write(buff, binary.LittleEndian, int32(t))
+		case types.Timestamp:
+			binary.Write(buff, binary.LittleEndian, int64(t))
+		case types.Interval:
+			binary.Write(buff, binary.LittleEndian, t.Months)
+			binary.Write(buff, binary.LittleEndian, t.Days)
+			binary.Write(buff, binary.LittleEndian, t.Micros)
+		case types.Decimal:
+			magnitude := new(big.Int).Abs(t.Unscaled).Bytes()
+			binary.Write(buff, binary.LittleEndian, int16(t.Scale))
+			binary.Write(buff, binary.LittleEndian, int8(t.Unscaled.Sign()))
+			binary.Write(buff, binary.LittleEndian, uint8(len(magnitude)))
+			buff.Write(magnitude)
 		default:
 			panic("unknown type")
 		}
@@ -48,70 +138,292 @@ func EncodeRow(row types.Row) []byte {
 	return buff.Bytes()
 }
 
-func DecodeRow(data []byte, schema []types.Column) types.Row {
-	buff := bytes.NewReader(data)
-	row := make(types.Row, 0)
-	for _, column := range schema {
+func nullBitmapSize(columns int) int {
+	return (columns + 7) / 8
+}
+
+// DecodeRow decodes a record against its schema.
+// Records that are too short, carry trailing bytes or hold invalid values are rejected.
+func DecodeRow(data []byte, schema []types.Column) (types.Row, error) {
+	size := nullBitmapSize(len(schema))
+	if len(data) < size {
+		return nil, fmt.Errorf("record of %d bytes is too short for its null bitmap", len(data))
+	}
+	nulls := data[:size]
+	if len(schema)%8 != 0 && nulls[size-1]>>(len(schema)%8) != 0 {
+		return nil, fmt.Errorf("null bitmap has bits set past the last column")
+	}
+
+	buff := bytes.NewReader(data[size:])
+	row := make(types.Row, 0, len(schema))
+	for i, column := range schema {
+		if nulls[i/8]&(1<<(i%8)) != 0 {
+			row = append(row, nil)
+			continue
+		}
 		switch column.Type {
 		case types.INT:
 			var v int64
-			binary.Read(buff, binary.LittleEndian, &v)
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
 			row = append(row, int(v))
 		case types.FLOAT:
 			var v float64
-			binary.Read(buff, binary.LittleEndian, &v)
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
 			row = append(row, v)
 		case types.BOOLEAN:
 			var v int8
-			binary.Read(buff, binary.LittleEndian, &v)
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			if v != 0 && v != 1 {
+				return nil, fmt.Errorf("column %s: invalid boolean byte %d", column.Name, v)
+			}
 			row = append(row, v == 1)
-		case types.TEXT:
+		case types.TEXT, types.CHAR, types.JSON, types.BLOB:
 			var contentLength int32
-			binary.Read(buff, binary.LittleEndian, &contentLength)
+			if err := binary.Read(buff, binary.LittleEndian, &contentLength); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			if contentLength < 0 || int(contentLength) > buff.Len() {
+				return nil, fmt.Errorf("column %s: %s length %d out of bounds", column.Name, column.Type, contentLength)
+			}
 			b := make([]byte, contentLength)
 			buff.Read(b)
-			row = append(row, string(b))
+			switch column.Type {
+			case types.BLOB:
+				row = append(row, b)
+			case types.CHAR:
+				row = append(row, types.Char(b))
+			case types.JSON:
+				row = append(row, types.JSONValue(b))
+			default:
+				row = append(row, string(b))
+			}
+		case types.DATE:
+			var v int32
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			row = append(row, types.Date(v))
+		case types.TIMESTAMP:
+			var v int64
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			row = append(row, types.Timestamp(v))
+		case types.INTERVAL:
+			var v types.Interval
+			if err := binary.Read(buff, binary.LittleEndian, &v); err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			row = append(row, v)
+		case types.DECIMAL:
+			v, err := decodeDecimal(buff)
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %w", column.Name, err)
+			}
+			row = append(row, v)
+		default:
+			return nil, fmt.Errorf("column %s: unknown data type %d", column.Name, column.Type)
 		}
 	}
-	return row
+	if buff.Len() != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after last column", buff.Len())
+	}
+	return row, nil
+}
+
+func decodeDecimal(buff *bytes.Reader) (types.Decimal, error) {
+	var header struct {
+		Scale  int16
+		Sign   int8
+		Length uint8
+	}
+	if err := binary.Read(buff, binary.LittleEndian, &header); err != nil {
+		return types.Decimal{}, err
+	}
+	if header.Scale < 0 {
+		return types.Decimal{}, fmt.Errorf("negative decimal scale %d", header.Scale)
+	}
+	if header.Sign < -1 || header.Sign > 1 || (header.Sign == 0) != (header.Length == 0) {
+		return types.Decimal{}, fmt.Errorf("invalid decimal sign %d for %d magnitude bytes", header.Sign, header.Length)
+	}
+	if int(header.Length) > buff.Len() {
+		return types.Decimal{}, fmt.Errorf("decimal length %d out of bounds", header.Length)
+	}
+	magnitude := make([]byte, header.Length)
+	buff.Read(magnitude)
+
+	unscaled := new(big.Int).SetBytes(magnitude)
+	if header.Sign < 0 {
+		unscaled.Neg(unscaled)
+	}
+	return types.Decimal{Unscaled: unscaled, Scale: int32(header.Scale)}, nil
 }
 
 /*
-Iterate() scans through all the pages
-For each page, reads the number of cells from the header
-For each cell, reads its slot to get offset and length
-Extracts the data, decodes it using the schema, and calls the callback
+Table records are stamped with the version of the schema they were written
+with, so rows survive the schema changing underneath them:
+| schemaVersion (u16) | row encoded by EncodeRow |
+*/
+const RECORD_HEADER_SIZE = 2
+
+func EncodeRecord(version uint16, row types.Row) []byte {
+	data := EncodeRow(row)
+	record := make([]byte, RECORD_HEADER_SIZE+len(data))
+	binary.LittleEndian.PutUint16(record[0:2], version)
+	copy(record[RECORD_HEADER_SIZE:], data)
+	return record
+}
+
+// SplitRecord returns a record's schema version and its encoded row
+func SplitRecord(record []byte) (uint16, []byte, error) {
+	if len(record) < RECORD_HEADER_SIZE {
+		return 0, nil, fmt.Errorf("record of %d bytes is too short", len(record))
+	}
+	return binary.LittleEndian.Uint16(record[0:2]), record[RECORD_HEADER_SIZE:], nil
+}
+
+// loadPages reads the page directory out of the free space map once and caches it
+func (h *Heap) loadPages() error {
+	if h.pages != nil {
+		return nil
+	}
+	pages, free, fsmPages, err := ReadFSM(h.pager, h.fsmStart)
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 || pages[0] != h.startPage {
+		return fmt.Errorf("free space map %d does not belong to heap %d", h.fsmStart, h.startPage)
+	}
+	h.pages, h.free, h.fsmPages = pages, free, fsmPages
+	return nil
+}
+
+// WalkChain follows NextPage pointers from start and returns every page on the chain
+func WalkChain(pager *Pager, start PageID) ([]PageID, error) {
+	fileEnd := pager.NextPageID()
+	seen := make(map[PageID]bool)
+	var pages []PageID
+	for id := start; id != 0; {
+		if id >= fileEnd {
+			return pages, fmt.Errorf("page chain from %d: page %d is past the end of the file", start, id)
+		}
+		if seen[id] {
+			return pages, fmt.Errorf("page chain from %d: cycle at page %d", start, id)
+		}
+		seen[id] = true
+		pages = append(pages, id)
+
+		page, err := pager.ReadPage(id)
+		if err != nil {
+			return pages, err
+		}
+		id = NextPage(page)
+	}
+	return pages, nil
+}
+
+func (h *Heap) StartPage() PageID {
+	return h.startPage
+}
+
+func (h *Heap) FSMStartPage() PageID {
+	return h.fsmStart
+}
+
+// Pages returns the heap's page chain in order
+func (h *Heap) Pages() ([]PageID, error) {
+	if err := h.loadPages(); err != nil {
+		return nil, err
+	}
+	return append([]PageID(nil), h.pages...), nil
+}
+
+func (h *Heap) readPage(id PageID) ([]byte, error) {
+	page, err := h.pager.ReadPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if problems := CheckPage(page); len(problems) > 0 {
+		return nil, fmt.Errorf("page %d: %s", id, problems[0])
+	}
+	return page, nil
+}
+
+/*
+Scan() walks every live record in chain order, handing the callback the
+record's bytes along with its ID. Deleted slots are skipped.
 Stops early if callback returns false
+A page that fails to read (e.g. a checksum mismatch) aborts the scan with that error
 */
-func (h *Heap) Iterate(schema []types.Column, cb func(types.Row) bool) {
-	// numPages := h.pager.NextPageID()
-	for i := uint32(0); i < h.numPages; i++ {
-		pageID := h.startPage + PageID(i)
-		page, err := h.pager.ReadPage(pageID)
+func (h *Heap) Scan(cb func(RecordID, []byte) bool) error {
+	if err := h.loadPages(); err != nil {
+		return err
+	}
+	// iterate over a copy so callbacks can delete records (and with them pages)
+	pages := append([]PageID(nil), h.pages...)
+	for _, pageID := range pages {
+		page, err := h.readPage(pageID)
 		if err != nil {
-			continue
+			return err
 		}
 		numCells := binary.LittleEndian.Uint16(page[0:2])
 		for cellIdx := uint16(0); cellIdx < numCells; cellIdx++ {
-			slotOffset := PAGE_HEADER_SIZE + cellIdx*SLOT_SIZE
+			if !slotInUse(page, cellIdx) {
+				continue
+			}
+			data, err := h.record(page, cellIdx)
+			if err != nil {
+				return fmt.Errorf("page %d slot %d: %w", pageID, cellIdx, err)
+			}
+			if !cb(RecordID{PageID: pageID, Slot: cellIdx}, data) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
 
-			recordOffset := binary.LittleEndian.Uint16(page[slotOffset : slotOffset+2])
-			recordLen := binary.LittleEndian.Uint16(page[slotOffset+2 : slotOffset+4])
+// Get returns the raw record stored under rid
+func (h *Heap) Get(rid RecordID) ([]byte, error) {
+	page, err := h.readPage(rid.PageID)
+	if err != nil {
+		return nil, err
+	}
+	if !validSlot(page, rid.Slot) {
+		return nil, fmt.Errorf("record %v does not exist", rid)
+	}
+	return h.record(page, rid.Slot)
+}
 
-			recordData := page[recordOffset : recordOffset+recordLen]
+// record returns the record in a slot, reading it back from its overflow pages when it has them
+func (h *Heap) record(page []byte, slot uint16) ([]byte, error) {
+	if !RecordOverflows(page, slot) {
+		return ReadRecord(page, slot), nil
+	}
+	data, _, err := ReadOverflow(h.pager, ReadRecord(page, slot))
+	return data, err
+}
 
-			row := DecodeRow(recordData, schema)
-			if !cb(row) {
-				return
-			}
-		}
+// cellFor returns what a record's slot holds: the record itself, or for one
+// larger than MAX_INLINE_RECORD the stub of the overflow pages it is written to
+func (h *Heap) cellFor(data []byte) ([]byte, bool, error) {
+	if len(data) <= MAX_INLINE_RECORD {
+		return data, false, nil
 	}
+	stub, err := writeOverflow(h.pager, data)
+	return stub, true, err
 }
 
 /*
 My slotted page implementation
 +----------------+
-| Header (4B)    |  <- Fixed size header
+| Header (16B)   |  <- Fixed size header
 +----------------+
 | Slot Array     |  <- Grows downward (4B per slot)
 +----------------+
@@ -122,62 +434,271 @@ My slotted page implementation
 | Data Cells     |  <- Grows upward from end of page
 +----------------+
 */
-func (h *Heap) Insert(data []byte) {
-	// try and insert in the last page first , it might have space
+func (h *Heap) Insert(data []byte) (RecordID, error) {
+	if err := h.loadPages(); err != nil {
+		return RecordID{}, err
+	}
+	cell, overflow, err := h.cellFor(data)
+	if err != nil {
+		return RecordID{}, err
+	}
+	rid, err := h.insertCell(cell, overflow)
+	if err != nil && overflow {
+		// the record never made it to a page, don't leak its overflow pages
+		freeOverflow(h.pager, cell)
+	}
+	return rid, err
+}
 
-	if h.numPages > 0 {
-		lastPage := h.startPage + PageID(h.numPages-1)
-		page, err := h.pager.ReadPage(lastPage)
-		if err == nil && h.insertIntoPage(page, data) {
-			h.pager.WritePage(lastPage, page)
-			return
+// insertCell stores a record's cell on the first page with room for it, adding a page when none has
+func (h *Heap) insertCell(data []byte, overflow bool) (RecordID, error) {
+	// ask the free space map for a page that has room, a free slot would need
+	// less but the slot array might have to grow
+	needed := len(data) + SLOT_SIZE
+	for i, bucket := range h.free {
+		if int(bucket)*FSM_BUCKET_SIZE < needed {
+			continue
+		}
+		page, err := h.readPage(h.pages[i])
+		if err != nil {
+			return RecordID{}, err
+		}
+		slot, ok := insertIntoPage(page, data)
+		if !ok {
+			// the map was out of date, correct it and keep looking
+			if err := h.updateFree(i, page); err != nil {
+				return RecordID{}, err
+			}
+			continue
+		}
+		if overflow {
+			markOverflow(page, slot)
+		}
+		if _, err := h.pager.WritePage(h.pages[i], page); err != nil {
+			return RecordID{}, err
 		}
+		return RecordID{PageID: h.pages[i], Slot: slot}, h.updateFree(i, page)
 	}
+
 	// allocate new page
-	newPageID := h.startPage + PageID(h.numPages)
-	page := make([]byte, PAGE_SIZE)
-	h.initializePage(page)
-	// just panic if the row can't fit
-	if !h.insertIntoPage(page, data) {
-		panic("Row too large for empty page")
+	newPage := make([]byte, PAGE_SIZE)
+	initializePage(newPage)
+	slot, ok := insertIntoPage(newPage, data)
+	if !ok {
+		return RecordID{}, fmt.Errorf("row of %d bytes is too large for an empty page", len(data))
+	}
+	if overflow {
+		markOverflow(newPage, slot)
+	}
+	newPageID, err := h.pager.AllocatePage()
+	if err != nil {
+		return RecordID{}, err
+	}
+	if _, err := h.pager.WritePage(newPageID, newPage); err != nil {
+		return RecordID{}, err
+	}
+
+	// link it onto the end of the chain
+	lastPage := h.pages[len(h.pages)-1]
+	page, err := h.readPage(lastPage)
+	if err != nil {
+		return RecordID{}, err
+	}
+	SetNextPage(page, newPageID)
+	if _, err := h.pager.WritePage(lastPage, page); err != nil {
+		return RecordID{}, err
+	}
+	h.pages = append(h.pages, newPageID)
+	h.free = append(h.free, FreeBucket(newPage))
+	if err := h.saveFSM(len(h.pages) - 1); err != nil {
+		return RecordID{}, err
 	}
-	h.pager.WritePage(newPageID, page)
-	h.numPages++
 
 	// notify catalog of growth
 	if h.growthCallback != nil {
-		h.growthCallback(h.numPages)
+		h.growthCallback(uint32(len(h.pages)))
+	}
+	return RecordID{PageID: newPageID, Slot: slot}, nil
+}
+
+/*
+Delete() marks the record's slot as free (offset 0, length 0).
+The cell bytes become dead space that compactPage reclaims the next time the
+page needs room, and the slot itself is reused by a later insert. Overflow
+pages of the record go straight back to the allocator.
+A page that ends up empty is unlinked from the chain and returned to the
+allocator, unless it is the heap's first page.
+*/
+func (h *Heap) Delete(rid RecordID) error {
+	if err := h.loadPages(); err != nil {
+		return err
+	}
+	idx := h.pageIndex(rid.PageID)
+	if idx < 0 {
+		return fmt.Errorf("page %d is not part of heap %d", rid.PageID, h.startPage)
+	}
+	page, err := h.readPage(rid.PageID)
+	if err != nil {
+		return err
+	}
+	if !validSlot(page, rid.Slot) {
+		return fmt.Errorf("record %v does not exist", rid)
+	}
+	if RecordOverflows(page, rid.Slot) {
+		if err := freeOverflow(h.pager, ReadRecord(page, rid.Slot)); err != nil {
+			return fmt.Errorf("record %v: %w", rid, err)
+		}
+	}
+
+	freeSlot(page, rid.Slot)
+
+	if binary.LittleEndian.Uint16(page[0:2]) == 0 && idx > 0 {
+		return h.unlinkPage(idx, page)
+	}
+	if _, err = h.pager.WritePage(rid.PageID, page); err != nil {
+		return err
 	}
+	return h.updateFree(idx, page)
+}
+
+/*
+Update() replaces a record. The new data is written back into the same slot
+when the page has room for it, so the RecordID usually survives; otherwise the
+record moves and its new ID is returned. Overflow pages are never rewritten in
+place, the new data gets its own and the old ones are freed.
+*/
+func (h *Heap) Update(rid RecordID, data []byte) (RecordID, error) {
+	if err := h.loadPages(); err != nil {
+		return RecordID{}, err
+	}
+	idx := h.pageIndex(rid.PageID)
+	if idx < 0 {
+		return RecordID{}, fmt.Errorf("page %d is not part of heap %d", rid.PageID, h.startPage)
+	}
+	page, err := h.readPage(rid.PageID)
+	if err != nil {
+		return RecordID{}, err
+	}
+	if !validSlot(page, rid.Slot) {
+		return RecordID{}, fmt.Errorf("record %v does not exist", rid)
+	}
+	cell, overflow, err := h.cellFor(data)
+	if err != nil {
+		return RecordID{}, err
+	}
+	var oldStub []byte
+	if RecordOverflows(page, rid.Slot) {
+		oldStub = append([]byte(nil), ReadRecord(page, rid.Slot)...)
+	}
+
+	setSlot(page, rid.Slot, 0, 0)
+	if insertIntoSlot(page, rid.Slot, cell) {
+		if overflow {
+			markOverflow(page, rid.Slot)
+		}
+		if _, err = h.pager.WritePage(rid.PageID, page); err != nil {
+			return RecordID{}, err
+		}
+		if err := h.updateFree(idx, page); err != nil {
+			return RecordID{}, err
+		}
+		if oldStub != nil {
+			return rid, freeOverflow(h.pager, oldStub)
+		}
+		return rid, nil
+	}
+
+	// doesn't fit on this page, the in-memory copy is discarded and the record
+	// moves, deleting it from the page on disk frees its old overflow pages
+	newRID, err := h.insertCell(cell, overflow)
+	if err != nil {
+		if overflow {
+			freeOverflow(h.pager, cell)
+		}
+		return RecordID{}, err
+	}
+	return newRID, h.Delete(rid)
+}
+
+// unlinkPage removes the empty idx-th page from the chain and frees it
+func (h *Heap) unlinkPage(idx int, page []byte) error {
+	prevID := h.pages[idx-1]
+	prev, err := h.pager.ReadPage(prevID)
+	if err != nil {
+		return err
+	}
+	SetNextPage(prev, NextPage(page))
+	if _, err := h.pager.WritePage(prevID, prev); err != nil {
+		return err
+	}
+	if err := h.pager.FreePage(h.pages[idx]); err != nil {
+		return err
+	}
+	h.pages = append(h.pages[:idx], h.pages[idx+1:]...)
+	h.free = append(h.free[:idx], h.free[idx+1:]...)
+	if err := h.saveFSM(idx); err != nil {
+		return err
+	}
+
+	if h.growthCallback != nil {
+		h.growthCallback(uint32(len(h.pages)))
+	}
+	return nil
 }
 
 /*
 initializePage() creates a new page with the header
-The header has a fixed size of 4 bytes
-Bytes 0-2: NumCells (uint16) - number of records stored
+The header has a fixed size of 16 bytes
+Bytes 0-2: NumCells (uint16) - number of slots (live or free)
 Bytes 2-4: DataStart (uint16) - offset where data region begins (grows backward from PAGE_SIZE)
+Bytes 4-8: Checksum (uint32) - maintained by the pager
+Bytes 8-16: NextPage (uint64) - next page of the heap, 0 for the last one
 */
-func (h *Heap) initializePage(page []byte) {
+func initializePage(page []byte) {
 	// Header:
 	// 0-2: NumCells (uint16) = 0
 	// 2-4: DataStart (uint16) = PAGE_SIZE
 	binary.LittleEndian.PutUint16(page[0:2], 0)
 	binary.LittleEndian.PutUint16(page[2:4], uint16(PAGE_SIZE))
+	SetNextPage(page, 0)
 }
 
-func (h *Heap) insertIntoPage(page []byte, data []byte) bool {
-	// gets how many records exist and where the data region currently starts.
+// insertIntoPage stores data in the first free slot, or a new one at the end of the slot array
+func insertIntoPage(page []byte, data []byte) (uint16, bool) {
 	numCells := binary.LittleEndian.Uint16(page[0:2])
-	dataStart := binary.LittleEndian.Uint16(page[2:4])
+	for slot := uint16(0); slot < numCells; slot++ {
+		if !slotInUse(page, slot) {
+			return slot, insertIntoSlot(page, slot, data)
+		}
+	}
+	return numCells, insertIntoSlot(page, numCells, data)
+}
 
-	// headerEnd is where the slot array ends (header + all existing slots). The free space is the gap between the end of the slot array and the start of the data region.
-	headerEnd := PAGE_HEADER_SIZE + numCells*SLOT_SIZE
-	freeSpace := int(dataStart) - int(headerEnd)
+/*
+insertIntoSlot writes data into a free slot, or appends a slot when slot == NumCells.
+If the gap between the slot array and DataStart is too small but the page has
+enough dead space overall, the page is compacted first.
+*/
+func insertIntoSlot(page []byte, slot uint16, data []byte) bool {
+	// gets how many records exist and where the data region currently starts.
+	numCells := binary.LittleEndian.Uint16(page[0:2])
 
-	needed := int(SLOT_SIZE) + len(data)
+	needed := len(data)
+	newNumCells := numCells
+	if slot == numCells {
+		needed += SLOT_SIZE
+		newNumCells++
+	}
 
-	if freeSpace < needed {
-		return false
+	// headerEnd is where the slot array ends (header + all existing slots). The free space is the gap between the end of the slot array and the start of the data region.
+	headerEnd := PAGE_HEADER_SIZE + int(numCells)*SLOT_SIZE
+	if int(binary.LittleEndian.Uint16(page[2:4]))-headerEnd < needed {
+		if FreeSpace(page) < needed {
+			return false
+		}
+		compactPage(page)
 	}
+	dataStart := binary.LittleEndian.Uint16(page[2:4])
 
 	// update DataStart
 	newDataStart := dataStart - uint16(len(data))
@@ -187,28 +708,193 @@ func (h *Heap) insertIntoPage(page []byte, data []byte) bool {
 
 	// write Slot
 	// slot: Offset (uint16), Size (uint16)
-	slotOffset := headerEnd
-	binary.LittleEndian.PutUint16(page[slotOffset:slotOffset+2], newDataStart)
-	binary.LittleEndian.PutUint16(page[slotOffset+2:slotOffset+4], uint16(len(data)))
+	setSlot(page, slot, newDataStart, uint16(len(data)))
 
 	// update Header
-	binary.LittleEndian.PutUint16(page[0:2], numCells+1)
+	binary.LittleEndian.PutUint16(page[0:2], newNumCells)
 	binary.LittleEndian.PutUint16(page[2:4], newDataStart)
 
 	return true
 }
 
-// InsertRaw inserts a raw record into a specific page.
-// Used by system components like the catalog.
-func (h *Heap) InsertRaw(page []byte, data []byte) bool {
-	return h.insertIntoPage(page, data)
+// FreeSpace is how many bytes a page could still hold once compacted
+func FreeSpace(page []byte) int {
+	numCells := binary.LittleEndian.Uint16(page[0:2])
+	used := PAGE_HEADER_SIZE + int(numCells)*SLOT_SIZE
+	for slot := uint16(0); slot < numCells; slot++ {
+		used += int(binary.LittleEndian.Uint16(page[PAGE_HEADER_SIZE+int(slot)*SLOT_SIZE+2:]) &^ SLOT_OVERFLOW)
+	}
+	return PAGE_SIZE - used
+}
+
+/*
+compactPage() defragments the data region: live cells are packed against the
+end of the page so all dead space ends up in the gap after the slot array.
+Slot numbers don't change, so RecordIDs stay valid.
+*/
+func compactPage(page []byte) {
+	numCells := binary.LittleEndian.Uint16(page[0:2])
+	cells := make([][]byte, numCells)
+	overflows := make([]bool, numCells)
+	for slot := uint16(0); slot < numCells; slot++ {
+		if slotInUse(page, slot) {
+			cells[slot] = append([]byte(nil), ReadRecord(page, slot)...)
+			overflows[slot] = RecordOverflows(page, slot)
+		}
+	}
+
+	dataStart := uint16(PAGE_SIZE)
+	for slot, cell := range cells {
+		if cell == nil {
+			continue
+		}
+		dataStart -= uint16(len(cell))
+		copy(page[dataStart:], cell)
+		setSlot(page, uint16(slot), dataStart, uint16(len(cell)))
+		if overflows[slot] {
+			markOverflow(page, uint16(slot))
+		}
+	}
+	// zero the reclaimed space so stale rows don't linger on disk
+	headerEnd := PAGE_HEADER_SIZE + int(numCells)*SLOT_SIZE
+	clear(page[headerEnd:dataStart])
+	binary.LittleEndian.PutUint16(page[2:4], dataStart)
 }
 
-// helper functions
-func (h *Heap) SetNumPages(numPages uint32) {
-	h.numPages = numPages
+// freeSlot marks a slot as free and trims free slots off the end of the slot array
+func freeSlot(page []byte, slot uint16) {
+	setSlot(page, slot, 0, 0)
+	numCells := binary.LittleEndian.Uint16(page[0:2])
+	for numCells > 0 && !slotInUse(page, numCells-1) {
+		numCells--
+	}
+	binary.LittleEndian.PutUint16(page[0:2], numCells)
+	if numCells == 0 {
+		binary.LittleEndian.PutUint16(page[2:4], uint16(PAGE_SIZE))
+	}
 }
 
+func setSlot(page []byte, slot uint16, offset uint16, length uint16) {
+	slotOffset := PAGE_HEADER_SIZE + int(slot)*SLOT_SIZE
+	binary.LittleEndian.PutUint16(page[slotOffset:slotOffset+2], offset)
+	binary.LittleEndian.PutUint16(page[slotOffset+2:slotOffset+4], length)
+}
+
+// a free slot has offset 0, which can never point at a real cell since the header lives there
+func slotInUse(page []byte, slot uint16) bool {
+	slotOffset := PAGE_HEADER_SIZE + int(slot)*SLOT_SIZE
+	return binary.LittleEndian.Uint16(page[slotOffset:slotOffset+2]) != 0
+}
+
+func validSlot(page []byte, slot uint16) bool {
+	return slot < binary.LittleEndian.Uint16(page[0:2]) && slotInUse(page, slot)
+}
+
+// ReadRecord returns the cell referenced by a slot, for an overflowed record its stub.
+// The page layout must already have been validated with CheckPage.
+func ReadRecord(page []byte, slot uint16) []byte {
+	slotOffset := PAGE_HEADER_SIZE + int(slot)*SLOT_SIZE
+	recordOffset := binary.LittleEndian.Uint16(page[slotOffset : slotOffset+2])
+	recordLen := binary.LittleEndian.Uint16(page[slotOffset+2:slotOffset+4]) &^ SLOT_OVERFLOW
+	return page[recordOffset : int(recordOffset)+int(recordLen)]
+}
+
+// LiveSlots lists the slots of a page that hold a record
+func LiveSlots(page []byte) []uint16 {
+	var slots []uint16
+	numCells := binary.LittleEndian.Uint16(page[0:2])
+	for slot := uint16(0); slot < numCells; slot++ {
+		if slotInUse(page, slot) {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+/*
+CheckPage validates the slotted page layout and reports every problem found:
+the slot array must fit before DataStart, and each cell must lie inside the
+data region without overlapping another cell. Free slots are skipped.
+*/
+func CheckPage(page []byte) []string {
+	var problems []string
+	numCells := int(binary.LittleEndian.Uint16(page[0:2]))
+	dataStart := int(binary.LittleEndian.Uint16(page[2:4]))
+	if dataStart > PAGE_SIZE {
+		return append(problems, fmt.Sprintf("DataStart %d is past the end of the page", dataStart))
+	}
+
+	headerEnd := PAGE_HEADER_SIZE + numCells*SLOT_SIZE
+	if headerEnd > dataStart {
+		return append(problems, fmt.Sprintf("slot array of %d cells ends at %d, past DataStart %d", numCells, headerEnd, dataStart))
+	}
+
+	type cell struct{ start, end, slot int }
+	cells := make([]cell, 0, numCells)
+	for i := 0; i < numCells; i++ {
+		slotOffset := PAGE_HEADER_SIZE + i*SLOT_SIZE
+		recordOffset := int(binary.LittleEndian.Uint16(page[slotOffset : slotOffset+2]))
+		recordLen := int(binary.LittleEndian.Uint16(page[slotOffset+2 : slotOffset+4]))
+		if recordOffset == 0 {
+			if recordLen != 0 {
+				problems = append(problems, fmt.Sprintf("slot %d: free slot has length %d", i, recordLen))
+			}
+			continue
+		}
+		if recordLen&SLOT_OVERFLOW != 0 {
+			recordLen &^= SLOT_OVERFLOW
+			if recordLen != OVERFLOW_STUB_SIZE {
+				problems = append(problems, fmt.Sprintf("slot %d: overflow stub of %d bytes", i, recordLen))
+				continue
+			}
+		}
+		if recordOffset < dataStart || recordOffset+recordLen > PAGE_SIZE {
+			problems = append(problems, fmt.Sprintf("slot %d: cell [%d, %d) outside data region [%d, %d)",
+				i, recordOffset, recordOffset+recordLen, dataStart, PAGE_SIZE))
+			continue
+		}
+		cells = append(cells, cell{recordOffset, recordOffset + recordLen, i})
+	}
+
+	sort.Slice(cells, func(a, b int) bool { return cells[a].start < cells[b].start })
+	for i := 1; i < len(cells); i++ {
+		if cells[i].start < cells[i-1].end {
+			problems = append(problems, fmt.Sprintf("slot %d overlaps slot %d", cells[i].slot, cells[i-1].slot))
+		}
+	}
+	return problems
+}
+
+// Free returns every page of the heap, its free space map and its records' overflow
+// pages to the allocator. The heap can't be used afterwards.
+func (h *Heap) Free() error {
+	if err := h.loadPages(); err != nil {
+		return err
+	}
+	for _, id := range h.pages {
+		page, err := h.readPage(id)
+		if err != nil {
+			return err
+		}
+		for _, slot := range LiveSlots(page) {
+			if !RecordOverflows(page, slot) {
+				continue
+			}
+			if err := freeOverflow(h.pager, ReadRecord(page, slot)); err != nil {
+				return fmt.Errorf("page %d slot %d: %w", id, slot, err)
+			}
+		}
+	}
+	for _, id := range append(h.pages, h.fsmPages...) {
+		if err := h.pager.FreePage(id); err != nil {
+			return err
+		}
+	}
+	h.pages, h.free, h.fsmPages = nil, nil, nil
+	return nil
+}
+
+// helper functions
 func (h *Heap) SetGrowthCallback(cb func(uint32)) {
 	h.growthCallback = cb
 }
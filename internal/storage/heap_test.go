@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/mbeka02/pesapal_challenge/internal/types"
+)
+
+func newTestHeap(t *testing.T) (*Pager, *Heap) {
+	t.Helper()
+	pager := newTestPager(t)
+	heap, err := CreateHeap(pager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pager, heap
+}
+
+func record(i, size int) []byte {
+	data := bytes.Repeat([]byte{byte(i)}, size)
+	binary.LittleEndian.PutUint32(data, uint32(i))
+	return data
+}
+
+// fillPages inserts records of size bytes until the heap has n pages
+func fillPages(t *testing.T, heap *Heap, n, size int) []RecordID {
+	t.Helper()
+	var rids []RecordID
+	for i := 0; ; i++ {
+		rid, err := heap.Insert(record(i, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages, _ := heap.Pages()
+		if len(pages) > n {
+			if err := heap.Delete(rid); err != nil {
+				t.Fatal(err)
+			}
+			return rids
+		}
+		rids = append(rids, rid)
+	}
+}
+
+func TestHeapInsertGetScan(t *testing.T) {
+	_, heap := newTestHeap(t)
+	rids := fillPages(t, heap, 3, 100)
+
+	for i, rid := range rids {
+		got, err := heap.Get(rid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, record(i, 100)) {
+			t.Fatalf("record %d read back wrong", i)
+		}
+	}
+	n := 0
+	err := heap.Scan(func(rid RecordID, data []byte) bool {
+		if rid != rids[n] || !bytes.Equal(data, record(n, 100)) {
+			t.Fatalf("scan found %v, want record %d at %v", rid, n, rids[n])
+		}
+		n++
+		return true
+	})
+	if err != nil || n != len(rids) {
+		t.Fatalf("scan visited %d records (%v), want %d", n, err, len(rids))
+	}
+}
+
+func TestFSMInsertAfterDelete(t *testing.T) {
+	pager, heap := newTestHeap(t)
+	rids := fillPages(t, heap, 3, 200)
+	pages, _ := heap.Pages()
+
+	// make room on the first page only
+	var freed int
+	for _, rid := range rids {
+		if rid.PageID == pages[0] && freed < 3 {
+			if err := heap.Delete(rid); err != nil {
+				t.Fatal(err)
+			}
+			freed++
+		}
+	}
+
+	// a heap opened from disk sees the same map
+	reopened := NewHeap(pager, heap.StartPage(), heap.FSMStartPage())
+	for i := 0; i < freed; i++ {
+		rid, err := reopened.Insert(record(1000+i, 200))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rid.PageID != pages[0] {
+			t.Fatalf("insert went to page %d, want the first page %d which has room", rid.PageID, pages[0])
+		}
+	}
+	after, _ := reopened.Pages()
+	if len(after) != len(pages) {
+		t.Fatalf("heap grew to %d pages, want %d", len(after), len(pages))
+	}
+
+	checkFSM(t, pager, heap)
+}
+
+// checkFSM makes sure the persisted buckets match the free space of the pages
+func checkFSM(t *testing.T, pager *Pager, heap *Heap) {
+	t.Helper()
+	ids, buckets, _, err := ReadFSM(pager, heap.FSMStartPage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range ids {
+		page, err := pager.ReadPage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buckets[i] != FreeBucket(page) {
+			t.Fatalf("page %d: FSM bucket %d, page has bucket %d", id, buckets[i], FreeBucket(page))
+		}
+	}
+}
+
+func TestHeapUpdate(t *testing.T) {
+	pager, heap := newTestHeap(t)
+	rids := fillPages(t, heap, 2, 200)
+	pages, _ := heap.Pages()
+	first := rids[0]
+
+	tests := []struct {
+		name  string
+		size  int
+		moves bool
+	}{
+		{"shrinks in place", 50, false},
+		{"grows into the space it freed", 200, false},
+		{"grows past the room on its page", 1000, true},
+	}
+	rid := first
+	for i, tt := range tests {
+		newRID, err := heap.Update(rid, record(i, tt.size))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if moved := newRID != rid; moved != tt.moves {
+			t.Fatalf("%s: moved from %v to %v, want moved %v", tt.name, rid, newRID, tt.moves)
+		}
+		got, err := heap.Get(newRID)
+		if err != nil || !bytes.Equal(got, record(i, tt.size)) {
+			t.Fatalf("%s: read back %d bytes (%v)", tt.name, len(got), err)
+		}
+		checkFSM(t, pager, heap)
+		rid = newRID
+	}
+	if rid.PageID == pages[0] {
+		t.Fatalf("the grown record should have left the full first page")
+	}
+	if _, err := heap.Get(first); err == nil {
+		t.Fatal("the old slot should be empty after the record moved")
+	}
+	if _, err := heap.Update(first, record(0, 10)); err == nil {
+		t.Fatal("updating an empty slot should fail")
+	}
+
+	// the room the moved record left on the first page is used by the next insert
+	reopened := NewHeap(pager, heap.StartPage(), heap.FSMStartPage())
+	newRID, err := reopened.Insert(record(99, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newRID.PageID != pages[0] {
+		t.Fatalf("insert went to page %d, want the first page %d which has room again", newRID.PageID, pages[0])
+	}
+	checkFSM(t, pager, reopened)
+}
+
+func TestHeapDeleteFreesEmptyPages(t *testing.T) {
+	pager, heap := newTestHeap(t)
+	rids := fillPages(t, heap, 3, 500)
+	pages, _ := heap.Pages()
+	before, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rid := range rids {
+		if rid.PageID == pages[1] {
+			if err := heap.Delete(rid); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	after, _ := heap.Pages()
+	if want := []PageID{pages[0], pages[2]}; !reflect.DeepEqual(after, want) {
+		t.Fatalf("pages = %v, want %v", after, want)
+	}
+	free, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(free) != len(before)+1 || free[0] != pages[1] {
+		t.Fatalf("free list = %v, want the emptied page %d", free, pages[1])
+	}
+}
+
+func TestCompactPage(t *testing.T) {
+	page := make([]byte, PAGE_SIZE)
+	initializePage(page)
+	// an overflow stub keeps its flag when it is moved
+	stub, _ := insertIntoPage(page, make([]byte, OVERFLOW_STUB_SIZE))
+	markOverflow(page, stub)
+	var slots []uint16
+	for i := 0; ; i++ {
+		slot, ok := insertIntoPage(page, record(i, 300))
+		if !ok {
+			break
+		}
+		slots = append(slots, slot)
+	}
+	// free every other cell, leaving the dead space scattered
+	for i := 0; i < len(slots); i += 2 {
+		freeSlot(page, slots[i])
+	}
+
+	big := record(99, 500)
+	slot, ok := insertIntoPage(page, big)
+	if !ok {
+		t.Fatal("insert should compact the page to make room")
+	}
+	if problems := CheckPage(page); len(problems) > 0 {
+		t.Fatalf("page after compaction: %v", problems)
+	}
+	if slot != slots[0] {
+		t.Fatalf("insert used slot %d, want the freed slot %d", slot, slots[0])
+	}
+	if !bytes.Equal(ReadRecord(page, slot), big) {
+		t.Fatal("new record read back wrong")
+	}
+	for i := 1; i < len(slots); i += 2 {
+		if !bytes.Equal(ReadRecord(page, slots[i]), record(i, 300)) {
+			t.Fatalf("slot %d lost its record in compaction", slots[i])
+		}
+	}
+	if !RecordOverflows(page, stub) || len(ReadRecord(page, stub)) != OVERFLOW_STUB_SIZE {
+		t.Fatal("the overflow stub lost its flag in compaction")
+	}
+}
+
+func TestRowRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		column types.Column
+		value  types.Value
+	}{
+		{"int", types.Column{Type: types.INT}, -42},
+		{"float", types.Column{Type: types.FLOAT}, 3.25},
+		{"bool", types.Column{Type: types.BOOLEAN}, true},
+		{"text", types.Column{Type: types.TEXT}, "héllo"},
+		{"char", types.Column{Type: types.CHAR, Length: 4}, types.Char("ab  ")},
+		{"json", types.Column{Type: types.JSON}, types.JSONValue(`{"a":[1,2]}`)},
+		{"blob", types.Column{Type: types.BLOB}, []byte{0, 1, 0xff}},
+		{"date", types.Column{Type: types.DATE}, types.Date(19753)},
+		{"timestamp", types.Column{Type: types.TIMESTAMP}, types.Timestamp(1706659200123456)},
+		{"interval", types.Column{Type: types.INTERVAL}, types.Interval{Months: 1, Days: -2, Micros: 3}},
+		{"decimal", types.Column{Type: types.DECIMAL}, types.Decimal{Unscaled: big.NewInt(-12345), Scale: 2}},
+		{"null", types.Column{Type: types.INT}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema := []types.Column{{Type: types.INT}, tt.column}
+			row := types.Row{7, tt.value}
+			got, err := DecodeRow(EncodeRow(row), schema)
+			if err != nil {
+				t.Fatalf("DecodeRow: %v", err)
+			}
+			if d, ok := tt.value.(types.Decimal); ok {
+				if got[1].(types.Decimal).Cmp(d) != 0 {
+					t.Fatalf("got %v, want %v", got[1], d)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, row) {
+				t.Fatalf("got %#v, want %#v", got, row)
+			}
+		})
+	}
+}
+
+func TestDecodeRowRejectsBadRecords(t *testing.T) {
+	schema := []types.Column{{Name: "a", Type: types.INT}, {Name: "b", Type: types.TEXT}}
+	data := EncodeRow(types.Row{1, "abc"})
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", data[:len(data)-1]},
+		{"trailing bytes", append(append([]byte(nil), data...), 0)},
+		{"null bit past the last column", append([]byte{0x80}, data[1:]...)},
+		{"empty", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeRow(tt.data, schema); err == nil {
+				t.Fatal("DecodeRow should reject the record")
+			}
+		})
+	}
+}
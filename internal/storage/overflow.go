@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+A record larger than MAX_INLINE_RECORD is stored out of line, so a row can
+hold a BLOB or TEXT value of any size and pages still take several rows. Its
+bytes are written to a chain of overflow pages and its slot holds a stub
+| length (u32) | first overflow page (u64) |
+with SLOT_OVERFLOW set in the slot's length field, which is otherwise at most
+PAGE_SIZE. An overflow page has the common header, NumCells being the number
+of the record's bytes it holds and NextPage the next page of the chain,
+followed by those bytes.
+*/
+const (
+	MAX_INLINE_RECORD      = (PAGE_SIZE-PAGE_HEADER_SIZE)/4 - SLOT_SIZE
+	OVERFLOW_STUB_SIZE     = 12
+	OVERFLOW_PAGE_CAPACITY = PAGE_SIZE - PAGE_HEADER_SIZE
+	SLOT_OVERFLOW          = 0x8000
+)
+
+// RecordOverflows reports whether a slot holds the stub of a record kept in overflow pages
+func RecordOverflows(page []byte, slot uint16) bool {
+	slotOffset := PAGE_HEADER_SIZE + int(slot)*SLOT_SIZE
+	return binary.LittleEndian.Uint16(page[slotOffset+2:])&SLOT_OVERFLOW != 0
+}
+
+func markOverflow(page []byte, slot uint16) {
+	slotOffset := PAGE_HEADER_SIZE + int(slot)*SLOT_SIZE
+	length := binary.LittleEndian.Uint16(page[slotOffset+2:])
+	binary.LittleEndian.PutUint16(page[slotOffset+2:], length|SLOT_OVERFLOW)
+}
+
+// writeOverflow copies a record into a new chain of overflow pages and returns its stub
+func writeOverflow(pager *Pager, data []byte) ([]byte, error) {
+	pages := make([]PageID, (len(data)+OVERFLOW_PAGE_CAPACITY-1)/OVERFLOW_PAGE_CAPACITY)
+	for i := range pages {
+		id, err := pager.AllocatePage()
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = id
+	}
+	for i, id := range pages {
+		chunk := data[i*OVERFLOW_PAGE_CAPACITY : min((i+1)*OVERFLOW_PAGE_CAPACITY, len(data))]
+		page := make([]byte, PAGE_SIZE)
+		binary.LittleEndian.PutUint16(page[0:2], uint16(len(chunk)))
+		if i+1 < len(pages) {
+			SetNextPage(page, pages[i+1])
+		}
+		copy(page[PAGE_HEADER_SIZE:], chunk)
+		if _, err := pager.WritePage(id, page); err != nil {
+			return nil, err
+		}
+	}
+
+	stub := make([]byte, OVERFLOW_STUB_SIZE)
+	binary.LittleEndian.PutUint32(stub[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint64(stub[4:12], uint64(pages[0]))
+	return stub, nil
+}
+
+// ReadOverflow reassembles a record from its stub, returning the overflow pages it was read from too
+func ReadOverflow(pager *Pager, stub []byte) ([]byte, []PageID, error) {
+	if len(stub) != OVERFLOW_STUB_SIZE {
+		return nil, nil, fmt.Errorf("overflow stub of %d bytes, want %d", len(stub), OVERFLOW_STUB_SIZE)
+	}
+	length := int(binary.LittleEndian.Uint32(stub[0:4]))
+	first := PageID(binary.LittleEndian.Uint64(stub[4:12]))
+	if length <= MAX_INLINE_RECORD {
+		return nil, nil, fmt.Errorf("overflowed record of %d bytes would fit inline", length)
+	}
+
+	data := make([]byte, 0, length)
+	var pages []PageID
+	for id := first; len(data) < length; {
+		// a chain that runs out or loops can't hold more pages than the length needs
+		if id == 0 || id >= pager.NextPageID() || len(pages) == (length+OVERFLOW_PAGE_CAPACITY-1)/OVERFLOW_PAGE_CAPACITY {
+			return nil, pages, fmt.Errorf("overflow chain from page %d ends after %d of %d bytes", first, len(data), length)
+		}
+		pages = append(pages, id)
+		page, err := pager.ReadPage(id)
+		if err != nil {
+			return nil, pages, err
+		}
+		n := int(binary.LittleEndian.Uint16(page[0:2]))
+		if n == 0 || n > OVERFLOW_PAGE_CAPACITY || len(data)+n > length {
+			return nil, pages, fmt.Errorf("overflow page %d holds %d bytes, %d of the record are left", id, n, length-len(data))
+		}
+		data = append(data, page[PAGE_HEADER_SIZE:PAGE_HEADER_SIZE+n]...)
+		id = NextPage(page)
+		if len(data) == length && id != 0 {
+			return nil, pages, fmt.Errorf("overflow page %d links on past the end of the record", pages[len(pages)-1])
+		}
+	}
+	return data, pages, nil
+}
+
+// freeOverflow returns the pages of an overflowed record to the allocator
+func freeOverflow(pager *Pager, stub []byte) error {
+	_, pages, err := ReadOverflow(pager, stub)
+	if err != nil {
+		return err
+	}
+	for _, id := range pages {
+		if err := pager.FreePage(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
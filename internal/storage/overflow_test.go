@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOverflowRecords(t *testing.T) {
+	pager, heap := newTestHeap(t)
+	before, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// large records share their heap page with small ones
+	sizes := []int{10000, 40, MAX_INLINE_RECORD + 1, 3 * OVERFLOW_PAGE_CAPACITY, 40}
+	var rids []RecordID
+	for i, size := range sizes {
+		rid, err := heap.Insert(record(i, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rids = append(rids, rid)
+	}
+	if pages, _ := heap.Pages(); len(pages) != 1 {
+		t.Fatalf("heap has %d pages, want 1", len(pages))
+	}
+	page, err := pager.ReadPage(rids[0].PageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problems := CheckPage(page); len(problems) > 0 {
+		t.Fatalf("heap page: %v", problems)
+	}
+	for i, rid := range rids {
+		if overflows := RecordOverflows(page, rid.Slot); overflows != (sizes[i] > MAX_INLINE_RECORD) {
+			t.Fatalf("record of %d bytes overflows = %v", sizes[i], overflows)
+		}
+		got, err := heap.Get(rid)
+		if err != nil || !bytes.Equal(got, record(i, sizes[i])) {
+			t.Fatalf("record of %d bytes read back %d bytes (%v)", sizes[i], len(got), err)
+		}
+	}
+	scanned := 0
+	err = heap.Scan(func(rid RecordID, data []byte) bool {
+		if !bytes.Equal(data, record(int(rid.Slot), sizes[rid.Slot])) {
+			t.Fatalf("Scan read record %v back wrong", rid)
+		}
+		scanned++
+		return true
+	})
+	if err != nil || scanned != len(sizes) {
+		t.Fatalf("Scan visited %d records (%v)", scanned, err)
+	}
+
+	freed := func() int {
+		t.Helper()
+		free, err := pager.FreeList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(free) - len(before)
+	}
+	// shrinking a record frees its overflow pages, growing it takes new ones
+	if _, err := heap.Update(rids[0], record(0, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if n := freed(); n != 3 {
+		t.Fatalf("%d pages freed by shrinking a 10000 byte record, want 3", n)
+	}
+	if _, err := heap.Update(rids[1], record(1, 20000)); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := heap.Get(rids[1]); err != nil || !bytes.Equal(got, record(1, 20000)) {
+		t.Fatalf("grown record read back %d bytes (%v)", len(got), err)
+	}
+	if err := heap.Delete(rids[3]); err != nil {
+		t.Fatal(err)
+	}
+	// the grown record reused the 3 freed pages, the deleted one gave back its own 3
+	if n := freed(); n != 3 {
+		t.Fatalf("%d pages freed, want 3", n)
+	}
+
+	// freeing the heap returns the overflow pages too
+	if err := heap.Free(); err != nil {
+		t.Fatal(err)
+	}
+	free, err := pager.FreeList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int(pager.NextPageID()) - 1; len(free) != want {
+		t.Fatalf("%d pages on the free list after Free, want all %d", len(free), want)
+	}
+}
+
+func TestReadOverflowRejectsBrokenChains(t *testing.T) {
+	pager := newTestPager(t)
+	stub, err := writeOverflow(pager, record(1, 2*OVERFLOW_PAGE_CAPACITY+10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, pages, err := ReadOverflow(pager, stub)
+	if err != nil || len(pages) != 3 || !bytes.Equal(data, record(1, 2*OVERFLOW_PAGE_CAPACITY+10)) {
+		t.Fatalf("ReadOverflow = %d bytes from %v (%v)", len(data), pages, err)
+	}
+
+	// cut the chain after its second page
+	page, err := pager.ReadPage(pages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetNextPage(page, 0)
+	if _, err := pager.WritePage(pages[1], page); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadOverflow(pager, stub); err == nil {
+		t.Fatal("a chain shorter than its record should be rejected")
+	}
+	// or loop it back to its start
+	SetNextPage(page, pages[0])
+	if _, err := pager.WritePage(pages[1], page); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadOverflow(pager, stub); err == nil {
+		t.Fatal("a chain that loops should be rejected")
+	}
+}
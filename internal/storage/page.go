@@ -1,5 +1,30 @@
 package storage
 
+import "encoding/binary"
+
 const PAGE_SIZE = 4096
 
 type PageID uint64
+
+/*
+Every page starts with the same header prefix:
+Bytes 0-2: NumCells (uint16)
+Bytes 2-4: DataStart (uint16)
+Bytes 4-8: Checksum (uint32) - CRC32C of the page with this field skipped,
+filled in by the pager on write and verified on read
+Bytes 8-16: NextPage (uint64) - next page in the chain, 0 terminates it
+(page 0 is the meta page so it can never be a successor)
+*/
+const (
+	CHECKSUM_OFFSET  = 4
+	CHECKSUM_SIZE    = 4
+	NEXT_PAGE_OFFSET = 8
+)
+
+func NextPage(page []byte) PageID {
+	return PageID(binary.LittleEndian.Uint64(page[NEXT_PAGE_OFFSET : NEXT_PAGE_OFFSET+8]))
+}
+
+func SetNextPage(page []byte, next PageID) {
+	binary.LittleEndian.PutUint64(page[NEXT_PAGE_OFFSET:NEXT_PAGE_OFFSET+8], uint64(next))
+}
@@ -1,31 +1,90 @@
 package storage
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 )
 
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type Pager struct {
+	path string
 	file *os.File
 }
 
+// CorruptPageError is returned by ReadPage when the bytes on disk don't
+// match the checksum stored in the page header.
+type CorruptPageError struct {
+	PageID   PageID
+	Offset   int64
+	Stored   uint32
+	Computed uint32
+}
+
+func (e *CorruptPageError) Error() string {
+	return fmt.Sprintf("page %d (file offset %d) is corrupt: stored checksum %08x, computed %08x",
+		e.PageID, e.Offset, e.Stored, e.Computed)
+}
+
 func NewPager(path string) (*Pager, error) {
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
 	if err != nil {
 		return nil, err
 	}
-	return &Pager{file: f}, nil
+	return &Pager{path: f.Name(), file: f}, nil
+}
+
+func (p *Pager) Path() string {
+	return p.path
+}
+
+func (p *Pager) Close() error {
+	return p.file.Close()
+}
+
+/*
+ReplaceWith atomically swaps the file behind this pager for the file at path
+(renaming it over ours) and reopens it. Used by VACUUM, which builds a compact
+copy of the database next to the original.
+*/
+func (p *Pager) ReplaceWith(path string) error {
+	if err := os.Rename(path, p.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	old := p.file
+	p.file = f
+	return old.Close()
 }
 
 func (p *Pager) ReadPage(id PageID) ([]byte, error) {
 	buff := make([]byte, PAGE_SIZE)
 	offset := int64(id) * PAGE_SIZE
 
-	_, err := p.file.ReadAt(buff, offset)
+	n, err := p.file.ReadAt(buff, offset)
 	if err != nil && err != io.EOF {
 		return nil, err
 	}
+
+	// pages past the end of the file have never been written. Every page in the
+	// file went through WritePage, even a blank one from AllocatePage, so an
+	// all-zero page there has been wiped and fails the checksum like any other
+	if n == 0 {
+		return buff, nil
+	}
+
+	stored := binary.LittleEndian.Uint32(buff[CHECKSUM_OFFSET : CHECKSUM_OFFSET+CHECKSUM_SIZE])
+	computed := pageChecksum(buff)
+	// a short read means the page was torn, which the checksum catches too
+	if n < PAGE_SIZE || stored != computed {
+		return nil, &CorruptPageError{PageID: id, Offset: offset, Stored: stored, Computed: computed}
+	}
 	return buff, nil
 }
 
@@ -33,6 +92,7 @@ func (p *Pager) WritePage(id PageID, page []byte) (int, error) {
 	if len(page) != PAGE_SIZE {
 		return 0, fmt.Errorf("Invalid page size")
 	}
+	binary.LittleEndian.PutUint32(page[CHECKSUM_OFFSET:CHECKSUM_OFFSET+CHECKSUM_SIZE], pageChecksum(page))
 	offset := int64(id) * PAGE_SIZE
 	return p.file.WriteAt(page, offset)
 }
@@ -45,3 +105,9 @@ func (p *Pager) NextPageID() PageID {
 	}
 	return PageID(stat.Size() / PAGE_SIZE)
 }
+
+// pageChecksum computes the CRC32C of a page, skipping the checksum field itself
+func pageChecksum(page []byte) uint32 {
+	crc := crc32.Update(0, castagnoli, page[:CHECKSUM_OFFSET])
+	return crc32.Update(crc, castagnoli, page[CHECKSUM_OFFSET+CHECKSUM_SIZE:])
+}
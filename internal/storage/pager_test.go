@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestPager(t *testing.T) *Pager {
+	t.Helper()
+	pager, err := NewPager(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pager.Close() })
+	if err := pager.InitMetaPage(); err != nil {
+		t.Fatal(err)
+	}
+	return pager
+}
+
+func TestPageRoundTrip(t *testing.T) {
+	pager := newTestPager(t)
+	id, err := pager.AllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := make([]byte, PAGE_SIZE)
+	copy(page[PAGE_HEADER_SIZE:], "hello, page")
+	SetNextPage(page, 42)
+	if _, err := pager.WritePage(id, page); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := pager.ReadPage(id)
+	if err != nil {
+		t.Fatalf("ReadPage: %v", err)
+	}
+	if !bytes.Equal(got, page) {
+		t.Fatal("page read back differs from the page written")
+	}
+	if NextPage(got) != 42 {
+		t.Fatalf("NextPage = %d, want 42", NextPage(got))
+	}
+}
+
+func TestReadPageDetectsCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(f *os.File, offset int64) error
+	}{
+		{"flipped byte", func(f *os.File, offset int64) error {
+			b := make([]byte, 1)
+			if _, err := f.ReadAt(b, offset+100); err != nil {
+				return err
+			}
+			b[0] ^= 0x01
+			_, err := f.WriteAt(b, offset+100)
+			return err
+		}},
+		{"zeroed page", func(f *os.File, offset int64) error {
+			_, err := f.WriteAt(make([]byte, PAGE_SIZE), offset)
+			return err
+		}},
+		{"torn write", func(f *os.File, offset int64) error {
+			return f.Truncate(offset + PAGE_SIZE/2)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pager := newTestPager(t)
+			id, err := pager.AllocatePage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			page := make([]byte, PAGE_SIZE)
+			copy(page[PAGE_HEADER_SIZE:], "some data")
+			if _, err := pager.WritePage(id, page); err != nil {
+				t.Fatal(err)
+			}
+
+			offset := int64(id) * PAGE_SIZE
+			if err := tt.corrupt(pager.file, offset); err != nil {
+				t.Fatal(err)
+			}
+			_, err = pager.ReadPage(id)
+			var corrupt *CorruptPageError
+			if !errors.As(err, &corrupt) {
+				t.Fatalf("ReadPage error = %v, want a CorruptPageError", err)
+			}
+			if corrupt.PageID != id || corrupt.Offset != offset {
+				t.Fatalf("error names page %d at offset %d, want page %d at offset %d", corrupt.PageID, corrupt.Offset, id, offset)
+			}
+		})
+	}
+}
+
+func TestReadPagePastEndOfFile(t *testing.T) {
+	pager := newTestPager(t)
+	page, err := pager.ReadPage(pager.NextPageID() + 3)
+	if err != nil {
+		t.Fatalf("ReadPage past the end of the file: %v", err)
+	}
+	if !bytes.Equal(page, make([]byte, PAGE_SIZE)) {
+		t.Fatal("a page past the end of the file should read as zeros")
+	}
+}
+
+func TestAllocatedPageVerifies(t *testing.T) {
+	pager := newTestPager(t)
+	id, err := pager.AllocatePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pager.ReadPage(id); err != nil {
+		t.Fatalf("a freshly allocated page should carry a valid checksum: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SPILL_PARTITIONS is how many files a SpillSet spreads its keys over once it spills
+const SPILL_PARTITIONS = 16
+
+// spillEntryOverhead approximates what a key costs in a Go map on top of its bytes
+const spillEntryOverhead = 48
+
+/*
+SpillSet is a set of keys, such as rows encoded with EncodeRow, kept in
+memory until they take more than its limit. It then spills: the keys it
+holds are written out to temporary files, partitioned by hash, and so is
+every key added after that. Add can only tell whether a key is new until the
+set spills; the keys added later are sorted out by Drain, one partition at a
+time, once all of them are in. Each partition is read into memory by
+itself, so a set can grow to about SPILL_PARTITIONS times its limit.
+
+A partition file is a sequence of | seen (u8) | length (u32) | key | records,
+seen being set for the keys already reported new before the spill, which come
+first.
+*/
+type SpillSet struct {
+	keys  map[string]struct{}
+	size  int
+	limit int
+	files []*os.File
+	parts []*bufio.Writer
+}
+
+func NewSpillSet(limit int) *SpillSet {
+	return &SpillSet{keys: make(map[string]struct{}), limit: limit}
+}
+
+// Spilled reports whether the set has gone to disk, after which Add leaves new keys to Drain
+func (s *SpillSet) Spilled() bool {
+	return s.files != nil
+}
+
+// Add adds a key, reporting whether it is new. Once the set has spilled it
+// reports false for every key, and Drain reports the new ones.
+func (s *SpillSet) Add(key []byte) (bool, error) {
+	if s.Spilled() {
+		return false, s.write(key, false)
+	}
+	if _, ok := s.keys[string(key)]; ok {
+		return false, nil
+	}
+	s.keys[string(key)] = struct{}{}
+	s.size += len(key) + spillEntryOverhead
+	if s.size > s.limit {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (s *SpillSet) spill() error {
+	for i := 0; i < SPILL_PARTITIONS; i++ {
+		f, err := os.CreateTemp("", "spill-*.tmp")
+		if err != nil {
+			return err
+		}
+		s.files = append(s.files, f)
+		s.parts = append(s.parts, bufio.NewWriter(f))
+	}
+	for key := range s.keys {
+		if err := s.write([]byte(key), true); err != nil {
+			return err
+		}
+	}
+	s.keys, s.size = nil, 0
+	return nil
+}
+
+func (s *SpillSet) write(key []byte, seen bool) error {
+	w := s.parts[hashKey(key)%SPILL_PARTITIONS]
+	var header [5]byte
+	if seen {
+		header[0] = 1
+	}
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(key)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(key)
+	return err
+}
+
+// Drain calls cb with each key added after the set spilled that it didn't
+// already hold, until cb returns false. It does nothing when the set never spilled.
+func (s *SpillSet) Drain(cb func(key []byte) bool) error {
+	for i, f := range s.files {
+		if err := s.parts[i].Flush(); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		stop, err := drainPartition(bufio.NewReader(f), cb)
+		if err != nil {
+			return fmt.Errorf("reading spilled keys: %w", err)
+		}
+		if stop {
+			return nil
+		}
+	}
+	return nil
+}
+
+func drainPartition(r *bufio.Reader, cb func(key []byte) bool) (bool, error) {
+	keys := make(map[string]struct{})
+	var header [5]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		key := make([]byte, binary.LittleEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, key); err != nil {
+			return false, err
+		}
+		if _, ok := keys[string(key)]; ok {
+			continue
+		}
+		keys[string(key)] = struct{}{}
+		if header[0] == 0 && !cb(key) {
+			return true, nil
+		}
+	}
+}
+
+// Close removes the files the set spilled to
+func (s *SpillSet) Close() error {
+	var errs []error
+	for _, f := range s.files {
+		errs = append(errs, f.Close(), os.Remove(f.Name()))
+	}
+	s.files, s.parts = nil, nil
+	return errors.Join(errs...)
+}
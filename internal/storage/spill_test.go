@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestSpillSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		spilled bool
+	}{
+		{"in memory", 1 << 20, false},
+		{"spilled", 1 << 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := NewSpillSet(tt.limit)
+			defer set.Close()
+
+			var found []string
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%d", i%300)
+				isNew, err := set.Add([]byte(key))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if isNew {
+					found = append(found, key)
+				}
+			}
+			if set.Spilled() != tt.spilled {
+				t.Fatalf("Spilled() = %v, want %v", set.Spilled(), tt.spilled)
+			}
+			err := set.Drain(func(key []byte) bool {
+				found = append(found, string(key))
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sort.Strings(found)
+			if len(found) != 300 {
+				t.Fatalf("found %d distinct keys, want 300", len(found))
+			}
+			for i := 1; i < len(found); i++ {
+				if found[i] == found[i-1] {
+					t.Fatalf("key %s reported twice", found[i])
+				}
+			}
+		})
+	}
+}
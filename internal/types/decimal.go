@@ -0,0 +1,225 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MAX_DECIMAL_PRECISION is the most digits a DECIMAL(p, s) column can declare
+const MAX_DECIMAL_PRECISION = 38
+
+// MAX_STORED_DECIMAL_BYTES is the longest magnitude a stored DECIMAL can have,
+// the row format gives its length one byte (about 614 digits)
+const MAX_STORED_DECIMAL_BYTES = 255
+
+/*
+Decimal is an exact number, Unscaled × 10^-Scale, so 12.50 is {1250, 2}.
+Values in a DECIMAL(p, s) column always carry scale s; results of arithmetic
+carry whatever scale the operation produces (see Add, Mul and Quo).
+*/
+type Decimal struct {
+	Unscaled *big.Int
+	Scale    int32
+}
+
+var bigTen = big.NewInt(10)
+
+func pow10(n int32) *big.Int {
+	return new(big.Int).Exp(bigTen, big.NewInt(int64(n)), nil)
+}
+
+func DecimalFromInt(i int) Decimal {
+	return Decimal{Unscaled: big.NewInt(int64(i))}
+}
+
+// DecimalFromFloat uses the shortest decimal form that reads back as f, so the
+// literal 19.99 becomes exactly 19.99 rather than its binary approximation
+func DecimalFromFloat(f float64) (Decimal, error) {
+	return ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// ParseDecimal reads a plain decimal number such as "-12.50"
+func ParseDecimal(s string) (Decimal, error) {
+	text := strings.TrimSpace(s)
+	digits := strings.TrimLeft(text, "+-")
+	intPart, fracPart, _ := strings.Cut(digits, ".")
+	if intPart == "" && fracPart == "" {
+		return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+	}
+	for _, c := range intPart + fracPart {
+		if c < '0' || c > '9' {
+			return Decimal{}, fmt.Errorf("invalid decimal %q", s)
+		}
+	}
+
+	unscaled, _ := new(big.Int).SetString("0"+intPart+fracPart, 10)
+	if strings.HasPrefix(text, "-") {
+		unscaled.Neg(unscaled)
+	}
+	return Decimal{Unscaled: unscaled, Scale: int32(len(fracPart))}, nil
+}
+
+func (d Decimal) String() string {
+	digits := new(big.Int).Abs(d.Unscaled).String()
+	sign := ""
+	if d.Unscaled.Sign() < 0 {
+		sign = "-"
+	}
+	if d.Scale <= 0 {
+		return sign + digits + strings.Repeat("0", int(-d.Scale))
+	}
+	if pad := int(d.Scale) + 1 - len(digits); pad > 0 {
+		digits = strings.Repeat("0", pad) + digits
+	}
+	point := len(digits) - int(d.Scale)
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Digits counts the significant digits of the unscaled value, the number a precision limits
+func (d Decimal) Digits() int {
+	if d.Unscaled.Sign() == 0 {
+		return 1
+	}
+	return len(new(big.Int).Abs(d.Unscaled).String())
+}
+
+// Rescale changes the scale, rounding half away from zero when digits are dropped
+func (d Decimal) Rescale(scale int32) Decimal {
+	if scale >= d.Scale {
+		return Decimal{Unscaled: new(big.Int).Mul(d.Unscaled, pow10(scale-d.Scale)), Scale: scale}
+	}
+	div := pow10(d.Scale - scale)
+	q, r := new(big.Int).QuoRem(d.Unscaled, div, new(big.Int))
+	// |r| * 2 >= div rounds away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(r), big.NewInt(2)).Cmp(div) >= 0 {
+		if d.Unscaled.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return Decimal{Unscaled: q, Scale: scale}
+}
+
+// Normalize drops trailing fractional zeros, so equal values get the same form
+func (d Decimal) Normalize() Decimal {
+	u, scale := new(big.Int).Set(d.Unscaled), d.Scale
+	r := new(big.Int)
+	for scale > 0 {
+		q, m := new(big.Int).QuoRem(u, bigTen, r)
+		if m.Sign() != 0 {
+			break
+		}
+		u, scale = q, scale-1
+	}
+	if u.Sign() == 0 {
+		scale = 0
+	}
+	return Decimal{Unscaled: u, Scale: scale}
+}
+
+// Fit rescales a value for a DECIMAL(precision, scale) column, failing if it has too many integer digits
+func (d Decimal) Fit(precision, scale int) (Decimal, error) {
+	v := d.Rescale(int32(scale))
+	if v.Digits() > precision {
+		return Decimal{}, fmt.Errorf("%s does not fit DECIMAL(%d, %d)", d, precision, scale)
+	}
+	return v, nil
+}
+
+// CheckStorable fails for a value the row format can't hold: a magnitude over
+// MAX_STORED_DECIMAL_BYTES or a scale outside 0..32767
+func (d Decimal) CheckStorable() error {
+	if (d.Unscaled.BitLen()+7)/8 > MAX_STORED_DECIMAL_BYTES {
+		return fmt.Errorf("decimal of %d digits is too large to store", d.Digits())
+	}
+	if d.Scale < 0 || d.Scale > math.MaxInt16 {
+		return fmt.Errorf("decimal scale %d out of range", d.Scale)
+	}
+	return nil
+}
+
+func (d Decimal) Neg() Decimal {
+	return Decimal{Unscaled: new(big.Int).Neg(d.Unscaled), Scale: d.Scale}
+}
+
+func (d Decimal) Abs() Decimal {
+	return Decimal{Unscaled: new(big.Int).Abs(d.Unscaled), Scale: d.Scale}
+}
+
+// Floor rounds down to a whole number
+func (d Decimal) Floor() Decimal {
+	if d.Scale <= 0 {
+		return d.Rescale(0)
+	}
+	// Div rounds towards negative infinity for a positive divisor
+	return Decimal{Unscaled: new(big.Int).Div(d.Unscaled, pow10(d.Scale))}
+}
+
+// Ceil rounds up to a whole number
+func (d Decimal) Ceil() Decimal {
+	return d.Neg().Floor().Neg()
+}
+
+// Rem is the remainder of truncated division, it has the sign of d and keeps the larger scale
+func (d Decimal) Rem(other Decimal) (Decimal, error) {
+	if other.Unscaled.Sign() == 0 {
+		return Decimal{}, fmt.Errorf("division by zero")
+	}
+	a, b := align(d, other)
+	return Decimal{Unscaled: new(big.Int).Rem(a.Unscaled, b.Unscaled), Scale: a.Scale}, nil
+}
+
+// Add keeps the larger of the two scales
+func (d Decimal) Add(other Decimal) Decimal {
+	a, b := align(d, other)
+	return Decimal{Unscaled: new(big.Int).Add(a.Unscaled, b.Unscaled), Scale: a.Scale}
+}
+
+// Mul is exact, its scale is the sum of the two scales
+func (d Decimal) Mul(other Decimal) Decimal {
+	return Decimal{Unscaled: new(big.Int).Mul(d.Unscaled, other.Unscaled), Scale: d.Scale + other.Scale}
+}
+
+// QUO_EXTRA_SCALE is how many digits a quotient keeps beyond the larger operand scale
+const QUO_EXTRA_SCALE = 6
+
+// Quo divides to the larger operand scale plus QUO_EXTRA_SCALE digits, rounding half away from zero
+func (d Decimal) Quo(other Decimal) (Decimal, error) {
+	if other.Unscaled.Sign() == 0 {
+		return Decimal{}, fmt.Errorf("division by zero")
+	}
+	scale := max(d.Scale, other.Scale) + QUO_EXTRA_SCALE
+	// compute one digit more than needed and let Rescale round it off
+	num := new(big.Int).Mul(d.Unscaled, pow10(scale+1-d.Scale+other.Scale))
+	q := new(big.Int).Quo(num, other.Unscaled)
+	return Decimal{Unscaled: q, Scale: scale + 1}.Rescale(scale), nil
+}
+
+func (d Decimal) Cmp(other Decimal) int {
+	a, b := align(d, other)
+	return a.Unscaled.Cmp(b.Unscaled)
+}
+
+func (d Decimal) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(d.Unscaled, pow10(d.Scale))
+}
+
+func (d Decimal) Float64() float64 {
+	f, _ := d.Rat().Float64()
+	return f
+}
+
+func align(a, b Decimal) (Decimal, Decimal) {
+	switch {
+	case a.Scale < b.Scale:
+		return a.Rescale(b.Scale), b
+	case b.Scale < a.Scale:
+		return a, b.Rescale(a.Scale)
+	default:
+		return a, b
+	}
+}
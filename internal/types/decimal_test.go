@@ -0,0 +1,139 @@
+package types
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func mustDecimal(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := ParseDecimal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseDecimal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "12.50", want: "12.50"},
+		{in: "-0.05", want: "-0.05"},
+		{in: "+7", want: "7"},
+		{in: ".5", want: "0.5"},
+		{in: "12345678901234567890.123456789", want: "12345678901234567890.123456789"},
+		{in: "", wantErr: true},
+		{in: "1e5", wantErr: true},
+		{in: "1.2.3", wantErr: true},
+	}
+	for _, tt := range tests {
+		d, err := ParseDecimal(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseDecimal(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		if err == nil && d.String() != tt.want {
+			t.Fatalf("ParseDecimal(%q) = %s, want %s", tt.in, d, tt.want)
+		}
+	}
+}
+
+func TestDecimalFit(t *testing.T) {
+	tests := []struct {
+		in               string
+		precision, scale int
+		want             string
+		wantErr          bool
+	}{
+		{in: "1.005", precision: 10, scale: 2, want: "1.01"},
+		{in: "-1.005", precision: 10, scale: 2, want: "-1.01"},
+		{in: "1.004", precision: 10, scale: 2, want: "1.00"},
+		{in: "7", precision: 5, scale: 2, want: "7.00"},
+		{in: "999.995", precision: 5, scale: 2, wantErr: true},
+		{in: "12345", precision: 4, scale: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		d, err := mustDecimal(t, tt.in).Fit(tt.precision, tt.scale)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%s.Fit(%d, %d) error = %v, want error %v", tt.in, tt.precision, tt.scale, err, tt.wantErr)
+		}
+		if err == nil && d.String() != tt.want {
+			t.Fatalf("%s.Fit(%d, %d) = %s, want %s", tt.in, tt.precision, tt.scale, d, tt.want)
+		}
+	}
+}
+
+func TestDecimalArithmetic(t *testing.T) {
+	tests := []struct {
+		name string
+		got  func() (Decimal, error)
+		want string
+	}{
+		{"add keeps the larger scale", func() (Decimal, error) {
+			return mustDecimal(t, "0.1").Add(mustDecimal(t, "0.20")), nil
+		}, "0.30"},
+		{"mul adds the scales", func() (Decimal, error) {
+			return mustDecimal(t, "1.5").Mul(mustDecimal(t, "2.25")), nil
+		}, "3.375"},
+		{"quo keeps six more digits", func() (Decimal, error) {
+			return mustDecimal(t, "1").Quo(mustDecimal(t, "3"))
+		}, "0.333333"},
+		{"quo rounds half away from zero", func() (Decimal, error) {
+			return mustDecimal(t, "-2").Quo(mustDecimal(t, "3"))
+		}, "-0.666667"},
+		{"rem", func() (Decimal, error) {
+			return mustDecimal(t, "10.5").Rem(mustDecimal(t, "3"))
+		}, "1.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.got()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.want {
+				t.Fatalf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := mustDecimal(t, "1").Quo(mustDecimal(t, "0.00")); err == nil {
+		t.Fatal("dividing by zero should fail")
+	}
+}
+
+func TestDecimalNormalizeAndCmp(t *testing.T) {
+	a, b := mustDecimal(t, "1.50"), mustDecimal(t, "1.5")
+	if a.Cmp(b) != 0 {
+		t.Fatalf("%s and %s should compare equal", a, b)
+	}
+	if a.Normalize().String() != "1.5" || mustDecimal(t, "0.000").Normalize().String() != "0" {
+		t.Fatal("Normalize should drop trailing zeros")
+	}
+	if mustDecimal(t, "-1").Cmp(mustDecimal(t, "0.5")) >= 0 {
+		t.Fatal("-1 should sort before 0.5")
+	}
+}
+
+func TestDecimalCheckStorable(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Decimal
+		ok   bool
+	}{
+		{"255 magnitude bytes", Decimal{Unscaled: new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255*8), big.NewInt(1))}, true},
+		{"256 magnitude bytes", Decimal{Unscaled: new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), 255*8))}, false},
+		{"700 digits", mustDecimal(t, strings.Repeat("9", 700)), false},
+		{"largest scale", Decimal{Unscaled: big.NewInt(1), Scale: 32767}, true},
+		{"scale past int16", Decimal{Unscaled: big.NewInt(1), Scale: 32768}, false},
+		{"negative scale", Decimal{Unscaled: big.NewInt(1), Scale: -1}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.d.CheckStorable(); (err == nil) != tt.ok {
+			t.Errorf("%s: CheckStorable() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package types
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONValue is a validated JSON document kept as compact text, whitespace removed but
+// keys left in the order they were written
+type JSONValue string
+
+func ParseJSON(s string) (JSONValue, error) {
+	var buff bytes.Buffer
+	if err := json.Compact(&buff, []byte(s)); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	return JSONValue(buff.String()), nil
+}
+
+/*
+JSONPath locates a value inside a document, written like SQLite's paths:
+$ is the whole document, .key or ."key" a member of an object and [n] an
+element of an array, e.g. $.items[0].id.
+*/
+type JSONPath []JSONStep
+
+// JSONStep is a single .key or [n]
+type JSONStep struct {
+	Key     string
+	Index   int
+	ByIndex bool
+}
+
+func ParseJSONPath(s string) (JSONPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("invalid JSON path %q, it must start with $", s)
+	}
+	var path JSONPath
+	rest := s[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			var key string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.Index(rest[1:], `"`)
+				if end < 0 {
+					return nil, fmt.Errorf("invalid JSON path %q: unterminated key", s)
+				}
+				key, rest = rest[1:end+1], rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ".[")
+				if end < 0 {
+					end = len(rest)
+				}
+				key, rest = rest[:end], rest[end:]
+			}
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", s)
+			}
+			path = append(path, JSONStep{Key: key})
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: unterminated [", s)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: %s is not an array index", s, rest[1:end])
+			}
+			path, rest = append(path, JSONStep{Index: n, ByIndex: true}), rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q at %q", s, rest)
+		}
+	}
+	return path, nil
+}
+
+// String writes the path back out in one canonical spelling, keys quoted only when they need it
+func (p JSONPath) String() string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, step := range p {
+		switch {
+		case step.ByIndex:
+			fmt.Fprintf(&b, "[%d]", step.Index)
+		case strings.ContainsAny(step.Key, `.[]" `):
+			fmt.Fprintf(&b, `."%s"`, step.Key)
+		default:
+			b.WriteString("." + step.Key)
+		}
+	}
+	return b.String()
+}
+
+// Extract returns the value at a path, false if the document has nothing there
+func (j JSONValue) Extract(path JSONPath) (JSONValue, bool) {
+	raw := json.RawMessage(j)
+	for _, step := range path {
+		if step.ByIndex {
+			var elems []json.RawMessage
+			if json.Unmarshal(raw, &elems) != nil || step.Index >= len(elems) {
+				return "", false
+			}
+			raw = elems[step.Index]
+			continue
+		}
+		var members map[string]json.RawMessage
+		if json.Unmarshal(raw, &members) != nil {
+			return "", false
+		}
+		var ok bool
+		if raw, ok = members[step.Key]; !ok {
+			return "", false
+		}
+	}
+	return JSONValue(raw), true
+}
+
+// Text is the value as SQL text: strings without their quotes, JSON null as NULL
+// and anything else as its JSON
+func (j JSONValue) Text() Value {
+	switch {
+	case j == "null":
+		return nil
+	case strings.HasPrefix(string(j), `"`):
+		var s string
+		json.Unmarshal([]byte(j), &s)
+		return s
+	default:
+		return string(j)
+	}
+}
+
+// Scalar is the value as an INT, FLOAT, TEXT or BOOLEAN (nil for JSON null),
+// false for objects and arrays
+func (j JSONValue) Scalar() (Value, bool) {
+	switch {
+	case j == "null":
+		return nil, true
+	case j == "true" || j == "false":
+		return j == "true", true
+	case strings.HasPrefix(string(j), `"`):
+		return j.Text(), true
+	case strings.HasPrefix(string(j), "{") || strings.HasPrefix(string(j), "["):
+		return nil, false
+	}
+	if i, err := strconv.Atoi(string(j)); err == nil {
+		return i, true
+	}
+	f, err := strconv.ParseFloat(string(j), 64)
+	return f, err == nil
+}
+
+// ArrayLength counts the elements of an array, false if the value isn't one
+func (j JSONValue) ArrayLength() (int, bool) {
+	var elems []json.RawMessage
+	if !strings.HasPrefix(string(j), "[") || json.Unmarshal([]byte(j), &elems) != nil {
+		return 0, false
+	}
+	return len(elems), true
+}
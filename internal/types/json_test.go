@@ -0,0 +1,71 @@
+package types
+
+import "testing"
+
+func TestJSONExtract(t *testing.T) {
+	doc, err := ParseJSON(`{"id": 7, "items": [{"sku": "A1"}, {"sku": null}], "odd key": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		want  JSONValue
+		found bool
+	}{
+		{"$", doc, true},
+		{"$.id", "7", true},
+		{"$.items[0].sku", `"A1"`, true},
+		{"$.items[1].sku", "null", true},
+		{`$."odd key"`, "true", true},
+		{"$.items[2]", "", false},
+		{"$.missing", "", false},
+		{"$.id.deeper", "", false},
+	}
+	for _, tt := range tests {
+		path, err := ParseJSONPath(tt.path)
+		if err != nil {
+			t.Fatalf("ParseJSONPath(%q): %v", tt.path, err)
+		}
+		got, ok := doc.Extract(path)
+		if ok != tt.found || got != tt.want {
+			t.Fatalf("Extract(%s) = %q, %v; want %q, %v", tt.path, got, ok, tt.want, tt.found)
+		}
+	}
+}
+
+func TestJSONPathErrors(t *testing.T) {
+	for _, path := range []string{"id", `$."open`, "$[x]"} {
+		if _, err := ParseJSONPath(path); err == nil {
+			t.Fatalf("ParseJSONPath(%q) should fail", path)
+		}
+	}
+}
+
+func TestJSONScalarAndText(t *testing.T) {
+	tests := []struct {
+		doc    JSONValue
+		scalar Value
+		text   Value
+	}{
+		{`"a"`, "a", "a"},
+		{"12", 12, "12"},
+		{"1.5", 1.5, "1.5"},
+		{"true", true, "true"},
+		{"null", nil, nil},
+	}
+	for _, tt := range tests {
+		scalar, ok := tt.doc.Scalar()
+		if !ok || scalar != tt.scalar {
+			t.Fatalf("Scalar(%s) = %v, %v; want %v", tt.doc, scalar, ok, tt.scalar)
+		}
+		if text := tt.doc.Text(); text != tt.text {
+			t.Fatalf("Text(%s) = %v, want %v", tt.doc, text, tt.text)
+		}
+	}
+	if _, ok := JSONValue(`[1,2]`).Scalar(); ok {
+		t.Fatal("an array is not a scalar")
+	}
+	if n, ok := JSONValue(`[1,2,3]`).ArrayLength(); !ok || n != 3 {
+		t.Fatalf("ArrayLength = %d, %v", n, ok)
+	}
+}
@@ -0,0 +1,249 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Dates and times are kept in UTC:
+  - Date counts days since 1970-01-01
+  - Timestamp counts microseconds since 1970-01-01 00:00:00
+  - Interval keeps months, days and microseconds apart like PostgreSQL does,
+    since a month (and, across DST, a day) has no fixed length
+*/
+type (
+	Date      int32
+	Timestamp int64
+	Interval  struct {
+		Months int32
+		Days   int32
+		Micros int64
+	}
+)
+
+const (
+	MICROS_PER_SECOND = int64(time.Second / time.Microsecond)
+	MICROS_PER_DAY    = 24 * 60 * 60 * MICROS_PER_SECOND
+)
+
+const (
+	dateLayout      = "2006-01-02"
+	timestampLayout = "2006-01-02 15:04:05"
+)
+
+// Dates and timestamps run from 0001-01-01 up to the end of 9999, the years their
+// layouts can print; arithmetic that leaves the range fails instead of wrapping
+var (
+	minTime = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTime = time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	minTimestamp = TimestampOf(minTime)
+	maxTimestamp = TimestampOf(maxTime) - 1
+
+	errDateRange      = errors.New("date out of range")
+	errTimestampRange = errors.New("timestamp out of range")
+	errIntervalRange  = errors.New("interval out of range")
+)
+
+func DateOf(t time.Time) Date {
+	t = t.UTC()
+	days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60)
+	return Date(days)
+}
+
+func TimestampOf(t time.Time) Timestamp {
+	return Timestamp(t.UnixMicro())
+}
+
+func (d Date) Time() time.Time {
+	return time.Unix(int64(d)*24*60*60, 0).UTC()
+}
+
+func (d Date) Timestamp() Timestamp {
+	return Timestamp(int64(d) * MICROS_PER_DAY)
+}
+
+func (t Timestamp) Time() time.Time {
+	return time.UnixMicro(int64(t)).UTC()
+}
+
+func (d Date) String() string {
+	return d.Time().Format(dateLayout)
+}
+
+// String prints the timestamp with as many fractional digits as it needs
+func (t Timestamp) String() string {
+	tm := t.Time()
+	s := tm.Format(timestampLayout)
+	if frac := tm.Nanosecond() / 1000; frac != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+	}
+	return s
+}
+
+// String prints an interval as e.g. "1 year 2 months 3 days 04:05:06"
+func (iv Interval) String() string {
+	var parts []string
+	unit := func(n int64, name string) {
+		if n == 0 {
+			return
+		}
+		if n != 1 && n != -1 {
+			name += "s"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n, name))
+	}
+	unit(int64(iv.Months/12), "year")
+	unit(int64(iv.Months%12), "month")
+	unit(int64(iv.Days), "day")
+
+	if iv.Micros != 0 || len(parts) == 0 {
+		micros, sign := iv.Micros, ""
+		if micros < 0 {
+			micros, sign = -micros, "-"
+		}
+		secs := micros / MICROS_PER_SECOND
+		clock := fmt.Sprintf("%s%02d:%02d:%02d", sign, secs/3600, secs/60%60, secs%60)
+		if frac := micros % MICROS_PER_SECOND; frac != 0 {
+			clock += strings.TrimRight(fmt.Sprintf(".%06d", frac), "0")
+		}
+		parts = append(parts, clock)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (iv Interval) Negate() (Interval, error) {
+	if iv.Months == math.MinInt32 || iv.Days == math.MinInt32 || iv.Micros == math.MinInt64 {
+		return Interval{}, errIntervalRange
+	}
+	return Interval{Months: -iv.Months, Days: -iv.Days, Micros: -iv.Micros}, nil
+}
+
+func (iv Interval) Add(other Interval) (Interval, error) {
+	return iv.add(int64(other.Months), int64(other.Days), other.Micros)
+}
+
+// add adds to the interval field by field, failing when a field leaves its range
+// rather than wrapping around
+func (iv Interval) add(months, days, micros int64) (Interval, error) {
+	months += int64(iv.Months)
+	days += int64(iv.Days)
+	if months != int64(int32(months)) || days != int64(int32(days)) {
+		return Interval{}, errIntervalRange
+	}
+	sum := iv.Micros + micros
+	if (micros > 0 && sum < iv.Micros) || (micros < 0 && sum > iv.Micros) {
+		return Interval{}, errIntervalRange
+	}
+	return Interval{Months: int32(months), Days: int32(days), Micros: sum}, nil
+}
+
+// AddTo applies the interval to a timestamp: months first, then days, then the time part.
+// A day past the end of the new month is clamped to its last day, so 2024-01-31 plus a
+// month is 2024-02-29 rather than the March day time.AddDate would normalize it to.
+// A result outside the TIMESTAMP range is an error.
+func (iv Interval) AddTo(t Timestamp) (Timestamp, error) {
+	tm := t.Time()
+	year, month, day := tm.Date()
+	first := time.Date(year, month+time.Month(iv.Months), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	tm = time.Date(first.Year(), first.Month(), min(day, last), tm.Hour(), tm.Minute(), tm.Second(), tm.Nanosecond(), time.UTC)
+	tm = tm.AddDate(0, 0, int(iv.Days))
+	// checked before converting, UnixMicro itself overflows some 290,000 years out
+	if tm.Before(minTime) || !tm.Before(maxTime) {
+		return 0, errTimestampRange
+	}
+	ts := TimestampOf(tm)
+	if iv.Micros > int64(maxTimestamp-ts) || iv.Micros < int64(minTimestamp-ts) {
+		return 0, errTimestampRange
+	}
+	return ts + Timestamp(iv.Micros), nil
+}
+
+// AddDays moves a date by whole days, failing outside the DATE range
+func (d Date) AddDays(n int) (Date, error) {
+	days := int64(d) + int64(n)
+	if days < int64(DateOf(minTime)) || days >= int64(DateOf(maxTime)) {
+		return 0, errDateRange
+	}
+	return Date(days), nil
+}
+
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(dateLayout, strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+	return DateOf(t), nil
+}
+
+// ParseTimestamp accepts "YYYY-MM-DD", "YYYY-MM-DD HH:MM:SS[.ffffff]" or the same with a T separator
+func ParseTimestamp(s string) (Timestamp, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02 15:04:05.999999", "2006-01-02T15:04:05.999999", "2006-01-02T15:04:05.999999Z07:00", dateLayout} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return TimestampOf(t), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timestamp %q, expected YYYY-MM-DD HH:MM:SS", s)
+}
+
+/*
+ParseInterval reads a list of quantities and units, e.g. "1 day", "2 hours 30 minutes"
+or "-1 month". Units can be singular or plural: year, month, week, day, hour,
+minute, second, millisecond and microsecond.
+*/
+func ParseInterval(s string) (Interval, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return Interval{}, fmt.Errorf("invalid interval %q, expected e.g. '1 day 2 hours'", s)
+	}
+
+	var iv Interval
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.ParseInt(fields[i], 10, 64)
+		if errors.Is(err, strconv.ErrRange) || n != int64(int32(n)) {
+			return Interval{}, fmt.Errorf("invalid interval %q: %w", s, errIntervalRange)
+		}
+		if err != nil {
+			return Interval{}, fmt.Errorf("invalid interval %q: %s is not a whole number", s, fields[i])
+		}
+		unit := fields[i+1]
+		if unit != "ms" && unit != "us" {
+			unit = strings.TrimSuffix(unit, "s")
+		}
+		// n fits in 32 bits, so none of these products overflow; the sums are checked
+		var months, days, micros int64
+		switch unit {
+		case "year":
+			months = n * 12
+		case "month", "mon":
+			months = n
+		case "week":
+			days = n * 7
+		case "day":
+			days = n
+		case "hour":
+			micros = n * 60 * 60 * MICROS_PER_SECOND
+		case "minute", "min":
+			micros = n * 60 * MICROS_PER_SECOND
+		case "second", "sec":
+			micros = n * MICROS_PER_SECOND
+		case "millisecond", "ms":
+			micros = n * 1000
+		case "microsecond", "us":
+			micros = n
+		default:
+			return Interval{}, fmt.Errorf("invalid interval %q: unknown unit %s", s, fields[i+1])
+		}
+		if iv, err = iv.add(months, days, micros); err != nil {
+			return Interval{}, fmt.Errorf("invalid interval %q: %w", s, err)
+		}
+	}
+	return iv, nil
+}
@@ -0,0 +1,179 @@
+package types
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestParseDateAndTimestamp(t *testing.T) {
+	d, err := ParseDate("2024-02-29")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.String() != "2024-02-29" {
+		t.Fatalf("date = %s", d)
+	}
+	if _, err := ParseDate("2023-02-29"); err == nil {
+		t.Fatal("2023-02-29 is not a date")
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"2024-01-31", "2024-01-31 00:00:00"},
+		{"2024-01-31 13:45:06", "2024-01-31 13:45:06"},
+		{"2024-01-31T13:45:06.25", "2024-01-31 13:45:06.25"},
+		{"2024-01-31T13:45:06+03:00", "2024-01-31 10:45:06"},
+	}
+	for _, tt := range tests {
+		ts, err := ParseTimestamp(tt.in)
+		if err != nil {
+			t.Fatalf("ParseTimestamp(%q): %v", tt.in, err)
+		}
+		if ts.String() != tt.want {
+			t.Fatalf("ParseTimestamp(%q) = %s, want %s", tt.in, ts, tt.want)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Interval
+		wantErr bool
+	}{
+		{in: "1 day", want: Interval{Days: 1}},
+		{in: "2 hours 30 minutes", want: Interval{Micros: 150 * 60 * MICROS_PER_SECOND}},
+		{in: "1 year -1 month", want: Interval{Months: 11}},
+		{in: "2 weeks", want: Interval{Days: 14}},
+		{in: "5 ms", want: Interval{Micros: 5000}},
+		{in: "1 fortnight", wantErr: true},
+		{in: "day", wantErr: true},
+		{in: "1.5 days", wantErr: true},
+	}
+	for _, tt := range tests {
+		iv, err := ParseInterval(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("ParseInterval(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		if err == nil && iv != tt.want {
+			t.Fatalf("ParseInterval(%q) = %+v, want %+v", tt.in, iv, tt.want)
+		}
+	}
+}
+
+func TestIntervalAddTo(t *testing.T) {
+	tests := []struct {
+		start    string
+		interval string
+		want     string
+	}{
+		{"2024-01-15 10:00:00", "1 month", "2024-02-15 10:00:00"},
+		{"2024-01-15 10:00:00", "1 day 2 hours", "2024-01-16 12:00:00"},
+		{"2024-03-10 00:00:00", "-10 days", "2024-02-29 00:00:00"},
+		{"2023-12-31 23:59:59", "1 second", "2024-01-01 00:00:00"},
+		// a day past the end of the new month is clamped to its last day
+		{"2024-01-31 10:00:00", "1 month", "2024-02-29 10:00:00"},
+		{"2023-01-31 10:00:00", "1 month", "2023-02-28 10:00:00"},
+		{"2024-03-31 00:00:00", "-1 month", "2024-02-29 00:00:00"},
+		{"2024-05-31 00:00:00", "1 month", "2024-06-30 00:00:00"},
+		{"2024-02-29 00:00:00", "1 year", "2025-02-28 00:00:00"},
+		{"2024-02-29 00:00:00", "4 years", "2028-02-29 00:00:00"},
+		{"2023-12-31 00:00:00", "2 months", "2024-02-29 00:00:00"},
+		// days are added after clamping, and the time part last
+		{"2024-01-31 00:00:00", "1 month 1 day", "2024-03-01 00:00:00"},
+		{"2024-01-31 23:00:00", "1 month 2 hours", "2024-03-01 01:00:00"},
+	}
+	for _, tt := range tests {
+		start, err := ParseTimestamp(tt.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		iv, err := ParseInterval(tt.interval)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := iv.AddTo(start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != tt.want {
+			t.Fatalf("%s + %s = %s, want %s", tt.start, tt.interval, got, tt.want)
+		}
+	}
+}
+
+func TestIntervalRange(t *testing.T) {
+	for _, in := range []string{
+		"200000000 years",
+		"3000000000 days",
+		"2147483647 months 1 month",
+		"-2147483648 days -1 day",
+		"2147483647 hours 2147483647 hours 2147483647 hours 2147483647 hours",
+	} {
+		if _, err := ParseInterval(in); err == nil || !strings.Contains(err.Error(), "interval out of range") {
+			t.Errorf("ParseInterval(%q) error = %v, want interval out of range", in, err)
+		}
+	}
+	if iv, err := ParseInterval("178956970 years 7 months"); err != nil || iv.Months != math.MaxInt32 {
+		t.Errorf("the largest month count should parse, got %+v, %v", iv, err)
+	}
+
+	max := Interval{Months: math.MaxInt32, Days: math.MaxInt32, Micros: math.MaxInt64}
+	if _, err := max.Add(Interval{Days: 1}); err == nil {
+		t.Error("adding a day to the largest interval should fail")
+	}
+	if _, err := max.Add(Interval{Micros: 1}); err == nil {
+		t.Error("adding a microsecond to the largest interval should fail")
+	}
+	if _, err := (Interval{Days: math.MinInt32}).Negate(); err == nil {
+		t.Error("negating the smallest day count should fail")
+	}
+	if got, err := max.Add(Interval{Months: -1, Days: -1, Micros: -1}); err != nil || got.Days != math.MaxInt32-1 {
+		t.Errorf("max - 1 = %+v, %v", got, err)
+	}
+}
+
+func TestAddToTimestampRange(t *testing.T) {
+	tests := []struct {
+		start    string
+		interval Interval
+		want     string
+	}{
+		{"2024-01-01 00:00:00", Interval{Months: 100000000 * 12}, ""},
+		{"2024-01-01 00:00:00", Interval{Days: math.MaxInt32}, ""},
+		{"2024-01-01 00:00:00", Interval{Micros: math.MaxInt64}, ""},
+		{"2024-01-01 00:00:00", Interval{Micros: math.MinInt64}, ""},
+		{"0001-01-01 00:00:00", Interval{Micros: -1}, ""},
+		{"9999-12-31 23:59:59", Interval{Micros: MICROS_PER_SECOND}, ""},
+		{"9999-12-31 23:59:59", Interval{Micros: MICROS_PER_SECOND - 1}, "9999-12-31 23:59:59.999999"},
+		{"0001-01-31 00:00:00", Interval{Months: -1}, ""},
+		{"0001-02-28 00:00:00", Interval{Months: -1}, "0001-01-28 00:00:00"},
+	}
+	for _, tt := range tests {
+		start, err := ParseTimestamp(tt.start)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := tt.interval.AddTo(start)
+		if tt.want == "" {
+			if err == nil || err.Error() != "timestamp out of range" {
+				t.Errorf("%s + %+v = %s, %v, want timestamp out of range", tt.start, tt.interval, got, err)
+			}
+			continue
+		}
+		if err != nil || got.String() != tt.want {
+			t.Errorf("%s + %+v = %s, %v, want %s", tt.start, tt.interval, got, err, tt.want)
+		}
+	}
+
+	last, _ := ParseDate("9999-12-31")
+	if _, err := last.AddDays(1); err == nil || err.Error() != "date out of range" {
+		t.Errorf("9999-12-31 + 1 error = %v, want date out of range", err)
+	}
+	if d, err := last.AddDays(-366); err != nil || d.String() != "9998-12-30" {
+		t.Errorf("9999-12-31 - 366 = %s, %v", d, err)
+	}
+}
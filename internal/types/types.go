@@ -1,5 +1,10 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+)
+
 type DataType int
 
 const (
@@ -7,14 +12,123 @@ const (
 	TEXT
 	BOOLEAN
 	FLOAT
+	DATE
+	TIMESTAMP
+	INTERVAL
+	DECIMAL
+	BLOB
+	CHAR
+	JSON
 )
 
+func (t DataType) String() string {
+	switch t {
+	case INT:
+		return "INT"
+	case TEXT:
+		return "TEXT"
+	case BOOLEAN:
+		return "BOOLEAN"
+	case FLOAT:
+		return "FLOAT"
+	case DATE:
+		return "DATE"
+	case TIMESTAMP:
+		return "TIMESTAMP"
+	case INTERVAL:
+		return "INTERVAL"
+	case DECIMAL:
+		return "DECIMAL"
+	case BLOB:
+		return "BLOB"
+	case CHAR:
+		return "CHAR"
+	case JSON:
+		return "JSON"
+	default:
+		return fmt.Sprintf("DataType(%d)", int(t))
+	}
+}
+
+// TypeOf returns the column type a Go value is stored as, false if it isn't a storable value
+func TypeOf(v Value) (DataType, bool) {
+	switch v.(type) {
+	case int:
+		return INT, true
+	case string:
+		return TEXT, true
+	case bool:
+		return BOOLEAN, true
+	case float64:
+		return FLOAT, true
+	case Date:
+		return DATE, true
+	case Timestamp:
+		return TIMESTAMP, true
+	case Interval:
+		return INTERVAL, true
+	case Decimal:
+		return DECIMAL, true
+	case []byte:
+		return BLOB, true
+	case Char:
+		return CHAR, true
+	case JSONValue:
+		return JSON, true
+	default:
+		return 0, false
+	}
+}
+
 type Column struct {
 	Name string
 	Type DataType
+	// ID identifies the column within its table across renames and schema
+	// versions, it is assigned by the catalog
+	ID uint16
+	// Precision and Scale constrain a DECIMAL column to DECIMAL(Precision, Scale),
+	// a Precision of 0 accepts any decimal as is
+	Precision uint8
+	Scale     uint8
+	// Length is n for CHAR(n), and for a TEXT column declared VARCHAR(n), in
+	// characters; a TEXT column with a Length of 0 is unbounded
+	Length uint16
+	// Default is the column's DEFAULT (nil for NULL), it fills the column in for
+	// INSERTs that leave it out and for rows stored before it was added
+	Default Value
+}
+
+// TypeName is the column's type as it would be declared, e.g. DECIMAL(10, 2)
+func (c Column) TypeName() string {
+	switch {
+	case c.Type == DECIMAL && c.Precision > 0:
+		return fmt.Sprintf("DECIMAL(%d, %d)", c.Precision, c.Scale)
+	case c.Type == TEXT && c.Length > 0:
+		return fmt.Sprintf("VARCHAR(%d)", c.Length)
+	case c.Type == CHAR:
+		return fmt.Sprintf("CHAR(%d)", c.Length)
+	}
+	return c.Type.String()
+}
+
+// MAX_CHAR_LENGTH is the longest CHAR(n) or VARCHAR(n) a column can declare
+const MAX_CHAR_LENGTH = 65535
+
+/*
+Char is a CHAR(n) value, always padded with spaces to n characters. Trailing
+spaces carry no meaning: they are ignored when comparing and dropped when the
+value is stored as TEXT.
+*/
+type Char string
+
+// Trim drops the padding
+func (c Char) Trim() string {
+	return strings.TrimRight(string(c), " ")
 }
 
 type (
+	// Value is an int, float64, string, bool, Date, Timestamp, Interval, Decimal,
+	// []byte (BLOB), Char or JSONValue, or nil for NULL
 	Value interface{}
 	Row   []Value
 )